@@ -10,12 +10,15 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
 	"dns-go/internal/api"
 	"dns-go/internal/config"
+	"dns-go/internal/mapping"
+	"dns-go/internal/resolver"
 	"dns-go/pkg/version"
 )
 
@@ -70,6 +73,11 @@ func run() error {
 
 	// Load DNS configuration to enable DNS mappings management (without flag parsing)
 	dnsConfig := config.DefaultConfig()
+	if maxStr := os.Getenv("MAX_CUSTOM_DNS_MAPPINGS"); maxStr != "" {
+		if limit, err := strconv.Atoi(maxStr); err == nil && limit >= 0 {
+			dnsConfig.MaxCustomMappings = limit
+		}
+	}
 	// Load custom DNS mappings from file without flag parsing
 	if err := loadCustomDNSOnly(dnsConfig); err != nil {
 		fmt.Printf("Warning: Could not load custom DNS mappings: %v\n", err)
@@ -166,7 +174,7 @@ func loadCustomDNSOnly(cfg *config.Config) error {
 
 	// Parse the JSON configuration
 	var customDNSConfig struct {
-		Mappings map[string]string `json:"mappings"`
+		Mappings map[string]mapping.Value `json:"mappings"`
 	}
 	if err := json.Unmarshal(data, &customDNSConfig); err != nil {
 		return fmt.Errorf("failed to parse custom DNS config file %s: %w", configPath, err)
@@ -178,21 +186,22 @@ func loadCustomDNSOnly(cfg *config.Config) error {
 	}
 
 	// Process and normalize the mappings from the config file
-	for domain, ip := range customDNSConfig.Mappings {
+	for domain, value := range customDNSConfig.Mappings {
 		domain = strings.TrimSpace(domain)
-		ip = strings.TrimSpace(ip)
+		ip := strings.TrimSpace(string(value))
 
 		if domain == "" || ip == "" {
 			return fmt.Errorf("invalid custom DNS mapping in config file: empty domain or IP")
 		}
 
-		// Ensure domain ends with a dot for DNS processing
-		if !strings.HasSuffix(domain, ".") {
-			domain += "."
-		}
+		// Ensure domain ends with a dot for DNS processing (regex keys are kept as written)
+		domain = mapping.NormalizeKey(domain)
 
 		cfg.CustomDNS[domain] = ip
 	}
 
-	return nil
+	if err := cfg.CheckMappingLimit(len(cfg.CustomDNS)); err != nil {
+		return err
+	}
+	return resolver.CheckPatternLimit(cfg.CustomDNS)
 }
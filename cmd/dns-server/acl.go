@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/netip"
+	"sort"
+
+	"dns-go/internal/config"
+
+	"github.com/miekg/dns"
+)
+
+// queryTypeRule limits the query types clients in a subnet may send
+type queryTypeRule struct {
+	subnet netip.Prefix
+	qtypes map[uint16]bool
+}
+
+// newQueryTypeRules builds the per-client query type rules, most specific
+// subnet first. Invalid entries are skipped; they are rejected by
+// config.Validate before the server starts.
+func newQueryTypeRules(rules config.QueryTypeRules) []queryTypeRule {
+	parsed := make([]queryTypeRule, 0, len(rules))
+	for subnet, qtypes := range rules {
+		prefix, err := config.ParseClientSubnet(subnet)
+		if err != nil {
+			continue
+		}
+		rule := queryTypeRule{subnet: prefix, qtypes: make(map[uint16]bool, len(qtypes))}
+		for _, qtype := range qtypes {
+			if t, ok := dns.StringToType[qtype]; ok {
+				rule.qtypes[t] = true
+			}
+		}
+		parsed = append(parsed, rule)
+	}
+
+	sort.Slice(parsed, func(i, j int) bool {
+		return parsed[i].subnet.Bits() > parsed[j].subnet.Bits()
+	})
+	return parsed
+}
+
+// queryTypeAllowed reports whether the client may send a query of the given
+// type. Clients outside every restricted subnet may send any type.
+func (s *DNSServer) queryTypeAllowed(client string, qtype uint16) bool {
+	if len(s.queryTypeRules) == 0 {
+		return true
+	}
+
+	addr, err := netip.ParseAddr(client)
+	if err != nil {
+		return true
+	}
+	addr = addr.Unmap()
+
+	for _, rule := range s.queryTypeRules {
+		if rule.subnet.Contains(addr) {
+			return rule.qtypes[qtype]
+		}
+	}
+	return true
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"time"
+
+	"dns-go/internal/alert"
+	"dns-go/internal/config"
+	"dns-go/internal/logging"
+)
+
+// setupAlerts creates the webhook notifier and failure rate tracker of the
+// configured alerts, and has PostgreSQL write failures reported through them
+func (s *DNSServer) setupAlerts(cfg *config.Config, logger *logging.Logger) {
+	if cfg.AlertWebhook == "" {
+		return
+	}
+
+	s.alerts = alert.New(cfg.AlertWebhook, cfg.AlertInterval)
+	s.alerts.SetErrorHandler(func(a alert.Alert, err error) {
+		s.logger.Warn("Failed to post alert", map[string]interface{}{
+			"event": string(a.Event),
+			"error": err.Error(),
+		})
+	})
+	if cfg.AlertFailureRate > 0 {
+		s.failureRate = alert.NewFailureWindow(cfg.AlertWindow, cfg.AlertFailureRate)
+	}
+
+	logger.SetPostgresErrorObserver(func(err error) {
+		s.alerts.Fire(alert.Alert{
+			Event:   alert.EventDependencyDown,
+			Message: "Failed to store query logs in PostgreSQL",
+			Details: map[string]interface{}{
+				"dependency": "postgres",
+				"error":      err.Error(),
+			},
+		})
+	})
+}
+
+// alertIfUpstreamsDown fires an alert when none of the upstream servers is
+// healthy any more
+func (s *DNSServer) alertIfUpstreamsDown() {
+	if s.alerts == nil || len(s.upstreamMgr.GetHealthyServers()) > 0 {
+		return
+	}
+	s.alerts.Fire(alert.Alert{
+		Event:   alert.EventUpstreamsDown,
+		Message: "All upstream DNS servers are unhealthy",
+		Details: map[string]interface{}{
+			"upstreams": s.config.UpstreamDNS,
+		},
+	})
+}
+
+// recordQueryOutcome tracks whether the upstreams answered a query and fires an
+// alert when the failure rate of the last window reached the threshold
+func (s *DNSServer) recordQueryOutcome(failed bool) {
+	if s.failureRate == nil {
+		return
+	}
+	if rate, exceeded := s.failureRate.Record(time.Now(), failed); exceeded {
+		s.alerts.Fire(alert.Alert{
+			Event:   alert.EventHighFailureRate,
+			Message: "High rate of failed DNS queries",
+			Details: map[string]interface{}{
+				"failure_rate": rate,
+				"threshold":    s.config.AlertFailureRate,
+				"window":       s.config.AlertWindow.String(),
+			},
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"dns-go/internal/types"
+	"dns-go/internal/upstream"
+)
+
+// appendAttempts adds the upstream results to the log entry, numbering them
+// after the attempts already logged. The attempt of the chosen result is marked
+// successful.
+func (s *DNSServer) appendAttempts(logEntry *types.LogEntry, results []upstream.QueryResult, chosen *upstream.QueryResult) {
+	for _, upstreamResult := range results {
+		attempt := types.UpstreamAttempt{
+			Server:   upstreamResult.Server,
+			Attempt:  len(logEntry.Upstreams) + 1,
+			Duration: types.DurationToMilliseconds(upstreamResult.RTT),
+
+			Truncated: upstreamResult.Truncated,
+		}
+		if upstreamResult.Truncated {
+			s.logger.Debug("Upstream answer truncated", map[string]interface{}{
+				"uuid":         logEntry.UUID,
+				"upstream":     upstreamResult.Server,
+				"query":        logEntry.Request.Query,
+				"tcp_fallback": s.config.TCPFallback,
+			})
+		}
+
+		if upstreamResult.Error != nil {
+			errStr := upstreamResult.Error.Error()
+			attempt.Error = &errStr
+		} else {
+			rttMs := types.DurationToMilliseconds(upstreamResult.RTT)
+			attempt.RTT = &rttMs
+			// Query returns the first successful result, or a SERVFAIL answer
+			// when no upstream did better
+			attempt.Successful = chosen.Error == nil && upstreamResult.Server == chosen.Server
+		}
+
+		logEntry.Upstreams = append(logEntry.Upstreams, attempt)
+	}
+}
+
+// logWithLateAttempts writes the log entry of an answered query in the
+// background, once the upstreams still being queried answered or
+// LogAttemptsWait passed, so the entry lists every attempt without delaying the
+// client. cancel stops the queries left running when the entry is written.
+func (s *DNSServer) logWithLateAttempts(logEntry types.LogEntry, chosen *upstream.QueryResult, late <-chan upstream.QueryResult, cancel context.CancelFunc) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer cancel()
+
+		timer := time.NewTimer(s.config.LogAttemptsWait)
+		defer timer.Stop()
+
+		var results []upstream.QueryResult
+	collect:
+		for {
+			select {
+			case result, ok := <-late:
+				if !ok {
+					break collect
+				}
+				results = append(results, result)
+			case <-timer.C:
+				break collect
+			}
+		}
+
+		s.appendAttempts(&logEntry, results, chosen)
+		s.logger.LogDNSEntry(logEntry)
+	}()
+}
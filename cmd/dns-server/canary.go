@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strings"
+
+	"dns-go/internal/alert"
+	"dns-go/internal/config"
+	"dns-go/internal/types"
+)
+
+// isCanary reports whether name is a canary domain or one of its subdomains
+func (s *DNSServer) isCanary(name string) bool {
+	return s.canaries != nil && s.canaries.Blocked(name)
+}
+
+// setupCanaryAlerts creates the notifier posting canary queries to the canary
+// webhook, each client and queried name at most once per alert interval
+func (s *DNSServer) setupCanaryAlerts(cfg *config.Config) {
+	if cfg.CanaryWebhook == "" {
+		return
+	}
+
+	s.canaryAlerts = alert.New(cfg.CanaryWebhook, cfg.AlertInterval)
+	s.canaryAlerts.SetErrorHandler(func(a alert.Alert, err error) {
+		s.logger.Warn("Failed to post canary alert", map[string]interface{}{
+			"uuid":  a.Details["uuid"],
+			"error": err.Error(),
+		})
+	})
+}
+
+// raiseCanaryAlert logs a query for a canary domain at error level and posts it
+// to the canary webhook, if configured, without delaying the answer
+func (s *DNSServer) raiseCanaryAlert(logEntry types.LogEntry) {
+	s.logger.Error("Canary domain queried", map[string]interface{}{
+		"uuid":   logEntry.UUID,
+		"client": logEntry.Request.Client,
+		"query":  logEntry.Request.Query,
+		"type":   logEntry.Request.Type,
+		"status": "canary",
+	})
+
+	s.canaryAlerts.Fire(alert.Alert{
+		Key:       logEntry.Request.Client + " " + strings.ToLower(logEntry.Request.Query),
+		Event:     alert.EventCanaryQuery,
+		Message:   "Canary domain queried",
+		Timestamp: logEntry.Timestamp,
+		Details: map[string]interface{}{
+			"uuid":   logEntry.UUID,
+			"client": logEntry.Request.Client,
+			"query":  logEntry.Request.Query,
+			"type":   logEntry.Request.Type,
+		},
+	})
+}
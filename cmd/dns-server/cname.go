@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"dns-go/internal/config"
+	"dns-go/internal/types"
+
+	"github.com/miekg/dns"
+)
+
+// maxCNAMEChases bounds the follow-up queries made to complete a CNAME chain
+const maxCNAMEChases = 4
+
+// danglingCNAME returns the final target of the CNAME chain in a successful
+// answer when the answer holds no record of the queried type for it, as left by
+// broken delegations. Answers without CNAME records are never dangling.
+func danglingCNAME(resp *dns.Msg, question dns.Question) (string, bool) {
+	if resp.Rcode != dns.RcodeSuccess || question.Qtype == dns.TypeCNAME || question.Qtype == dns.TypeANY {
+		return "", false
+	}
+
+	// A looping chain cannot be completed by querying its target
+	chain, loop := cnameChain(resp.Answer, question.Name)
+	if loop || len(chain) == 1 {
+		return "", false
+	}
+
+	name := chain[len(chain)-1]
+	for _, rr := range resp.Answer {
+		if rr.Header().Rrtype == question.Qtype && strings.EqualFold(rr.Header().Name, name) {
+			return "", false
+		}
+	}
+	return name, true
+}
+
+// cnameChain follows the CNAME records of an answer from name and returns the
+// lowercased names of the chain in order, starting with name. It reports a loop
+// when a CNAME points back to an earlier name of the chain.
+func cnameChain(answer []dns.RR, name string) ([]string, bool) {
+	name = strings.ToLower(name)
+	chain := []string{name}
+	seen := map[string]bool{name: true}
+	for {
+		target := ""
+		for _, rr := range answer {
+			if cname, ok := rr.(*dns.CNAME); ok && strings.EqualFold(cname.Hdr.Name, name) {
+				target = strings.ToLower(cname.Target)
+				break
+			}
+		}
+		if target == "" {
+			return chain, false
+		}
+		if seen[target] {
+			return chain, true
+		}
+		chain = append(chain, target)
+		name, seen[target] = target, true
+	}
+}
+
+// limitCNAMEChain enforces the maximum CNAME chain length on an upstream
+// answer. Over the limit, the answer is cut after the allowed CNAME records
+// with the truncate policy; otherwise it is refused, and limitCNAMEChain
+// returns false.
+func (s *DNSServer) limitCNAMEChain(logEntry types.LogEntry, resp *dns.Msg, question dns.Question) (*dns.Msg, bool) {
+	limit := s.config.MaxCNAMEChain
+	if limit <= 0 {
+		return resp, true
+	}
+
+	chain, loop := cnameChain(resp.Answer, question.Name)
+	length := len(chain) - 1
+	if loop {
+		// The CNAME record closing the loop
+		length++
+	}
+	if length <= limit {
+		return resp, true
+	}
+
+	s.logger.Warn("Upstream answer exceeds the CNAME chain limit", map[string]interface{}{
+		"uuid":   logEntry.UUID,
+		"query":  logEntry.Request.Query,
+		"type":   logEntry.Request.Type,
+		"length": length,
+		"limit":  limit,
+		"policy": s.config.CNAMEChainPolicy,
+	})
+	if s.config.CNAMEChainPolicy != config.CNAMEChainPolicyTruncate {
+		return resp, false
+	}
+
+	// Keep the records of the names whose CNAME is within the limit, ending the
+	// answer in a CNAME for the client to follow
+	kept := make(map[string]bool, limit)
+	for _, name := range chain[:limit] {
+		kept[name] = true
+	}
+	answer := make([]dns.RR, 0, limit)
+	for _, rr := range resp.Answer {
+		if kept[strings.ToLower(rr.Header().Name)] {
+			answer = append(answer, rr)
+		}
+	}
+	resp.Answer = answer
+	return resp, true
+}
+
+// completeCNAMEChain logs an answer whose CNAME chain ends without a record of
+// the queried type and, when enabled, queries the chain's target to add its
+// records and response code to the answer
+func (s *DNSServer) completeCNAMEChain(ctx context.Context, logEntry types.LogEntry, resp *dns.Msg, question dns.Question) *dns.Msg {
+	target, dangling := danglingCNAME(resp, question)
+	if !dangling {
+		return resp
+	}
+
+	fields := map[string]interface{}{
+		"uuid":   logEntry.UUID,
+		"query":  logEntry.Request.Query,
+		"type":   logEntry.Request.Type,
+		"target": s.redact(target),
+	}
+	if !s.config.ChaseDanglingCNAMEs {
+		s.logger.Warn("Upstream answer ends in a dangling CNAME", fields)
+		return resp
+	}
+
+	for chase := 0; dangling && chase < maxCNAMEChases; chase++ {
+		query := new(dns.Msg)
+		query.SetQuestion(target, question.Qtype)
+
+		result, _ := s.managerFor(target).Query(ctx, query)
+		if result.Error != nil || result.Response == nil {
+			fields["error"] = "no answer for the CNAME target"
+			if result.Error != nil {
+				fields["error"] = result.Error.Error()
+			}
+			s.logger.Warn("Failed to complete dangling CNAME chain", fields)
+			return resp
+		}
+
+		resp.Answer = append(resp.Answer, result.Response.Answer...)
+		resp.Rcode = result.Response.Rcode
+		if len(result.Response.Answer) == 0 {
+			// The target has no such record either; its response code stands
+			break
+		}
+		target, dangling = danglingCNAME(resp, question)
+	}
+
+	s.logger.Debug("Followed dangling CNAME chain", fields)
+	return resp
+}
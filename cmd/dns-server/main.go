@@ -7,17 +7,22 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"net/netip"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"dns-go/internal/alert"
 	"dns-go/internal/config"
 	"dns-go/internal/logging"
 	"dns-go/internal/postgres"
 	"dns-go/internal/resolver"
+	"dns-go/internal/rewrite"
 	"dns-go/internal/types"
 	"dns-go/internal/upstream"
 	"dns-go/pkg/version"
@@ -30,20 +35,36 @@ type DNSServer struct {
 	config         *config.Config
 	logger         *logging.Logger
 	resolver       *resolver.LocalResolver
+	blocklist      *resolver.Blocklist // nil unless a blocklist is configured
+	canaries       *resolver.Blocklist // nil unless canary domains are configured
+	canaryAlerts   *alert.Notifier     // nil unless a canary webhook is configured
+	alerts         *alert.Notifier     // nil unless an alert webhook is configured
+	failureRate    *alert.FailureWindow
 	upstreamMgr    *upstream.Manager
+	zoneRoutes     []zoneRoute
 	requestLimiter chan struct{}
+	inFlight       int64 // atomic count of requests currently being processed
 	wg             sync.WaitGroup
 	shutdown       chan struct{}
 	server         *dns.Server
+	tcpServer      *dns.Server
+	pool           *workerPool
+	queryTypeRules []queryTypeRule
 }
 
 // NewDNSServer creates a new DNS server instance with all improvements
 func NewDNSServer(cfg *config.Config, logger *logging.Logger) *DNSServer {
 	// Create local resolver for custom DNS mappings
 	localResolver := resolver.New(cfg.CustomDNS)
+	localResolver.SetResponseCache(cfg.LocalResponseCache)
+	localResolver.SetTTL(uint32(cfg.CustomDNSTTL))
+	localResolver.SetZones(cfg.CustomZones, resolver.ZoneConfig{
+		Nameservers: cfg.ZoneNameservers,
+		Hostmaster:  cfg.ZoneHostmaster,
+	})
 
 	// Create upstream manager with concurrent query support
-	upstreamMgr := upstream.New(cfg.UpstreamDNS, cfg.Timeout, cfg.RetryAttempts)
+	upstreamMgr := newUpstreamManager(cfg, cfg.UpstreamDNS, logger)
 
 	// Create request limiter channel
 	requestLimiter := make(chan struct{}, cfg.MaxConcurrent)
@@ -55,17 +76,78 @@ func NewDNSServer(cfg *config.Config, logger *logging.Logger) *DNSServer {
 		upstreamMgr:    upstreamMgr,
 		requestLimiter: requestLimiter,
 		shutdown:       make(chan struct{}),
+		queryTypeRules: newQueryTypeRules(cfg.ClientQueryTypes),
+		zoneRoutes:     newZoneRoutes(cfg, logger),
+	}
+	upstreamMgr.SetStateObserver(server.logStateChange)
+	for _, route := range server.zoneRoutes {
+		route.manager.SetStateObserver(server.logStateChange)
+	}
+
+	// A configured blocklist file gets a blocklist even when empty, so entries
+	// added to it later are picked up on reload
+	if cfg.BlockedDomains != nil || cfg.BlocklistFile != "" {
+		server.blocklist = resolver.NewBlocklist(cfg.BlockedDomains)
+		server.blocklist.SetSinkhole(cfg.BlocklistSinkhole)
+	}
+	if len(cfg.CanaryDomains) > 0 {
+		server.canaries = resolver.NewBlocklist(cfg.CanaryDomains)
+		server.canaries.SetSinkhole(cfg.CanarySinkhole)
+		server.setupCanaryAlerts(cfg)
 	}
+	server.setupAlerts(cfg, logger)
 
 	return server
 }
 
+// newUpstreamManager creates the manager querying the given recursive servers
+// with the configured upstream settings
+func newUpstreamManager(cfg *config.Config, servers []string, logger *logging.Logger) *upstream.Manager {
+	manager := upstream.New(servers, cfg.Timeout, cfg.RetryAttempts)
+	manager.SetRandomizeQueryIDs(cfg.RandomizeQueryIDs)
+	manager.SetServfailIsFailure(cfg.ServfailIsFailure)
+	manager.SetFailoverOnRefused(cfg.FailoverOnRefused)
+	manager.SetTCPFallback(cfg.TCPFallback)
+	manager.SetDNSSECValidation(cfg.DNSSECValidate)
+	manager.SetDoHPoolSize(cfg.DoHPoolSize)
+	manager.SetServerTimeouts(cfg.UpstreamTimeouts)
+	manager.SetPadding(cfg.PaddingBlockSize, cfg.PadPlainDNS)
+	if cfg.UpstreamStrategy == config.UpstreamStrategySequential {
+		manager.SetStrategy(upstream.StrategySequential)
+	}
+	switch cfg.RecursionDesired {
+	case config.RecursionDesiredOn:
+		manager.SetRDMode(upstream.RDForceOn)
+	case config.RecursionDesiredOff:
+		manager.SetRDMode(upstream.RDForceOff)
+	}
+	manager.SetStaggerDelay(cfg.UpstreamStagger)
+	manager.SetCircuitBreaker(cfg.UpstreamFailureThreshold, cfg.UpstreamRecoveryTimeout)
+	manager.SetHealthProbe(cfg.HealthProbeName, cfg.HealthProbeLatency)
+	if cfg.LogTLSDetails {
+		manager.SetTLSObserver(func(details upstream.TLSDetails) {
+			logger.Debug("Upstream TLS handshake", map[string]interface{}{
+				"server":       details.ServerName,
+				"version":      details.Version,
+				"cipher_suite": details.CipherSuite,
+				"subject":      details.Subject,
+				"not_after":    details.NotAfter.Format(time.RFC3339),
+			})
+		})
+	}
+	return manager
+}
+
 // handleDNSRequest processes incoming DNS queries with concurrent upstream queries
 func (s *DNSServer) handleDNSRequest(w dns.ResponseWriter, r *dns.Msg) {
 	// Rate limiting
 	select {
 	case s.requestLimiter <- struct{}{}:
-		defer func() { <-s.requestLimiter }()
+		atomic.AddInt64(&s.inFlight, 1)
+		defer func() {
+			atomic.AddInt64(&s.inFlight, -1)
+			<-s.requestLimiter
+		}()
 	default:
 		// Too many concurrent requests, return SERVFAIL
 		s.logger.Warn("Request rate limited", map[string]interface{}{
@@ -113,11 +195,43 @@ func (s *DNSServer) handleDNSRequest(w dns.ResponseWriter, r *dns.Msg) {
 	question := r.Question[0]
 	logEntry.Request = types.RequestInfo{
 		Client: clientAddr,
-		Query:  question.Name,
+		Query:  s.redact(question.Name),
 		Type:   dns.TypeToString[question.Qtype],
 		ID:     r.Id,
 	}
 
+	// Servers in practice answer only one question per message
+	if len(r.Question) > 1 {
+		if s.config.MultiQuestionPolicy != config.MultiQuestionPolicyFirst {
+			s.answerWithRcode(w, r, logEntry, dns.RcodeFormatError, "multi_question")
+			return
+		}
+
+		s.logger.Warn("Query with multiple questions, answering only the first", map[string]interface{}{
+			"uuid":      requestUUID,
+			"client":    clientAddr,
+			"questions": len(r.Question),
+			"status":    "multi_question",
+		})
+		r = r.Copy()
+		r.Question = r.Question[:1]
+	}
+
+	// Refuse query types the client's subnet is not allowed to send
+	if !s.queryTypeAllowed(clientAddr, question.Qtype) {
+		s.answerWithRcode(w, r, logEntry, dns.RcodeRefused, "refused_type_acl")
+		return
+	}
+
+	// Alert on canary domains however they are answered, sinkholing them if configured
+	if s.isCanary(question.Name) {
+		s.raiseCanaryAlert(logEntry)
+		if len(s.config.CanarySinkhole) > 0 {
+			s.answerLocally(w, r, logEntry, s.canaries.Response(question), "canary")
+			return
+		}
+	}
+
 	// Check custom resolver first
 	if customResp := s.resolver.Resolve(question); customResp != nil {
 		logEntry.Status = "custom_resolution"
@@ -125,6 +239,9 @@ func (s *DNSServer) handleDNSRequest(w dns.ResponseWriter, r *dns.Msg) {
 
 		// Update response ID to match request
 		customResp.Id = r.Id
+		if family := s.preferredFamily(); family != 0 {
+			customResp = orderAnswers(customResp, family)
+		}
 
 		// Set response info for custom resolution
 		logEntry.Response = &types.ResponseInfo{
@@ -134,43 +251,111 @@ func (s *DNSServer) handleDNSRequest(w dns.ResponseWriter, r *dns.Msg) {
 			RTT:         0, // Custom resolution, no network RTT
 		}
 
-		logEntry.Answers = types.ExtractAnswers(customResp.Answer)
+		logEntry.Answers = s.logAnswers(customResp.Answer)
 		logEntry.IPAddresses = types.ExtractIPAddresses(customResp.Answer)
 
 		s.logger.LogDNSEntry(logEntry)
-		s.logger.LogRequestResponse(requestUUID, clientAddr, question.Name,
+		s.logger.LogRequestResponse(requestUUID, clientAddr, logEntry.Request.Query,
 			dns.TypeToString[question.Qtype], "custom_resolution",
 			types.DurationToMilliseconds(time.Since(start)), "custom")
+		reportSource(w, r, customResp, "custom_resolution", "custom")
 		w.WriteMsg(customResp)
 		return
 	}
 
-	// Query upstream servers concurrently
-	ctx, cancel := context.WithTimeout(context.Background(), s.config.Timeout)
-	defer cancel()
+	// Answer localhost and loopback PTR queries without leaking them upstream
+	if s.config.AnswerLocalhost {
+		if localhostResp := resolver.Localhost(question); localhostResp != nil {
+			s.answerLocally(w, r, logEntry, localhostResp, "localhost")
+			return
+		}
+	}
 
-	result, allResults := s.upstreamMgr.QueryConcurrent(ctx, r)
+	// Answer queries for the server's own hostnames with its address
+	if selfResp := s.selfResponse(w, question); selfResp != nil {
+		s.answerLocally(w, r, logEntry, selfResp, "self")
+		return
+	}
 
-	// Convert upstream results to log format
-	for i, upstreamResult := range allResults {
-		attempt := types.UpstreamAttempt{
-			Server:   upstreamResult.Server,
-			Attempt:  i + 1,
-			Duration: types.DurationToMilliseconds(upstreamResult.RTT),
+	// Keep reverse lookups of private address ranges inside the network, unless mapped above
+	if question.Qtype == dns.TypePTR && s.config.PrivatePTRPolicy != config.LocalDomainPolicyForward && isPrivateReverseName(question.Name) {
+		rcode := dns.RcodeRefused
+		if s.config.PrivatePTRPolicy == config.LocalDomainPolicyNXDomain {
+			rcode = dns.RcodeNameError
 		}
+		s.answerWithRcode(w, r, logEntry, rcode, "private_ptr")
+		return
+	}
 
-		if upstreamResult.Error != nil {
-			errStr := upstreamResult.Error.Error()
-			attempt.Error = &errStr
-		} else {
-			rttMs := types.DurationToMilliseconds(upstreamResult.RTT)
-			attempt.RTT = &rttMs
+	// Answer local-only (mDNS) and special-use names without consulting upstream
+	// resolvers, unless they were mapped above
+	switch action, status := s.specialUseAction(question.Name); action {
+	case config.LocalDomainPolicyRefuse:
+		s.answerWithRcode(w, r, logEntry, dns.RcodeRefused, status)
+		return
+	case config.LocalDomainPolicyNXDomain:
+		s.answerWithRcode(w, r, logEntry, dns.RcodeNameError, status)
+		return
+	case config.SpecialUseLoopback:
+		s.answerLocally(w, r, logEntry, resolver.Loopback(question), status)
+		return
+	}
+
+	// In allowlist mode, refuse anything that is not explicitly permitted
+	if s.config.AllowlistEnabled() && !s.isAllowed(question.Name) {
+		s.answerWithRcode(w, r, logEntry, dns.RcodeRefused, "refused_not_allowed")
+		return
+	}
+
+	// Answer blocked domains with NXDOMAIN or the sinkhole addresses
+	if s.blocklist != nil {
+		if blockedResp := s.blocklist.Response(question); blockedResp != nil {
+			s.answerLocally(w, r, logEntry, blockedResp, "blocked")
+			return
 		}
+	}
+
+	// Query upstream servers within the receiving listener's budget
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.ListenerTimeout(listenerNetwork(w)))
+	var late <-chan upstream.QueryResult
+	defer func() {
+		// Upstreams still queried for the log are canceled once it is written
+		if late == nil {
+			cancel()
+		}
+	}()
 
-		logEntry.Upstreams = append(logEntry.Upstreams, attempt)
+	upstreamStart := time.Now()
+	result, allResults, late := s.queryUpstreams(ctx, withoutSourceOption(r))
+	if s.config.LogAttemptsWait <= 0 {
+		late = nil
 	}
+	s.recordQueryOutcome(result.Error != nil)
+	logEntry.Timings = &types.Timings{
+		Local:    types.DurationToMilliseconds(upstreamStart.Sub(start)),
+		Upstream: types.DurationToMilliseconds(time.Since(upstreamStart)),
+	}
+
+	// Convert upstream results to log format
+	s.appendAttempts(&logEntry, allResults, result)
 
 	if result.Error == nil && result.Response != nil {
+		result.Response = s.completeCNAMEChain(ctx, logEntry, result.Response, question)
+		var withinLimit bool
+		result.Response, withinLimit = s.limitCNAMEChain(logEntry, result.Response, question)
+		if !withinLimit {
+			// Also stops the upstreams still being queried
+			late = nil
+			s.answerWithRcode(w, r, logEntry, dns.RcodeServerFailure, "cname_chain_too_long")
+			return
+		}
+		if rewrite.Apply(result.Response, s.config.RewriteRules) {
+			s.logger.Debug("Rewrote upstream answer", map[string]interface{}{
+				"uuid":  requestUUID,
+				"query": logEntry.Request.Query,
+			})
+		}
+
 		// Successful response
 		logEntry.Response = &types.ResponseInfo{
 			Upstream:    result.Server,
@@ -179,37 +364,51 @@ func (s *DNSServer) handleDNSRequest(w dns.ResponseWriter, r *dns.Msg) {
 			RTT:         types.DurationToMilliseconds(result.RTT),
 		}
 
-		logEntry.Answers = types.ExtractAnswers(result.Response.Answer)
+		logEntry.Answers = s.logAnswers(result.Response.Answer)
 		logEntry.IPAddresses = types.ExtractIPAddresses(result.Response.Answer)
 		logEntry.Status = "success"
-		logEntry.Duration = types.DurationToMilliseconds(time.Since(start))
-
-		s.logger.LogDNSEntry(logEntry)
-		s.logger.LogRequestResponse(requestUUID, clientAddr, question.Name,
-			dns.TypeToString[question.Qtype], "success",
-			types.DurationToMilliseconds(time.Since(start)), result.Server)
 
 		// Forward the response back to the client
-		if err := w.WriteMsg(result.Response); err != nil {
+		clientResp := result.Response
+		clientResp.Id = r.Id
+		if s.config.MinimizeResponses {
+			clientResp = minimizeResponse(clientResp)
+		}
+		if family := s.preferredFamily(); family != 0 {
+			clientResp = orderAnswers(clientResp, family)
+		}
+		reportSource(w, r, clientResp, "success", result.Server)
+		writeStart := time.Now()
+		if err := w.WriteMsg(clientResp); err != nil {
 			s.logger.Error("Failed to write response", map[string]interface{}{
 				"uuid":   requestUUID,
 				"client": clientAddr,
 				"error":  err.Error(),
 			})
 		}
+		logEntry.Timings.Write = types.DurationToMilliseconds(time.Since(writeStart))
+
+		logEntry.Duration = types.DurationToMilliseconds(time.Since(start))
+		s.warnIfSlow(logEntry, time.Since(start))
+
+		if late != nil {
+			s.logWithLateAttempts(logEntry, result, late, cancel)
+		} else {
+			s.logger.LogDNSEntry(logEntry)
+		}
+		s.logger.LogRequestResponse(requestUUID, clientAddr, logEntry.Request.Query,
+			dns.TypeToString[question.Qtype], "success",
+			types.DurationToMilliseconds(time.Since(start)), result.Server)
 		return
 	}
 
 	// All upstreams failed
 	logEntry.Status = "all_upstreams_failed"
-	logEntry.Duration = types.DurationToMilliseconds(time.Since(start))
-	s.logger.LogJSON(logEntry)
-	s.logger.LogRequestResponse(requestUUID, clientAddr, question.Name,
-		dns.TypeToString[question.Qtype], "all_upstreams_failed",
-		types.DurationToMilliseconds(time.Since(start)), "none")
 
 	msg := &dns.Msg{}
 	msg.SetRcode(r, dns.RcodeServerFailure)
+	reportSource(w, r, msg, logEntry.Status, "")
+	writeStart := time.Now()
 	if err := w.WriteMsg(msg); err != nil {
 		s.logger.Error("Failed to write SERVFAIL", map[string]interface{}{
 			"uuid":   requestUUID,
@@ -217,42 +416,307 @@ func (s *DNSServer) handleDNSRequest(w dns.ResponseWriter, r *dns.Msg) {
 			"error":  err.Error(),
 		})
 	}
+	logEntry.Timings.Write = types.DurationToMilliseconds(time.Since(writeStart))
+
+	logEntry.Duration = types.DurationToMilliseconds(time.Since(start))
+	s.warnIfSlow(logEntry, time.Since(start))
+	s.logger.LogJSON(logEntry)
+	s.logger.LogRequestResponse(requestUUID, clientAddr, logEntry.Request.Query,
+		dns.TypeToString[question.Qtype], "all_upstreams_failed",
+		types.DurationToMilliseconds(time.Since(start)), "none")
+}
+
+// specialUseAction returns the configured action and log status for a name under
+// a local-only or special-use domain (RFC 6761), or an empty action when the name
+// should be resolved normally. Local-only domains take precedence; otherwise the
+// most specific special-use domain wins.
+func (s *DNSServer) specialUseAction(name string) (string, string) {
+	name = strings.ToLower(dns.Fqdn(name))
+	for _, domain := range s.config.LocalDomains {
+		if dns.IsSubDomain(domain, name) {
+			return s.config.LocalDomainPolicy, "local_domain"
+		}
+	}
+
+	action, matched := "", ""
+	for domain, domainAction := range s.config.SpecialUseDomains {
+		if dns.IsSubDomain(domain, name) && len(domain) > len(matched) {
+			action, matched = domainAction, domain
+		}
+	}
+	return action, "special_use_domain"
+}
+
+// queryUpstreams queries the upstream servers, or the servers of the stub zone
+// or upstream route of the name, repeating the whole query after a short delay
+// when every server failed, as long as the budget allows. Results of servers
+// still being queried after the answer was chosen arrive on the returned
+// channel, see upstream.Manager.QueryCollect.
+func (s *DNSServer) queryUpstreams(ctx context.Context, r *dns.Msg) (*upstream.QueryResult, []upstream.QueryResult, <-chan upstream.QueryResult) {
+	manager := s.managerFor(r.Question[0].Name)
+	result, allResults, late := manager.QueryCollect(ctx, r)
+
+	for retry := 0; retry < s.config.QueryRetries && result.Error != nil; retry++ {
+		select {
+		case <-ctx.Done():
+			return result, allResults, late
+		case <-time.After(s.config.QueryRetryDelay):
+		}
+
+		var retryResults []upstream.QueryResult
+		result, retryResults, late = manager.QueryCollect(ctx, r)
+		allResults = append(allResults, retryResults...)
+	}
+
+	return result, allResults, late
+}
+
+// warnIfSlow logs a warning when answering a query took longer than the
+// configured slow query threshold
+func (s *DNSServer) warnIfSlow(logEntry types.LogEntry, duration time.Duration) {
+	if s.config.SlowQueryThreshold <= 0 || duration <= s.config.SlowQueryThreshold {
+		return
+	}
+
+	upstream := "none"
+	if logEntry.Response != nil {
+		upstream = logEntry.Response.Upstream
+	}
+
+	s.logger.Warn("Slow DNS response", map[string]interface{}{
+		"uuid":        logEntry.UUID,
+		"client":      logEntry.Request.Client,
+		"query":       logEntry.Request.Query,
+		"type":        logEntry.Request.Type,
+		"upstream":    upstream,
+		"status":      logEntry.Status,
+		"duration_ms": types.DurationToMilliseconds(duration),
+		"threshold":   s.config.SlowQueryThreshold.String(),
+	})
+}
+
+// logStateChange logs an upstream server moving between health states, as a
+// warning when it becomes unhealthy
+func (s *DNSServer) logStateChange(change upstream.StateChange) {
+	fields := map[string]interface{}{
+		"server":   change.Server,
+		"from":     change.From.String(),
+		"to":       change.To.String(),
+		"failures": change.FailureCount,
+	}
+
+	if change.To == upstream.StateUnhealthy {
+		s.logger.Warn("Upstream health state changed", fields)
+		s.alertIfUpstreamsDown()
+		return
+	}
+	s.logger.Info("Upstream health state changed", fields)
+}
+
+// preferredFamily returns the address record type to list first in answers, or
+// 0 when answers keep their original order
+func (s *DNSServer) preferredFamily() uint16 {
+	switch s.config.AnswerOrder {
+	case config.AnswerOrderIPv4First:
+		return dns.TypeA
+	case config.AnswerOrderIPv6First:
+		return dns.TypeAAAA
+	}
+	return 0
+}
+
+// isAllowed reports whether name is an allowlisted domain or one of its subdomains
+func (s *DNSServer) isAllowed(name string) bool {
+	name = strings.ToLower(dns.Fqdn(name))
+	for _, domain := range s.config.AllowedDomains {
+		if dns.IsSubDomain(domain, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// privateRanges are the RFC 1918 and unique local (RFC 4193) address ranges
+var privateRanges = []netip.Prefix{
+	netip.MustParsePrefix("10.0.0.0/8"),
+	netip.MustParsePrefix("172.16.0.0/12"),
+	netip.MustParsePrefix("192.168.0.0/16"),
+	netip.MustParsePrefix("fc00::/7"),
+}
+
+// isPrivateReverseName reports whether an in-addr.arpa or ip6.arpa name lies
+// within a private address range. Partial names (e.g. 168.192.in-addr.arpa.)
+// match when the whole network they cover is private.
+func isPrivateReverseName(name string) bool {
+	prefix, ok := reverseNamePrefix(name)
+	if !ok {
+		return false
+	}
+	for _, private := range privateRanges {
+		if prefix.Bits() >= private.Bits() && private.Contains(prefix.Addr()) {
+			return true
+		}
+	}
+	return false
+}
+
+// reverseNamePrefix converts a reverse lookup name to the network it covers
+func reverseNamePrefix(name string) (netip.Prefix, bool) {
+	name = strings.ToLower(dns.Fqdn(name))
+
+	switch {
+	case strings.HasSuffix(name, ".in-addr.arpa."):
+		labels := dns.SplitDomainName(strings.TrimSuffix(name, ".in-addr.arpa."))
+		if len(labels) > 4 {
+			return netip.Prefix{}, false
+		}
+		var addr [4]byte
+		for i, label := range labels {
+			octet, err := strconv.ParseUint(label, 10, 8)
+			if err != nil {
+				return netip.Prefix{}, false
+			}
+			addr[len(labels)-1-i] = byte(octet)
+		}
+		return netip.PrefixFrom(netip.AddrFrom4(addr), len(labels)*8), true
+
+	case strings.HasSuffix(name, ".ip6.arpa."):
+		labels := dns.SplitDomainName(strings.TrimSuffix(name, ".ip6.arpa."))
+		if len(labels) > 32 {
+			return netip.Prefix{}, false
+		}
+		var addr [16]byte
+		for i, label := range labels {
+			nibble, err := strconv.ParseUint(label, 16, 4)
+			if err != nil || len(label) != 1 {
+				return netip.Prefix{}, false
+			}
+			pos := len(labels) - 1 - i
+			if pos%2 == 0 {
+				addr[pos/2] |= byte(nibble) << 4
+			} else {
+				addr[pos/2] |= byte(nibble)
+			}
+		}
+		return netip.PrefixFrom(netip.AddrFrom16(addr), len(labels)*4), true
+	}
+
+	return netip.Prefix{}, false
+}
+
+// listenerNetwork returns the network ("udp" or "tcp") of the listener that received the query
+func listenerNetwork(w dns.ResponseWriter) string {
+	if _, ok := w.LocalAddr().(*net.TCPAddr); ok {
+		return "tcp"
+	}
+	return "udp"
+}
+
+// answerLocally sends a response synthesized by the server itself and logs the
+// request under the given status
+func (s *DNSServer) answerLocally(w dns.ResponseWriter, r *dns.Msg, logEntry types.LogEntry, resp *dns.Msg, status string) {
+	resp.Id = r.Id
+
+	logEntry.Status = status
+	logEntry.Response = &types.ResponseInfo{
+		Upstream:    "local",
+		Rcode:       dns.RcodeToString[resp.Rcode],
+		AnswerCount: len(resp.Answer),
+	}
+	logEntry.Answers = s.logAnswers(resp.Answer)
+	logEntry.IPAddresses = types.ExtractIPAddresses(resp.Answer)
+	logEntry.Duration = types.DurationToMilliseconds(time.Since(logEntry.Timestamp))
+
+	s.logger.LogDNSEntry(logEntry)
+	s.logger.LogRequestResponse(logEntry.UUID, logEntry.Request.Client, logEntry.Request.Query,
+		logEntry.Request.Type, status, logEntry.Duration, "local")
+
+	reportSource(w, r, resp, status, "local")
+	if err := w.WriteMsg(resp); err != nil {
+		s.logger.Error("Failed to write response", map[string]interface{}{
+			"uuid":   logEntry.UUID,
+			"client": logEntry.Request.Client,
+			"error":  err.Error(),
+		})
+	}
+}
+
+// answerWithRcode answers the query locally with an empty response carrying the
+// given rcode and logs the request under the given status
+func (s *DNSServer) answerWithRcode(w dns.ResponseWriter, r *dns.Msg, logEntry types.LogEntry, rcode int, status string) {
+	msg := &dns.Msg{}
+	msg.SetRcode(r, rcode)
+
+	logEntry.Status = status
+	logEntry.Response = &types.ResponseInfo{
+		Upstream: "local",
+		Rcode:    dns.RcodeToString[rcode],
+	}
+	logEntry.Duration = types.DurationToMilliseconds(time.Since(logEntry.Timestamp))
+
+	s.logger.LogDNSEntry(logEntry)
+	s.logger.LogRequestResponse(logEntry.UUID, logEntry.Request.Client, logEntry.Request.Query,
+		logEntry.Request.Type, status, logEntry.Duration, "local")
+
+	reportSource(w, r, msg, status, "local")
+	if err := w.WriteMsg(msg); err != nil {
+		s.logger.Error("Failed to write response", map[string]interface{}{
+			"uuid":   logEntry.UUID,
+			"client": logEntry.Request.Client,
+			"error":  err.Error(),
+		})
+	}
 }
 
 // Start begins the DNS server with all improvements
 func (s *DNSServer) Start(ctx context.Context) error {
 	// Start background services
 	s.upstreamMgr.StartHealthChecks(s.config.HealthCheckInterval)
+	for _, route := range s.zoneRoutes {
+		route.manager.StartHealthChecks(s.config.HealthCheckInterval)
+	}
 
 	// Start custom DNS configuration watcher
 	s.startCustomDNSWatcher(ctx)
 
-	// Setup DNS handler
-	dns.HandleFunc(".", s.handleDNSRequest)
-
-	// Setup UDP server
-	s.server = &dns.Server{
-		Addr: net.JoinHostPort(s.config.ListenAddress, s.config.Port),
-		Net:  "udp",
+	// Setup DNS handler, optionally served by a fixed-size worker pool
+	var handler dns.Handler = dns.HandlerFunc(s.handleDNSRequest)
+	if s.config.Workers > 0 {
+		s.pool = newWorkerPool(handler, s.config.Workers, s.config.WorkerQueueLength())
+		handler = s.pool
+	}
+	dns.Handle(".", handler)
+
+	// Setup UDP server, and a TCP server on the same address sharing the handler
+	addr := net.JoinHostPort(s.config.ListenAddress, s.config.Port)
+	s.server = s.newListener(addr, "udp")
+	servers := []*dns.Server{s.server}
+	if s.config.ListenTCP {
+		s.tcpServer = s.newListener(addr, "tcp")
+		servers = append(servers, s.tcpServer)
 	}
 
 	s.logger.Info("Starting DNS server", map[string]interface{}{
 		"address":   s.config.ListenAddress,
 		"port":      s.config.Port,
+		"tcp":       s.config.ListenTCP,
 		"upstreams": strings.Join(s.config.UpstreamDNS, ", "),
 		"version":   version.Get().Short(),
 	})
 
-	// Start server in goroutine
-	s.wg.Add(1)
-	go func() {
-		defer s.wg.Done()
-		if err := s.server.ListenAndServe(); err != nil {
-			s.logger.Error("DNS server error", map[string]interface{}{
-				"error": err.Error(),
-			})
-		}
-	}()
+	// Start servers in goroutines
+	for _, server := range servers {
+		s.wg.Add(1)
+		go func(server *dns.Server) {
+			defer s.wg.Done()
+			if err := server.ListenAndServe(); err != nil {
+				s.logger.Error("DNS server error", map[string]interface{}{
+					"net":   server.Net,
+					"error": err.Error(),
+				})
+			}
+		}(server)
+	}
 
 	// Wait for context cancellation or shutdown signal
 	select {
@@ -269,17 +733,29 @@ func (s *DNSServer) Shutdown(ctx context.Context) error {
 
 	// Stop background services
 	s.upstreamMgr.StopHealthChecks()
+	for _, route := range s.zoneRoutes {
+		route.manager.StopHealthChecks()
+	}
 
-	// Shutdown server with timeout
-	if s.server != nil {
-		if err := s.server.ShutdownContext(ctx); err != nil {
+	// Shutdown servers with timeout
+	for _, server := range []*dns.Server{s.server, s.tcpServer} {
+		if server == nil {
+			continue
+		}
+		if err := server.ShutdownContext(ctx); err != nil {
 			s.logger.Error("Error shutting down server", map[string]interface{}{
+				"net":   server.Net,
 				"error": err.Error(),
 			})
 			return err
 		}
 	}
 
+	// Stop request workers once the listener no longer accepts queries
+	if s.pool != nil {
+		s.pool.Stop()
+	}
+
 	// Signal shutdown to other goroutines
 	close(s.shutdown)
 
@@ -287,6 +763,8 @@ func (s *DNSServer) Shutdown(ctx context.Context) error {
 	done := make(chan struct{})
 	go func() {
 		s.wg.Wait()
+		s.alerts.Wait()
+		s.canaryAlerts.Wait()
 		close(done)
 	}()
 
@@ -298,6 +776,22 @@ func (s *DNSServer) Shutdown(ctx context.Context) error {
 	}
 }
 
+// shutdownContext returns the context bounding graceful shutdown
+func (s *DNSServer) shutdownContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), s.config.ShutdownTimeout)
+}
+
+// scheduleForceExit calls exit(1) once the force-exit timeout has passed, in
+// case graceful shutdown hangs
+func (s *DNSServer) scheduleForceExit(exit func(int)) *time.Timer {
+	return time.AfterFunc(s.config.ForceExitTimeout, func() {
+		s.logger.Error("Force shutdown after timeout", map[string]interface{}{
+			"timeout": s.config.ForceExitTimeout.String(),
+		})
+		exit(1)
+	})
+}
+
 // startCustomDNSWatcher starts a background goroutine that monitors the custom DNS configuration file
 // for changes and reloads the resolver mappings when the file is modified
 func (s *DNSServer) startCustomDNSWatcher(ctx context.Context) {
@@ -358,8 +852,10 @@ func (s *DNSServer) GetStats() map[string]interface{} {
 	upstreamStats := s.upstreamMgr.GetStats()
 
 	return map[string]interface{}{
-		"version":   version.Get().Short(),
-		"upstreams": upstreamStats,
+		"version":            version.Get().Short(),
+		"upstreams":          upstreamStats,
+		"in_flight_requests": atomic.LoadInt64(&s.inFlight),
+		"max_concurrent":     s.config.MaxConcurrent,
 	}
 }
 
@@ -393,13 +889,14 @@ func run() error {
 	}
 
 	// Setup logging
-	logger, jsonFile, humanFile, err := logging.NewFromConfig(cfg.LogFile, cfg.LogLevel)
+	logger, jsonFile, humanFile, err := logging.NewFromConfig(cfg.LogFile, cfg.LogLevel, int64(cfg.LogMaxSize)<<20, cfg.LogMaxBackups)
 	if err != nil {
 		return fmt.Errorf("failed to setup logging: %w", err)
 	}
 
-	// Ensure log files are closed on exit
+	// Ensure buffered log entries are written and log files are closed on exit
 	defer func() {
+		logger.Close()
 		if jsonFile != nil {
 			logger.Info("Closing log files", nil)
 			jsonFile.Close()
@@ -428,13 +925,15 @@ func run() error {
 		})
 		cancel()
 
-		// Force shutdown after 30 seconds
-		time.AfterFunc(30*time.Second, func() {
-			logger.Error("Force shutdown after timeout", nil)
-			os.Exit(1)
-		})
+		// Force shutdown if graceful shutdown takes too long
+		server.scheduleForceExit(os.Exit)
 	}()
 
+	// Reload mappings and the blocklist on SIGHUP
+	reloadChan := make(chan os.Signal, 1)
+	signal.Notify(reloadChan, syscall.SIGHUP)
+	go server.reloadOnSignal(ctx, reloadChan)
+
 	// Log startup information
 	versionInfo := version.Get()
 	startupConfig := map[string]interface{}{
@@ -451,6 +950,14 @@ func run() error {
 		startupConfig["custom_dns_mappings"] = cfg.CustomDNS
 	}
 
+	if cfg.BlocklistFile != "" {
+		startupConfig["blocked_domains"] = len(cfg.BlockedDomains)
+	}
+
+	if len(cfg.CanaryDomains) > 0 {
+		startupConfig["canary_domains"] = cfg.CanaryDomains
+	}
+
 	logger.Info("DNS Proxy Server starting", map[string]interface{}{
 		"version": versionInfo.String(),
 		"config":  startupConfig,
@@ -493,7 +1000,7 @@ func run() error {
 	}
 
 	// Graceful shutdown
-	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	shutdownCtx, shutdownCancel := server.shutdownContext()
 	defer shutdownCancel()
 
 	if err := server.Shutdown(shutdownCtx); err != nil {
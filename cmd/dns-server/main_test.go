@@ -0,0 +1,1482 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"dns-go/internal/alert"
+	"dns-go/internal/config"
+	"dns-go/internal/logging"
+	"dns-go/internal/types"
+	"dns-go/internal/upstream/testutil"
+
+	"github.com/miekg/dns"
+)
+
+// testResponseWriter captures the message written by the handler
+type testResponseWriter struct {
+	msg    *dns.Msg
+	remote net.Addr
+	local  net.Addr
+}
+
+func newTestResponseWriter() *testResponseWriter {
+	return &testResponseWriter{
+		remote: &net.UDPAddr{IP: net.ParseIP("192.0.2.100"), Port: 53000},
+		local:  &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 53},
+	}
+}
+
+func (w *testResponseWriter) LocalAddr() net.Addr       { return w.local }
+func (w *testResponseWriter) RemoteAddr() net.Addr      { return w.remote }
+func (w *testResponseWriter) WriteMsg(m *dns.Msg) error { w.msg = m; return nil }
+func (w *testResponseWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+func (w *testResponseWriter) Close() error        { return nil }
+func (w *testResponseWriter) TsigStatus() error   { return nil }
+func (w *testResponseWriter) TsigTimersOnly(bool) {}
+func (w *testResponseWriter) Hijack()             {}
+
+// newTestServer creates a DNS server forwarding to a fake upstream. The returned
+// buffer receives the JSON request log.
+func newTestServer(t testing.TB, upstream *testutil.Server, configure func(*config.Config)) (*DNSServer, *bytes.Buffer) {
+	t.Helper()
+
+	cfg := config.DefaultConfig()
+	cfg.UpstreamDNS = []string{upstream.Addr}
+	cfg.Timeout = 200 * time.Millisecond
+	if configure != nil {
+		configure(cfg)
+	}
+
+	logs := &bytes.Buffer{}
+	return NewDNSServer(cfg, logging.New(logs, logging.ERROR)), logs
+}
+
+// query sends a single question through the handler and returns the reply
+func query(s *DNSServer, name string, qtype uint16) *dns.Msg {
+	req := new(dns.Msg)
+	req.SetQuestion(dns.Fqdn(name), qtype)
+
+	w := newTestResponseWriter()
+	s.handleDNSRequest(w, req)
+	return w.msg
+}
+
+func TestHandleDNSRequest_LocalDomainRefused(t *testing.T) {
+	upstream := testutil.NewServer(t, testutil.Answer("192.0.2.1", 60))
+	s, _ := newTestServer(t, upstream, nil)
+
+	resp := query(s, "printer.local", dns.TypeA)
+	if resp == nil || resp.Rcode != dns.RcodeRefused {
+		t.Fatalf("Expected REFUSED for .local query, got %v", resp)
+	}
+	if upstream.Queries() != 0 {
+		t.Errorf("Expected .local query not to be forwarded, upstream saw %d queries", upstream.Queries())
+	}
+}
+
+func TestHandleDNSRequest_LocalDomainPolicies(t *testing.T) {
+	tests := []struct {
+		name          string
+		policy        string
+		wantRcode     int
+		wantForwarded bool
+	}{
+		{name: "nxdomain", policy: config.LocalDomainPolicyNXDomain, wantRcode: dns.RcodeNameError},
+		{name: "forward", policy: config.LocalDomainPolicyForward, wantRcode: dns.RcodeSuccess, wantForwarded: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := testutil.NewServer(t, testutil.Answer("192.0.2.1", 60))
+			s, _ := newTestServer(t, upstream, func(cfg *config.Config) {
+				cfg.LocalDomainPolicy = tt.policy
+			})
+
+			resp := query(s, "printer.local", dns.TypeA)
+			if resp == nil || resp.Rcode != tt.wantRcode {
+				t.Fatalf("Expected rcode %s, got %v", dns.RcodeToString[tt.wantRcode], resp)
+			}
+			if forwarded := upstream.Queries() > 0; forwarded != tt.wantForwarded {
+				t.Errorf("Expected forwarded=%v, upstream saw %d queries", tt.wantForwarded, upstream.Queries())
+			}
+		})
+	}
+}
+
+func TestHandleDNSRequest_LocalDomainMapped(t *testing.T) {
+	upstream := testutil.NewServer(t, testutil.Answer("192.0.2.1", 60))
+	s, _ := newTestServer(t, upstream, func(cfg *config.Config) {
+		cfg.CustomDNS["nas.local."] = "192.168.0.10"
+	})
+
+	resp := query(s, "nas.local", dns.TypeA)
+	if resp == nil || len(resp.Answer) != 1 {
+		t.Fatalf("Expected custom mapping answer, got %v", resp)
+	}
+	if a := resp.Answer[0].(*dns.A); a.A.String() != "192.168.0.10" {
+		t.Errorf("Expected 192.168.0.10, got %s", a.A)
+	}
+	if upstream.Queries() != 0 {
+		t.Errorf("Expected mapped .local query not to be forwarded, upstream saw %d queries", upstream.Queries())
+	}
+}
+
+func TestHandleDNSRequest_Forwarded(t *testing.T) {
+	upstream := testutil.NewServer(t, testutil.Answer("192.0.2.1", 60))
+	s, _ := newTestServer(t, upstream, nil)
+
+	resp := query(s, "example.com", dns.TypeA)
+	if resp == nil || resp.Rcode != dns.RcodeSuccess || len(resp.Answer) != 1 {
+		t.Fatalf("Expected forwarded answer, got %v", resp)
+	}
+	if upstream.Queries() != 1 {
+		t.Errorf("Expected 1 upstream query, got %d", upstream.Queries())
+	}
+}
+
+func TestHandleDNSRequest_SpecialUseDomains(t *testing.T) {
+	upstream := testutil.NewServer(t, testutil.Answer("192.0.2.1", 60))
+	s, _ := newTestServer(t, upstream, nil)
+
+	resp := query(s, "localhost", dns.TypeA)
+	if resp == nil || len(resp.Answer) != 1 {
+		t.Fatalf("Expected loopback answer for localhost, got %v", resp)
+	}
+	if a := resp.Answer[0].(*dns.A); !a.A.Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Errorf("Expected 127.0.0.1, got %s", a.A)
+	}
+
+	resp = query(s, "app.localhost", dns.TypeAAAA)
+	if resp == nil || len(resp.Answer) != 1 {
+		t.Fatalf("Expected loopback answer for app.localhost, got %v", resp)
+	}
+	if aaaa := resp.Answer[0].(*dns.AAAA); !aaaa.AAAA.Equal(net.IPv6loopback) {
+		t.Errorf("Expected ::1, got %s", aaaa.AAAA)
+	}
+
+	resp = query(s, "foo.invalid", dns.TypeA)
+	if resp == nil || resp.Rcode != dns.RcodeNameError {
+		t.Fatalf("Expected NXDOMAIN for .invalid, got %v", resp)
+	}
+
+	resp = query(s, "hidden.onion", dns.TypeA)
+	if resp == nil || resp.Rcode != dns.RcodeRefused {
+		t.Fatalf("Expected REFUSED for .onion, got %v", resp)
+	}
+
+	if upstream.Queries() != 0 {
+		t.Errorf("Expected special-use queries not to be forwarded, upstream saw %d queries", upstream.Queries())
+	}
+
+	resp = query(s, "www.example", dns.TypeA)
+	if resp == nil || resp.Rcode != dns.RcodeSuccess || upstream.Queries() != 1 {
+		t.Errorf("Expected .example to be resolved upstream, got %v", resp)
+	}
+}
+
+func TestHandleDNSRequest_ListenerTimeout(t *testing.T) {
+	upstream := testutil.NewServer(t, testutil.Drop())
+	s, _ := newTestServer(t, upstream, func(cfg *config.Config) {
+		cfg.Timeout = 2 * time.Second
+		cfg.TCPTimeout = 50 * time.Millisecond
+	})
+
+	req := new(dns.Msg)
+	req.SetQuestion("example.com.", dns.TypeA)
+
+	w := newTestResponseWriter()
+	w.local = &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 53}
+	w.remote = &net.TCPAddr{IP: net.ParseIP("192.0.2.100"), Port: 53000}
+
+	start := time.Now()
+	s.handleDNSRequest(w, req)
+	elapsed := time.Since(start)
+
+	if w.msg == nil || w.msg.Rcode != dns.RcodeServerFailure {
+		t.Fatalf("Expected SERVFAIL, got %v", w.msg)
+	}
+	if elapsed > time.Second {
+		t.Errorf("Expected TCP query to give up within the TCP budget, took %v", elapsed)
+	}
+}
+
+func TestGetStats_InFlightRequests(t *testing.T) {
+	release := make(chan struct{})
+	answer := testutil.Answer("192.0.2.1", 60)
+	upstream := testutil.NewServer(t, func(req *dns.Msg) *dns.Msg {
+		<-release
+		return answer(req)
+	})
+	s, _ := newTestServer(t, upstream, func(cfg *config.Config) {
+		cfg.Timeout = 2 * time.Second
+	})
+
+	const concurrent = 3
+	var wg sync.WaitGroup
+	for i := 0; i < concurrent; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			query(s, "example.com", dns.TypeA)
+		}()
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for s.GetStats()["in_flight_requests"].(int64) != concurrent {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d in-flight requests, got %v", concurrent, s.GetStats()["in_flight_requests"])
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	close(release)
+	wg.Wait()
+
+	if got := s.GetStats()["in_flight_requests"].(int64); got != 0 {
+		t.Errorf("Expected 0 in-flight requests after completion, got %d", got)
+	}
+}
+
+func TestHandleDNSRequest_MinimizeResponses(t *testing.T) {
+	verbose := func(req *dns.Msg) *dns.Msg {
+		resp := testutil.Answer("192.0.2.1", 60)(req)
+		ns, _ := dns.NewRR("example.com. 3600 IN NS ns1.example.com.")
+		glue, _ := dns.NewRR("ns1.example.com. 3600 IN A 192.0.2.53")
+		resp.Ns = append(resp.Ns, ns)
+		resp.Extra = append(resp.Extra, glue)
+		resp.SetEdns0(1232, false)
+		return resp
+	}
+
+	for _, minimize := range []bool{false, true} {
+		upstream := testutil.NewServer(t, verbose)
+		s, _ := newTestServer(t, upstream, func(cfg *config.Config) {
+			cfg.MinimizeResponses = minimize
+		})
+
+		resp := query(s, "example.com", dns.TypeA)
+		if resp == nil || len(resp.Answer) != 1 {
+			t.Fatalf("minimize=%v: expected 1 answer, got %v", minimize, resp)
+		}
+
+		if !minimize {
+			if len(resp.Ns) != 1 || len(resp.Extra) != 2 {
+				t.Errorf("Expected full response, got ns=%d extra=%d", len(resp.Ns), len(resp.Extra))
+			}
+			continue
+		}
+
+		if len(resp.Ns) != 0 {
+			t.Errorf("Expected authority section stripped, got %v", resp.Ns)
+		}
+		if len(resp.Extra) != 1 || resp.IsEdns0() == nil {
+			t.Errorf("Expected only the OPT record in additional section, got %v", resp.Extra)
+		}
+	}
+}
+
+func TestHandleDNSRequest_Allowlist(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     string
+		wantRcode int
+	}{
+		{name: "allowlisted domain", query: "example.com", wantRcode: dns.RcodeSuccess},
+		{name: "allowlisted subdomain", query: "www.Example.com", wantRcode: dns.RcodeSuccess},
+		{name: "not listed", query: "example.org", wantRcode: dns.RcodeRefused},
+		{name: "suffix is not a subdomain", query: "notexample.com", wantRcode: dns.RcodeRefused},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := testutil.NewServer(t, testutil.Answer("192.0.2.1", 60))
+			s, logs := newTestServer(t, upstream, func(cfg *config.Config) {
+				cfg.AllowedDomains = []string{"example.com."}
+			})
+
+			resp := query(s, tt.query, dns.TypeA)
+			if resp == nil || resp.Rcode != tt.wantRcode {
+				t.Fatalf("Expected rcode %s, got %v", dns.RcodeToString[tt.wantRcode], resp)
+			}
+			if tt.wantRcode == dns.RcodeRefused {
+				if upstream.Queries() != 0 {
+					t.Errorf("Expected refused query not to be forwarded, upstream saw %d queries", upstream.Queries())
+				}
+				if !bytes.Contains(logs.Bytes(), []byte("refused_not_allowed")) {
+					t.Errorf("Expected refused_not_allowed status in logs, got %s", logs.String())
+				}
+			}
+		})
+	}
+}
+
+func TestHandleDNSRequest_Blocklist(t *testing.T) {
+	upstream := testutil.NewServer(t, testutil.Answer("192.0.2.1", 60))
+	s, logs := newTestServer(t, upstream, func(cfg *config.Config) {
+		cfg.BlockedDomains = []string{"ads.example."}
+	})
+
+	resp := query(s, "tracker.ads.example", dns.TypeA)
+	if resp == nil || resp.Rcode != dns.RcodeNameError {
+		t.Fatalf("Expected NXDOMAIN for a blocked subdomain, got %v", resp)
+	}
+	if upstream.Queries() != 0 {
+		t.Errorf("Expected blocked query not to be forwarded, upstream saw %d queries", upstream.Queries())
+	}
+	var entry types.LogEntry
+	if err := json.Unmarshal(firstLogLine(logs), &entry); err != nil {
+		t.Fatalf("Failed to decode log entry: %v", err)
+	}
+	if entry.Status != "blocked" {
+		t.Errorf("Expected status blocked, got %q", entry.Status)
+	}
+
+	resp = query(s, "example.com", dns.TypeA)
+	if resp == nil || len(resp.Answer) != 1 || upstream.Queries() != 1 {
+		t.Errorf("Expected a domain that is not blocked to be forwarded, got %v", resp)
+	}
+
+	// With a sinkhole, blocked names resolve to its addresses
+	s, _ = newTestServer(t, upstream, func(cfg *config.Config) {
+		cfg.BlockedDomains = []string{"ads.example."}
+		cfg.BlocklistSinkhole = []string{"0.0.0.0", "::"}
+	})
+	resp = query(s, "ads.example", dns.TypeAAAA)
+	if resp == nil || resp.Rcode != dns.RcodeSuccess || len(resp.Answer) != 1 {
+		t.Fatalf("Expected the sinkhole answer, got %v", resp)
+	}
+	if aaaa := resp.Answer[0].(*dns.AAAA); !aaaa.AAAA.Equal(net.IPv6unspecified) {
+		t.Errorf("Expected ::, got %s", aaaa.AAAA)
+	}
+}
+
+func TestHandleDNSRequest_CanaryDomain(t *testing.T) {
+	upstream := testutil.NewServer(t, testutil.Answer("192.0.2.1", 60))
+
+	alerts := make(chan alert.Alert, 4)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alert alert.Alert
+		if err := json.NewDecoder(r.Body).Decode(&alert); err != nil {
+			t.Errorf("Failed to decode canary alert: %v", err)
+		}
+		alerts <- alert
+	}))
+	defer webhook.Close()
+
+	s, _ := newTestServer(t, upstream, func(cfg *config.Config) {
+		cfg.CanaryDomains = []string{"canary.example."}
+		cfg.CanaryWebhook = webhook.URL
+	})
+
+	// Normal queries raise no alert
+	if resp := query(s, "example.com", dns.TypeA); resp == nil || len(resp.Answer) != 1 {
+		t.Fatalf("Expected a normal query to be resolved, got %v", resp)
+	}
+	s.canaryAlerts.Wait()
+	if len(alerts) != 0 {
+		t.Error("Expected no canary alert for a normal query")
+	}
+
+	// Canary queries raise an alert and still resolve normally
+	resp := query(s, "db.Canary.example", dns.TypeA)
+	if resp == nil || len(resp.Answer) != 1 || upstream.Queries() != 2 {
+		t.Fatalf("Expected the canary query to be resolved upstream, got %v", resp)
+	}
+	select {
+	case a := <-alerts:
+		if a.Event != alert.EventCanaryQuery || a.Details["query"] != "db.Canary.example." || a.Details["type"] != "A" || a.Details["client"] != "192.0.2.100" {
+			t.Errorf("Unexpected canary alert %+v", a)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the canary alert to be posted to the webhook")
+	}
+
+	// Repeated queries of the same client and name are suppressed
+	query(s, "db.canary.example", dns.TypeAAAA)
+	query(s, "www.canary.example", dns.TypeA)
+	s.canaryAlerts.Wait()
+	if len(alerts) != 1 {
+		t.Fatalf("Expected only the query of another name to raise an alert, got %d", len(alerts))
+	}
+	if a := <-alerts; a.Details["query"] != "www.canary.example." {
+		t.Errorf("Unexpected canary alert %+v", a)
+	}
+
+	// With a sinkhole, canary names resolve to its addresses
+	s, _ = newTestServer(t, upstream, func(cfg *config.Config) {
+		cfg.CanaryDomains = []string{"canary.example."}
+		cfg.CanarySinkhole = []string{"0.0.0.0"}
+	})
+	resp = query(s, "canary.example", dns.TypeA)
+	if resp == nil || len(resp.Answer) != 1 || upstream.Queries() != 4 {
+		t.Fatalf("Expected the sinkhole answer, got %v", resp)
+	}
+	if a := resp.Answer[0].(*dns.A); !a.A.Equal(net.IPv4zero) {
+		t.Errorf("Expected 0.0.0.0, got %s", a.A)
+	}
+}
+
+func TestHandleDNSRequest_StubZone(t *testing.T) {
+	upstream := testutil.NewServer(t, testutil.Answer("192.0.2.1", 60))
+
+	var sawRD atomic.Bool
+	answer := testutil.Answer("10.0.0.10", 60)
+	authoritative := testutil.NewServer(t, func(req *dns.Msg) *dns.Msg {
+		sawRD.Store(req.RecursionDesired)
+		resp := answer(req)
+		resp.Authoritative = true
+		return resp
+	})
+
+	s, _ := newTestServer(t, upstream, func(cfg *config.Config) {
+		cfg.StubZones = config.ZoneServers{"corp.internal.": {authoritative.Addr}}
+	})
+
+	resp := query(s, "host.Corp.internal", dns.TypeA)
+	if resp == nil || len(resp.Answer) != 1 {
+		t.Fatalf("Expected an answer from the stub zone, got %v", resp)
+	}
+	if a := resp.Answer[0].(*dns.A); !a.A.Equal(net.ParseIP("10.0.0.10")) {
+		t.Errorf("Expected 10.0.0.10, got %s", a.A)
+	}
+	if !resp.Authoritative {
+		t.Error("Expected the AA bit of the authoritative answer to be preserved")
+	}
+	if !resp.RecursionDesired {
+		t.Error("Expected the response to echo the client's RD bit")
+	}
+	if authoritative.Queries() != 1 || sawRD.Load() {
+		t.Errorf("Expected a single query without RD at the authoritative server, got %d (RD %v)", authoritative.Queries(), sawRD.Load())
+	}
+	if upstream.Queries() != 0 {
+		t.Errorf("Expected stub zone query not to reach the upstreams, upstream saw %d queries", upstream.Queries())
+	}
+
+	// Names outside the zone still go to the upstreams
+	resp = query(s, "example.com", dns.TypeA)
+	if resp == nil || len(resp.Answer) != 1 || upstream.Queries() != 1 || authoritative.Queries() != 1 {
+		t.Errorf("Expected example.com to be resolved by the upstreams, got %v", resp)
+	}
+}
+
+func TestHandleDNSRequest_DanglingCNAME(t *testing.T) {
+	answer := testutil.Answer("192.0.2.7", 60)
+	handler := func(req *dns.Msg) *dns.Msg {
+		if req.Question[0].Name == "edge.example.net." {
+			return answer(req)
+		}
+		// A CNAME chain without the address of its target
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		resp.Answer = append(resp.Answer, &dns.CNAME{
+			Hdr:    dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: 60},
+			Target: "edge.example.net.",
+		})
+		return resp
+	}
+
+	tests := []struct {
+		name    string
+		chase   bool
+		answers int
+		queries int
+	}{
+		{"passed through", false, 1, 1},
+		{"chased", true, 2, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := testutil.NewServer(t, handler)
+			s, _ := newTestServer(t, upstream, func(cfg *config.Config) {
+				cfg.ChaseDanglingCNAMEs = tt.chase
+			})
+
+			resp := query(s, "www.example.com", dns.TypeA)
+			if resp == nil || resp.Rcode != dns.RcodeSuccess || len(resp.Answer) != tt.answers {
+				t.Fatalf("Expected %d answer records, got %v", tt.answers, resp)
+			}
+			if _, ok := resp.Answer[0].(*dns.CNAME); !ok {
+				t.Errorf("Expected the answer to start with the CNAME, got %v", resp.Answer[0])
+			}
+			if tt.chase {
+				if a, ok := resp.Answer[1].(*dns.A); !ok || a.Hdr.Name != "edge.example.net." || !a.A.Equal(net.ParseIP("192.0.2.7")) {
+					t.Errorf("Expected the address of the CNAME target, got %v", resp.Answer[1])
+				}
+			}
+			if got := upstream.Queries(); got != tt.queries {
+				t.Errorf("Expected %d upstream queries, got %d", tt.queries, got)
+			}
+		})
+	}
+}
+
+func TestDanglingCNAME(t *testing.T) {
+	cname := func(name, target string) dns.RR {
+		return &dns.CNAME{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET}, Target: target}
+	}
+	a := &dns.A{Hdr: dns.RR_Header{Name: "c.example.", Rrtype: dns.TypeA, Class: dns.ClassINET}, A: net.ParseIP("192.0.2.1")}
+
+	tests := []struct {
+		name   string
+		qtype  uint16
+		rcode  int
+		answer []dns.RR
+		target string
+	}{
+		{"no CNAME", dns.TypeA, dns.RcodeSuccess, nil, ""},
+		{"complete chain", dns.TypeA, dns.RcodeSuccess, []dns.RR{cname("a.example.", "B.example."), cname("b.example.", "c.example."), a}, ""},
+		{"dangling chain", dns.TypeA, dns.RcodeSuccess, []dns.RR{cname("a.example.", "b.example."), cname("b.example.", "c.example.")}, "c.example."},
+		{"other type", dns.TypeAAAA, dns.RcodeSuccess, []dns.RR{cname("a.example.", "c.example."), a}, "c.example."},
+		{"CNAME query", dns.TypeCNAME, dns.RcodeSuccess, []dns.RR{cname("a.example.", "c.example.")}, ""},
+		{"NXDOMAIN target", dns.TypeA, dns.RcodeNameError, []dns.RR{cname("a.example.", "c.example.")}, ""},
+		{"unrelated CNAME", dns.TypeA, dns.RcodeSuccess, []dns.RR{cname("x.example.", "c.example.")}, ""},
+		{"loop", dns.TypeA, dns.RcodeSuccess, []dns.RR{cname("a.example.", "b.example."), cname("b.example.", "a.example.")}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &dns.Msg{Answer: tt.answer}
+			resp.Rcode = tt.rcode
+			target, dangling := danglingCNAME(resp, dns.Question{Name: "a.example.", Qtype: tt.qtype, Qclass: dns.ClassINET})
+			if target != tt.target || dangling != (tt.target != "") {
+				t.Errorf("Expected target %q, got %q (dangling %v)", tt.target, target, dangling)
+			}
+		})
+	}
+}
+
+func TestHandleDNSRequest_CNAMEChainLimit(t *testing.T) {
+	// www.example.com -> c1 -> c2 -> c3 -> c4, which has an address
+	handler := func(req *dns.Msg) *dns.Msg {
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		name := req.Question[0].Name
+		for i := 1; i <= 4; i++ {
+			target := fmt.Sprintf("c%d.example.net.", i)
+			resp.Answer = append(resp.Answer, &dns.CNAME{
+				Hdr:    dns.RR_Header{Name: name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: 60},
+				Target: target,
+			})
+			name = target
+		}
+		resp.Answer = append(resp.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.ParseIP("192.0.2.7"),
+		})
+		return resp
+	}
+
+	tests := []struct {
+		name    string
+		limit   int
+		policy  string
+		rcode   int
+		answers int
+		status  string
+	}{
+		{"within limit", 4, config.CNAMEChainPolicyReject, dns.RcodeSuccess, 5, "success"},
+		{"unlimited", 0, config.CNAMEChainPolicyReject, dns.RcodeSuccess, 5, "success"},
+		{"rejected", 3, config.CNAMEChainPolicyReject, dns.RcodeServerFailure, 0, "cname_chain_too_long"},
+		{"truncated", 2, config.CNAMEChainPolicyTruncate, dns.RcodeSuccess, 2, "success"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := testutil.NewServer(t, handler)
+			s, logs := newTestServer(t, upstream, func(cfg *config.Config) {
+				cfg.MaxCNAMEChain = tt.limit
+				cfg.CNAMEChainPolicy = tt.policy
+			})
+
+			resp := query(s, "www.example.com", dns.TypeA)
+			if resp == nil || resp.Rcode != tt.rcode || len(resp.Answer) != tt.answers {
+				t.Fatalf("Expected rcode %s with %d answer records, got %v", dns.RcodeToString[tt.rcode], tt.answers, resp)
+			}
+			if tt.policy == config.CNAMEChainPolicyTruncate {
+				last, ok := resp.Answer[len(resp.Answer)-1].(*dns.CNAME)
+				if !ok || last.Target != "c2.example.net." {
+					t.Errorf("Expected the answer to end in the CNAME to c2.example.net., got %v", resp.Answer)
+				}
+			}
+
+			var entry types.LogEntry
+			if err := json.Unmarshal(firstLogLine(logs), &entry); err != nil {
+				t.Fatalf("Failed to decode log entry: %v", err)
+			}
+			if entry.Status != tt.status {
+				t.Errorf("Expected status %q, got %q", tt.status, entry.Status)
+			}
+		})
+	}
+}
+
+func TestHandleDNSRequest_UpstreamRoute(t *testing.T) {
+	public := testutil.NewServer(t, testutil.Answer("192.0.2.1", 60))
+	internal := testutil.NewServer(t, testutil.Answer("10.0.0.10", 60))
+	lab := testutil.NewServer(t, testutil.Answer("10.1.0.10", 60))
+
+	s, _ := newTestServer(t, public, func(cfg *config.Config) {
+		cfg.UpstreamRoutes = config.ZoneServers{
+			"corp.internal.":     {internal.Addr},
+			"lab.corp.internal.": {lab.Addr},
+		}
+	})
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"host.Corp.internal", "10.0.0.10"},
+		{"corp.internal", "10.0.0.10"},
+		{"db.lab.corp.internal", "10.1.0.10"},
+		{"example.com", "192.0.2.1"},
+		{"notcorp.internal", "192.0.2.1"},
+	}
+	for _, tt := range tests {
+		resp := query(s, tt.name, dns.TypeA)
+		if resp == nil || len(resp.Answer) != 1 {
+			t.Fatalf("Expected an answer for %s, got %v", tt.name, resp)
+		}
+		if a := resp.Answer[0].(*dns.A); !a.A.Equal(net.ParseIP(tt.want)) {
+			t.Errorf("Expected %s to resolve to %s, got %s", tt.name, tt.want, a.A)
+		}
+		if !resp.RecursionDesired {
+			t.Errorf("Expected routed queries to keep the RD bit, got %v", resp)
+		}
+	}
+
+	if public.Queries() != 2 || internal.Queries() != 2 || lab.Queries() != 1 {
+		t.Errorf("Expected each query to reach only its route's servers, got public=%d internal=%d lab=%d",
+			public.Queries(), internal.Queries(), lab.Queries())
+	}
+}
+
+func TestHandleDNSRequest_OversizedMessage(t *testing.T) {
+	for _, network := range []string{"tcp", "udp"} {
+		t.Run(network, func(t *testing.T) {
+			upstream := testutil.NewServer(t, testutil.Answer("192.0.2.1", 60))
+			s, logs := newTestServer(t, upstream, func(cfg *config.Config) {
+				cfg.MaxMessageSize = 512
+			})
+
+			// Serve the handler through the listener the server starts, which
+			// checks the size of the raw message before parsing it
+			started := make(chan struct{})
+			server := s.newListener("127.0.0.1:0", network)
+			server.Handler = dns.HandlerFunc(s.handleDNSRequest)
+			server.NotifyStartedFunc = func() { close(started) }
+			go server.ListenAndServe()
+			<-started
+			defer server.Shutdown()
+
+			client := &dns.Client{Net: network, Timeout: time.Second}
+			var addr string
+			if network == "tcp" {
+				addr = server.Listener.Addr().String()
+			} else {
+				addr = server.PacketConn.LocalAddr().String()
+			}
+
+			req := new(dns.Msg)
+			req.SetQuestion("example.com.", dns.TypeA)
+			resp, _, err := client.Exchange(req, addr)
+			if err != nil {
+				t.Fatalf("Expected small query to be answered, got error: %v", err)
+			}
+			if resp.Rcode != dns.RcodeSuccess {
+				t.Fatalf("Expected NOERROR for small query, got %s", dns.RcodeToString[resp.Rcode])
+			}
+
+			oversized := new(dns.Msg)
+			oversized.SetQuestion("example.com.", dns.TypeA)
+			oversized.Extra = append(oversized.Extra, &dns.TXT{
+				Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeTXT, Class: dns.ClassINET},
+				Txt: []string{string(bytes.Repeat([]byte("x"), 255)), string(bytes.Repeat([]byte("y"), 255))},
+			})
+			resp, _, err = client.Exchange(oversized, addr)
+			if err != nil {
+				t.Fatalf("Expected oversized query to be answered, got error: %v", err)
+			}
+			if resp.Rcode != dns.RcodeFormatError || resp.Id != oversized.Id {
+				t.Errorf("Expected FORMERR for oversized query, got %s", dns.RcodeToString[resp.Rcode])
+			}
+			if upstream.Queries() != 1 {
+				t.Errorf("Expected oversized query not to be forwarded, upstream saw %d queries", upstream.Queries())
+			}
+			if !bytes.Contains(logs.Bytes(), []byte("oversized_message")) {
+				t.Errorf("Expected oversized_message status in logs, got %s", logs.String())
+			}
+		})
+	}
+}
+
+func TestHandleDNSRequest_MarksSuccessfulAttempt(t *testing.T) {
+	// The first upstream refuses the connection immediately, the second answers
+	// after a short delay, so the second attempt is the one that succeeds
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to reserve UDP port: %v", err)
+	}
+	closedAddr := conn.LocalAddr().String()
+	conn.Close()
+
+	answer := testutil.Answer("192.0.2.1", 60)
+	upstream := testutil.NewServer(t, func(req *dns.Msg) *dns.Msg {
+		time.Sleep(20 * time.Millisecond)
+		return answer(req)
+	})
+	s, logs := newTestServer(t, upstream, func(cfg *config.Config) {
+		cfg.UpstreamDNS = []string{closedAddr, upstream.Addr}
+	})
+
+	resp := query(s, "example.com", dns.TypeA)
+	if resp == nil || resp.Rcode != dns.RcodeSuccess {
+		t.Fatalf("Expected NOERROR, got %v", resp)
+	}
+
+	var entry types.LogEntry
+	if err := json.Unmarshal(firstLogLine(logs), &entry); err != nil {
+		t.Fatalf("Failed to decode log entry: %v", err)
+	}
+	if len(entry.Upstreams) != 2 {
+		t.Fatalf("Expected 2 upstream attempts, got %+v", entry.Upstreams)
+	}
+	if first := entry.Upstreams[0]; first.Successful || first.Error == nil {
+		t.Errorf("Expected first attempt to be a failure, got %+v", first)
+	}
+	if second := entry.Upstreams[1]; !second.Successful || second.Attempt != 2 || second.Server != upstream.Addr {
+		t.Errorf("Expected attempt 2 via %s to be marked successful, got %+v", upstream.Addr, second)
+	}
+}
+
+func TestHandleDNSRequest_LogsLateAttempts(t *testing.T) {
+	fast := testutil.NewServer(t, testutil.Answer("192.0.2.1", 60))
+	release := make(chan struct{})
+	answer := testutil.Answer("192.0.2.2", 60)
+	slow := testutil.NewServer(t, func(req *dns.Msg) *dns.Msg {
+		<-release
+		return answer(req)
+	})
+	s, logs := newTestServer(t, fast, func(cfg *config.Config) {
+		cfg.UpstreamDNS = []string{fast.Addr, slow.Addr}
+		cfg.Timeout = time.Second
+		cfg.LogAttemptsWait = time.Second
+	})
+
+	resp := query(s, "example.com", dns.TypeA)
+	if resp == nil || len(resp.Answer) != 1 {
+		t.Fatalf("Expected the fast upstream's answer, got %v", resp)
+	}
+	if a := resp.Answer[0].(*dns.A); !a.A.Equal(net.ParseIP("192.0.2.1")) {
+		t.Errorf("Expected 192.0.2.1 from the fast upstream, got %s", a.A)
+	}
+
+	// The slow upstream only answers once the client got its response
+	close(release)
+	s.wg.Wait()
+
+	var entry types.LogEntry
+	if err := json.Unmarshal(firstLogLine(logs), &entry); err != nil {
+		t.Fatalf("Failed to decode log entry: %v", err)
+	}
+	if len(entry.Upstreams) != 2 {
+		t.Fatalf("Expected 2 upstream attempts, got %+v", entry.Upstreams)
+	}
+	if first := entry.Upstreams[0]; !first.Successful || first.Server != fast.Addr {
+		t.Errorf("Expected attempt 1 via %s to be marked successful, got %+v", fast.Addr, first)
+	}
+	if second := entry.Upstreams[1]; second.Successful || second.Attempt != 2 || second.Server != slow.Addr || second.Error != nil {
+		t.Errorf("Expected a completed attempt 2 via %s, got %+v", slow.Addr, second)
+	}
+}
+
+// firstLogLine returns the first JSON line written to the request log
+func firstLogLine(logs *bytes.Buffer) []byte {
+	line, _, _ := bytes.Cut(logs.Bytes(), []byte("\n"))
+	return line
+}
+
+func TestHandleDNSRequest_AnswerLocalhost(t *testing.T) {
+	upstream := testutil.NewServer(t, testutil.Answer("192.0.2.1", 60))
+	s, _ := newTestServer(t, upstream, nil)
+
+	resp := query(s, "1.0.0.127.in-addr.arpa", dns.TypePTR)
+	if resp == nil || len(resp.Answer) != 1 {
+		t.Fatalf("Expected loopback PTR answer, got %v", resp)
+	}
+	if ptr := resp.Answer[0].(*dns.PTR); ptr.Ptr != "localhost." {
+		t.Errorf("Expected localhost., got %s", ptr.Ptr)
+	}
+	if upstream.Queries() != 0 {
+		t.Errorf("Expected loopback PTR not to be forwarded, upstream saw %d queries", upstream.Queries())
+	}
+
+	// Disabling local answers leaves localhost to the special-use policy
+	s, _ = newTestServer(t, upstream, func(cfg *config.Config) {
+		cfg.AnswerLocalhost = false
+	})
+	resp = query(s, "localhost", dns.TypeA)
+	if resp == nil || len(resp.Answer) != 1 {
+		t.Fatalf("Expected loopback answer, got %v", resp)
+	}
+	if a := resp.Answer[0].(*dns.A); !a.A.Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Errorf("Expected 127.0.0.1, got %s", a.A)
+	}
+
+	s, _ = newTestServer(t, upstream, func(cfg *config.Config) {
+		cfg.AnswerLocalhost = false
+		cfg.SpecialUseDomains["localhost."] = config.LocalDomainPolicyNXDomain
+	})
+	resp = query(s, "localhost", dns.TypeA)
+	if resp == nil || resp.Rcode != dns.RcodeNameError {
+		t.Fatalf("Expected NXDOMAIN from the special-use policy, got %v", resp)
+	}
+	if upstream.Queries() != 0 {
+		t.Errorf("Expected localhost not to be forwarded, upstream saw %d queries", upstream.Queries())
+	}
+}
+
+func TestHandleDNSRequest_SelfHostname(t *testing.T) {
+	upstream := testutil.NewServer(t, testutil.Answer("192.0.2.1", 60))
+	s, logs := newTestServer(t, upstream, func(cfg *config.Config) {
+		cfg.SelfHostnames = []string{"dns.home.lan."}
+	})
+
+	// Without a configured address, the address the query arrived on is used
+	resp := query(s, "DNS.home.lan", dns.TypeA)
+	if resp == nil || len(resp.Answer) != 1 {
+		t.Fatalf("Expected self answer, got %v", resp)
+	}
+	if a := resp.Answer[0].(*dns.A); !a.A.Equal(net.ParseIP("127.0.0.1")) {
+		t.Errorf("Expected 127.0.0.1, got %s", a.A)
+	}
+	var entry types.LogEntry
+	if err := json.Unmarshal(firstLogLine(logs), &entry); err != nil {
+		t.Fatalf("Failed to decode log entry: %v", err)
+	}
+	if entry.Status != "self" {
+		t.Errorf("Expected status self, got %q", entry.Status)
+	}
+
+	// The address has no IPv6 counterpart, so AAAA queries get an empty answer
+	resp = query(s, "dns.home.lan", dns.TypeAAAA)
+	if resp == nil || resp.Rcode != dns.RcodeSuccess || len(resp.Answer) != 0 {
+		t.Errorf("Expected NODATA for AAAA, got %v", resp)
+	}
+	if upstream.Queries() != 0 {
+		t.Errorf("Expected self hostname not to be forwarded, upstream saw %d queries", upstream.Queries())
+	}
+
+	// Other names are unaffected
+	resp = query(s, "example.com", dns.TypeA)
+	if resp == nil || len(resp.Answer) != 1 || upstream.Queries() != 1 {
+		t.Errorf("Expected example.com to be forwarded, got %v", resp)
+	}
+
+	// A configured address takes precedence
+	s, _ = newTestServer(t, upstream, func(cfg *config.Config) {
+		cfg.SelfHostnames = []string{"dns.home.lan."}
+		cfg.SelfAddress = "192.0.2.53"
+	})
+	resp = query(s, "dns.home.lan", dns.TypeA)
+	if resp == nil || len(resp.Answer) != 1 {
+		t.Fatalf("Expected self answer, got %v", resp)
+	}
+	if a := resp.Answer[0].(*dns.A); !a.A.Equal(net.ParseIP("192.0.2.53")) {
+		t.Errorf("Expected 192.0.2.53, got %s", a.A)
+	}
+}
+
+func TestHandleDNSRequest_MultipleQuestions(t *testing.T) {
+	tests := []struct {
+		name          string
+		policy        string
+		wantRcode     int
+		wantForwarded bool
+	}{
+		{name: "formerr", policy: config.MultiQuestionPolicyFormErr, wantRcode: dns.RcodeFormatError},
+		{name: "first", policy: config.MultiQuestionPolicyFirst, wantRcode: dns.RcodeSuccess, wantForwarded: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var upstreamQuestions int32
+			answer := testutil.Answer("192.0.2.1", 60)
+			upstream := testutil.NewServer(t, func(req *dns.Msg) *dns.Msg {
+				atomic.StoreInt32(&upstreamQuestions, int32(len(req.Question)))
+				return answer(req)
+			})
+			s, logs := newTestServer(t, upstream, func(cfg *config.Config) {
+				cfg.MultiQuestionPolicy = tt.policy
+			})
+
+			req := new(dns.Msg)
+			req.SetQuestion("example.com.", dns.TypeA)
+			req.Question = append(req.Question, dns.Question{Name: "example.org.", Qtype: dns.TypeA, Qclass: dns.ClassINET})
+
+			w := newTestResponseWriter()
+			s.handleDNSRequest(w, req)
+
+			if w.msg == nil || w.msg.Rcode != tt.wantRcode {
+				t.Fatalf("Expected rcode %s, got %v", dns.RcodeToString[tt.wantRcode], w.msg)
+			}
+			if w.msg.Id != req.Id {
+				t.Errorf("Expected response ID %d, got %d", req.Id, w.msg.Id)
+			}
+			if forwarded := upstream.Queries() > 0; forwarded != tt.wantForwarded {
+				t.Fatalf("Expected forwarded=%v, upstream saw %d queries", tt.wantForwarded, upstream.Queries())
+			}
+
+			if tt.wantForwarded {
+				if n := atomic.LoadInt32(&upstreamQuestions); n != 1 {
+					t.Errorf("Expected only the first question to be forwarded, upstream saw %d", n)
+				}
+				if len(req.Question) != 2 {
+					t.Errorf("Expected the client's message not to be modified, got %d questions", len(req.Question))
+				}
+			} else if !bytes.Contains(logs.Bytes(), []byte("multi_question")) {
+				t.Errorf("Expected multi_question status in logs, got %s", logs.String())
+			}
+		})
+	}
+}
+
+func TestHandleDNSRequest_SlowQueryWarning(t *testing.T) {
+	tests := []struct {
+		name     string
+		delay    time.Duration
+		wantWarn bool
+	}{
+		{name: "slow", delay: 60 * time.Millisecond, wantWarn: true},
+		{name: "fast", delay: 0, wantWarn: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			answer := testutil.Answer("192.0.2.1", 60)
+			upstream := testutil.NewServer(t, func(req *dns.Msg) *dns.Msg {
+				time.Sleep(tt.delay)
+				return answer(req)
+			})
+			s, _ := newTestServer(t, upstream, func(cfg *config.Config) {
+				cfg.SlowQueryThreshold = 30 * time.Millisecond
+			})
+			warnings := &bytes.Buffer{}
+			s.logger = logging.New(&bytes.Buffer{}, logging.WARN)
+			s.logger.SetHumanOutput(warnings)
+
+			resp := query(s, "example.com", dns.TypeA)
+			if resp == nil || resp.Rcode != dns.RcodeSuccess {
+				t.Fatalf("Expected NOERROR, got %v", resp)
+			}
+
+			output := warnings.String()
+			if warned := bytes.Contains([]byte(output), []byte("Slow DNS response")); warned != tt.wantWarn {
+				t.Fatalf("Expected warning=%v, got %q", tt.wantWarn, output)
+			}
+			if tt.wantWarn {
+				for _, field := range []string{"query=example.com.", "client=192.0.2.100", "upstream=" + upstream.Addr, "duration_ms="} {
+					if !bytes.Contains([]byte(output), []byte(field)) {
+						t.Errorf("Expected warning to contain %q, got %q", field, output)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestHandleDNSRequest_PrivatePTR(t *testing.T) {
+	tests := []struct {
+		name          string
+		query         string
+		policy        string
+		wantRcode     int
+		wantForwarded bool
+	}{
+		{name: "rfc1918 refused", query: "10.0.168.192.in-addr.arpa", policy: config.LocalDomainPolicyRefuse, wantRcode: dns.RcodeRefused},
+		{name: "rfc1918 nxdomain", query: "5.0.0.10.in-addr.arpa", policy: config.LocalDomainPolicyNXDomain, wantRcode: dns.RcodeNameError},
+		{name: "partial rfc1918 zone", query: "16.172.in-addr.arpa", policy: config.LocalDomainPolicyRefuse, wantRcode: dns.RcodeRefused},
+		{name: "ula refused", query: "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.d.f.ip6.arpa", policy: config.LocalDomainPolicyRefuse, wantRcode: dns.RcodeRefused},
+		{name: "public forwarded", query: "8.8.8.8.in-addr.arpa", policy: config.LocalDomainPolicyRefuse, wantRcode: dns.RcodeSuccess, wantForwarded: true},
+		{name: "outside 172.16/12 forwarded", query: "1.0.32.172.in-addr.arpa", policy: config.LocalDomainPolicyRefuse, wantRcode: dns.RcodeSuccess, wantForwarded: true},
+		{name: "policy forward", query: "10.0.168.192.in-addr.arpa", policy: config.LocalDomainPolicyForward, wantRcode: dns.RcodeSuccess, wantForwarded: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := testutil.NewServer(t, testutil.Answer("192.0.2.1", 60))
+			s, _ := newTestServer(t, upstream, func(cfg *config.Config) {
+				cfg.PrivatePTRPolicy = tt.policy
+			})
+
+			resp := query(s, tt.query, dns.TypePTR)
+			if resp == nil || resp.Rcode != tt.wantRcode {
+				t.Fatalf("Expected rcode %s, got %v", dns.RcodeToString[tt.wantRcode], resp)
+			}
+			if forwarded := upstream.Queries() > 0; forwarded != tt.wantForwarded {
+				t.Errorf("Expected forwarded=%v, upstream saw %d queries", tt.wantForwarded, upstream.Queries())
+			}
+		})
+	}
+}
+
+func TestHandleDNSRequest_PrivatePTRMapped(t *testing.T) {
+	upstream := testutil.NewServer(t, testutil.Answer("192.0.2.1", 60))
+	s, _ := newTestServer(t, upstream, func(cfg *config.Config) {
+		cfg.PrivatePTRPolicy = config.LocalDomainPolicyRefuse
+		cfg.CustomDNS["10.0.168.192.in-addr.arpa."] = "nas.lan"
+	})
+
+	resp := query(s, "10.0.168.192.in-addr.arpa", dns.TypePTR)
+	if resp == nil || resp.Rcode != dns.RcodeSuccess || len(resp.Answer) != 1 {
+		t.Fatalf("Expected mapped PTR answer, got %v", resp)
+	}
+	if ptr := resp.Answer[0].(*dns.PTR); ptr.Ptr != "nas.lan." {
+		t.Errorf("Expected nas.lan., got %s", ptr.Ptr)
+	}
+	if upstream.Queries() != 0 {
+		t.Errorf("Expected mapped PTR query not to be forwarded, upstream saw %d queries", upstream.Queries())
+	}
+}
+
+func TestHandleDNSRequest_ReverseOfAddressMapping(t *testing.T) {
+	upstream := testutil.NewServer(t, testutil.Answer("192.0.2.1", 60))
+	s, _ := newTestServer(t, upstream, func(cfg *config.Config) {
+		cfg.PrivatePTRPolicy = config.LocalDomainPolicyNXDomain
+		cfg.CustomDNS["nas.lan."] = "192.168.0.10"
+	})
+
+	resp := query(s, "10.0.168.192.in-addr.arpa", dns.TypePTR)
+	if resp == nil || resp.Rcode != dns.RcodeSuccess || len(resp.Answer) != 1 {
+		t.Fatalf("Expected PTR answer for the mapped address, got %v", resp)
+	}
+	if ptr := resp.Answer[0].(*dns.PTR); ptr.Ptr != "nas.lan." {
+		t.Errorf("Expected nas.lan., got %s", ptr.Ptr)
+	}
+
+	resp = query(s, "11.0.168.192.in-addr.arpa", dns.TypePTR)
+	if resp == nil || resp.Rcode != dns.RcodeNameError {
+		t.Errorf("Expected NXDOMAIN for an unmapped address, got %v", resp)
+	}
+	if upstream.Queries() != 0 {
+		t.Errorf("Expected reverse lookups not to be forwarded, upstream saw %d queries", upstream.Queries())
+	}
+}
+
+func TestHandleDNSRequest_AnswerOrder(t *testing.T) {
+	// Upstream answering with interleaved address families behind a CNAME
+	mixed := func(req *dns.Msg) *dns.Msg {
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		name := req.Question[0].Name
+		for _, rr := range []string{
+			name + " 60 IN CNAME edge.example.net.",
+			"edge.example.net. 60 IN A 192.0.2.1",
+			"edge.example.net. 60 IN AAAA 2001:db8::1",
+			"edge.example.net. 60 IN A 192.0.2.2",
+			"edge.example.net. 60 IN AAAA 2001:db8::2",
+		} {
+			parsed, err := dns.NewRR(rr)
+			if err != nil {
+				panic(err)
+			}
+			resp.Answer = append(resp.Answer, parsed)
+		}
+		return resp
+	}
+
+	tests := []struct {
+		name  string
+		order string
+		want  []uint16
+	}{
+		{name: "none", order: config.AnswerOrderNone, want: []uint16{dns.TypeCNAME, dns.TypeA, dns.TypeAAAA, dns.TypeA, dns.TypeAAAA}},
+		{name: "ipv4 first", order: config.AnswerOrderIPv4First, want: []uint16{dns.TypeCNAME, dns.TypeA, dns.TypeA, dns.TypeAAAA, dns.TypeAAAA}},
+		{name: "ipv6 first", order: config.AnswerOrderIPv6First, want: []uint16{dns.TypeCNAME, dns.TypeAAAA, dns.TypeAAAA, dns.TypeA, dns.TypeA}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := testutil.NewServer(t, mixed)
+			s, _ := newTestServer(t, upstream, func(cfg *config.Config) {
+				cfg.AnswerOrder = tt.order
+			})
+
+			resp := query(s, "www.example.com", dns.TypeA)
+			if resp == nil || len(resp.Answer) != len(tt.want) {
+				t.Fatalf("Expected %d answers, got %v", len(tt.want), resp)
+			}
+			for i, rr := range resp.Answer {
+				if rr.Header().Rrtype != tt.want[i] {
+					t.Fatalf("Answer %d: expected %s, got %s", i, dns.TypeToString[tt.want[i]], rr)
+				}
+			}
+
+			// Records of the same family keep their relative order
+			addresses := types.ExtractIPAddresses(resp.Answer)
+			if tt.order == config.AnswerOrderIPv6First && (addresses[0] != "2001:db8::1" || addresses[2] != "192.0.2.1") {
+				t.Errorf("Expected stable ordering within each family, got %v", addresses)
+			}
+		})
+	}
+}
+
+func TestHandleDNSRequest_QueryRetries(t *testing.T) {
+	tests := []struct {
+		name      string
+		retries   int
+		wantRcode int
+		wantTries int
+	}{
+		{name: "disabled", retries: 0, wantRcode: dns.RcodeServerFailure, wantTries: 1},
+		{name: "retried", retries: 2, wantRcode: dns.RcodeSuccess, wantTries: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The first query is lost, as if by a transient network glitch
+			upstream := testutil.NewServer(t, testutil.Sequence(testutil.Drop(), testutil.Answer("192.0.2.1", 60)))
+			s, logs := newTestServer(t, upstream, func(cfg *config.Config) {
+				cfg.Timeout = 100 * time.Millisecond
+				cfg.UDPTimeout = time.Second
+				cfg.QueryRetries = tt.retries
+				cfg.QueryRetryDelay = 10 * time.Millisecond
+			})
+
+			resp := query(s, "example.com", dns.TypeA)
+			if resp == nil || resp.Rcode != tt.wantRcode {
+				t.Fatalf("Expected %s, got %v", dns.RcodeToString[tt.wantRcode], resp)
+			}
+			if upstream.Queries() != tt.wantTries {
+				t.Errorf("Expected %d upstream queries, got %d", tt.wantTries, upstream.Queries())
+			}
+
+			var entry types.LogEntry
+			if err := json.Unmarshal(firstLogLine(logs), &entry); err != nil {
+				t.Fatalf("Failed to decode log entry: %v", err)
+			}
+			if len(entry.Upstreams) != tt.wantTries {
+				t.Errorf("Expected %d logged upstream attempts, got %+v", tt.wantTries, entry.Upstreams)
+			}
+		})
+	}
+}
+
+func TestHandleDNSRequest_QueryRetriesWithinBudget(t *testing.T) {
+	upstream := testutil.NewServer(t, testutil.Drop())
+	s, _ := newTestServer(t, upstream, func(cfg *config.Config) {
+		cfg.Timeout = 50 * time.Millisecond
+		cfg.UDPTimeout = 200 * time.Millisecond
+		cfg.QueryRetries = 100
+		cfg.QueryRetryDelay = 10 * time.Millisecond
+	})
+
+	start := time.Now()
+	resp := query(s, "example.com", dns.TypeA)
+	elapsed := time.Since(start)
+
+	if resp == nil || resp.Rcode != dns.RcodeServerFailure {
+		t.Fatalf("Expected SERVFAIL, got %v", resp)
+	}
+	if elapsed > time.Second {
+		t.Errorf("Expected retries to stop at the query budget, took %v", elapsed)
+	}
+}
+
+func TestHandleDNSRequest_LogsUpstreamStateChange(t *testing.T) {
+	upstream := testutil.NewServer(t, testutil.Drop())
+	s, _ := newTestServer(t, upstream, func(cfg *config.Config) {
+		cfg.Timeout = 50 * time.Millisecond
+	})
+	output := &bytes.Buffer{}
+	s.logger = logging.New(&bytes.Buffer{}, logging.INFO)
+	s.logger.SetHumanOutput(output)
+
+	// The circuit breaker opens after three failed queries
+	for i := 0; i < 4; i++ {
+		query(s, "example.com", dns.TypeA)
+	}
+
+	logged := output.String()
+	if n := strings.Count(logged, "Upstream health state changed"); n != 1 {
+		t.Fatalf("Expected exactly one state change log, got %d in %q", n, logged)
+	}
+	for _, field := range []string{"[WARN]", "server=" + upstream.Addr, "from=healthy", "to=unhealthy", "failures=3"} {
+		if !strings.Contains(logged, field) {
+			t.Errorf("Expected state change log to contain %q, got %q", field, logged)
+		}
+	}
+}
+
+func TestHandleDNSRequest_AlertsWhenAllUpstreamsDown(t *testing.T) {
+	var posted atomic.Int32
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var a alert.Alert
+		if err := json.NewDecoder(r.Body).Decode(&a); err != nil || a.Event != alert.EventUpstreamsDown {
+			t.Errorf("Expected an upstreams_down alert, got %+v (%v)", a, err)
+		}
+		posted.Add(1)
+	}))
+	defer webhook.Close()
+
+	first := testutil.NewServer(t, testutil.Drop())
+	second := testutil.NewServer(t, testutil.Drop())
+	s, _ := newTestServer(t, first, func(cfg *config.Config) {
+		cfg.UpstreamDNS = []string{first.Addr, second.Addr}
+		cfg.Timeout = 50 * time.Millisecond
+		cfg.AlertWebhook = webhook.URL
+	})
+
+	// The circuit breakers open after three failed queries; later failures
+	// must not alert again within the interval
+	for i := 0; i < 6; i++ {
+		query(s, "example.com", dns.TypeA)
+	}
+	s.alerts.Wait()
+
+	if n := posted.Load(); n != 1 {
+		t.Errorf("Expected exactly one alert, got %d", n)
+	}
+}
+
+func TestStart_ListensOnTCP(t *testing.T) {
+	upstream := testutil.NewServer(t, testutil.Answer("192.0.2.1", 60))
+
+	// Reserve a port that is free for TCP; the UDP server binds the same number
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to reserve TCP port: %v", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	_, port, _ := net.SplitHostPort(addr)
+
+	s, _ := newTestServer(t, upstream, func(cfg *config.Config) {
+		cfg.ListenAddress = "127.0.0.1"
+		cfg.Port = port
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go s.Start(ctx)
+	t.Cleanup(func() {
+		cancel()
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), time.Second)
+		defer shutdownCancel()
+		s.Shutdown(shutdownCtx)
+	})
+
+	req := new(dns.Msg)
+	req.SetQuestion("example.com.", dns.TypeA)
+	client := &dns.Client{Net: "tcp", Timeout: 200 * time.Millisecond}
+
+	// Retry until the listener is up
+	var resp *dns.Msg
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		if resp, _, err = client.Exchange(req, addr); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatalf("Expected an answer over TCP, got: %v", err)
+	}
+	if resp.Rcode != dns.RcodeSuccess || len(resp.Answer) != 1 {
+		t.Errorf("Expected NOERROR with one answer, got %v", resp)
+	}
+}
+
+func TestShutdownTiming_UsesConfiguredTimeouts(t *testing.T) {
+	upstream := testutil.NewServer(t, testutil.Answer("192.0.2.1", 60))
+	s, _ := newTestServer(t, upstream, func(cfg *config.Config) {
+		cfg.ShutdownTimeout = 3 * time.Second
+		cfg.ForceExitTimeout = 20 * time.Millisecond
+	})
+	s.logger = logging.New(&bytes.Buffer{}, logging.ERROR)
+	s.logger.SetHumanOutput(&bytes.Buffer{})
+
+	ctx, cancel := s.shutdownContext()
+	defer cancel()
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		t.Fatal("Expected the shutdown context to have a deadline")
+	}
+	if remaining := time.Until(deadline); remaining <= 2*time.Second || remaining > 3*time.Second {
+		t.Errorf("Expected a shutdown deadline about 3s away, got %v", remaining)
+	}
+
+	exited := make(chan int, 1)
+	start := time.Now()
+	timer := s.scheduleForceExit(func(code int) { exited <- code })
+	defer timer.Stop()
+
+	select {
+	case code := <-exited:
+		if code != 1 {
+			t.Errorf("Expected exit code 1, got %d", code)
+		}
+		if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+			t.Errorf("Expected force exit after the configured 20ms, got %v", elapsed)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected force exit after the configured timeout")
+	}
+}
+
+func TestHandleDNSRequest_DNSSECPassthrough(t *testing.T) {
+	records := []string{
+		"example.com. 3600 IN DNSKEY 257 3 13 mdsswUyr3DPW132mOi8V9xESWE8jTo0dxCjjnopKl+GqJxpVXckHAeF+KkxLbxILfDLUT0rAK9iUzy1L53eKGQ==",
+		"example.com. 3600 IN RRSIG DNSKEY 13 2 3600 20261101000000 20261001000000 2371 example.com. oJB1W6WNGv+ldvQ3WDG0MQkg5IEhjRip8WTrPYGv07h108dUKGMeDPKijVCHX3DDKdfb+v6oB9wfuh3DTJXUAfI/M0zmO/zz8bW0Rznl8O3tGNazPwQKkRN20XPXV6nwwfoXmJQbsLNrLfkGJ5D6fwFm8nN+6pBzeDQfsS3Ap3o=",
+	}
+	nsec, _ := dns.NewRR("example.com. 300 IN NSEC www.example.com. A NS SOA RRSIG NSEC DNSKEY")
+
+	var mu sync.Mutex
+	var sent *dns.Msg
+	upstream := testutil.NewServer(t, func(req *dns.Msg) *dns.Msg {
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		for _, record := range records {
+			rr, err := dns.NewRR(record)
+			if err != nil {
+				t.Errorf("Failed to parse %q: %v", record, err)
+				return nil
+			}
+			resp.Answer = append(resp.Answer, rr)
+		}
+		resp.Ns = append(resp.Ns, nsec)
+		resp.SetEdns0(1232, true)
+		mu.Lock()
+		sent = resp.Copy()
+		mu.Unlock()
+		return resp
+	})
+
+	// Answer filtering features must leave DNSSEC records intact
+	s, _ := newTestServer(t, upstream, func(cfg *config.Config) {
+		cfg.MinimizeResponses = true
+		cfg.AnswerOrder = config.AnswerOrderIPv4First
+	})
+
+	req := new(dns.Msg)
+	req.SetQuestion("example.com.", dns.TypeDNSKEY)
+	req.SetEdns0(1232, true)
+
+	w := newTestResponseWriter()
+	s.handleDNSRequest(w, req)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if w.msg == nil || sent == nil {
+		t.Fatalf("Expected the upstream response to be relayed, got %v", w.msg)
+	}
+
+	sent.Id = w.msg.Id
+	want, err := sent.Pack()
+	if err != nil {
+		t.Fatalf("Failed to pack upstream response: %v", err)
+	}
+	got, err := w.msg.Pack()
+	if err != nil {
+		t.Fatalf("Failed to pack relayed response: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("Expected the response to be relayed byte-identical\nwant: %v\ngot:  %v", sent, w.msg)
+	}
+}
+
+func TestHandleDNSRequest_ClientQueryTypes(t *testing.T) {
+	tests := []struct {
+		name      string
+		rules     config.QueryTypeRules
+		qtype     uint16
+		wantRcode int
+	}{
+		{name: "allowed type", rules: config.QueryTypeRules{"192.0.2.0/24": {"A", "AAAA"}}, qtype: dns.TypeA, wantRcode: dns.RcodeSuccess},
+		{name: "disallowed type", rules: config.QueryTypeRules{"192.0.2.0/24": {"A", "AAAA"}}, qtype: dns.TypeTXT, wantRcode: dns.RcodeRefused},
+		{name: "client outside restricted subnets", rules: config.QueryTypeRules{"198.51.100.0/24": {"A"}}, qtype: dns.TypeTXT, wantRcode: dns.RcodeSuccess},
+		{name: "most specific subnet wins", rules: config.QueryTypeRules{"192.0.2.0/24": {"A"}, "192.0.2.100": {"TXT"}}, qtype: dns.TypeTXT, wantRcode: dns.RcodeSuccess},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := testutil.NewServer(t, testutil.Rcode(dns.RcodeSuccess))
+			s, logs := newTestServer(t, upstream, func(cfg *config.Config) {
+				cfg.ClientQueryTypes = tt.rules
+			})
+
+			resp := query(s, "example.com", tt.qtype)
+			if resp == nil || resp.Rcode != tt.wantRcode {
+				t.Fatalf("Expected rcode %s, got %v", dns.RcodeToString[tt.wantRcode], resp)
+			}
+			if tt.wantRcode == dns.RcodeRefused {
+				if upstream.Queries() != 0 {
+					t.Errorf("Expected refused query not to be forwarded, upstream saw %d queries", upstream.Queries())
+				}
+				if !bytes.Contains(logs.Bytes(), []byte("refused_type_acl")) {
+					t.Errorf("Expected refused_type_acl status in logs, got %s", logs.String())
+				}
+			} else if upstream.Queries() != 1 {
+				t.Errorf("Expected the query to be forwarded, upstream saw %d queries", upstream.Queries())
+			}
+		})
+	}
+}
+
+func TestHandleDNSRequest_LogsTimings(t *testing.T) {
+	const upstreamDelay = 30 * time.Millisecond
+
+	answer := testutil.Answer("192.0.2.1", 60)
+	upstream := testutil.NewServer(t, func(req *dns.Msg) *dns.Msg {
+		time.Sleep(upstreamDelay)
+		return answer(req)
+	})
+	s, logs := newTestServer(t, upstream, func(cfg *config.Config) {
+		cfg.CustomDNS["custom.example."] = "10.0.0.1"
+	})
+
+	resp := query(s, "example.com", dns.TypeA)
+	if resp == nil || resp.Rcode != dns.RcodeSuccess {
+		t.Fatalf("Expected NOERROR, got %v", resp)
+	}
+
+	var entry types.LogEntry
+	if err := json.Unmarshal(firstLogLine(logs), &entry); err != nil {
+		t.Fatalf("Failed to decode log entry: %v", err)
+	}
+	timings := entry.Timings
+	if timings == nil {
+		t.Fatalf("Expected timings for an upstream-resolved query, got %+v", entry)
+	}
+	if timings.Upstream < types.DurationToMilliseconds(upstreamDelay) {
+		t.Errorf("Expected upstream time of at least %v, got %.3fms", upstreamDelay, timings.Upstream)
+	}
+	if timings.Local < 0 || timings.Write < 0 {
+		t.Errorf("Expected non-negative timings, got %+v", timings)
+	}
+
+	// The phases account for nearly all of the total
+	sum := timings.Local + timings.Upstream + timings.Write
+	if sum > entry.Duration || entry.Duration-sum > 5 {
+		t.Errorf("Expected timings %+v to sum to about the total %.3fms, got %.3fms", timings, entry.Duration, sum)
+	}
+
+	// Locally answered queries have no upstream phases to report
+	logs.Reset()
+	query(s, "custom.example", dns.TypeA)
+	entry = types.LogEntry{}
+	if err := json.Unmarshal(firstLogLine(logs), &entry); err != nil {
+		t.Fatalf("Failed to decode log entry: %v", err)
+	}
+	if entry.Status != "custom_resolution" || entry.Timings != nil {
+		t.Errorf("Expected a custom resolution without timings, got %+v", entry)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"dns-go/internal/types"
+
+	"github.com/miekg/dns"
+)
+
+// dnsHeaderLen is the size of the fixed DNS message header
+const dnsHeaderLen = 12
+
+// errOversizedMessage is returned when a TCP frame announces a message larger
+// than the configured maximum, before its body is read
+var errOversizedMessage = errors.New("DNS message exceeds the maximum size")
+
+// newListener returns a DNS server for addr on network that enforces
+// MaxMessageSize on the raw messages it receives
+func (s *DNSServer) newListener(addr, network string) *dns.Server {
+	server := &dns.Server{
+		Addr: addr,
+		Net:  network,
+	}
+	if max := s.config.MaxMessageSize; max > 0 {
+		// Read one byte past the limit so larger datagrams can be told apart
+		server.UDPSize = max + 1
+		server.DecorateReader = func(r dns.Reader) dns.Reader {
+			return &sizeLimitReader{Reader: r, s: s, max: max}
+		}
+	}
+	return server
+}
+
+// sizeLimitReader rejects DNS messages larger than max before they are parsed,
+// answering FORMERR from the header alone
+type sizeLimitReader struct {
+	dns.Reader
+	s   *DNSServer
+	max int
+}
+
+// ReadTCP checks the length prefix of the frame before reading its body. An
+// oversized frame is answered and the connection closed, as the rest of the
+// stream cannot be resynchronized without reading it.
+func (r *sizeLimitReader) ReadTCP(conn net.Conn, timeout time.Duration) ([]byte, error) {
+	limited := &frameLimitConn{Conn: conn, max: r.max}
+	m, err := r.Reader.ReadTCP(limited, timeout)
+	if !errors.Is(err, errOversizedMessage) {
+		return m, err
+	}
+
+	header := make([]byte, dnsHeaderLen)
+	if _, readErr := io.ReadFull(conn, header); readErr != nil {
+		return nil, err
+	}
+	if reply := r.s.rejectOversized(conn.RemoteAddr(), header, limited.length); reply != nil {
+		frame := make([]byte, 2, 2+len(reply))
+		binary.BigEndian.PutUint16(frame, uint16(len(reply)))
+		conn.Write(append(frame, reply...))
+	}
+	return nil, err
+}
+
+// ReadUDP answers oversized datagrams and keeps reading until one within the
+// limit arrives
+func (r *sizeLimitReader) ReadUDP(conn *net.UDPConn, timeout time.Duration) ([]byte, *dns.SessionUDP, error) {
+	for {
+		m, session, err := r.Reader.ReadUDP(conn, timeout)
+		if err != nil || len(m) <= r.max || len(m) < dnsHeaderLen {
+			return m, session, err
+		}
+		if reply := r.s.rejectOversized(session.RemoteAddr(), m, len(m)); reply != nil {
+			dns.WriteToSessionUDP(conn, reply, session)
+		}
+	}
+}
+
+// ReadPacketConn is ReadUDP for generic packet connections
+func (r *sizeLimitReader) ReadPacketConn(conn net.PacketConn, timeout time.Duration) ([]byte, net.Addr, error) {
+	reader, ok := r.Reader.(dns.PacketConnReader)
+	if !ok {
+		return nil, nil, errors.New("DNS reader does not support packet connections")
+	}
+	for {
+		m, addr, err := reader.ReadPacketConn(conn, timeout)
+		if err != nil || len(m) <= r.max || len(m) < dnsHeaderLen {
+			return m, addr, err
+		}
+		if reply := r.s.rejectOversized(addr, m, len(m)); reply != nil {
+			conn.WriteTo(reply, addr)
+		}
+	}
+}
+
+// frameLimitConn fails the read of a TCP frame's length prefix when it
+// announces more than max bytes
+type frameLimitConn struct {
+	net.Conn
+	max     int
+	length  int
+	prefix  []byte
+	checked bool
+}
+
+func (c *frameLimitConn) Read(p []byte) (int, error) {
+	if !c.checked {
+		prefix := make([]byte, 2)
+		if _, err := io.ReadFull(c.Conn, prefix); err != nil {
+			return 0, err
+		}
+		c.checked = true
+		c.length = int(binary.BigEndian.Uint16(prefix))
+		if c.length > c.max {
+			return 0, errOversizedMessage
+		}
+		c.prefix = prefix
+	}
+	if len(c.prefix) > 0 {
+		n := copy(p, c.prefix)
+		c.prefix = c.prefix[n:]
+		return n, nil
+	}
+	return c.Conn.Read(p)
+}
+
+// rejectOversized logs an oversized query and returns the packed FORMERR reply
+// built from its header, or nil when the message is itself a response
+func (s *DNSServer) rejectOversized(remote net.Addr, header []byte, size int) []byte {
+	id := binary.BigEndian.Uint16(header[0:2])
+	flags := binary.BigEndian.Uint16(header[2:4])
+	if flags&(1<<15) != 0 {
+		// Never answer responses; replies could be used for reflection
+		return nil
+	}
+
+	clientAddr := types.ExtractIPFromAddr(remote.String())
+	logEntry := types.LogEntry{
+		Timestamp: time.Now(),
+		UUID:      types.GenerateRequestUUID(),
+		Request: types.RequestInfo{
+			Client: clientAddr,
+			Query:  "OVERSIZED",
+			Type:   "UNKNOWN",
+			ID:     id,
+		},
+		Upstreams: make([]types.UpstreamAttempt, 0),
+		Status:    "oversized_message",
+		Response:  &types.ResponseInfo{Upstream: "local", Rcode: dns.RcodeToString[dns.RcodeFormatError]},
+	}
+	s.logger.LogDNSEntry(logEntry)
+	s.logger.LogRequestResponse(logEntry.UUID, clientAddr, "OVERSIZED", "UNKNOWN",
+		"oversized_message", 0, "local")
+	s.logger.Debug("Rejected oversized query", map[string]interface{}{
+		"uuid":   logEntry.UUID,
+		"client": clientAddr,
+		"size":   size,
+		"max":    s.config.MaxMessageSize,
+	})
+
+	reply := &dns.Msg{}
+	reply.Id = id
+	reply.Response = true
+	reply.Opcode = int(flags>>11) & 0xF
+	reply.Rcode = dns.RcodeFormatError
+	packed, err := reply.Pack()
+	if err != nil {
+		return nil
+	}
+	return packed
+}
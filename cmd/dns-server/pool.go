@@ -0,0 +1,127 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// workerPool serves DNS requests on a fixed number of goroutines fed from a
+// bounded queue, so processing concurrency does not grow with the packet rate.
+// Requests arriving while the queue is full are answered with SERVFAIL, the same
+// way requests over the MaxConcurrent limit are.
+//
+// The DNS library still starts a goroutine per packet, which waits in ServeDNS
+// while its request is queued. These goroutines are bounded by the workers plus
+// the queue size, as any request beyond that is answered right away.
+type workerPool struct {
+	handler dns.Handler
+	jobs    chan poolJob
+	quit    chan struct{}
+	wg      sync.WaitGroup
+
+	mu      sync.RWMutex // guards stopped against concurrent queueing
+	stopped bool
+}
+
+// poolJob is a queued request; done is closed once it has been answered
+type poolJob struct {
+	w    dns.ResponseWriter
+	r    *dns.Msg
+	done chan struct{}
+}
+
+// newWorkerPool starts workers goroutines serving requests from a queue of
+// queueSize pending requests
+func newWorkerPool(handler dns.Handler, workers, queueSize int) *workerPool {
+	p := &workerPool{
+		handler: handler,
+		jobs:    make(chan poolJob, queueSize),
+		quit:    make(chan struct{}),
+	}
+
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+
+	return p
+}
+
+// work answers queued requests until the pool is stopped, then answers the
+// requests left in the queue with SERVFAIL
+func (p *workerPool) work() {
+	defer p.wg.Done()
+	for {
+		select {
+		case job := <-p.jobs:
+			select {
+			case <-p.quit:
+				job.fail()
+			default:
+				p.handler.ServeDNS(job.w, job.r)
+				close(job.done)
+			}
+		case <-p.quit:
+			for {
+				select {
+				case job := <-p.jobs:
+					job.fail()
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// fail answers the job with SERVFAIL
+func (job poolJob) fail() {
+	serverFailure(job.w, job.r)
+	close(job.done)
+}
+
+// serverFailure answers the request with SERVFAIL
+func serverFailure(w dns.ResponseWriter, r *dns.Msg) {
+	msg := &dns.Msg{}
+	msg.SetRcode(r, dns.RcodeServerFailure)
+	w.WriteMsg(msg)
+}
+
+// ServeDNS queues the request for a worker and waits until it has been answered.
+// The response writer is only valid until ServeDNS returns, so the request must
+// be finished before handing control back to the DNS server.
+func (p *workerPool) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	job := poolJob{w: w, r: r, done: make(chan struct{})}
+
+	p.mu.RLock()
+	queued := false
+	if !p.stopped {
+		select {
+		case p.jobs <- job:
+			queued = true
+		default:
+		}
+	}
+	p.mu.RUnlock()
+
+	if !queued {
+		// Queue full or pool stopped, return SERVFAIL
+		serverFailure(w, r)
+		return
+	}
+
+	<-job.done
+}
+
+// Stop stops the workers after they finish the request they are serving.
+// Requests still queued, and any arriving later, are answered with SERVFAIL.
+func (p *workerPool) Stop() {
+	p.mu.Lock()
+	if !p.stopped {
+		p.stopped = true
+		close(p.quit)
+	}
+	p.mu.Unlock()
+	p.wg.Wait()
+}
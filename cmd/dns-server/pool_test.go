@@ -0,0 +1,201 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"dns-go/internal/config"
+	"dns-go/internal/upstream/testutil"
+
+	"github.com/miekg/dns"
+)
+
+func TestWorkerPool_QueueFull(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	blocking := dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		started <- struct{}{}
+		<-release
+		msg := &dns.Msg{}
+		msg.SetReply(r)
+		w.WriteMsg(msg)
+	})
+
+	pool := newWorkerPool(blocking, 1, 1)
+	defer pool.Stop()
+
+	req := new(dns.Msg)
+	req.SetQuestion("example.com.", dns.TypeA)
+
+	served := make(chan *dns.Msg, 2)
+	serve := func() {
+		w := newTestResponseWriter()
+		pool.ServeDNS(w, req)
+		served <- w.msg
+	}
+
+	// One request occupies the worker, the next one waits in the queue
+	go serve()
+	<-started
+	go serve()
+	deadline := time.Now().Add(time.Second)
+	for len(pool.jobs) < 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the request to be queued")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	w := newTestResponseWriter()
+	pool.ServeDNS(w, req)
+	if w.msg == nil || w.msg.Rcode != dns.RcodeServerFailure {
+		t.Fatalf("Expected SERVFAIL while the queue is full, got %v", w.msg)
+	}
+
+	close(release)
+	for i := 0; i < 2; i++ {
+		if msg := <-served; msg == nil || msg.Rcode != dns.RcodeSuccess {
+			t.Errorf("Expected queued request to be answered, got %v", msg)
+		}
+	}
+}
+
+func TestWorkerPool_Stop(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	blocking := dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		started <- struct{}{}
+		<-release
+		msg := &dns.Msg{}
+		msg.SetReply(r)
+		w.WriteMsg(msg)
+	})
+
+	pool := newWorkerPool(blocking, 1, 1)
+
+	req := new(dns.Msg)
+	req.SetQuestion("example.com.", dns.TypeA)
+
+	served := make(chan *dns.Msg, 2)
+	serve := func() {
+		w := newTestResponseWriter()
+		pool.ServeDNS(w, req)
+		served <- w.msg
+	}
+
+	// One request occupies the worker, the next one waits in the queue
+	go serve()
+	<-started
+	go serve()
+	deadline := time.Now().Add(time.Second)
+	for len(pool.jobs) < 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the request to be queued")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		pool.Stop()
+		close(stopped)
+	}()
+	<-pool.quit
+
+	w := newTestResponseWriter()
+	pool.ServeDNS(w, req)
+	if w.msg == nil || w.msg.Rcode != dns.RcodeServerFailure {
+		t.Fatalf("Expected SERVFAIL once the pool is stopped, got %v", w.msg)
+	}
+
+	close(release)
+	rcodes := map[int]int{}
+	for i := 0; i < 2; i++ {
+		msg := <-served
+		if msg == nil {
+			t.Fatal("Expected every request to be answered before ServeDNS returns")
+		}
+		rcodes[msg.Rcode]++
+	}
+	if rcodes[dns.RcodeSuccess] != 1 || rcodes[dns.RcodeServerFailure] != 1 {
+		t.Errorf("Expected the running request answered and the queued one to get SERVFAIL, got %v", rcodes)
+	}
+	<-stopped
+}
+
+// BenchmarkHandler compares throughput and peak goroutine count of the
+// goroutine-per-query model with a fixed worker pool. Each request is served
+// on its own goroutine, as the DNS library does for every packet.
+func BenchmarkHandler(b *testing.B) {
+	upstream := testutil.NewServer(b, testutil.Answer("192.0.2.1", 60))
+
+	// Keep per-request log lines out of the benchmark output
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	benchmarks := []struct {
+		name    string
+		workers int
+	}{
+		{name: "PerQuery", workers: 0},
+		{name: "Pool8", workers: 8},
+		{name: "Pool32", workers: 32},
+	}
+
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			s, _ := newTestServer(b, upstream, func(cfg *config.Config) {
+				cfg.MaxConcurrent = 10000
+			})
+
+			var handler dns.Handler = dns.HandlerFunc(s.handleDNSRequest)
+			if bm.workers > 0 {
+				pool := newWorkerPool(handler, bm.workers, s.config.MaxConcurrent)
+				defer pool.Stop()
+				handler = pool
+			}
+
+			// Sample the goroutine count while the benchmark runs
+			var peak int64
+			stop := make(chan struct{})
+			go func() {
+				ticker := time.NewTicker(time.Millisecond)
+				defer ticker.Stop()
+				for {
+					select {
+					case <-ticker.C:
+						if n := int64(runtime.NumGoroutine()); n > atomic.LoadInt64(&peak) {
+							atomic.StoreInt64(&peak, n)
+						}
+					case <-stop:
+						return
+					}
+				}
+			}()
+
+			b.SetParallelism(64)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				req := new(dns.Msg)
+				req.SetQuestion("example.com.", dns.TypeA)
+				for pb.Next() {
+					done := make(chan struct{})
+					go func() {
+						defer close(done)
+						handler.ServeDNS(newTestResponseWriter(), req)
+					}()
+					<-done
+				}
+			})
+			b.StopTimer()
+
+			close(stop)
+			b.ReportMetric(float64(atomic.LoadInt64(&peak)), "peak-goroutines")
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"dns-go/internal/config"
+	"dns-go/internal/types"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/publicsuffix"
+)
+
+// redactedName replaces query names in logs when redaction mode is drop
+const redactedName = "redacted."
+
+// redactName returns the form of a query name that may be logged and stored
+// under the given redaction mode. Every mode maps the same name to the same
+// value, so domain counts can still be aggregated. The hash mode keys its HMAC
+// with key, so names cannot be recovered by hashing candidate domains.
+func redactName(name, mode, key string) string {
+	switch mode {
+	case config.QueryRedactionRegistrable:
+		fqdn := strings.ToLower(dns.Fqdn(name))
+		registrable, err := publicsuffix.EffectiveTLDPlusOne(strings.TrimSuffix(fqdn, "."))
+		if err != nil {
+			// Public suffixes and the root have no registrable domain
+			return fqdn
+		}
+		return registrable + "."
+	case config.QueryRedactionHash:
+		mac := hmac.New(sha256.New, []byte(key))
+		mac.Write([]byte(strings.ToLower(dns.Fqdn(name))))
+		return hex.EncodeToString(mac.Sum(nil))
+	case config.QueryRedactionDrop:
+		return redactedName
+	default:
+		return name
+	}
+}
+
+// redact returns the logged form of a name under the configured redaction
+func (s *DNSServer) redact(name string) string {
+	return redactName(name, s.config.QueryRedaction, s.config.QueryRedactionKey)
+}
+
+// logAnswers converts answer records to their logged form, redacting owner
+// names and the names in their data the same way as the query name
+func (s *DNSServer) logAnswers(rrs []dns.RR) [][]string {
+	if s.config.QueryRedaction == config.QueryRedactionNone {
+		return types.ExtractAnswers(rrs)
+	}
+
+	redacted := make([]dns.RR, len(rrs))
+	for i, rr := range rrs {
+		rr = dns.Copy(rr)
+		rr.Header().Name = s.redact(rr.Header().Name)
+		switch rr := rr.(type) {
+		case *dns.CNAME:
+			rr.Target = s.redact(rr.Target)
+		case *dns.DNAME:
+			rr.Target = s.redact(rr.Target)
+		case *dns.PTR:
+			rr.Ptr = s.redact(rr.Ptr)
+		case *dns.MX:
+			rr.Mx = s.redact(rr.Mx)
+		case *dns.SRV:
+			rr.Target = s.redact(rr.Target)
+		case *dns.NS:
+			rr.Ns = s.redact(rr.Ns)
+		case *dns.SOA:
+			rr.Ns = s.redact(rr.Ns)
+			rr.Mbox = s.redact(rr.Mbox)
+		case *dns.SVCB:
+			rr.Target = s.redact(rr.Target)
+		case *dns.HTTPS:
+			rr.Target = s.redact(rr.Target)
+		}
+		redacted[i] = rr
+	}
+	return types.ExtractAnswers(redacted)
+}
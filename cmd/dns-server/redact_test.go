@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"dns-go/internal/config"
+	"dns-go/internal/types"
+	"dns-go/internal/upstream/testutil"
+
+	"github.com/miekg/dns"
+)
+
+func TestRedactName(t *testing.T) {
+	tests := []struct {
+		mode string
+		name string
+		want string
+	}{
+		{mode: config.QueryRedactionNone, name: "www.Example.com.", want: "www.Example.com."},
+		{mode: config.QueryRedactionRegistrable, name: "a.b.Example.com.", want: "example.com."},
+		{mode: config.QueryRedactionRegistrable, name: "www.example.co.uk.", want: "example.co.uk."},
+		{mode: config.QueryRedactionRegistrable, name: "co.uk.", want: "co.uk."},
+		{mode: config.QueryRedactionHash, name: "www.Example.com.", want: "732cbc2b0c8de95267d473e4d8a83d97924bdbfc010c932d9ebca0a020071dfd"},
+		{mode: config.QueryRedactionDrop, name: "www.example.com.", want: "redacted."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode+" "+tt.name, func(t *testing.T) {
+			if got := redactName(tt.name, tt.mode, "secret"); got != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+
+	if redactName("www.example.com.", config.QueryRedactionHash, "other") == redactName("www.example.com.", config.QueryRedactionHash, "secret") {
+		t.Error("Expected hashes under different keys to differ")
+	}
+}
+
+func TestHandleDNSRequest_QueryRedaction(t *testing.T) {
+	tests := []struct {
+		mode string
+		want string
+	}{
+		{mode: config.QueryRedactionNone, want: "www.example.com."},
+		{mode: config.QueryRedactionRegistrable, want: "example.com."},
+		{mode: config.QueryRedactionHash, want: redactName("www.example.com.", config.QueryRedactionHash, "secret")},
+		{mode: config.QueryRedactionDrop, want: "redacted."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			upstream := testutil.NewServer(t, testutil.Answer("192.0.2.1", 60))
+			s, logs := newTestServer(t, upstream, func(cfg *config.Config) {
+				cfg.QueryRedaction = tt.mode
+				cfg.QueryRedactionKey = "secret"
+			})
+
+			resp := query(s, "www.example.com", dns.TypeA)
+			if resp == nil || len(resp.Answer) != 1 || resp.Answer[0].Header().Name != "www.example.com." {
+				t.Fatalf("Expected the client to get the unredacted answer, got %v", resp)
+			}
+
+			var entry types.LogEntry
+			if err := json.Unmarshal(firstLogLine(logs), &entry); err != nil {
+				t.Fatalf("Failed to decode log entry: %v", err)
+			}
+			if entry.Request.Query != tt.want {
+				t.Errorf("Expected stored query %q, got %q", tt.want, entry.Request.Query)
+			}
+			if len(entry.Answers) != 1 || entry.Answers[0][0] != tt.want {
+				t.Errorf("Expected stored answer owner %q, got %v", tt.want, entry.Answers)
+			}
+		})
+	}
+}
+
+func TestHandleDNSRequest_RedactsAnswerData(t *testing.T) {
+	upstream := testutil.NewServer(t, func(req *dns.Msg) *dns.Msg {
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		cname, _ := dns.NewRR("www.example.com. 60 IN CNAME edge.cdn.example.net.")
+		a, _ := dns.NewRR("edge.cdn.example.net. 60 IN A 192.0.2.1")
+		resp.Answer = []dns.RR{cname, a}
+		return resp
+	})
+
+	for _, mode := range []string{config.QueryRedactionRegistrable, config.QueryRedactionHash, config.QueryRedactionDrop} {
+		t.Run(mode, func(t *testing.T) {
+			s, logs := newTestServer(t, upstream, func(cfg *config.Config) {
+				cfg.QueryRedaction = mode
+				cfg.QueryRedactionKey = "secret"
+			})
+
+			resp := query(s, "www.example.com", dns.TypeA)
+			if resp == nil || len(resp.Answer) != 2 || resp.Answer[0].(*dns.CNAME).Target != "edge.cdn.example.net." {
+				t.Fatalf("Expected the client to get the unredacted answer, got %v", resp)
+			}
+
+			var entry types.LogEntry
+			if err := json.Unmarshal(firstLogLine(logs), &entry); err != nil {
+				t.Fatalf("Failed to decode log entry: %v", err)
+			}
+			if len(entry.Answers) != 2 {
+				t.Fatalf("Expected 2 stored answers, got %v", entry.Answers)
+			}
+			for _, answer := range entry.Answers {
+				for _, field := range answer {
+					if strings.Contains(field, "www.example.com") || strings.Contains(field, "edge.cdn") {
+						t.Errorf("Expected names to be redacted, got %v", answer)
+					}
+				}
+			}
+			if want := redactName("edge.cdn.example.net.", mode, "secret"); entry.Answers[0][len(entry.Answers[0])-1] != want {
+				t.Errorf("Expected CNAME target %q, got %v", want, entry.Answers[0])
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"dns-go/internal/resolver"
+)
+
+// Reload re-reads the custom DNS mappings (PostgreSQL or custom-dns.json), the
+// hosts file and the blocklist file, and applies them to the running server.
+// Every source is read before any is applied, so nothing is applied when one
+// cannot be read. All other settings, upstreams included, are given as flags
+// and only change with a restart.
+func (s *DNSServer) Reload() error {
+	var blocked []string
+	if s.blocklist != nil && s.config.BlocklistFile != "" {
+		domains, err := resolver.LoadBlocklistFile(s.config.BlocklistFile)
+		if err != nil {
+			return err
+		}
+		blocked = domains
+	}
+
+	mappings, err := s.config.ReloadMappings()
+	if err != nil {
+		return fmt.Errorf("failed to reload DNS mappings: %w", err)
+	}
+	if mappings != nil {
+		added, removed, changed := diffMappings(s.resolver.GetMappings(), mappings)
+		s.resolver.UpdateMappings(mappings)
+		s.logger.Info("Reloaded custom DNS mappings", map[string]interface{}{
+			"mapping_count": len(mappings),
+			"added":         added,
+			"removed":       removed,
+			"changed":       changed,
+		})
+	}
+
+	if blocked != nil {
+		s.blocklist.SetDomains(blocked)
+		s.logger.Info("Reloaded blocklist", map[string]interface{}{
+			"blocked_domains": len(blocked),
+		})
+	}
+
+	return nil
+}
+
+// diffMappings counts the domains added, removed and mapped to a different
+// value in next compared to prev
+func diffMappings(prev, next map[string]string) (added, removed, changed int) {
+	for domain, value := range next {
+		old, exists := prev[domain]
+		switch {
+		case !exists:
+			added++
+		case old != value:
+			changed++
+		}
+	}
+	for domain := range prev {
+		if _, exists := next[domain]; !exists {
+			removed++
+		}
+	}
+	return added, removed, changed
+}
+
+// reloadOnSignal calls Reload for each signal received (SIGHUP) until ctx is
+// done, logging failures
+func (s *DNSServer) reloadOnSignal(ctx context.Context, signals <-chan os.Signal) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case sig := <-signals:
+			s.logger.Info("Received reload signal", map[string]interface{}{
+				"signal": sig.String(),
+			})
+			if err := s.Reload(); err != nil {
+				s.logger.Error("Failed to reload configuration", map[string]interface{}{
+					"error": err.Error(),
+				})
+			}
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"dns-go/internal/config"
+	"dns-go/internal/logging"
+	"dns-go/internal/upstream/testutil"
+
+	"github.com/miekg/dns"
+)
+
+func TestReload(t *testing.T) {
+	for _, env := range []string{"POSTGRES_HOST", "POSTGRES_PORT", "POSTGRES_DB"} {
+		t.Setenv(env, "")
+	}
+	t.Chdir(t.TempDir())
+
+	write := func(name, data string) {
+		t.Helper()
+		if err := os.WriteFile(name, []byte(data), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	write("custom-dns.json", `{"mappings": {"server.local": "192.168.0.30"}}`)
+	write("hosts", "192.168.0.50 nas.local\n")
+	write("blocklist", "ads.example\n")
+
+	upstream := testutil.NewServer(t, testutil.Answer("192.0.2.1", 60))
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	os.Args = []string{"test", "-upstreams=" + upstream.Addr, "-hosts-file=hosts", "-blocklist=blocklist"}
+
+	cfg, err := config.LoadFromFlags()
+	if err != nil {
+		t.Fatalf("Failed to load configuration: %v", err)
+	}
+	s := NewDNSServer(cfg, logging.New(&bytes.Buffer{}, logging.ERROR))
+
+	address := func(name string) net.IP {
+		t.Helper()
+		resp := query(s, name, dns.TypeA)
+		if resp == nil || resp.Rcode != dns.RcodeSuccess || len(resp.Answer) != 1 {
+			return nil
+		}
+		return resp.Answer[0].(*dns.A).A
+	}
+	blocked := func(name string) bool {
+		resp := query(s, name, dns.TypeA)
+		return resp != nil && resp.Rcode == dns.RcodeNameError
+	}
+
+	if ip := address("server.local"); !ip.Equal(net.ParseIP("192.168.0.30")) {
+		t.Fatalf("Expected the initial mapping, got %v", ip)
+	}
+
+	write("custom-dns.json", `{"mappings": {"server.local": "192.168.0.31", "web.local": "192.168.0.40"}}`)
+	write("hosts", "192.168.0.51 nas.local\n")
+	write("blocklist", "tracker.example\n")
+	if err := s.Reload(); err != nil {
+		t.Fatalf("Unexpected reload error: %v", err)
+	}
+
+	for name, want := range map[string]string{
+		"server.local": "192.168.0.31",
+		"web.local":    "192.168.0.40",
+		"nas.local":    "192.168.0.51",
+	} {
+		if ip := address(name); !ip.Equal(net.ParseIP(want)) {
+			t.Errorf("Expected %s to resolve to %s after the reload, got %v", name, want, ip)
+		}
+	}
+	if blocked("ads.example") || !blocked("tracker.example") {
+		t.Error("Expected the reloaded blocklist to replace the previous one")
+	}
+
+	// A source that cannot be read leaves the running configuration alone
+	write("custom-dns.json", `{"mappings": `)
+	if err := s.Reload(); err == nil {
+		t.Error("Expected an error for a malformed custom DNS file")
+	}
+	if ip := address("web.local"); !ip.Equal(net.ParseIP("192.168.0.40")) {
+		t.Errorf("Expected the previous mappings to stay in effect, got %v", ip)
+	}
+}
+
+func TestReload_HostsFileOnly(t *testing.T) {
+	for _, env := range []string{"POSTGRES_HOST", "POSTGRES_PORT", "POSTGRES_DB"} {
+		t.Setenv(env, "")
+	}
+	t.Chdir(t.TempDir())
+
+	write := func(name, data string) {
+		t.Helper()
+		if err := os.WriteFile(name, []byte(data), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	write("hosts", "192.168.0.50 nas.local\n192.168.0.60 old.example\n")
+	write("blocklist", "ads.example\n")
+
+	upstream := testutil.NewServer(t, testutil.Answer("192.0.2.1", 60))
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	os.Args = []string{"test", "-upstreams=" + upstream.Addr, "-custom-dns=cli.local=10.0.0.9", "-hosts-file=hosts", "-blocklist=blocklist"}
+
+	cfg, err := config.LoadFromFlags()
+	if err != nil {
+		t.Fatalf("Failed to load configuration: %v", err)
+	}
+	s := NewDNSServer(cfg, logging.New(&bytes.Buffer{}, logging.ERROR))
+
+	address := func(name string) net.IP {
+		t.Helper()
+		resp := query(s, name, dns.TypeA)
+		if resp == nil || resp.Rcode != dns.RcodeSuccess || len(resp.Answer) != 1 {
+			return nil
+		}
+		return resp.Answer[0].(*dns.A).A
+	}
+
+	write("hosts", "192.168.0.51 nas.local\n192.168.0.70 new.local\n")
+	if err := s.Reload(); err != nil {
+		t.Fatalf("Unexpected reload error: %v", err)
+	}
+
+	for name, want := range map[string]string{
+		"nas.local":   "192.168.0.51",
+		"new.local":   "192.168.0.70",
+		"cli.local":   "10.0.0.9",
+		"old.example": "192.0.2.1", // no longer mapped, forwarded upstream
+	} {
+		if ip := address(name); !ip.Equal(net.ParseIP(want)) {
+			t.Errorf("Expected %s to resolve to %s after the reload, got %v", name, want, ip)
+		}
+	}
+
+	// A source failing to load keeps the hosts file changes from being applied
+	write("hosts", "192.168.0.52 nas.local\n")
+	if err := os.Remove("blocklist"); err != nil {
+		t.Fatalf("Failed to remove the blocklist: %v", err)
+	}
+	if err := s.Reload(); err == nil {
+		t.Fatal("Expected an error for a missing blocklist")
+	}
+	if ip := address("nas.local"); !ip.Equal(net.ParseIP("192.168.0.51")) {
+		t.Errorf("Expected the previous hosts mappings to stay in effect, got %v", ip)
+	}
+	if got := s.config.GetCustomDNS()["nas.local."]; got != "192.168.0.51" {
+		t.Errorf("Expected the configuration to keep the previous hosts mappings, got %q", got)
+	}
+}
+
+func TestDiffMappings(t *testing.T) {
+	prev := map[string]string{"a.": "10.0.0.1", "b.": "10.0.0.2", "c.": "10.0.0.3"}
+	next := map[string]string{"a.": "10.0.0.1", "b.": "10.0.0.20", "d.": "10.0.0.4"}
+
+	added, removed, changed := diffMappings(prev, next)
+	if added != 1 || removed != 1 || changed != 1 {
+		t.Errorf("Expected 1 added, 1 removed and 1 changed, got %d, %d and %d", added, removed, changed)
+	}
+}
+
+func TestReload_BlocklistAddedLater(t *testing.T) {
+	for _, env := range []string{"POSTGRES_HOST", "POSTGRES_PORT", "POSTGRES_DB"} {
+		t.Setenv(env, "")
+	}
+
+	path := filepath.Join(t.TempDir(), "blocklist")
+	upstream := testutil.NewServer(t, testutil.Answer("192.0.2.1", 60))
+	s, _ := newTestServer(t, upstream, func(cfg *config.Config) {
+		cfg.BlocklistFile = path
+	})
+
+	if err := os.WriteFile(path, []byte("ads.example\n"), 0644); err != nil {
+		t.Fatalf("Failed to write blocklist: %v", err)
+	}
+	if err := s.Reload(); err != nil {
+		t.Fatalf("Unexpected reload error: %v", err)
+	}
+	if resp := query(s, "ads.example", dns.TypeA); resp == nil || resp.Rcode != dns.RcodeNameError {
+		t.Errorf("Expected entries added to the blocklist file to be blocked after a reload, got %v", resp)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"github.com/miekg/dns"
+)
+
+// minimizeResponse returns a copy of the response keeping only the answer
+// section. The EDNS0 OPT pseudo-record is preserved so the client still sees
+// the negotiated payload size and flags, and DNSSEC records are preserved so
+// signatures and denial-of-existence proofs stay verifiable.
+func minimizeResponse(resp *dns.Msg) *dns.Msg {
+	minimized := resp.Copy()
+
+	ns := minimized.Ns[:0]
+	for _, rr := range minimized.Ns {
+		if isDNSSECRecord(rr) {
+			ns = append(ns, rr)
+		}
+	}
+	minimized.Ns = ns
+	if len(minimized.Ns) == 0 {
+		minimized.Ns = nil
+	}
+
+	extra := minimized.Extra[:0]
+	for _, rr := range minimized.Extra {
+		if rr.Header().Rrtype == dns.TypeOPT || isDNSSECRecord(rr) {
+			extra = append(extra, rr)
+		}
+	}
+	minimized.Extra = extra
+	if len(minimized.Extra) == 0 {
+		minimized.Extra = nil
+	}
+
+	return minimized
+}
+
+// isDNSSECRecord reports whether rr carries DNSSEC data that response
+// filtering must pass through untouched
+func isDNSSECRecord(rr dns.RR) bool {
+	switch rr.Header().Rrtype {
+	case dns.TypeRRSIG, dns.TypeDNSKEY, dns.TypeDS, dns.TypeNSEC, dns.TypeNSEC3:
+		return true
+	}
+	return false
+}
+
+// orderAnswers returns a copy of the response with A and AAAA answers reordered
+// so the preferred family comes first. Address records only swap places among
+// themselves, so CNAME chains and other records keep their positions.
+func orderAnswers(resp *dns.Msg, preferred uint16) *dns.Msg {
+	ordered := resp.Copy()
+
+	var slots []int
+	var first, second []dns.RR
+	for i, rr := range ordered.Answer {
+		switch rr.Header().Rrtype {
+		case dns.TypeA, dns.TypeAAAA:
+			slots = append(slots, i)
+			if rr.Header().Rrtype == preferred {
+				first = append(first, rr)
+			} else {
+				second = append(second, rr)
+			}
+		}
+	}
+
+	for i, rr := range append(first, second...) {
+		ordered.Answer[slots[i]] = rr
+	}
+
+	return ordered
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"dns-go/internal/config"
+	"dns-go/internal/logging"
+	"dns-go/internal/upstream"
+
+	"github.com/miekg/dns"
+)
+
+// zoneRoute sends queries for names in a zone to servers of its own instead of
+// the default upstreams
+type zoneRoute struct {
+	zone    string
+	manager *upstream.Manager
+}
+
+// newZoneRoutes creates the upstream managers of the configured stub zones and
+// upstream routes, most specific zone first. Upstream routes query recursive
+// servers with the same settings as the default upstreams. Stub zone health
+// checks probe the zone apex, and their answers are relayed without DNSSEC
+// validation since internal zones rarely chain up to the root trust anchor.
+func newZoneRoutes(cfg *config.Config, logger *logging.Logger) []zoneRoute {
+	routes := make([]zoneRoute, 0, len(cfg.StubZones)+len(cfg.UpstreamRoutes))
+	for zone, servers := range cfg.StubZones {
+		manager := upstream.New(servers, cfg.Timeout, cfg.RetryAttempts)
+		manager.SetAuthoritative(true)
+		manager.SetRandomizeQueryIDs(cfg.RandomizeQueryIDs)
+		manager.SetServfailIsFailure(cfg.ServfailIsFailure)
+		manager.SetFailoverOnRefused(cfg.FailoverOnRefused)
+		manager.SetTCPFallback(cfg.TCPFallback)
+		manager.SetServerTimeouts(cfg.UpstreamTimeouts)
+		manager.SetCircuitBreaker(cfg.UpstreamFailureThreshold, cfg.UpstreamRecoveryTimeout)
+		manager.SetHealthProbe(zone, cfg.HealthProbeLatency)
+		routes = append(routes, zoneRoute{zone: zone, manager: manager})
+	}
+	for zone, servers := range cfg.UpstreamRoutes {
+		routes = append(routes, zoneRoute{zone: zone, manager: newUpstreamManager(cfg, servers, logger)})
+	}
+
+	sort.Slice(routes, func(i, j int) bool {
+		return dns.CountLabel(routes[i].zone) > dns.CountLabel(routes[j].zone)
+	})
+	return routes
+}
+
+// managerFor returns the upstream manager resolving name: that of the most
+// specific stub zone or upstream route containing it, or the one of the
+// default upstreams
+func (s *DNSServer) managerFor(name string) *upstream.Manager {
+	name = strings.ToLower(dns.Fqdn(name))
+	for _, route := range s.zoneRoutes {
+		if dns.IsSubDomain(route.zone, name) {
+			return route.manager
+		}
+	}
+	return s.upstreamMgr
+}
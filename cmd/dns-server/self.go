@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// selfTTL is the TTL of answers for the server's own hostnames
+const selfTTL = 300
+
+// isSelfHostname reports whether name is one of the configured hostnames of
+// this server
+func (s *DNSServer) isSelfHostname(name string) bool {
+	name = strings.ToLower(dns.Fqdn(name))
+	for _, self := range s.config.SelfHostnames {
+		if name == self {
+			return true
+		}
+	}
+	return false
+}
+
+// selfAddress returns the address the server answers its own hostnames with:
+// the configured self address, else the listen address, else the address the
+// query was received on, skipping unspecified (wildcard) addresses
+func (s *DNSServer) selfAddress(w dns.ResponseWriter) net.IP {
+	if ip := net.ParseIP(s.config.SelfAddress); ip != nil {
+		return ip
+	}
+	if ip := net.ParseIP(s.config.ListenAddress); ip != nil && !ip.IsUnspecified() {
+		return ip
+	}
+
+	var ip net.IP
+	switch addr := w.LocalAddr().(type) {
+	case *net.UDPAddr:
+		ip = addr.IP
+	case *net.TCPAddr:
+		ip = addr.IP
+	}
+	if ip == nil || ip.IsUnspecified() {
+		return nil
+	}
+	return ip
+}
+
+// selfResponse answers a query for one of the server's own hostnames with its
+// address. Other query types for the name get an empty answer. It returns nil
+// for other names, or when the server's address is not known.
+func (s *DNSServer) selfResponse(w dns.ResponseWriter, question dns.Question) *dns.Msg {
+	if !s.isSelfHostname(question.Name) {
+		return nil
+	}
+	ip := s.selfAddress(w)
+	if ip == nil {
+		return nil
+	}
+
+	msg := &dns.Msg{}
+	msg.SetReply(&dns.Msg{Question: []dns.Question{question}})
+	msg.Authoritative = true
+
+	hdr := dns.RR_Header{Name: question.Name, Rrtype: question.Qtype, Class: dns.ClassINET, Ttl: selfTTL}
+	if ip4 := ip.To4(); ip4 != nil {
+		if question.Qtype == dns.TypeA {
+			msg.Answer = append(msg.Answer, &dns.A{Hdr: hdr, A: ip4})
+		}
+	} else if question.Qtype == dns.TypeAAAA {
+		msg.Answer = append(msg.Answer, &dns.AAAA{Hdr: hdr, AAAA: ip})
+	}
+
+	return msg
+}
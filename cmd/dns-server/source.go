@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+
+	"dns-go/internal/types"
+
+	"github.com/miekg/dns"
+)
+
+// sourceOption returns the answer source option of a query, if it has one
+func sourceOption(r *dns.Msg) *dns.EDNS0_LOCAL {
+	opt := r.IsEdns0()
+	if opt == nil {
+		return nil
+	}
+	for _, option := range opt.Option {
+		if local, ok := option.(*dns.EDNS0_LOCAL); ok && local.Code == types.EDNS0SourceOption {
+			return local
+		}
+	}
+	return nil
+}
+
+// withoutSourceOption returns the query without the answer source option, so
+// it is not forwarded to the upstream servers
+func withoutSourceOption(r *dns.Msg) *dns.Msg {
+	if sourceOption(r) == nil {
+		return r
+	}
+
+	stripped := r.Copy()
+	opt := stripped.IsEdns0()
+	options := opt.Option[:0]
+	for _, option := range opt.Option {
+		if local, ok := option.(*dns.EDNS0_LOCAL); !ok || local.Code != types.EDNS0SourceOption {
+			options = append(options, option)
+		}
+	}
+	opt.Option = options
+	return stripped
+}
+
+// reportSource adds how the query was answered to resp when a loopback client
+// asked for it with the answer source option
+func reportSource(w dns.ResponseWriter, r, resp *dns.Msg, status, upstream string) {
+	if sourceOption(r) == nil {
+		return
+	}
+	if ip := net.ParseIP(types.ExtractIPFromAddr(w.RemoteAddr().String())); ip == nil || !ip.IsLoopback() {
+		return
+	}
+
+	data, err := json.Marshal(types.AnswerSource{Status: status, Upstream: upstream})
+	if err != nil {
+		return
+	}
+	opt := resp.IsEdns0()
+	if opt == nil {
+		requestOpt := r.IsEdns0()
+		resp.SetEdns0(requestOpt.UDPSize(), requestOpt.Do())
+		opt = resp.IsEdns0()
+	}
+	opt.Option = append(opt.Option, &dns.EDNS0_LOCAL{Code: types.EDNS0SourceOption, Data: data})
+}
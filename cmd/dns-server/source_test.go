@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+
+	"dns-go/internal/types"
+	"dns-go/internal/upstream/testutil"
+
+	"github.com/miekg/dns"
+)
+
+func TestHandleDNSRequest_ReportsSource(t *testing.T) {
+	answer := testutil.Answer("192.0.2.1", 60)
+	forwarded := make(chan bool, 1)
+	upstream := testutil.NewServer(t, func(req *dns.Msg) *dns.Msg {
+		forwarded <- sourceOption(req) != nil
+		return answer(req)
+	})
+	s, _ := newTestServer(t, upstream, nil)
+
+	tests := []struct {
+		name   string
+		remote net.IP
+		want   bool
+	}{
+		{name: "loopback client", remote: net.ParseIP("127.0.0.1"), want: true},
+		{name: "remote client", remote: net.ParseIP("192.0.2.100"), want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := new(dns.Msg)
+			req.SetQuestion(dns.Fqdn(tt.name+".example.com"), dns.TypeA)
+			req.SetEdns0(dns.DefaultMsgSize, false)
+			req.IsEdns0().Option = append(req.IsEdns0().Option, &dns.EDNS0_LOCAL{Code: types.EDNS0SourceOption})
+
+			w := newTestResponseWriter()
+			w.remote = &net.UDPAddr{IP: tt.remote, Port: 53000}
+			s.handleDNSRequest(w, req)
+
+			if <-forwarded {
+				t.Error("Expected the source option not to be forwarded upstream")
+			}
+			if w.msg == nil || w.msg.Rcode != dns.RcodeSuccess {
+				t.Fatalf("Expected NOERROR, got %v", w.msg)
+			}
+
+			option := sourceOption(w.msg)
+			if !tt.want {
+				if option != nil {
+					t.Errorf("Expected no source option for %s, got %s", tt.remote, option.Data)
+				}
+				return
+			}
+			if option == nil {
+				t.Fatal("Expected a source option in the response")
+			}
+			var source types.AnswerSource
+			if err := json.Unmarshal(option.Data, &source); err != nil {
+				t.Fatalf("Failed to decode source option: %v", err)
+			}
+			if source.Status != "success" || source.Upstream != upstream.Addr {
+				t.Errorf("Expected success from %s, got %+v", upstream.Addr, source)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+import React from 'react';
+import { RcodeMetric } from '../../types';
+
+interface ResponseCodesProps {
+  rcodes: RcodeMetric[] | null | undefined;
+}
+
+const ResponseCodes: React.FC<ResponseCodesProps> = ({ rcodes }) => {
+  if (!rcodes || rcodes.length === 0) {
+    return (
+      <div className="bg-white rounded-lg shadow-md p-6">
+        <h3 className="text-lg font-semibold text-gray-900 mb-4">Response Codes</h3>
+        <div className="text-center text-gray-500 py-8">
+          No response data available
+        </div>
+      </div>
+    );
+  }
+
+  const total = rcodes.reduce((sum, rcode) => sum + rcode.count, 0);
+
+  const formatNumber = (num: number): string => {
+    if (num >= 1000000) {
+      return (num / 1000000).toFixed(1) + 'M';
+    } else if (num >= 1000) {
+      return (num / 1000).toFixed(1) + 'K';
+    }
+    return num.toString();
+  };
+
+  const colorFor = (rcode: string): string => {
+    switch (rcode) {
+      case 'NOERROR':
+        return 'bg-green-100 border-green-300 text-green-800';
+      case 'NXDOMAIN':
+        return 'bg-yellow-100 border-yellow-300 text-yellow-800';
+      case 'SERVFAIL':
+        return 'bg-red-100 border-red-300 text-red-800';
+      case 'REFUSED':
+        return 'bg-orange-100 border-orange-300 text-orange-800';
+      default:
+        return 'bg-gray-100 border-gray-300 text-gray-800';
+    }
+  };
+
+  return (
+    <div className="bg-white rounded-lg shadow-md p-6">
+      <h3 className="text-lg font-semibold text-gray-900 mb-4">Response Codes</h3>
+      <div className="grid grid-cols-2 md:grid-cols-5 gap-4">
+        {rcodes.map((rcode) => (
+          <div
+            key={rcode.rcode}
+            className={`p-4 rounded-lg border-2 text-center ${colorFor(rcode.rcode)}`}
+          >
+            <div className="font-semibold text-sm mb-1">{rcode.rcode}</div>
+            <div className="text-xl font-bold">{formatNumber(rcode.count)}</div>
+            <div className="text-xs">{total > 0 ? ((rcode.count / total) * 100).toFixed(1) : '0.0'}%</div>
+          </div>
+        ))}
+      </div>
+    </div>
+  );
+};
+
+export default ResponseCodes;
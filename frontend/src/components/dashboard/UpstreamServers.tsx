@@ -0,0 +1,54 @@
+import React from 'react';
+import { UpstreamStats } from '../../types';
+
+interface UpstreamServersProps {
+  servers: Record<string, UpstreamStats> | null | undefined;
+}
+
+const UpstreamServers: React.FC<UpstreamServersProps> = ({ servers }) => {
+  if (!servers || Object.keys(servers).length === 0) {
+    return (
+      <div className="bg-white rounded-lg shadow-md p-6">
+        <h3 className="text-lg font-semibold text-gray-900 mb-4">Upstream Servers</h3>
+        <div className="text-center text-gray-500 py-8">
+          No upstream data available
+        </div>
+      </div>
+    );
+  }
+
+  // Most wins first
+  const entries = Object.entries(servers).sort(([, a], [, b]) => b.wins - a.wins);
+  const totalWins = entries.reduce((sum, [, stats]) => sum + stats.wins, 0);
+
+  const formatNumber = (num: number): string => {
+    if (num >= 1000000) {
+      return (num / 1000000).toFixed(1) + 'M';
+    } else if (num >= 1000) {
+      return (num / 1000).toFixed(1) + 'K';
+    }
+    return num.toString();
+  };
+
+  return (
+    <div className="bg-white rounded-lg shadow-md p-6">
+      <h3 className="text-lg font-semibold text-gray-900 mb-4">Upstream Servers</h3>
+      <div className="grid grid-cols-1 md:grid-cols-3 gap-4">
+        {entries.map(([server, stats]) => (
+          <div
+            key={server}
+            className="p-4 rounded-lg border-2 text-center bg-blue-100 border-blue-300 text-blue-800"
+          >
+            <div className="font-semibold text-sm mb-1 break-all">{server}</div>
+            <div className="text-xl font-bold">{formatNumber(stats.wins)} wins</div>
+            <div className="text-xs">
+              {totalWins > 0 ? ((stats.wins / totalWins) * 100).toFixed(1) : '0.0'}% of answers
+            </div>
+          </div>
+        ))}
+      </div>
+    </div>
+  );
+};
+
+export default UpstreamServers;
@@ -4,6 +4,8 @@ import { useMetrics, useHealth } from '../hooks/useMetrics.ts';
 import OverviewCards from '../components/dashboard/OverviewCards.tsx';
 import Charts from '../components/dashboard/Charts.tsx';
 import QueryTypes from '../components/dashboard/QueryTypes.tsx';
+import ResponseCodes from '../components/dashboard/ResponseCodes.tsx';
+import UpstreamServers from '../components/dashboard/UpstreamServers.tsx';
 import TopClients from '../components/dashboard/TopClients.tsx';
 import LogCounts from '../components/dashboard/LogCounts.tsx';
 import ConnectionStatus from '../components/shared/ConnectionStatus.tsx';
@@ -80,6 +82,16 @@ const DashboardPage: React.FC = () => {
             <TopClients clients={metrics?.top_clients} />
           </section>
 
+          {/* Response Code Breakdown */}
+          <section>
+            <ResponseCodes rcodes={metrics?.rcodes} />
+          </section>
+
+          {/* Upstream Wins */}
+          <section>
+            <UpstreamServers servers={metrics?.upstream_servers} />
+          </section>
+
           {/* Log Storage Statistics */}
           <section>
             <LogCounts />
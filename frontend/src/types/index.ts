@@ -66,6 +66,22 @@ export interface QueryTypeMetric {
   count: number;
 }
 
+export interface RcodeMetric {
+  rcode: string;
+  count: number;
+}
+
+export interface UpstreamStats {
+  total_queries: number;
+  successful_queries: number;
+  failed_queries: number;
+  races: number; // Answered queries this server was sent
+  wins: number; // Answered queries this server's response was used for
+  truncations: number;
+  average_rtt_ms: number;
+  last_used: string;
+}
+
 export interface Metrics {
   total_requests?: number;
   upstream_requests?: number;
@@ -73,6 +89,8 @@ export interface Metrics {
   avg_response_time?: number;
   clients?: Client[];
   query_types?: QueryTypeMetric[]; // Pre-sorted, top 8 query types
+  rcodes?: RcodeMetric[]; // Pre-sorted response code breakdown
+  upstream_servers?: Record<string, UpstreamStats>;
   requests?: DnsRequest[];
   uptime?: string;
   version?: string;
@@ -90,6 +108,7 @@ export interface VersionInfo {
   git_commit?: string;
   build_date?: string;
   go_version?: string;
+  platform?: string;
 }
 
 export interface SearchResponse {
@@ -17,29 +17,45 @@ const (
 
 // Scheduler manages periodic background jobs
 type Scheduler struct {
-	pgClient      *postgres.Client
-	retentionDays int
-	stopChan      chan struct{}
-	doneChan      chan struct{}
+	pgClient  *postgres.Client
+	retention time.Duration
+	stopChan  chan struct{}
+	doneChan  chan struct{}
+
+	// onAggregation is called after aggregated stats have been stored
+	onAggregation func()
 }
 
 // NewScheduler creates a new scheduler instance
 func NewScheduler(pgClient *postgres.Client) *Scheduler {
 	return &Scheduler{
-		pgClient:      pgClient,
-		retentionDays: DefaultRetentionDays,
-		stopChan:      make(chan struct{}),
-		doneChan:      make(chan struct{}),
+		pgClient:  pgClient,
+		retention: DefaultRetentionDays * 24 * time.Hour,
+		stopChan:  make(chan struct{}),
+		doneChan:  make(chan struct{}),
 	}
 }
 
 // SetRetentionDays sets the log retention period in days
 func (s *Scheduler) SetRetentionDays(days int) {
 	if days > 0 {
-		s.retentionDays = days
+		s.retention = time.Duration(days) * 24 * time.Hour
+	}
+}
+
+// SetRetention sets how long DNS logs are kept before the cleanup job purges
+// them
+func (s *Scheduler) SetRetention(retention time.Duration) {
+	if retention > 0 {
+		s.retention = retention
 	}
 }
 
+// SetOnAggregation registers a function called after each successful aggregation
+func (s *Scheduler) SetOnAggregation(fn func()) {
+	s.onAggregation = fn
+}
+
 // Start starts the background jobs (aggregation hourly, cleanup daily)
 func (s *Scheduler) Start() error {
 	if s.pgClient == nil {
@@ -66,7 +82,7 @@ func (s *Scheduler) Start() error {
 
 	fmt.Println("🔄 Background scheduler started:")
 	fmt.Println("   - Aggregation: runs hourly")
-	fmt.Printf("   - Log cleanup: runs daily (retention: %d days)\n", s.retentionDays)
+	fmt.Printf("   - Log cleanup: runs daily (retention: %s)\n", FormatRetention(s.retention))
 
 	for {
 		select {
@@ -98,27 +114,41 @@ func (s *Scheduler) runAggregation() error {
 	}
 	duration := time.Since(start)
 	fmt.Printf("📊 Aggregation completed in %v\n", duration)
+
+	if s.onAggregation != nil {
+		s.onAggregation()
+	}
 	return nil
 }
 
 // runCleanup deletes old logs based on the retention policy
 func (s *Scheduler) runCleanup() error {
 	start := time.Now()
-	deletedCount, err := s.pgClient.DeleteOldLogs(s.retentionDays)
+	deletedCount, err := s.pgClient.PurgeLogsOlderThan(s.retention)
 	if err != nil {
 		return fmt.Errorf("cleanup failed: %w", err)
 	}
 	duration := time.Since(start)
 
 	if deletedCount > 0 {
-		fmt.Printf("🧹 Cleanup completed: deleted %d logs older than %d days (took %v)\n",
-			deletedCount, s.retentionDays, duration)
+		fmt.Printf("🧹 Cleanup completed: deleted %d logs older than %s (took %v)\n",
+			deletedCount, FormatRetention(s.retention), duration)
 	} else {
-		fmt.Printf("🧹 Cleanup completed: no logs older than %d days to delete\n", s.retentionDays)
+		fmt.Printf("🧹 Cleanup completed: no logs older than %s to delete\n", FormatRetention(s.retention))
 	}
 	return nil
 }
 
+// FormatRetention describes a retention period in days when it is a whole
+// number of days
+func FormatRetention(retention time.Duration) string {
+	day := 24 * time.Hour
+	if retention >= day && retention%day == 0 {
+		return fmt.Sprintf("%d days", retention/day)
+	}
+	return retention.String()
+}
+
 // Stop stops the scheduler gracefully
 func (s *Scheduler) Stop(ctx context.Context) error {
 	close(s.stopChan)
@@ -0,0 +1,161 @@
+// Package alert posts operational alerts, such as all upstreams being down, to
+// a webhook as JSON.
+package alert
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// webhookTimeout bounds each webhook POST
+const webhookTimeout = 5 * time.Second
+
+const (
+	// maxPendingPosts bounds the alerts being posted at once; further alerts
+	// are dropped until the webhook catches up
+	maxPendingPosts = 16
+
+	// maxTrackedKeys bounds the alert keys remembered for rate limiting before
+	// those outside the interval are forgotten
+	maxTrackedKeys = 4096
+)
+
+// Event identifies the kind of problem an alert reports
+type Event string
+
+const (
+	// EventUpstreamsDown fires when every upstream server is unhealthy
+	EventUpstreamsDown Event = "upstreams_down"
+
+	// EventHighFailureRate fires when the share of failed queries over a window
+	// exceeds the configured threshold
+	EventHighFailureRate Event = "high_failure_rate"
+
+	// EventDependencyDown fires when a backing service such as PostgreSQL
+	// cannot be reached
+	EventDependencyDown Event = "dependency_down"
+
+	// EventCanaryQuery fires when a client queries a canary domain
+	EventCanaryQuery Event = "canary_query"
+)
+
+// Alert is the JSON body posted to the webhook
+type Alert struct {
+	// Key tells apart alerts of the same event that are rate limited
+	// separately, such as canary queries of different clients and domains
+	Key string `json:"-"`
+
+	Event     Event                  `json:"event"`
+	Message   string                 `json:"message"`
+	Timestamp time.Time              `json:"timestamp"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+}
+
+// Notifier posts alerts to a webhook, firing each event and key at most once
+// per interval. A nil Notifier drops all alerts.
+type Notifier struct {
+	url      string
+	interval time.Duration
+	client   *http.Client
+	pending  chan struct{} // one slot per alert being posted
+
+	mu        sync.Mutex
+	lastFired map[string]time.Time // by event and key
+	onError   func(Alert, error)
+
+	wg sync.WaitGroup
+}
+
+// New creates a notifier posting to url, firing each event at most once per
+// interval
+func New(url string, interval time.Duration) *Notifier {
+	return &Notifier{
+		url:       url,
+		interval:  interval,
+		client:    &http.Client{Timeout: webhookTimeout},
+		pending:   make(chan struct{}, maxPendingPosts),
+		lastFired: make(map[string]time.Time),
+	}
+}
+
+// SetErrorHandler registers a function called when posting an alert fails
+func (n *Notifier) SetErrorHandler(fn func(Alert, error)) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.onError = fn
+}
+
+// Fire posts the alert in the background unless an alert of the same event and
+// key was fired within the interval, or too many alerts are still being posted.
+// It reports whether the alert was sent.
+func (n *Notifier) Fire(alert Alert) bool {
+	if n == nil {
+		return false
+	}
+	if alert.Timestamp.IsZero() {
+		alert.Timestamp = time.Now()
+	}
+	key := string(alert.Event) + "/" + alert.Key
+
+	n.mu.Lock()
+	if last, ok := n.lastFired[key]; ok && alert.Timestamp.Sub(last) < n.interval {
+		n.mu.Unlock()
+		return false
+	}
+	select {
+	case n.pending <- struct{}{}:
+	default:
+		n.mu.Unlock()
+		return false
+	}
+	if len(n.lastFired) >= maxTrackedKeys {
+		for k, last := range n.lastFired {
+			if alert.Timestamp.Sub(last) >= n.interval {
+				delete(n.lastFired, k)
+			}
+		}
+	}
+	n.lastFired[key] = alert.Timestamp
+	onError := n.onError
+	n.mu.Unlock()
+
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		defer func() { <-n.pending }()
+		if err := n.post(alert); err != nil && onError != nil {
+			onError(alert, err)
+		}
+	}()
+	return true
+}
+
+// Wait blocks until all alerts fired so far have been posted
+func (n *Notifier) Wait() {
+	if n != nil {
+		n.wg.Wait()
+	}
+}
+
+// post sends an alert to the webhook
+func (n *Notifier) post(alert Alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+
+	resp, err := n.client.Post(n.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %s", resp.Status)
+	}
+	return nil
+}
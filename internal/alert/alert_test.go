@@ -0,0 +1,188 @@
+package alert
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhook records the alerts posted to a test server
+type webhook struct {
+	*httptest.Server
+	mu     sync.Mutex
+	alerts []Alert
+}
+
+func newWebhook(t *testing.T, status int) *webhook {
+	t.Helper()
+	w := &webhook{}
+	w.Server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		var a Alert
+		if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+			t.Errorf("Failed to decode alert: %v", err)
+		}
+		w.mu.Lock()
+		w.alerts = append(w.alerts, a)
+		w.mu.Unlock()
+		rw.WriteHeader(status)
+	}))
+	t.Cleanup(w.Close)
+	return w
+}
+
+func (w *webhook) received() []Alert {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]Alert(nil), w.alerts...)
+}
+
+func TestNotifier_RateLimitsEachEvent(t *testing.T) {
+	hook := newWebhook(t, http.StatusOK)
+	n := New(hook.URL, time.Minute)
+
+	start := time.Now()
+	fire := func(event Event, at time.Duration) bool {
+		return n.Fire(Alert{Event: event, Message: "test", Timestamp: start.Add(at)})
+	}
+
+	if !fire(EventUpstreamsDown, 0) {
+		t.Fatal("Expected the first alert to be sent")
+	}
+	if fire(EventUpstreamsDown, 30*time.Second) {
+		t.Error("Expected a repeated alert within the interval to be dropped")
+	}
+	if !fire(EventHighFailureRate, 30*time.Second) {
+		t.Error("Expected another event to be sent within the interval")
+	}
+	if !fire(EventUpstreamsDown, time.Minute) {
+		t.Error("Expected the alert to be sent again once the interval passed")
+	}
+	n.Wait()
+
+	alerts := hook.received()
+	if len(alerts) != 3 {
+		t.Fatalf("Expected 3 posted alerts, got %d", len(alerts))
+	}
+	counts := map[Event]int{}
+	for _, a := range alerts {
+		counts[a.Event]++
+	}
+	if counts[EventUpstreamsDown] != 2 || counts[EventHighFailureRate] != 1 {
+		t.Errorf("Unexpected alerts %v", counts)
+	}
+}
+
+func TestNotifier_RateLimitsEachKey(t *testing.T) {
+	hook := newWebhook(t, http.StatusOK)
+	n := New(hook.URL, time.Minute)
+
+	start := time.Now()
+	fire := func(key string) bool {
+		return n.Fire(Alert{Event: EventCanaryQuery, Key: key, Timestamp: start})
+	}
+
+	if !fire("192.0.2.1 canary.example.") || !fire("192.0.2.2 canary.example.") {
+		t.Fatal("Expected alerts with different keys to be sent")
+	}
+	if fire("192.0.2.1 canary.example.") {
+		t.Error("Expected a repeated key within the interval to be dropped")
+	}
+	n.Wait()
+
+	if alerts := hook.received(); len(alerts) != 2 {
+		t.Errorf("Expected 2 posted alerts, got %d", len(alerts))
+	}
+}
+
+func TestNotifier_BoundsPendingPosts(t *testing.T) {
+	release := make(chan struct{})
+	hook := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer hook.Close()
+	n := New(hook.URL, time.Minute)
+
+	sent := 0
+	for i := 0; i < 2*maxPendingPosts; i++ {
+		if n.Fire(Alert{Event: EventCanaryQuery, Key: fmt.Sprint(i)}) {
+			sent++
+		}
+	}
+	close(release)
+	n.Wait()
+
+	if sent != maxPendingPosts {
+		t.Errorf("Expected %d alerts to be posted while the webhook is stuck, got %d", maxPendingPosts, sent)
+	}
+	if !n.Fire(Alert{Event: EventCanaryQuery, Key: "after"}) {
+		t.Error("Expected alerts to be posted again once the webhook caught up")
+	}
+	n.Wait()
+}
+
+func TestNotifier_ReportsErrors(t *testing.T) {
+	hook := newWebhook(t, http.StatusInternalServerError)
+	n := New(hook.URL, time.Minute)
+
+	var failed []Event
+	var mu sync.Mutex
+	n.SetErrorHandler(func(a Alert, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		failed = append(failed, a.Event)
+	})
+
+	n.Fire(Alert{Event: EventDependencyDown})
+	n.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(failed) != 1 || failed[0] != EventDependencyDown {
+		t.Errorf("Expected the failed post to be reported, got %v", failed)
+	}
+}
+
+func TestNotifier_Nil(t *testing.T) {
+	var n *Notifier
+	if n.Fire(Alert{Event: EventUpstreamsDown}) {
+		t.Error("Expected a nil notifier to drop alerts")
+	}
+	n.Wait()
+}
+
+func TestFailureWindow(t *testing.T) {
+	f := NewFailureWindow(time.Minute, 0.5)
+	start := time.Now()
+
+	// Too few queries to judge
+	for i := 0; i < minWindowQueries-1; i++ {
+		f.Record(start, true)
+	}
+	if _, exceeded := f.Record(start.Add(time.Minute), true); exceeded {
+		t.Error("Expected a window with too few queries not to exceed the threshold")
+	}
+
+	// Half of the queries failed
+	for i := 1; i < 2*minWindowQueries; i++ {
+		f.Record(start.Add(time.Minute), i%2 == 0)
+	}
+	if _, exceeded := f.Record(start.Add(90*time.Second), true); exceeded {
+		t.Error("Expected the window to stay open before it ends")
+	}
+	rate, exceeded := f.Record(start.Add(2*time.Minute), false)
+	if !exceeded {
+		t.Errorf("Expected the failure rate %v to exceed the threshold", rate)
+	}
+
+	// A healthy window
+	for i := 0; i < 2*minWindowQueries; i++ {
+		f.Record(start.Add(2*time.Minute), i == 0)
+	}
+	if rate, exceeded := f.Record(start.Add(3*time.Minute), false); exceeded {
+		t.Errorf("Expected failure rate %v not to exceed the threshold", rate)
+	}
+}
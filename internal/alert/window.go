@@ -0,0 +1,51 @@
+package alert
+
+import (
+	"sync"
+	"time"
+)
+
+// minWindowQueries is the fewest queries a window needs before its failure rate
+// is judged, so a handful of failures during quiet periods do not alert
+const minWindowQueries = 10
+
+// FailureWindow tracks the share of failed queries over consecutive windows
+type FailureWindow struct {
+	window    time.Duration
+	threshold float64
+
+	mu       sync.Mutex
+	start    time.Time
+	total    int
+	failures int
+}
+
+// NewFailureWindow creates a tracker judging the failure rate of each window
+// against threshold (e.g. 0.5 for half of the queries)
+func NewFailureWindow(window time.Duration, threshold float64) *FailureWindow {
+	return &FailureWindow{window: window, threshold: threshold}
+}
+
+// Record counts a query at time now. When now closes a window whose failure
+// rate reached the threshold, it returns that rate and true; a new window then
+// starts with this query.
+func (f *FailureWindow) Record(now time.Time, failed bool) (float64, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var rate float64
+	exceeded := false
+	if f.start.IsZero() || now.Sub(f.start) >= f.window {
+		if f.total >= minWindowQueries {
+			rate = float64(f.failures) / float64(f.total)
+			exceeded = rate >= f.threshold
+		}
+		f.start, f.total, f.failures = now, 0, 0
+	}
+
+	f.total++
+	if failed {
+		f.failures++
+	}
+	return rate, exceeded
+}
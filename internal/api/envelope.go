@@ -0,0 +1,81 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+)
+
+// envelopeVersionHeader selects the response envelope when set to
+// envelopeVersion, as the /api/v2 routes do
+const (
+	envelopeVersionHeader = "X-API-Version"
+	envelopeVersion       = "2"
+)
+
+// Envelope is the uniform shape of list responses for API version 2: the
+// listed items, metadata such as totals and filters, and an error on failure
+type Envelope struct {
+	Data  interface{}            `json:"data"`
+	Meta  map[string]interface{} `json:"meta"`
+	Error *EnvelopeError         `json:"error"`
+}
+
+// EnvelopeError describes a failed request inside an Envelope
+type EnvelopeError struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+// envelopeKey marks requests received on an /api/v2 route
+type envelopeKey struct{}
+
+// enveloped serves h with the response envelope, for the /api/v2 routes
+func enveloped(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h(w, r.WithContext(context.WithValue(r.Context(), envelopeKey{}, true)))
+	}
+}
+
+// wantsEnvelope reports whether the client asked for the response envelope,
+// through an /api/v2 route or the X-API-Version header
+func wantsEnvelope(r *http.Request) bool {
+	if v, _ := r.Context().Value(envelopeKey{}).(bool); v {
+		return true
+	}
+	return r.Header.Get(envelopeVersionHeader) == envelopeVersion
+}
+
+// writeList writes a list response. The legacy shape holds the data under
+// dataKey next to the metadata fields; the envelope separates the two.
+func writeList(w http.ResponseWriter, r *http.Request, dataKey string, data interface{}, meta map[string]interface{}) error {
+	if meta == nil {
+		meta = map[string]interface{}{}
+	}
+	if wantsEnvelope(r) {
+		return json.NewEncoder(w).Encode(Envelope{Data: data, Meta: meta})
+	}
+
+	response := make(map[string]interface{}, len(meta)+1)
+	for key, value := range meta {
+		response[key] = value
+	}
+	response[dataKey] = data
+	return json.NewEncoder(w).Encode(response)
+}
+
+// writeError replies with an error: as plain text in the legacy shape, or as
+// an envelope holding it
+func writeError(w http.ResponseWriter, r *http.Request, message string, status int) {
+	if !wantsEnvelope(r) {
+		http.Error(w, message, status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(Envelope{
+		Meta:  map[string]interface{}{},
+		Error: &EnvelopeError{Status: status, Message: message},
+	})
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWriteList(t *testing.T) {
+	data := []string{"example.com.", "example.org."}
+	meta := map[string]interface{}{"total": 2, "filter": "example"}
+
+	tests := []struct {
+		name     string
+		request  func() *http.Request
+		envelope bool
+	}{
+		{name: "legacy by default", request: func() *http.Request {
+			return httptest.NewRequest(http.MethodGet, "/api/domains", nil)
+		}},
+		{name: "version header", envelope: true, request: func() *http.Request {
+			r := httptest.NewRequest(http.MethodGet, "/api/domains", nil)
+			r.Header.Set("X-API-Version", "2")
+			return r
+		}},
+		{name: "version path", envelope: true, request: func() *http.Request {
+			var enveloped *http.Request
+			// Capture the request as seen by a handler behind an /api/v2 route
+			handler := envelopedRequest(&enveloped)
+			handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/v2/domains", nil))
+			return enveloped
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			if err := writeList(rec, tt.request(), "domains", data, meta); err != nil {
+				t.Fatalf("Failed to write list: %v", err)
+			}
+
+			var body map[string]interface{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+
+			if !tt.envelope {
+				if len(body) != 3 || body["total"] != 2.0 || body["filter"] != "example" || len(body["domains"].([]interface{})) != 2 {
+					t.Errorf("Expected the legacy shape, got %v", body)
+				}
+				return
+			}
+
+			if len(body) != 3 || body["error"] != nil {
+				t.Fatalf("Expected data, meta and a null error, got %v", body)
+			}
+			if items, ok := body["data"].([]interface{}); !ok || len(items) != 2 || items[0] != "example.com." {
+				t.Errorf("Expected the list as data, got %v", body["data"])
+			}
+			if m, ok := body["meta"].(map[string]interface{}); !ok || len(m) != 2 || m["total"] != 2.0 || m["filter"] != "example" {
+				t.Errorf("Expected the metadata as meta, got %v", body["meta"])
+			}
+		})
+	}
+}
+
+// envelopedRequest returns an /api/v2 handler storing the request it serves
+func envelopedRequest(dst **http.Request) http.HandlerFunc {
+	return enveloped(func(w http.ResponseWriter, r *http.Request) { *dst = r })
+}
+
+func TestListEndpoints_EnvelopeErrors(t *testing.T) {
+	s := &Server{}
+
+	// Legacy errors stay plain text
+	rec := httptest.NewRecorder()
+	s.handleDomains(rec, httptest.NewRequest(http.MethodGet, "/api/domains", nil))
+	if rec.Code != http.StatusServiceUnavailable || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf("Expected a plain text 503, got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+
+	for _, tt := range []struct {
+		url      string
+		handler  http.HandlerFunc
+		wantCode int
+	}{
+		{url: "/api/v2/clients", handler: s.handleClients, wantCode: http.StatusServiceUnavailable},
+		{url: "/api/v2/search?since=yesterday", handler: s.handleSearch, wantCode: http.StatusBadRequest},
+		{url: "/api/v2/domains", handler: s.handleDomains, wantCode: http.StatusServiceUnavailable},
+		{url: "/api/v2/domains/ttls?limit=0", handler: s.handleDomainTTLs, wantCode: http.StatusBadRequest},
+		{url: "/api/v2/dns-mappings", handler: s.handleDNSMappings, wantCode: http.StatusServiceUnavailable},
+	} {
+		t.Run(tt.url, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			enveloped(tt.handler)(rec, httptest.NewRequest(http.MethodGet, tt.url, nil))
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("Expected %d, got %d: %s", tt.wantCode, rec.Code, rec.Body.String())
+			}
+			var envelope Envelope
+			if err := json.Unmarshal(rec.Body.Bytes(), &envelope); err != nil {
+				t.Fatalf("Expected an envelope, got %q: %v", rec.Body.String(), err)
+			}
+			if envelope.Data != nil || envelope.Error == nil || envelope.Error.Status != tt.wantCode || envelope.Error.Message == "" {
+				t.Errorf("Expected the error in the envelope, got %+v", envelope)
+			}
+		})
+	}
+}
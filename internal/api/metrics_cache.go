@@ -0,0 +1,60 @@
+package api
+
+import (
+	"sync"
+	"time"
+
+	"dns-go/internal/metrics"
+)
+
+// defaultMetricsCacheTTL is how long built dashboard metrics are reused
+const defaultMetricsCacheTTL = 5 * time.Second
+
+// metricsCache keeps the last built dashboard metrics for a short time so rapid
+// dashboard polling doesn't repeatedly aggregate in PostgreSQL
+type metricsCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	now     func() time.Time
+	metrics *metrics.DashboardMetrics
+	expires time.Time
+}
+
+// newMetricsCache creates a cache keeping metrics for ttl; a zero ttl disables it
+func newMetricsCache(ttl time.Duration) *metricsCache {
+	return &metricsCache{
+		ttl: ttl,
+		now: time.Now,
+	}
+}
+
+// get returns the cached metrics, calling build when they are missing or expired.
+// Build errors are returned as is and not cached.
+func (c *metricsCache) get(build func() (*metrics.DashboardMetrics, error)) (*metrics.DashboardMetrics, error) {
+	if c.ttl <= 0 {
+		return build()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.metrics != nil && c.now().Before(c.expires) {
+		return c.metrics, nil
+	}
+
+	dashboardMetrics, err := build()
+	if err != nil {
+		return nil, err
+	}
+
+	c.metrics = dashboardMetrics
+	c.expires = c.now().Add(c.ttl)
+	return dashboardMetrics, nil
+}
+
+// invalidate drops the cached metrics so the next request rebuilds them
+func (c *metricsCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.metrics = nil
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"dns-go/internal/metrics"
+)
+
+func TestMetricsCache(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	cache := newMetricsCache(5 * time.Second)
+	cache.now = func() time.Time { return now }
+
+	builds := 0
+	build := func() (*metrics.DashboardMetrics, error) {
+		builds++
+		return &metrics.DashboardMetrics{}, nil
+	}
+
+	first, err := cache.get(build)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// A rapid second request is served from the cache
+	now = now.Add(2 * time.Second)
+	second, _ := cache.get(build)
+	if builds != 1 || second != first {
+		t.Fatalf("Expected cached metrics within TTL, got %d builds", builds)
+	}
+
+	// After the TTL the metrics are rebuilt
+	now = now.Add(5 * time.Second)
+	third, _ := cache.get(build)
+	if builds != 2 || third == first {
+		t.Fatalf("Expected rebuild after TTL, got %d builds", builds)
+	}
+
+	// Invalidation forces a rebuild
+	cache.invalidate()
+	cache.get(build)
+	if builds != 3 {
+		t.Errorf("Expected rebuild after invalidation, got %d builds", builds)
+	}
+}
+
+func TestMetricsCache_ErrorsNotCached(t *testing.T) {
+	cache := newMetricsCache(5 * time.Second)
+
+	builds := 0
+	failing := func() (*metrics.DashboardMetrics, error) {
+		builds++
+		return nil, errors.New("query failed")
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := cache.get(failing); err == nil {
+			t.Fatal("Expected build error")
+		}
+	}
+	if builds != 2 {
+		t.Errorf("Expected failed builds not to be cached, got %d builds", builds)
+	}
+}
+
+func TestMetricsCache_Disabled(t *testing.T) {
+	cache := newMetricsCache(0)
+
+	builds := 0
+	build := func() (*metrics.DashboardMetrics, error) {
+		builds++
+		return &metrics.DashboardMetrics{}, nil
+	}
+
+	cache.get(build)
+	cache.get(build)
+	if builds != 2 {
+		t.Errorf("Expected every request to rebuild with caching disabled, got %d builds", builds)
+	}
+}
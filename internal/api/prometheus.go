@@ -0,0 +1,100 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"dns-go/internal/metrics"
+)
+
+// prometheusContentType is the Prometheus text exposition format
+const prometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// handlePrometheusMetrics serves the in-memory metrics, collected from the DNS
+// log since the API server started, in the Prometheus text format
+func (s *Server) handlePrometheusMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", prometheusContentType)
+	writePrometheusMetrics(w, s.metrics.Snapshot())
+}
+
+// writePrometheusMetrics writes the metrics of a snapshot in the Prometheus text
+// format, upstreams sorted by address
+func writePrometheusMetrics(w io.Writer, snapshot metrics.Snapshot) {
+	writeMetric(w, "dns_requests_total", "counter", "DNS requests received.", float64(snapshot.TotalRequests))
+	writeMetric(w, "dns_requests_successful_total", "counter", "DNS requests answered by an upstream server.", float64(snapshot.SuccessfulQueries))
+	writeMetric(w, "dns_requests_failed_total", "counter", "DNS requests for which all upstream servers failed.", float64(snapshot.FailedQueries))
+
+	successRate := 0.0
+	if snapshot.TotalRequests > 0 {
+		successRate = float64(snapshot.SuccessfulQueries) / float64(snapshot.TotalRequests)
+	}
+	writeMetric(w, "dns_success_ratio", "gauge", "Share of DNS requests answered by an upstream server.", successRate)
+	writeMetric(w, "dns_active_clients", "gauge", "Clients seen in the last hour.", float64(snapshot.ActiveClients))
+
+	upstreams := make([]string, 0, len(snapshot.Upstreams))
+	for server := range snapshot.Upstreams {
+		upstreams = append(upstreams, server)
+	}
+	sort.Strings(upstreams)
+
+	writeHeader(w, "dns_upstream_queries_total", "counter", "Queries sent to each upstream server, by outcome.")
+	for _, server := range upstreams {
+		stats := snapshot.Upstreams[server]
+		label := `upstream="` + escapeLabelValue(server) + `"`
+		fmt.Fprintf(w, "dns_upstream_queries_total{%s,result=\"success\"} %d\n", label, stats.SuccessfulQueries)
+		fmt.Fprintf(w, "dns_upstream_queries_total{%s,result=\"failure\"} %d\n", label, stats.FailedQueries)
+	}
+
+	writeHeader(w, "dns_upstream_truncations_total", "counter", "Truncated UDP answers from each upstream server.")
+	for _, server := range upstreams {
+		fmt.Fprintf(w, "dns_upstream_truncations_total{upstream=\"%s\"} %d\n", escapeLabelValue(server), snapshot.Upstreams[server].Truncations)
+	}
+
+	writeHeader(w, "dns_upstream_rtt_seconds", "histogram", "Round trip time of the answers used from each upstream server.")
+	for _, server := range upstreams {
+		stats := snapshot.Upstreams[server]
+		label := `upstream="` + escapeLabelValue(server) + `"`
+		var cumulative int64
+		for i, bound := range metrics.RTTBuckets {
+			if i < len(stats.RTTHistogram) {
+				cumulative += stats.RTTHistogram[i]
+			}
+			fmt.Fprintf(w, "dns_upstream_rtt_seconds_bucket{%s,le=\"%s\"} %d\n", label, formatFloat(bound/1000), cumulative)
+		}
+		fmt.Fprintf(w, "dns_upstream_rtt_seconds_bucket{%s,le=\"+Inf\"} %d\n", label, stats.RTTCount)
+		fmt.Fprintf(w, "dns_upstream_rtt_seconds_sum{%s} %s\n", label, formatFloat(stats.RTTSum/1000))
+		fmt.Fprintf(w, "dns_upstream_rtt_seconds_count{%s} %d\n", label, stats.RTTCount)
+	}
+}
+
+// writeMetric writes an unlabeled metric with its HELP and TYPE lines
+func writeMetric(w io.Writer, name, metricType, help string, value float64) {
+	writeHeader(w, name, metricType, help)
+	fmt.Fprintf(w, "%s %s\n", name, formatFloat(value))
+}
+
+// writeHeader writes the HELP and TYPE lines of a metric
+func writeHeader(w io.Writer, name, metricType, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, metricType)
+}
+
+// formatFloat formats a sample value the way Prometheus parses it
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// escapeLabelValue escapes backslashes, double quotes and newlines in a label
+// value
+func escapeLabelValue(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
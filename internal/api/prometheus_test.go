@@ -0,0 +1,87 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"dns-go/internal/metrics"
+	"dns-go/internal/types"
+)
+
+// sampleLine matches a Prometheus sample: a metric name, optional labels and
+// a value
+var sampleLine = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*(\{[a-zA-Z_][a-zA-Z0-9_]*="(?:[^"\\]|\\.)*"(,[a-zA-Z_][a-zA-Z0-9_]*="(?:[^"\\]|\\.)*")*\})? [^ ]+$`)
+
+func TestHandlePrometheusMetrics(t *testing.T) {
+	m := metrics.NewMetrics()
+	record := func(status, upstream string, rtt float64) {
+		entry := types.LogEntry{
+			Timestamp: time.Now(),
+			Request:   types.RequestInfo{Client: "192.0.2.10", Query: "example.com.", Type: "A"},
+			Status:    status,
+			Upstreams: []types.UpstreamAttempt{{Server: upstream, Attempt: 1, Successful: status == "success"}},
+		}
+		if status == "success" {
+			entry.Response = &types.ResponseInfo{Upstream: upstream, Rcode: "NOERROR", RTT: rtt}
+		}
+		m.RecordRequest(entry)
+	}
+	record("success", "8.8.8.8:53", 3)
+	record("success", "8.8.8.8:53", 40)
+	record("success", "8.8.8.8:53", 4000)
+	record("all_upstreams_failed", "1.1.1.1:53", 0)
+
+	s := &Server{metrics: m}
+	rec := httptest.NewRecorder()
+	s.handlePrometheusMetrics(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Expected the Prometheus text format, got %q", ct)
+	}
+
+	body := rec.Body.String()
+	for _, line := range strings.Split(strings.TrimSuffix(body, "\n"), "\n") {
+		if strings.HasPrefix(line, "# HELP ") || strings.HasPrefix(line, "# TYPE ") {
+			continue
+		}
+		if !sampleLine.MatchString(line) {
+			t.Errorf("Malformed metric line: %q", line)
+		}
+	}
+
+	for _, want := range []string{
+		"# TYPE dns_requests_total counter",
+		"dns_requests_total 4",
+		"dns_requests_failed_total 1",
+		"dns_success_ratio 0.75",
+		"dns_active_clients 1",
+		`dns_upstream_queries_total{upstream="8.8.8.8:53",result="success"} 3`,
+		`dns_upstream_queries_total{upstream="1.1.1.1:53",result="failure"} 1`,
+		"# TYPE dns_upstream_rtt_seconds histogram",
+		`dns_upstream_rtt_seconds_bucket{upstream="8.8.8.8:53",le="0.005"} 1`,
+		`dns_upstream_rtt_seconds_bucket{upstream="8.8.8.8:53",le="0.05"} 2`,
+		`dns_upstream_rtt_seconds_bucket{upstream="8.8.8.8:53",le="2.5"} 2`,
+		`dns_upstream_rtt_seconds_bucket{upstream="8.8.8.8:53",le="+Inf"} 3`,
+		`dns_upstream_rtt_seconds_sum{upstream="8.8.8.8:53"} 4.043`,
+		`dns_upstream_rtt_seconds_count{upstream="8.8.8.8:53"} 3`,
+	} {
+		if !strings.Contains(body, want+"\n") {
+			t.Errorf("Expected line %q in:\n%s", want, body)
+		}
+	}
+}
+
+func TestEscapeLabelValue(t *testing.T) {
+	got := escapeLabelValue("https://dns.example/q?a=\"b\"\\\n")
+	want := `https://dns.example/q?a=\"b\"\\\n`
+	if got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
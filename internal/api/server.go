@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"os"
 	"strconv"
@@ -13,10 +14,30 @@ import (
 
 	"dns-go/internal/aggregation"
 	"dns-go/internal/config"
+	"dns-go/internal/mapping"
 	"dns-go/internal/metrics"
 	"dns-go/internal/monitor"
 	"dns-go/internal/postgres"
+	"dns-go/internal/resolver"
+	"dns-go/internal/types"
 	"dns-go/pkg/version"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// defaultPostgresConnectMaxWait is how long the API waits for PostgreSQL at startup
+	defaultPostgresConnectMaxWait = 60 * time.Second
+	// defaultPostgresHealthInterval is how often the PostgreSQL connection is health checked
+	defaultPostgresHealthInterval = 30 * time.Second
+	// postgresMaxHealthFailures is how many consecutive failed checks trigger a reconnect
+	postgresMaxHealthFailures = 3
+	// resolveTimeout bounds test resolutions made through the DNS server
+	resolveTimeout = 5 * time.Second
+	// healthProbeTimeout bounds each dependency probe of /api/health/detailed
+	healthProbeTimeout = 5 * time.Second
+	// defaultHealthSlowThreshold is the probe latency above which a dependency is reported slow
+	defaultHealthSlowThreshold = time.Second
 )
 
 // Server provides REST API endpoints for DNS server metrics
@@ -28,6 +49,20 @@ type Server struct {
 	config     *config.Config
 	port       string
 	scheduler  *aggregation.Scheduler
+
+	// metricsCache reuses recently built /api/metrics responses
+	metricsCache *metricsCache
+
+	// resolverAddr is the DNS server address used by /api/resolve
+	resolverAddr string
+
+	// elasticsearchURL is the Elasticsearch instance probed by
+	// /api/health/detailed, when configured
+	elasticsearchURL string
+
+	// healthSlowThreshold is the probe latency above which
+	// /api/health/detailed reports a dependency as slow
+	healthSlowThreshold time.Duration
 }
 
 // Config holds API server configuration
@@ -67,17 +102,34 @@ func NewServer(cfg Config) (*Server, error) {
 
 	if pgHost != "" || pgPort != "" || pgDB != "" {
 		pgConfig := postgres.Config{
-			Host:     pgHost,
-			Port:     pgPort,
-			Database: pgDB,
-			User:     pgUser,
-			Password: pgPassword,
+			Host:           pgHost,
+			Port:           pgPort,
+			Database:       pgDB,
+			User:           pgUser,
+			Password:       pgPassword,
+			ConnectMaxWait: defaultPostgresConnectMaxWait,
+		}
+
+		// Configure how long to wait for PostgreSQL to come up from environment variable
+		if maxWaitStr := os.Getenv("POSTGRES_CONNECT_MAX_WAIT"); maxWaitStr != "" {
+			if maxWait, err := time.ParseDuration(maxWaitStr); err == nil && maxWait >= 0 {
+				pgConfig.ConnectMaxWait = maxWait
+			}
 		}
 
-		if client, err := postgres.NewClient(pgConfig); err == nil {
+		if client, err := postgres.NewClientWithRetry(pgConfig); err == nil {
 			pgClient = client
 			fmt.Println("✅ PostgreSQL client initialized successfully")
 
+			// Re-open the connection if PostgreSQL goes away at runtime
+			healthInterval := defaultPostgresHealthInterval
+			if intervalStr := os.Getenv("POSTGRES_HEALTH_CHECK_INTERVAL"); intervalStr != "" {
+				if interval, err := time.ParseDuration(intervalStr); err == nil && interval > 0 {
+					healthInterval = interval
+				}
+			}
+			pgClient.StartHealthMonitor(healthInterval, postgresMaxHealthFailures)
+
 			// Migrate DNS mappings from JSON file to PostgreSQL if needed
 			const customDNSConfigFile = "custom-dns.json"
 			if err := pgClient.MigrateDNSMappingsFromJSON(customDNSConfigFile); err != nil {
@@ -92,20 +144,49 @@ func NewServer(cfg Config) (*Server, error) {
 		fmt.Println("📝 No PostgreSQL configuration provided")
 	}
 
+	// Configure how long /api/metrics responses are reused from environment variable
+	metricsCacheTTL := defaultMetricsCacheTTL
+	if ttlStr := os.Getenv("API_METRICS_CACHE_TTL"); ttlStr != "" {
+		if ttl, err := time.ParseDuration(ttlStr); err == nil && ttl >= 0 {
+			metricsCacheTTL = ttl
+		}
+	}
+
+	// Configure when /api/health/detailed reports a dependency as slow from environment variable
+	healthSlowThreshold := defaultHealthSlowThreshold
+	if thresholdStr := os.Getenv("HEALTH_SLOW_THRESHOLD"); thresholdStr != "" {
+		if threshold, err := time.ParseDuration(thresholdStr); err == nil && threshold > 0 {
+			healthSlowThreshold = threshold
+		}
+	}
+
 	s := &Server{
-		metrics:    metricsCollector,
-		logMonitor: logMonitor,
-		pgClient:   pgClient,
-		config:     cfg.DNSConfig,
-		port:       cfg.Port,
+		metrics:             metricsCollector,
+		logMonitor:          logMonitor,
+		pgClient:            pgClient,
+		config:              cfg.DNSConfig,
+		port:                cfg.Port,
+		metricsCache:        newMetricsCache(metricsCacheTTL),
+		resolverAddr:        os.Getenv("DNS_RESOLVER_ADDRESS"),
+		elasticsearchURL:    elasticsearchURL(),
+		healthSlowThreshold: healthSlowThreshold,
 	}
 
 	// Initialize and start background scheduler if PostgreSQL is available
 	if pgClient != nil {
 		s.scheduler = aggregation.NewScheduler(pgClient)
 
-		// Configure retention days from environment variable
-		if retentionStr := os.Getenv("LOG_RETENTION_DAYS"); retentionStr != "" {
+		// Serve fresh aggregated stats as soon as they are stored
+		s.scheduler.SetOnAggregation(s.metricsCache.invalidate)
+
+		// Configure retention from environment variables; LOG_RETENTION takes
+		// a duration such as 36h and wins over LOG_RETENTION_DAYS
+		if retentionStr := os.Getenv("LOG_RETENTION"); retentionStr != "" {
+			if retention, err := time.ParseDuration(retentionStr); err == nil && retention > 0 {
+				s.scheduler.SetRetention(retention)
+				fmt.Printf("📋 Log retention period set to %s\n", aggregation.FormatRetention(retention))
+			}
+		} else if retentionStr := os.Getenv("LOG_RETENTION_DAYS"); retentionStr != "" {
 			if days, err := strconv.Atoi(retentionStr); err == nil && days > 0 {
 				s.scheduler.SetRetentionDays(days)
 				fmt.Printf("📋 Log retention period set to %d days\n", days)
@@ -127,12 +208,25 @@ func NewServer(cfg Config) (*Server, error) {
 	mux.HandleFunc("/api/clients", s.handleClients)
 	mux.HandleFunc("/api/search", s.handleSearch)
 	mux.HandleFunc("/api/domains", s.handleDomains)
+	mux.HandleFunc("/api/domains/ttls", s.handleDomainTTLs)
 	mux.HandleFunc("/api/health", s.handleHealth)
+	mux.HandleFunc("/api/health/detailed", s.handleHealthDetailed)
 	mux.HandleFunc("/api/version", s.handleVersion)
+	mux.HandleFunc("/api/resolve", s.handleResolve)
 	mux.HandleFunc("/api/dns-mappings", s.handleDNSMappings)
 	mux.HandleFunc("/api/log-counts", s.handleLogCounts)
 	mux.HandleFunc("/api/docs/logs", s.handleLogsDocs)
 
+	// List endpoints answering with the response envelope
+	mux.HandleFunc("/api/v2/clients", enveloped(s.handleClients))
+	mux.HandleFunc("/api/v2/search", enveloped(s.handleSearch))
+	mux.HandleFunc("/api/v2/domains", enveloped(s.handleDomains))
+	mux.HandleFunc("/api/v2/domains/ttls", enveloped(s.handleDomainTTLs))
+	mux.HandleFunc("/api/v2/dns-mappings", enveloped(s.handleDNSMappings))
+
+	// Prometheus scrape endpoint
+	mux.HandleFunc("/metrics", s.handlePrometheusMetrics)
+
 	// CORS middleware
 	handler := s.corsMiddleware(s.loggingMiddleware(mux))
 
@@ -158,10 +252,15 @@ func (s *Server) Start() error {
 	fmt.Printf("  👥 GET /api/clients      - DNS clients and statistics\n")
 	fmt.Printf("  🔎 GET /api/search       - Search through DNS logs\n")
 	fmt.Printf("  🌍 GET /api/domains      - Domain request counts and statistics\n")
+	fmt.Printf("  ⏱️  GET /api/domains/ttls - Min/avg/max answer TTLs per domain\n")
 	fmt.Printf("  📚 GET /api/docs/logs    - Logs API documentation\n")
 	fmt.Printf("  ❤️  GET /api/health       - Health check endpoint\n")
+	fmt.Printf("  🩺 GET /api/health/detailed - Dependency health with probe latencies\n")
 	fmt.Printf("  ℹ️  GET /api/version      - Version and build information\n")
+	fmt.Printf("  🧪 GET /api/resolve      - Test-resolve a name through the DNS server\n")
 	fmt.Printf("  🌐 GET/PUT/POST/DELETE /api/dns-mappings - Manage custom DNS mappings\n")
+	fmt.Printf("  📈 GET /metrics          - Prometheus metrics\n")
+	fmt.Printf("  📦 GET /api/v2/{clients,search,domains,domains/ttls,dns-mappings} - Lists as {data, meta, error}\n")
 	fmt.Printf("\n🌐 Access URLs:\n")
 	fmt.Printf("  Local:    http://localhost:%s/api\n", s.port)
 	fmt.Printf("  Network:  http://0.0.0.0:%s/api\n", s.port)
@@ -229,7 +328,7 @@ func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Build dashboard metrics from PostgreSQL
-	dashboardMetrics, err := s.buildDashboardMetricsFromPostgres()
+	dashboardMetrics, err := s.metricsCache.get(s.buildDashboardMetricsFromPostgres)
 	if err != nil {
 		http.Error(w, "Failed to build metrics: "+err.Error(), http.StatusInternalServerError)
 		return
@@ -286,6 +385,16 @@ func (s *Server) buildDashboardMetricsFromPostgresDirect() (*metrics.DashboardMe
 		return nil, fmt.Errorf("failed to get query types: %w", err)
 	}
 
+	rcodes, err := s.pgClient.GetRcodeBreakdown(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rcode breakdown: %w", err)
+	}
+
+	upstreamWins, err := s.pgClient.GetUpstreamWins(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get upstream wins: %w", err)
+	}
+
 	// Get DNS server start time to calculate uptime
 	dnsServerStartTime, err := s.pgClient.GetDNSServerStartTime()
 	uptimeStr := "N/A"
@@ -298,9 +407,12 @@ func (s *Server) buildDashboardMetricsFromPostgresDirect() (*metrics.DashboardMe
 	overview := metrics.OverviewMetrics{
 		Uptime:              uptimeStr,
 		TotalRequests:       overviewStats.TotalRequests,
-		RequestsPerSecond:   0, // Calculate from time window if needed
+		RequestsPerSecond:   overviewStats.RequestsPerSecond,
 		SuccessRate:         0,
 		AverageResponseTime: overviewStats.AverageResponseTime,
+		P50ResponseTime:     overviewStats.Latency.P50,
+		P95ResponseTime:     overviewStats.Latency.P95,
+		P99ResponseTime:     overviewStats.Latency.P99,
 		Clients:             overviewStats.ActiveClients,
 	}
 
@@ -339,9 +451,6 @@ func (s *Server) buildDashboardMetricsFromPostgresDirect() (*metrics.DashboardMe
 		}
 	}
 
-	// Build upstream servers stats (empty for now, can be added later)
-	upstreamServers := make(map[string]*metrics.UpstreamStats)
-
 	startTimeStr := time.Now().Format(time.RFC3339)
 	if dnsServerStartTime != nil {
 		startTimeStr = dnsServerStartTime.Format(time.RFC3339)
@@ -352,7 +461,8 @@ func (s *Server) buildDashboardMetricsFromPostgresDirect() (*metrics.DashboardMe
 		TimeSeriesData:  timeSeries,
 		TopClients:      clientMetrics,
 		QueryTypes:      queryTypeMetrics,
-		UpstreamServers: upstreamServers,
+		Rcodes:          convertRcodeMetrics(rcodes),
+		UpstreamServers: convertUpstreamWins(upstreamWins),
 		SystemInfo: metrics.SystemInfo{
 			Version:   version.Get().Short(),
 			StartTime: startTimeStr,
@@ -376,9 +486,12 @@ func (s *Server) convertCachedStatsToDashboardMetrics(cachedStats *postgres.Aggr
 	overview := metrics.OverviewMetrics{
 		Uptime:              uptimeStr,
 		TotalRequests:       overviewStats.TotalRequests,
-		RequestsPerSecond:   0,
+		RequestsPerSecond:   overviewStats.RequestsPerSecond,
 		SuccessRate:         0,
 		AverageResponseTime: overviewStats.AverageResponseTime,
+		P50ResponseTime:     overviewStats.Latency.P50,
+		P95ResponseTime:     overviewStats.Latency.P95,
+		P99ResponseTime:     overviewStats.Latency.P99,
 		Clients:             overviewStats.ActiveClients,
 	}
 
@@ -416,9 +529,6 @@ func (s *Server) convertCachedStatsToDashboardMetrics(cachedStats *postgres.Aggr
 		}
 	}
 
-	// Build upstream servers stats (empty for now, can be added later)
-	upstreamServers := make(map[string]*metrics.UpstreamStats)
-
 	startTimeStr := time.Now().Format(time.RFC3339)
 	if dnsServerStartTime != nil {
 		startTimeStr = dnsServerStartTime.Format(time.RFC3339)
@@ -429,7 +539,8 @@ func (s *Server) convertCachedStatsToDashboardMetrics(cachedStats *postgres.Aggr
 		TimeSeriesData:  timeSeries,
 		TopClients:      clientMetrics,
 		QueryTypes:      queryTypeMetrics,
-		UpstreamServers: upstreamServers,
+		Rcodes:          convertRcodeMetrics(cachedStats.Rcodes),
+		UpstreamServers: convertUpstreamWins(cachedStats.UpstreamWins),
 		SystemInfo: metrics.SystemInfo{
 			Version:   version.Get().Short(),
 			StartTime: startTimeStr,
@@ -437,6 +548,29 @@ func (s *Server) convertCachedStatsToDashboardMetrics(cachedStats *postgres.Aggr
 	}
 }
 
+// convertRcodeMetrics converts PostgreSQL rcode counts to dashboard metrics
+func convertRcodeMetrics(rcodes []postgres.RcodeMetric) []metrics.RcodeMetric {
+	result := make([]metrics.RcodeMetric, len(rcodes))
+	for i, rc := range rcodes {
+		result[i] = metrics.RcodeMetric{
+			Rcode: rc.Rcode,
+			Count: rc.Count,
+		}
+	}
+	return result
+}
+
+// convertUpstreamWins converts PostgreSQL upstream win counts to dashboard
+// upstream stats; the logs only record the upstream that answered, so the other
+// counters are left empty
+func convertUpstreamWins(wins []postgres.UpstreamWinMetric) map[string]*metrics.UpstreamStats {
+	result := make(map[string]*metrics.UpstreamStats, len(wins))
+	for _, w := range wins {
+		result[w.Server] = &metrics.UpstreamStats{Wins: w.Wins}
+	}
+	return result
+}
+
 // convertTimeSeriesPoints converts PostgreSQL time series points to metrics format
 func convertTimeSeriesPoints(points []postgres.TimeSeriesPoint) []metrics.TimePoint {
 	result := make([]metrics.TimePoint, len(points))
@@ -507,21 +641,21 @@ func getWeekStart(t time.Time) time.Time {
 
 func (s *Server) handleClients(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 
 	if s.pgClient == nil {
-		http.Error(w, "PostgreSQL not connected", http.StatusServiceUnavailable)
+		writeError(w, r, "PostgreSQL not connected", http.StatusServiceUnavailable)
 		return
 	}
 
 	// Get all clients from PostgreSQL
 	pgClients, err := s.pgClient.GetTopClients(1000) // Get many clients
 	if err != nil {
-		http.Error(w, "Failed to get clients: "+err.Error(), http.StatusInternalServerError)
+		writeError(w, r, "Failed to get clients: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
@@ -536,13 +670,12 @@ func (s *Server) handleClients(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	response := map[string]interface{}{
-		"clients": clients,
-		"total":   len(clients),
+	meta := map[string]interface{}{
+		"total": len(clients),
 	}
 
-	if err := json.NewEncoder(w).Encode(response); err != nil {
-		http.Error(w, "Failed to encode clients", http.StatusInternalServerError)
+	if err := writeList(w, r, "clients", clients, meta); err != nil {
+		writeError(w, r, "Failed to encode clients", http.StatusInternalServerError)
 		return
 	}
 }
@@ -567,7 +700,24 @@ func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+// DependencyHealth is the outcome of probing one dependency of the API server
+type DependencyHealth struct {
+	Status  string  `json:"status"` // "up", "slow" or "down"
+	Latency float64 `json:"latency_ms"`
+	Error   string  `json:"error,omitempty"`
+}
+
+// dependencyProbe checks that a dependency is reachable and working
+type dependencyProbe struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+// handleHealthDetailed probes each configured dependency (PostgreSQL,
+// Elasticsearch and the DNS server with its upstreams) and reports how long it
+// took to answer. Dependencies slower than the threshold leave the API
+// degraded; any that are down make it unhealthy, answered with 503.
+func (s *Server) handleHealthDetailed(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
@@ -575,6 +725,150 @@ func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 
+	dependencies := s.probeDependencies(r.Context(), s.dependencyProbes())
+
+	status := "healthy"
+	for _, dependency := range dependencies {
+		switch {
+		case dependency.Status == "down":
+			status = "unhealthy"
+		case dependency.Status == "slow" && status == "healthy":
+			status = "degraded"
+		}
+	}
+
+	if status == "unhealthy" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":       status,
+		"timestamp":    time.Now().Unix(),
+		"version":      version.Get().Short(),
+		"dependencies": dependencies,
+	})
+}
+
+// dependencyProbes returns the probes of the dependencies the API server is
+// configured with
+func (s *Server) dependencyProbes() []dependencyProbe {
+	var probes []dependencyProbe
+	if s.pgClient != nil {
+		probes = append(probes, dependencyProbe{name: "postgres", check: s.pgClient.PingContext})
+	}
+	if s.elasticsearchURL != "" {
+		probes = append(probes, dependencyProbe{name: "elasticsearch", check: s.probeElasticsearch})
+	}
+	if s.resolverAddr != "" {
+		probes = append(probes, dependencyProbe{name: "upstream", check: s.probeUpstream})
+	}
+	return probes
+}
+
+// probeDependencies runs the probes concurrently, each bounded by
+// healthProbeTimeout, and times them
+func (s *Server) probeDependencies(ctx context.Context, probes []dependencyProbe) map[string]DependencyHealth {
+	type outcome struct {
+		name   string
+		health DependencyHealth
+	}
+
+	outcomes := make(chan outcome, len(probes))
+	for _, probe := range probes {
+		go func(probe dependencyProbe) {
+			probeCtx, cancel := context.WithTimeout(ctx, healthProbeTimeout)
+			defer cancel()
+
+			start := time.Now()
+			err := probe.check(probeCtx)
+			latency := time.Since(start)
+
+			health := DependencyHealth{Status: "up", Latency: types.DurationToMilliseconds(latency)}
+			switch {
+			case err != nil:
+				health.Status, health.Error = "down", err.Error()
+			case latency > s.healthSlowThreshold:
+				health.Status = "slow"
+			}
+			outcomes <- outcome{name: probe.name, health: health}
+		}(probe)
+	}
+
+	dependencies := make(map[string]DependencyHealth, len(probes))
+	for range probes {
+		o := <-outcomes
+		dependencies[o.name] = o.health
+	}
+	return dependencies
+}
+
+// probeElasticsearch checks the health of the Elasticsearch cluster
+func (s *Server) probeElasticsearch(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(s.elasticsearchURL, "/")+"/_cluster/health", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("cluster health returned status %s", resp.Status)
+	}
+	return nil
+}
+
+// probeUpstream resolves the DNS server's health probe name through it, which
+// reaches its upstream servers
+func (s *Server) probeUpstream(ctx context.Context) error {
+	name := "."
+	if s.config != nil && s.config.HealthProbeName != "" {
+		name = s.config.HealthProbeName
+	}
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn(name), dns.TypeNS)
+	msg.RecursionDesired = true
+
+	client := &dns.Client{Timeout: healthProbeTimeout}
+	resp, _, err := client.ExchangeContext(ctx, msg, s.resolverAddr)
+	if err != nil {
+		return err
+	}
+	if resp.Rcode == dns.RcodeServerFailure || resp.Rcode == dns.RcodeRefused {
+		return fmt.Errorf("resolving %s returned %s", msg.Question[0].Name, dns.RcodeToString[resp.Rcode])
+	}
+	return nil
+}
+
+// elasticsearchURL returns the Elasticsearch address configured through the
+// ELASTICSEARCH_URL or ELASTICSEARCH_HOST and ELASTICSEARCH_PORT environment
+// variables, or an empty string when none is
+func elasticsearchURL() string {
+	if url := os.Getenv("ELASTICSEARCH_URL"); url != "" {
+		return url
+	}
+	host := os.Getenv("ELASTICSEARCH_HOST")
+	if host == "" {
+		return ""
+	}
+	port := os.Getenv("ELASTICSEARCH_PORT")
+	if port == "" {
+		port = "9200"
+	}
+	return "http://" + net.JoinHostPort(host, port)
+}
+
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
 	// Parse query parameters
 	query := r.URL.Query()
 	domain := query.Get("domain")
@@ -604,13 +898,13 @@ func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
 	if sinceStr != "" {
 		parsedTime, err := time.Parse("2006-01-02T15:04:05Z", sinceStr)
 		if err != nil {
-			http.Error(w, "Invalid since parameter: must be in format 2024-01-02T15:04:05Z", http.StatusBadRequest)
+			writeError(w, r, "Invalid since parameter: must be in format 2024-01-02T15:04:05Z", http.StatusBadRequest)
 			return
 		}
 
 		// Validate that the timestamp is not in the future
 		if parsedTime.After(time.Now()) {
-			http.Error(w, "Invalid since parameter: timestamp cannot be in the future", http.StatusBadRequest)
+			writeError(w, r, "Invalid since parameter: timestamp cannot be in the future", http.StatusBadRequest)
 			return
 		}
 
@@ -619,7 +913,7 @@ func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
 
 	// Use PostgreSQL for search
 	if s.pgClient == nil {
-		http.Error(w, "Search service unavailable: PostgreSQL not connected", http.StatusServiceUnavailable)
+		writeError(w, r, "Search service unavailable: PostgreSQL not connected", http.StatusServiceUnavailable)
 		return
 	}
 
@@ -627,30 +921,29 @@ func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
 	searchResult, err := s.pgClient.SearchLogs(domain, clientIP, limit, offset, since)
 	if err != nil {
 		fmt.Printf("PostgreSQL search failed: %v\n", err)
-		http.Error(w, "Search failed: "+err.Error(), http.StatusInternalServerError)
+		writeError(w, r, "Search failed: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	response := map[string]interface{}{
-		"results": searchResult.Results,
-		"total":   searchResult.Total,
-		"limit":   limit,
-		"offset":  offset,
-		"domain":  domain,
-		"client":  clientIP,
-		"since":   since,
-		"source":  "postgres",
+	meta := map[string]interface{}{
+		"total":  searchResult.Total,
+		"limit":  limit,
+		"offset": offset,
+		"domain": domain,
+		"client": clientIP,
+		"since":  since,
+		"source": "postgres",
 	}
 
-	if err := json.NewEncoder(w).Encode(response); err != nil {
-		http.Error(w, "Failed to encode search results", http.StatusInternalServerError)
+	if err := writeList(w, r, "results", searchResult.Results, meta); err != nil {
+		writeError(w, r, "Failed to encode search results", http.StatusInternalServerError)
 		return
 	}
 }
 
 func (s *Server) handleDomains(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
@@ -668,13 +961,13 @@ func (s *Server) handleDomains(w http.ResponseWriter, r *http.Request) {
 	if sinceStr != "" {
 		parsedTime, err := time.Parse("2006-01-02T15:04:05Z", sinceStr)
 		if err != nil {
-			http.Error(w, "Invalid since parameter: must be in format 2006-01-02T15:04:05Z", http.StatusBadRequest)
+			writeError(w, r, "Invalid since parameter: must be in format 2006-01-02T15:04:05Z", http.StatusBadRequest)
 			return
 		}
 
 		// Validate that the timestamp is not in the future
 		if parsedTime.After(time.Now()) {
-			http.Error(w, "Invalid since parameter: timestamp cannot be in the future", http.StatusBadRequest)
+			writeError(w, r, "Invalid since parameter: timestamp cannot be in the future", http.StatusBadRequest)
 			return
 		}
 
@@ -683,7 +976,7 @@ func (s *Server) handleDomains(w http.ResponseWriter, r *http.Request) {
 
 	// Use PostgreSQL for domain aggregation
 	if s.pgClient == nil {
-		http.Error(w, "Domain aggregation service unavailable: PostgreSQL not connected", http.StatusServiceUnavailable)
+		writeError(w, r, "Domain aggregation service unavailable: PostgreSQL not connected", http.StatusServiceUnavailable)
 		return
 	}
 
@@ -691,19 +984,72 @@ func (s *Server) handleDomains(w http.ResponseWriter, r *http.Request) {
 	domainCounts, err := s.pgClient.GetDomainCounts(since, domainFilter, clientIP)
 	if err != nil {
 		fmt.Printf("PostgreSQL domain aggregation failed: %v\n", err)
-		http.Error(w, "Domain aggregation failed: "+err.Error(), http.StatusInternalServerError)
+		writeError(w, r, "Domain aggregation failed: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	response := map[string]interface{}{
-		"domains": domainCounts,
-		"total":   len(domainCounts),
-		"since":   since,
-		"filter":  domainFilter,
+	meta := map[string]interface{}{
+		"total":  len(domainCounts),
+		"since":  since,
+		"filter": domainFilter,
 	}
 
-	if err := json.NewEncoder(w).Encode(response); err != nil {
-		http.Error(w, "Failed to encode domain counts", http.StatusInternalServerError)
+	if err := writeList(w, r, "domains", domainCounts, meta); err != nil {
+		writeError(w, r, "Failed to encode domain counts", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (s *Server) handleDomainTTLs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	query := r.URL.Query()
+	domainFilter := query.Get("domain")
+
+	var since *time.Time
+	if sinceStr := query.Get("since"); sinceStr != "" {
+		parsedTime, err := time.Parse("2006-01-02T15:04:05Z", sinceStr)
+		if err != nil {
+			writeError(w, r, "Invalid since parameter: must be in format 2006-01-02T15:04:05Z", http.StatusBadRequest)
+			return
+		}
+		since = &parsedTime
+	}
+
+	limit := 100
+	if limitStr := query.Get("limit"); limitStr != "" {
+		parsedLimit, err := strconv.Atoi(limitStr)
+		if err != nil || parsedLimit <= 0 || parsedLimit > 1000 {
+			writeError(w, r, "Invalid limit parameter: must be between 1 and 1000", http.StatusBadRequest)
+			return
+		}
+		limit = parsedLimit
+	}
+
+	if s.pgClient == nil {
+		writeError(w, r, "Domain TTL statistics unavailable: PostgreSQL not connected", http.StatusServiceUnavailable)
+		return
+	}
+
+	domainTTLs, err := s.pgClient.GetDomainTTLs(since, domainFilter, limit)
+	if err != nil {
+		writeError(w, r, "Domain TTL aggregation failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	meta := map[string]interface{}{
+		"total":  len(domainTTLs),
+		"since":  since,
+		"filter": domainFilter,
+	}
+
+	if err := writeList(w, r, "domains", domainTTLs, meta); err != nil {
+		writeError(w, r, "Failed to encode domain TTLs", http.StatusInternalServerError)
 		return
 	}
 }
@@ -716,13 +1062,108 @@ func (s *Server) handleVersion(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 
-	versionInfo := version.Get()
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"version":    versionInfo.Version,
-		"git_commit": versionInfo.GitCommit,
-		"build_date": versionInfo.BuildDate,
-		"go_version": versionInfo.GoVersion,
-	})
+	json.NewEncoder(w).Encode(version.Get())
+}
+
+// ResolveResult is the outcome of a test resolution through the DNS server
+type ResolveResult struct {
+	Name        string     `json:"name"`
+	Type        string     `json:"type"`
+	Rcode       string     `json:"rcode"`
+	Source      string     `json:"source"`             // "upstream", "local" for answers made by the DNS server (custom mappings, local names), or "unknown" when it did not report it
+	Status      string     `json:"status,omitempty"`   // the DNS server's log status for the query
+	Upstream    string     `json:"upstream,omitempty"` // the upstream server that answered; empty when none did
+	Server      string     `json:"server"`             // the DNS server queried (DNS_RESOLVER_ADDRESS), not the upstream
+	RTT         float64    `json:"rtt_ms"`
+	Answers     [][]string `json:"answers"`
+	IPAddresses []string   `json:"ip_addresses"`
+}
+
+func (s *Server) handleResolve(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if s.resolverAddr == "" {
+		http.Error(w, "DNS resolver address not configured (set DNS_RESOLVER_ADDRESS)", http.StatusServiceUnavailable)
+		return
+	}
+
+	name := strings.TrimSpace(r.URL.Query().Get("name"))
+	if _, ok := dns.IsDomainName(name); name == "" || !ok {
+		http.Error(w, "A valid name parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	qtype := dns.TypeA
+	if typeStr := strings.ToUpper(strings.TrimSpace(r.URL.Query().Get("type"))); typeStr != "" {
+		var ok bool
+		if qtype, ok = dns.StringToType[typeStr]; !ok {
+			http.Error(w, "Unknown query type: "+typeStr, http.StatusBadRequest)
+			return
+		}
+	}
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn(name), qtype)
+	msg.RecursionDesired = true
+
+	// Ask the DNS server to report how it answered
+	msg.SetEdns0(dns.DefaultMsgSize, false)
+	opt := msg.IsEdns0()
+	opt.Option = append(opt.Option, &dns.EDNS0_LOCAL{Code: types.EDNS0SourceOption})
+
+	ctx, cancel := context.WithTimeout(r.Context(), resolveTimeout)
+	defer cancel()
+
+	client := &dns.Client{Timeout: resolveTimeout}
+	resp, rtt, err := client.ExchangeContext(ctx, msg, s.resolverAddr)
+	if err != nil {
+		http.Error(w, "Resolution failed: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	result := ResolveResult{
+		Name:        dns.Fqdn(name),
+		Type:        dns.TypeToString[qtype],
+		Rcode:       dns.RcodeToString[resp.Rcode],
+		Source:      "unknown",
+		Server:      s.resolverAddr,
+		RTT:         types.DurationToMilliseconds(rtt),
+		Answers:     types.ExtractAnswers(resp.Answer),
+		IPAddresses: types.ExtractIPAddresses(resp.Answer),
+	}
+	if source, ok := answerSource(resp); ok {
+		result.Status = source.Status
+		switch source.Upstream {
+		case "local", "custom":
+			result.Source = "local"
+		default:
+			result.Source = "upstream"
+			result.Upstream = source.Upstream
+		}
+	}
+
+	json.NewEncoder(w).Encode(result)
+}
+
+// answerSource returns how the DNS server reported answering a query, which it
+// does for loopback clients asking with the answer source option
+func answerSource(resp *dns.Msg) (types.AnswerSource, bool) {
+	var source types.AnswerSource
+	opt := resp.IsEdns0()
+	if opt == nil {
+		return source, false
+	}
+	for _, option := range opt.Option {
+		if local, ok := option.(*dns.EDNS0_LOCAL); ok && local.Code == types.EDNS0SourceOption {
+			return source, json.Unmarshal(local.Data, &source) == nil
+		}
+	}
+	return source, false
 }
 
 func (s *Server) handleLogCounts(w http.ResponseWriter, r *http.Request) {
@@ -769,7 +1210,7 @@ func (s *Server) handleDNSMappings(w http.ResponseWriter, r *http.Request) {
 
 	// Require PostgreSQL client for DNS mappings
 	if s.pgClient == nil {
-		http.Error(w, "PostgreSQL not connected", http.StatusServiceUnavailable)
+		writeError(w, r, "PostgreSQL not connected", http.StatusServiceUnavailable)
 		return
 	}
 
@@ -778,7 +1219,7 @@ func (s *Server) handleDNSMappings(w http.ResponseWriter, r *http.Request) {
 		// Return current DNS mappings from PostgreSQL
 		mappings, err := s.pgClient.GetAllDNSMappings()
 		if err != nil {
-			http.Error(w, "Failed to get DNS mappings: "+err.Error(), http.StatusInternalServerError)
+			writeError(w, r, "Failed to get DNS mappings: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
 
@@ -789,11 +1230,9 @@ func (s *Server) handleDNSMappings(w http.ResponseWriter, r *http.Request) {
 			displayMappings[displayDomain] = ip
 		}
 
-		response := map[string]interface{}{
-			"mappings": displayMappings,
-			"count":    len(displayMappings),
-		}
-		json.NewEncoder(w).Encode(response)
+		writeList(w, r, "mappings", displayMappings, map[string]interface{}{
+			"count": len(displayMappings),
+		})
 
 	case http.MethodPost:
 		// Add a single DNS mapping
@@ -815,16 +1254,21 @@ func (s *Server) handleDNSMappings(w http.ResponseWriter, r *http.Request) {
 		}
 
 		domain := strings.TrimSpace(requestBody.Domain)
-		ip := strings.TrimSpace(requestBody.IP)
+		ip := mapping.NormalizeValue(requestBody.IP)
 
 		if domain == "" || ip == "" {
 			http.Error(w, "Domain and IP are required", http.StatusBadRequest)
 			return
 		}
 
-		// Ensure domain ends with a dot for DNS processing
-		if !strings.HasSuffix(domain, ".") {
-			domain += "."
+		// Ensure domain ends with a dot for DNS processing (regex keys are kept as written)
+		domain = mapping.NormalizeKey(domain)
+
+		if mapping.IsPatternKey(domain) {
+			if _, err := resolver.CompilePattern(domain); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
 		}
 
 		// Check if domain already exists
@@ -839,6 +1283,20 @@ func (s *Server) handleDNSMappings(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		if s.config != nil {
+			if err := s.config.CheckMappingLimit(len(existingMappings) + 1); err != nil {
+				http.Error(w, "Too many DNS mappings: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if mapping.IsPatternKey(domain) {
+			existingMappings[domain] = ip
+			if err := resolver.CheckPatternLimit(existingMappings); err != nil {
+				http.Error(w, "Too many DNS mappings: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
 		// Create the mapping in PostgreSQL
 		if err := s.pgClient.CreateDNSMapping(domain, ip); err != nil {
 			http.Error(w, "Failed to create DNS mapping: "+err.Error(), http.StatusInternalServerError)
@@ -868,10 +1326,8 @@ func (s *Server) handleDNSMappings(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		// Ensure domain ends with a dot for DNS processing
-		if !strings.HasSuffix(domain, ".") {
-			domain += "."
-		}
+		// Ensure domain ends with a dot for DNS processing (regex keys are kept as written)
+		domain = mapping.NormalizeKey(domain)
 
 		// Delete from PostgreSQL
 		if err := s.pgClient.DeleteDNSMapping(domain); err != nil {
@@ -907,7 +1363,7 @@ func (s *Server) corsMiddleware(next http.Handler) http.Handler {
 		// In production, you should restrict this to specific domains
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, "+envelopeVersionHeader)
 		w.Header().Set("Access-Control-Max-Age", "86400")
 
 		if r.Method == "OPTIONS" {
@@ -0,0 +1,224 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"dns-go/internal/types"
+	"dns-go/internal/upstream/testutil"
+
+	"github.com/miekg/dns"
+)
+
+// withSource makes handler report an answer source the way the DNS server does
+// when the query asks for it
+func withSource(handler func(*dns.Msg) *dns.Msg, status, upstream string) func(*dns.Msg) *dns.Msg {
+	return func(req *dns.Msg) *dns.Msg {
+		resp := handler(req)
+		opt := req.IsEdns0()
+		if opt == nil || len(opt.Option) == 0 {
+			return resp
+		}
+		data, _ := json.Marshal(types.AnswerSource{Status: status, Upstream: upstream})
+		resp.SetEdns0(opt.UDPSize(), false)
+		resp.IsEdns0().Option = append(resp.IsEdns0().Option, &dns.EDNS0_LOCAL{Code: types.EDNS0SourceOption, Data: data})
+		return resp
+	}
+}
+
+func TestHandleResolve(t *testing.T) {
+	dnsServer := testutil.NewServer(t, withSource(testutil.Answer("192.0.2.10", 60), "success", "8.8.8.8:53"))
+	s := &Server{resolverAddr: dnsServer.Addr}
+
+	rec := httptest.NewRecorder()
+	s.handleResolve(rec, httptest.NewRequest(http.MethodGet, "/api/resolve?name=example.com&type=a", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var result ResolveResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if result.Name != "example.com." || result.Type != "A" || result.Rcode != "NOERROR" {
+		t.Errorf("Unexpected result header: %+v", result)
+	}
+	if len(result.IPAddresses) != 1 || result.IPAddresses[0] != "192.0.2.10" {
+		t.Errorf("Expected 192.0.2.10, got %v", result.IPAddresses)
+	}
+	if result.Source != "upstream" || result.Upstream != "8.8.8.8:53" || result.Status != "success" {
+		t.Errorf("Expected a successful answer from 8.8.8.8:53, got %+v", result)
+	}
+	if result.Server != dnsServer.Addr {
+		t.Errorf("Expected server %s, got %s", dnsServer.Addr, result.Server)
+	}
+	if dnsServer.Queries() != 1 {
+		t.Errorf("Expected 1 query to reach the DNS server, got %d", dnsServer.Queries())
+	}
+}
+
+func TestHandleResolve_LocalAnswer(t *testing.T) {
+	dnsServer := testutil.NewServer(t, withSource(testutil.Answer("10.0.0.5", 300), "custom_resolution", "custom"))
+	s := &Server{resolverAddr: dnsServer.Addr}
+
+	rec := httptest.NewRecorder()
+	s.handleResolve(rec, httptest.NewRequest(http.MethodGet, "/api/resolve?name=nas.local", nil))
+
+	var result ResolveResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if result.Source != "local" || result.Status != "custom_resolution" || result.Upstream != "" {
+		t.Errorf("Expected a local custom answer, got %+v", result)
+	}
+}
+
+func TestHandleResolve_SourceNotReported(t *testing.T) {
+	answer := testutil.Answer("10.0.0.5", 300)
+	dnsServer := testutil.NewServer(t, func(req *dns.Msg) *dns.Msg {
+		resp := answer(req)
+		resp.Authoritative = true
+		return resp
+	})
+	s := &Server{resolverAddr: dnsServer.Addr}
+
+	rec := httptest.NewRecorder()
+	s.handleResolve(rec, httptest.NewRequest(http.MethodGet, "/api/resolve?name=nas.local", nil))
+
+	var result ResolveResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if result.Source != "unknown" || result.Upstream != "" {
+		t.Errorf("Expected an unknown source without the option, got %+v", result)
+	}
+}
+
+func TestHandleResolve_Errors(t *testing.T) {
+	tests := []struct {
+		name     string
+		resolver string
+		url      string
+		method   string
+		wantCode int
+	}{
+		{name: "not configured", url: "/api/resolve?name=example.com", method: http.MethodGet, wantCode: http.StatusServiceUnavailable},
+		{name: "missing name", resolver: "127.0.0.1:53", url: "/api/resolve", method: http.MethodGet, wantCode: http.StatusBadRequest},
+		{name: "unknown type", resolver: "127.0.0.1:53", url: "/api/resolve?name=example.com&type=BOGUS", method: http.MethodGet, wantCode: http.StatusBadRequest},
+		{name: "wrong method", resolver: "127.0.0.1:53", url: "/api/resolve?name=example.com", method: http.MethodPost, wantCode: http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{resolverAddr: tt.resolver}
+
+			rec := httptest.NewRecorder()
+			s.handleResolve(rec, httptest.NewRequest(tt.method, tt.url, nil))
+
+			if rec.Code != tt.wantCode {
+				t.Errorf("Expected %d, got %d", tt.wantCode, rec.Code)
+			}
+		})
+	}
+}
+
+func TestHandleHealthDetailed(t *testing.T) {
+	dnsServer := testutil.NewServer(t, testutil.Answer("192.0.2.10", 60))
+	es := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/_cluster/health" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"status":"green"}`))
+	}))
+	defer es.Close()
+
+	s := &Server{resolverAddr: dnsServer.Addr, elasticsearchURL: es.URL, healthSlowThreshold: time.Minute}
+
+	rec := httptest.NewRecorder()
+	s.handleHealthDetailed(rec, httptest.NewRequest(http.MethodGet, "/api/health/detailed", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var result struct {
+		Status       string                     `json:"status"`
+		Dependencies map[string]json.RawMessage `json:"dependencies"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if result.Status != "healthy" {
+		t.Errorf("Expected healthy, got %s", result.Status)
+	}
+	for _, name := range []string{"upstream", "elasticsearch"} {
+		var dependency map[string]interface{}
+		if err := json.Unmarshal(result.Dependencies[name], &dependency); err != nil {
+			t.Fatalf("Failed to decode %s health: %v", name, err)
+		}
+		if dependency["status"] != "up" {
+			t.Errorf("Expected %s to be up, got %v", name, dependency)
+		}
+		if latency, ok := dependency["latency_ms"].(float64); !ok || latency <= 0 {
+			t.Errorf("Expected a latency for %s, got %v", name, dependency)
+		}
+	}
+	if dnsServer.Queries() != 1 {
+		t.Errorf("Expected one probe query, got %d", dnsServer.Queries())
+	}
+}
+
+func TestProbeDependencies(t *testing.T) {
+	s := &Server{healthSlowThreshold: 20 * time.Millisecond}
+	dependencies := s.probeDependencies(context.Background(), []dependencyProbe{
+		{name: "fast", check: func(ctx context.Context) error { return nil }},
+		{name: "slow", check: func(ctx context.Context) error {
+			time.Sleep(50 * time.Millisecond)
+			return nil
+		}},
+		{name: "broken", check: func(ctx context.Context) error { return errors.New("connection refused") }},
+	})
+
+	if got := dependencies["fast"]; got.Status != "up" || got.Error != "" {
+		t.Errorf("Expected fast to be up, got %+v", got)
+	}
+	if got := dependencies["slow"]; got.Status != "slow" || got.Latency < 50 {
+		t.Errorf("Expected slow to be slow with its latency, got %+v", got)
+	}
+	if got := dependencies["broken"]; got.Status != "down" || got.Error != "connection refused" {
+		t.Errorf("Expected broken to be down with its error, got %+v", got)
+	}
+}
+
+func TestHandleHealthDetailed_Unhealthy(t *testing.T) {
+	es := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer es.Close()
+
+	s := &Server{elasticsearchURL: es.URL, healthSlowThreshold: time.Minute}
+
+	rec := httptest.NewRecorder()
+	s.handleHealthDetailed(rec, httptest.NewRequest(http.MethodGet, "/api/health/detailed", nil))
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected 503, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var result struct {
+		Status       string                      `json:"status"`
+		Dependencies map[string]DependencyHealth `json:"dependencies"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if result.Status != "unhealthy" || result.Dependencies["elasticsearch"].Status != "down" {
+		t.Errorf("Expected elasticsearch to be reported down, got %+v", result)
+	}
+}
@@ -3,16 +3,26 @@
 package config
 
 import (
+	"bufio"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 
+	"dns-go/internal/mapping"
 	"dns-go/internal/postgres"
+	"dns-go/internal/resolver"
+	"dns-go/internal/rewrite"
+
+	"github.com/miekg/dns"
 )
 
 const (
@@ -20,30 +30,203 @@ const (
 	defaultListenAddress       = "0.0.0.0"
 	defaultPort                = "53"
 	defaultLogLevel            = "info"
+	defaultLogMaxSize          = 100 // MB
+	defaultLogMaxBackups       = 5
 	defaultMaxConcurrent       = 100
 	defaultTimeout             = 5 * time.Second
 	defaultRetryAttempts       = 3
 	defaultHealthCheckInterval = 30 * time.Second
+	defaultLocalDomainPolicy   = LocalDomainPolicyRefuse
+	defaultMaxMessageSize      = 4096
+	defaultMultiQuestionPolicy = MultiQuestionPolicyFormErr
+	defaultMaxCNAMEChain       = 16
+	defaultPrivatePTRPolicy    = LocalDomainPolicyForward
+	defaultMaxCustomMappings   = 10000
+	defaultCustomDNSOnError    = CustomDNSErrorPolicyFail
+	defaultQueryRetryDelay     = 50 * time.Millisecond
+	defaultHealthProbeName     = "."
+	defaultShutdownTimeout     = 10 * time.Second
+	defaultDoHPoolSize         = 16
+	defaultPaddingBlockSize    = 128
+	defaultForceExitTimeout    = 30 * time.Second
+	defaultFailureThreshold    = 3
+	defaultRecoveryTimeout     = 30 * time.Second
+	defaultAlertInterval       = 5 * time.Minute
+	defaultAlertWindow         = time.Minute
 	customDNSConfigFile        = "custom-dns.json"
 )
 
+// Policies for queries under mDNS/local-only domains such as .local
+const (
+	LocalDomainPolicyRefuse   = "refuse"
+	LocalDomainPolicyNXDomain = "nxdomain"
+	LocalDomainPolicyForward  = "forward"
+)
+
+// Policies for queries carrying more than one question
+const (
+	MultiQuestionPolicyFormErr = "formerr"
+	MultiQuestionPolicyFirst   = "first"
+)
+
+// Policies for upstream answers whose CNAME chain exceeds MaxCNAMEChain
+const (
+	CNAMEChainPolicyReject   = "reject"   // answer SERVFAIL
+	CNAMEChainPolicyTruncate = "truncate" // cut the chain at the limit
+)
+
+// Ways of redacting query names in logs and storage
+const (
+	QueryRedactionNone        = "none"        // log the full name
+	QueryRedactionRegistrable = "registrable" // keep only the registrable domain (eTLD+1)
+	QueryRedactionHash        = "hash"        // log an HMAC-SHA-256 of the name keyed with QueryRedactionKey
+	QueryRedactionDrop        = "drop"        // replace the name with a placeholder
+)
+
+// Strategies for spreading a query across upstream servers
+const (
+	UpstreamStrategyConcurrent = "concurrent"
+	UpstreamStrategySequential = "sequential"
+)
+
+// Handling of the Recursion Desired bit of queries forwarded upstream
+const (
+	RecursionDesiredPreserve = "preserve" // keep the client's RD bit
+	RecursionDesiredOn       = "on"       // always ask upstreams to recurse
+	RecursionDesiredOff      = "off"      // never ask upstreams to recurse
+)
+
+// Policies for a custom DNS configuration that cannot be loaded at startup
+const (
+	CustomDNSErrorPolicyFail = "fail"
+	CustomDNSErrorPolicyWarn = "warn"
+)
+
+// Preferred address family ordering of A and AAAA answers
+const (
+	AnswerOrderNone      = "none"
+	AnswerOrderIPv4First = "ipv4-first"
+	AnswerOrderIPv6First = "ipv6-first"
+)
+
+// SpecialUseLoopback answers special-use domain queries (e.g. .localhost) with
+// loopback addresses. The special-use policy table also accepts the local
+// domain policies above.
+const SpecialUseLoopback = "loopback"
+
 var (
 	// defaultUpstreamDNS contains the default DNS servers to use
 	defaultUpstreamDNS = []string{"8.8.8.8:53", "1.1.1.1:53"}
+
+	// defaultLocalDomains contains the local-only domains (RFC 6762) that are never forwarded by default
+	defaultLocalDomains = []string{"local."}
+
+	// defaultSpecialUseDomains contains the RFC 6761 / RFC 7686 special-use domains and how they are answered.
+	// localhost is answered by the resolver first unless AnswerLocalhost is disabled. Example names are
+	// resolved normally, as RFC 6761 section 6.5 asks of caching DNS servers.
+	defaultSpecialUseDomains = map[string]string{
+		"localhost.": SpecialUseLoopback,
+		"invalid.":   LocalDomainPolicyNXDomain,
+		"test.":      LocalDomainPolicyNXDomain,
+		"example.":   LocalDomainPolicyForward,
+		"onion.":     LocalDomainPolicyRefuse,
+	}
 )
 
 // Config holds the DNS server configuration
 type Config struct {
 	ListenAddress       string            `json:"listen_address"`
 	Port                string            `json:"port"`
+	ListenTCP           bool              `json:"listen_tcp"`
 	UpstreamDNS         []string          `json:"upstream_dns"`
+	UpstreamAliases     map[string]string `json:"upstream_aliases,omitempty"`
+	UpstreamStrategy    string            `json:"upstream_strategy"`
+	RecursionDesired    string            `json:"recursion_desired"`
+	UpstreamTimeouts    ServerTimeouts    `json:"upstream_timeouts,omitempty"`
+	UpstreamStagger     time.Duration     `json:"upstream_stagger,omitempty"`
+	StubZones           ZoneServers       `json:"stub_zones,omitempty"`
+	UpstreamRoutes      ZoneServers       `json:"upstream_routes,omitempty"`
 	CustomDNS           map[string]string `json:"custom_dns,omitempty"`
+	HostsFile           string            `json:"hosts_file,omitempty"`
+	CustomDNSTTL        int               `json:"custom_dns_ttl"`
+	MaxCustomMappings   int               `json:"max_custom_mappings"`
+	CustomDNSOnError    string            `json:"custom_dns_on_error"`
+	CustomZones         []string          `json:"custom_zones,omitempty"`
+	ZoneNameservers     []string          `json:"zone_nameservers,omitempty"`
+	ZoneHostmaster      string            `json:"zone_hostmaster,omitempty"`
 	LogFile             string            `json:"log_file,omitempty"`
 	LogLevel            string            `json:"log_level"`
+	LogMaxSize          int               `json:"log_max_size"`
+	LogMaxBackups       int               `json:"log_max_backups"`
+	QueryRedaction      string            `json:"query_redaction"`
+	QueryRedactionKey   string            `json:"-"`
 	MaxConcurrent       int               `json:"max_concurrent"`
 	Timeout             time.Duration     `json:"timeout"`
 	RetryAttempts       int               `json:"retry_attempts"`
+	QueryRetries        int               `json:"query_retries"`
+	QueryRetryDelay     time.Duration     `json:"query_retry_delay"`
 	HealthCheckInterval time.Duration     `json:"health_check_interval"`
+	HealthProbeName     string            `json:"health_probe_name"`
+	HealthProbeLatency  time.Duration     `json:"health_probe_latency,omitempty"`
+	LocalDomains        []string          `json:"local_domains"`
+	LocalDomainPolicy   string            `json:"local_domain_policy"`
+	SpecialUseDomains   map[string]string `json:"special_use_domains"`
+	PrivatePTRPolicy    string            `json:"private_ptr_policy"`
+	UDPTimeout          time.Duration     `json:"udp_timeout,omitempty"`
+	TCPTimeout          time.Duration     `json:"tcp_timeout,omitempty"`
+	MinimizeResponses   bool              `json:"minimize_responses"`
+	AnswerOrder         string            `json:"answer_order"`
+	AnswerLocalhost     bool              `json:"answer_localhost"`
+	SelfHostnames       []string          `json:"self_hostnames,omitempty"`
+	SelfAddress         string            `json:"self_address,omitempty"`
+	LocalResponseCache  bool              `json:"local_response_cache"`
+	RandomizeQueryIDs   bool              `json:"randomize_query_ids"`
+	LogTLSDetails       bool              `json:"log_tls_details"`
+	ServfailIsFailure   bool              `json:"servfail_is_failure"`
+	FailoverOnRefused   bool              `json:"failover_on_refused"`
+	ChaseDanglingCNAMEs bool              `json:"chase_dangling_cnames"`
+	MaxCNAMEChain       int               `json:"max_cname_chain"`
+	CNAMEChainPolicy    string            `json:"cname_chain_policy"`
+	TCPFallback         bool              `json:"tcp_fallback"`
+	DNSSECValidate      bool              `json:"dnssec_validate"`
+	DoHPoolSize         int               `json:"doh_pool_size"`
+	PaddingBlockSize    int               `json:"padding_block_size"`
+	PadPlainDNS         bool              `json:"pad_plain_dns"`
+	SlowQueryThreshold  time.Duration     `json:"slow_query_threshold,omitempty"`
+	LogAttemptsWait     time.Duration     `json:"log_attempts_wait,omitempty"`
+	AllowlistFile       string            `json:"allowlist_file,omitempty"`
+	AllowedDomains      []string          `json:"allowed_domains,omitempty"`
+	BlocklistFile       string            `json:"blocklist_file,omitempty"`
+	BlockedDomains      []string          `json:"-"`
+	BlocklistSinkhole   []string          `json:"blocklist_sinkhole,omitempty"`
+	RewriteRulesFile    string            `json:"rewrite_rules_file,omitempty"`
+	RewriteRules        []rewrite.Rule    `json:"rewrite_rules,omitempty"`
+	CanaryDomains       []string          `json:"canary_domains,omitempty"`
+	CanarySinkhole      []string          `json:"canary_sinkhole,omitempty"`
+	CanaryWebhook       string            `json:"canary_webhook,omitempty"`
+	ClientQueryTypes    QueryTypeRules    `json:"client_query_types,omitempty"`
+	MaxMessageSize      int               `json:"max_message_size"`
+	MultiQuestionPolicy string            `json:"multi_question_policy"`
+	Workers             int               `json:"workers"`
+	WorkerQueueSize     int               `json:"worker_queue_size,omitempty"`
+	ShutdownTimeout     time.Duration     `json:"shutdown_timeout"`
+	ForceExitTimeout    time.Duration     `json:"force_exit_timeout"`
+
+	// Upstream circuit breaker
+	UpstreamFailureThreshold int           `json:"upstream_failure_threshold"`
+	UpstreamRecoveryTimeout  time.Duration `json:"upstream_recovery_timeout"`
+
+	// Webhook alerts on upstream outages, high failure rates and lost
+	// dependencies, each event fired at most once per AlertInterval
+	AlertWebhook     string        `json:"alert_webhook,omitempty"`
+	AlertInterval    time.Duration `json:"alert_interval"`
+	AlertFailureRate float64       `json:"alert_failure_rate,omitempty"`
+	AlertWindow      time.Duration `json:"alert_window"`
+
+	// Mappings given with -custom-dns and read from HostsFile, which reloads
+	// lay the other sources over
+	flagMappings  map[string]string
+	hostsMappings map[string]string
 
 	// File watching for hot reload
 	customDNSPath    string
@@ -51,9 +234,21 @@ type Config struct {
 	mutex            sync.RWMutex
 }
 
+// QueryTypeRules maps client subnets (CIDR or a single IP) to the query types
+// clients in them may send
+type QueryTypeRules map[string][]string
+
+// ZoneServers maps zones to the servers queries for names in them are sent to,
+// such as the authoritative servers of stub zones
+type ZoneServers map[string][]string
+
+// ServerTimeouts maps upstream servers, as listed in UpstreamDNS, to their own
+// query timeout
+type ServerTimeouts map[string]time.Duration
+
 // CustomDNSConfig represents the structure of the custom DNS configuration file
 type CustomDNSConfig struct {
-	Mappings map[string]string `json:"mappings"`
+	Mappings map[string]mapping.Value `json:"mappings"`
 }
 
 // DefaultConfig returns a configuration with sensible defaults
@@ -61,14 +256,57 @@ func DefaultConfig() *Config {
 	return &Config{
 		ListenAddress:       defaultListenAddress,
 		Port:                defaultPort,
+		ListenTCP:           true,
 		UpstreamDNS:         append([]string(nil), defaultUpstreamDNS...), // Copy slice
+		UpstreamStrategy:    UpstreamStrategyConcurrent,
+		RecursionDesired:    RecursionDesiredPreserve,
 		CustomDNS:           make(map[string]string),
+		CustomDNSTTL:        resolver.DefaultTTL,
+		MaxCustomMappings:   defaultMaxCustomMappings,
+		CustomDNSOnError:    defaultCustomDNSOnError,
 		LogLevel:            defaultLogLevel,
+		LogMaxSize:          defaultLogMaxSize,
+		LogMaxBackups:       defaultLogMaxBackups,
+		QueryRedaction:      QueryRedactionNone,
 		MaxConcurrent:       defaultMaxConcurrent,
 		Timeout:             defaultTimeout,
 		RetryAttempts:       defaultRetryAttempts,
+		QueryRetryDelay:     defaultQueryRetryDelay,
 		HealthCheckInterval: defaultHealthCheckInterval,
+		HealthProbeName:     defaultHealthProbeName,
+		LocalDomains:        append([]string(nil), defaultLocalDomains...), // Copy slice
+		LocalDomainPolicy:   defaultLocalDomainPolicy,
+		SpecialUseDomains:   copyMappings(defaultSpecialUseDomains),
+		PrivatePTRPolicy:    defaultPrivatePTRPolicy,
+		MaxMessageSize:      defaultMaxMessageSize,
+		MultiQuestionPolicy: defaultMultiQuestionPolicy,
+		MaxCNAMEChain:       defaultMaxCNAMEChain,
+		CNAMEChainPolicy:    CNAMEChainPolicyReject,
+		AnswerOrder:         AnswerOrderNone,
+		AnswerLocalhost:     true,
+		LocalResponseCache:  true,
+		RandomizeQueryIDs:   true,
+		TCPFallback:         true,
+		DoHPoolSize:         defaultDoHPoolSize,
+		PaddingBlockSize:    defaultPaddingBlockSize,
+		ShutdownTimeout:     defaultShutdownTimeout,
+		ForceExitTimeout:    defaultForceExitTimeout,
+
+		UpstreamFailureThreshold: defaultFailureThreshold,
+		UpstreamRecoveryTimeout:  defaultRecoveryTimeout,
+
+		AlertInterval: defaultAlertInterval,
+		AlertWindow:   defaultAlertWindow,
+	}
+}
+
+// copyMappings returns a copy of a domain-keyed string map
+func copyMappings(src map[string]string) map[string]string {
+	dst := make(map[string]string, len(src))
+	for domain, value := range src {
+		dst[domain] = value
 	}
+	return dst
 }
 
 // LoadFromFlags parses command line flags and returns configuration.
@@ -78,23 +316,188 @@ func LoadFromFlags() (*Config, error) {
 
 	listenAddr := flag.String("listen", cfg.ListenAddress, "Listen address")
 	port := flag.String("port", cfg.Port, "Listen port")
-	upstreams := flag.String("upstreams", strings.Join(cfg.UpstreamDNS, ","), "Comma-separated list of upstream DNS servers")
-	customDNS := flag.String("custom-dns", "", "Custom DNS mappings in format: domain1=ip1,domain2=ip2 (e.g., server.local=192.168.0.30)")
+	listenTCP := flag.Bool("tcp", cfg.ListenTCP, "Also listen for queries over TCP on the same address and port")
+	upstreams := flag.String("upstreams", strings.Join(cfg.UpstreamDNS, ","), "Comma-separated list of upstream DNS servers; append #N to set a priority, lower values are preferred (e.g., 192.168.0.1:53,8.8.8.8:53#1)")
+	upstreamAliases := flag.String("upstream-aliases", "", "Short names for upstream servers in format: alias1=upstream1,alias2=upstream2 (e.g., cloudflare=1.1.1.1:53); aliases can be used wherever an upstream is listed")
+	upstreamStrategy := flag.String("upstream-strategy", cfg.UpstreamStrategy, "How queries are sent to upstream servers: concurrent (all at once) or sequential (one at a time in listed order)")
+	recursionDesired := flag.String("recursion-desired", cfg.RecursionDesired, "RD bit of queries forwarded upstream: preserve (the client's), on or off")
+	upstreamRoutes := flag.String("upstream-routes", "", "Domains resolved by their own upstream servers instead of the default ones in format: domain1=server1|server2,domain2=server3 (e.g., corp.internal=10.0.0.53:53); subdomains follow the most specific domain")
+	upstreamTimeouts := flag.String("upstream-timeouts", "", "Per-upstream timeouts in format: upstream1=duration1,upstream2=duration2 (e.g., 192.168.0.1:53=300ms); other upstreams use -timeout")
+	stubZones := flag.String("stub-zones", "", "Zones forwarded to their authoritative servers instead of the upstreams in format: zone1=server1|server2,zone2=server3 (e.g., corp.internal=10.0.0.53:53|10.0.0.54:53)")
+	upstreamStagger := flag.Duration("upstream-stagger", cfg.UpstreamStagger, "With the concurrent strategy, query upstreams fastest first, each this much later than the previous, skipping the rest once one answers (0 = all at once)")
+	customDNS := flag.String("custom-dns", "", "Custom DNS mappings in format: domain1=ip1,domain2=ip2 (e.g., server.local=192.168.0.30); list further IPs after a mapping to answer with several records (e.g., web.local=192.168.0.31,192.168.0.32); txt:, mx: and srv: values add other record types (e.g., local=mx:10 mail.local)")
+	customDNSTTL := flag.Int("custom-dns-ttl", cfg.CustomDNSTTL, "TTL in seconds of records answered from custom DNS mappings; add ttl:N to a mapping to override it (e.g., web.local=192.168.0.31,ttl:60)")
+	hostsFile := flag.String("hosts-file", "", "Path to a hosts file (IP domain [domain...] per line) with further custom DNS mappings; custom-dns.json and PostgreSQL mappings take precedence")
+	maxCustomMappings := flag.Int("max-custom-mappings", cfg.MaxCustomMappings, "Maximum number of custom DNS mappings (0 = no limit)")
+	customDNSErrorPolicy := flag.String("custom-dns-error-policy", cfg.CustomDNSOnError, "Handling of an unreadable or malformed custom DNS configuration at startup (fail, warn)")
+	customZones := flag.String("custom-zones", "", "Comma-separated list of custom zones answered with NS and SOA records at their apex (e.g., internal.local)")
+	zoneNameservers := flag.String("zone-nameservers", "", "Comma-separated NS targets for custom zones (defaults to ns.<zone>)")
+	zoneHostmaster := flag.String("zone-hostmaster", "", "SOA mailbox for custom zones in domain form (defaults to hostmaster.<zone>)")
 	logFile := flag.String("log", cfg.LogFile, "Log file path (optional)")
 	logLevel := flag.String("log-level", cfg.LogLevel, "Log level (debug, info, warn, error)")
+	logMaxSize := flag.Int("log-max-size", cfg.LogMaxSize, "Rotate each log file once it reaches this size in megabytes (0 = never rotate)")
+	logMaxBackups := flag.Int("log-max-backups", cfg.LogMaxBackups, "Number of rotated log files to keep")
+	queryRedaction := flag.String("query-redaction", cfg.QueryRedaction, "Redaction of query names in logs and storage (none, registrable, hash, drop)")
+	queryRedactionKey := flag.String("query-redaction-key", os.Getenv("QUERY_REDACTION_KEY"), "Secret key of the HMAC used by -query-redaction=hash (defaults to $QUERY_REDACTION_KEY)")
 	maxConcurrent := flag.Int("max-concurrent", cfg.MaxConcurrent, "Maximum concurrent requests")
 	timeout := flag.Duration("timeout", cfg.Timeout, "Upstream server timeout")
 	retryAttempts := flag.Int("retry-attempts", cfg.RetryAttempts, "Number of retry attempts")
-
+	queryRetries := flag.Int("query-retries", cfg.QueryRetries, "Number of times to repeat the whole upstream query when every upstream failed, within the query's timeout budget (0 = disabled)")
+	queryRetryDelay := flag.Duration("query-retry-delay", cfg.QueryRetryDelay, "Delay before repeating an upstream query that failed on every upstream")
+	failureThreshold := flag.Int("upstream-failure-threshold", cfg.UpstreamFailureThreshold, "Number of consecutive failures after which an upstream is marked unhealthy")
+	recoveryTimeout := flag.Duration("upstream-recovery-timeout", cfg.UpstreamRecoveryTimeout, "Time an unhealthy upstream sits out before health checks may bring it back")
+	healthProbeName := flag.String("health-probe-name", cfg.HealthProbeName, "Name queried with type SOA by upstream health checks")
+	healthProbeLatency := flag.Duration("health-probe-latency", cfg.HealthProbeLatency, "Mark an upstream unhealthy when several consecutive health checks take longer than this to answer (0 = disabled)")
+	localDomains := flag.String("local-domains", strings.Join(cfg.LocalDomains, ","), "Comma-separated list of local-only (mDNS) domains that are not forwarded upstream")
+	localDomainPolicy := flag.String("local-domain-policy", cfg.LocalDomainPolicy, "Answer for unmapped local-only domain queries (refuse, nxdomain, forward)")
+	privatePTRPolicy := flag.String("private-ptr-policy", cfg.PrivatePTRPolicy, "Answer for unmapped reverse lookups of private (RFC 1918 / ULA) addresses (refuse, nxdomain, forward)")
+	udpTimeout := flag.Duration("udp-timeout", cfg.UDPTimeout, "Overall resolution budget for queries received over UDP (defaults to -timeout)")
+	tcpTimeout := flag.Duration("tcp-timeout", cfg.TCPTimeout, "Overall resolution budget for queries received over TCP (defaults to -timeout)")
+	minimizeResponses := flag.Bool("minimize-responses", cfg.MinimizeResponses, "Strip authority and additional sections (except EDNS0 OPT) from upstream responses")
+	answerOrder := flag.String("answer-order", cfg.AnswerOrder, "Order of A and AAAA records within answers (none, ipv4-first, ipv6-first)")
+	answerLocalhost := flag.Bool("answer-localhost", cfg.AnswerLocalhost, "Answer localhost A/AAAA and loopback PTR queries in the resolver; when disabled, localhost follows -special-use-domains")
+	selfHostnames := flag.String("self-hostnames", "", "Comma-separated hostnames of this server answered locally with its own address (e.g., dns.home.lan)")
+	selfAddress := flag.String("self-address", "", "Address returned for -self-hostnames (defaults to the listen address, or the address the query was received on)")
+	slowQueryThreshold := flag.Duration("slow-query-threshold", cfg.SlowQueryThreshold, "Log a warning for queries taking longer than this to answer (0 = disabled)")
+	logAttemptsWait := flag.Duration("log-attempts-wait", cfg.LogAttemptsWait, "After answering, wait up to this long for the slower upstreams to log every attempt of a query (0 = log only the attempts completed when answering)")
+	servfailIsFailure := flag.Bool("servfail-is-failure", cfg.ServfailIsFailure, "Count SERVFAIL answers toward marking an upstream unhealthy, like timeouts")
+	dohPoolSize := flag.Int("doh-pool-size", cfg.DoHPoolSize, "Maximum number of connections opened and kept idle per DNS over HTTPS upstream")
+	paddingBlockSize := flag.Int("padding-block-size", cfg.PaddingBlockSize, "Pad queries to DoT/DoH upstreams with EDNS0 padding to a multiple of this many bytes (0 = disabled)")
+	padPlainDNS := flag.Bool("pad-plain-dns", cfg.PadPlainDNS, "Also pad queries to plain UDP/TCP upstreams")
+	dnssecValidate := flag.Bool("dnssec-validate", cfg.DNSSECValidate, "Request DNSSEC records from upstreams and validate signed answers, setting the AD bit on validated answers and answering SERVFAIL when validation fails")
+	tcpFallback := flag.Bool("tcp-fallback", cfg.TCPFallback, "Retry truncated UDP answers from upstream servers over TCP")
+	chaseDanglingCNAMEs := flag.Bool("chase-dangling-cnames", cfg.ChaseDanglingCNAMEs, "Query the target of CNAME chains that upstream answers leave without a record of the queried type, and add its records to the answer")
+	maxCNAMEChain := flag.Int("max-cname-chain", cfg.MaxCNAMEChain, "Maximum number of CNAME records chained in an upstream answer (0 = unlimited)")
+	cnameChainPolicy := flag.String("cname-chain-policy", cfg.CNAMEChainPolicy, "Handling of upstream answers with a longer CNAME chain than -max-cname-chain: reject (SERVFAIL) or truncate (cut the chain at the limit)")
+	failoverOnRefused := flag.Bool("failover-on-refused", cfg.FailoverOnRefused, "Treat REFUSED upstream answers like SERVFAIL, preferring another upstream's answer")
+	logTLSDetails := flag.Bool("log-tls-details", cfg.LogTLSDetails, "Log negotiated TLS version, cipher suite and certificate of DoT/DoH upstream connections at debug level")
+	randomizeQueryIDs := flag.Bool("randomize-query-ids", cfg.RandomizeQueryIDs, "Use a fresh random query ID for each upstream exchange instead of the client's")
+	localResponseCache := flag.Bool("local-response-cache", cfg.LocalResponseCache, "Reuse synthesized responses for custom DNS mappings until the mappings change")
+	maxMessageSize := flag.Int("max-message-size", cfg.MaxMessageSize, "Maximum accepted DNS query size in bytes; larger queries get FORMERR (0 = no limit)")
+	multiQuestionPolicy := flag.String("multi-question-policy", cfg.MultiQuestionPolicy, "Handling of queries with more than one question (formerr, first)")
+	workers := flag.Int("workers", cfg.Workers, "Number of worker goroutines processing queries (0 = one goroutine per query)")
+	workerQueueSize := flag.Int("worker-queue", cfg.WorkerQueueSize, "Number of queries waiting for a worker before new ones get SERVFAIL (defaults to -max-concurrent)")
+	shutdownTimeout := flag.Duration("shutdown-timeout", cfg.ShutdownTimeout, "Time allowed for in-flight queries to drain during graceful shutdown")
+	forceExitTimeout := flag.Duration("force-exit-timeout", cfg.ForceExitTimeout, "Time after a shutdown signal before the process exits even if shutdown has not finished")
+	allowlistFile := flag.String("allowlist", cfg.AllowlistFile, "Path to a domain allowlist file (one domain per line); when set, only listed domains and their subdomains are resolved")
+	rewriteRulesFile := flag.String("rewrite-rules", cfg.RewriteRulesFile, "Path to a JSON file of rules rewriting upstream answers, e.g. [{\"suffix\": \"example.com\", \"replace\": {\"192.0.2.1\": \"10.0.0.1\"}, \"ttl\": 60}]")
+	blocklistFile := flag.String("blocklist", cfg.BlocklistFile, "Path to a domain blocklist file (hosts file format or one domain per line); listed domains and their subdomains are answered with NXDOMAIN")
+	blocklistSinkhole := flag.String("blocklist-sinkhole", "", "Comma-separated addresses answered for blocked domains instead of NXDOMAIN (e.g., 0.0.0.0,::)")
+	alertWebhook := flag.String("alert-webhook", cfg.AlertWebhook, "URL receiving a JSON POST when all upstreams are down, the failure rate is high or PostgreSQL is unreachable")
+	alertInterval := flag.Duration("alert-interval", cfg.AlertInterval, "Minimum time between two alerts of the same kind")
+	alertFailureRate := flag.Float64("alert-failure-rate", cfg.AlertFailureRate, "Share of failed queries (0-1) within -alert-window that triggers an alert (0 = disabled)")
+	alertWindow := flag.Duration("alert-window", cfg.AlertWindow, "Window over which the query failure rate is measured")
+	canaryDomains := flag.String("canary-domains", "", "Comma-separated canary domains; queries for them and their subdomains raise a security alert")
+	canarySinkhole := flag.String("canary-sinkhole", "", "Comma-separated addresses answered for canary domains instead of resolving them (e.g., 0.0.0.0,::)")
+	canaryWebhook := flag.String("canary-webhook", cfg.CanaryWebhook, "URL receiving a JSON POST for canary domain queries, once per -alert-interval for each client and queried name")
+	clientQueryTypes := flag.String("client-query-types", "", "Query types allowed per client subnet in format: subnet1=type1|type2,subnet2=type3 (e.g., 192.168.10.0/24=A|AAAA); other types are refused for clients in a listed subnet")
+	specialUseDomains := flag.String("special-use-domains", "", "Special-use domain policies in format: domain1=action1,domain2=action2 (actions: loopback, nxdomain, refuse, forward); replaces the RFC 6761 defaults")
+
+	flag.Usage = func() {
+		out := flag.CommandLine.Output()
+		fmt.Fprintf(out, "Usage of %s:\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprintln(out, "\nOn SIGHUP the custom DNS mappings (custom-dns.json or PostgreSQL), the -hosts-file and the -blocklist")
+		fmt.Fprintln(out, "file are read again. Every other setting, upstreams included, takes effect only after a restart.")
+	}
 	flag.Parse()
 
 	cfg.ListenAddress = strings.TrimSpace(*listenAddr)
 	cfg.Port = strings.TrimSpace(*port)
+	cfg.ListenTCP = *listenTCP
 	cfg.LogFile = strings.TrimSpace(*logFile)
 	cfg.LogLevel = strings.ToLower(strings.TrimSpace(*logLevel))
+	cfg.LogMaxSize = *logMaxSize
+	cfg.LogMaxBackups = *logMaxBackups
+	cfg.QueryRedaction = strings.ToLower(strings.TrimSpace(*queryRedaction))
+	cfg.QueryRedactionKey = *queryRedactionKey
 	cfg.MaxConcurrent = *maxConcurrent
+	cfg.UpstreamStrategy = strings.ToLower(strings.TrimSpace(*upstreamStrategy))
+	cfg.RecursionDesired = strings.ToLower(strings.TrimSpace(*recursionDesired))
+	cfg.UpstreamStagger = *upstreamStagger
+	cfg.CustomDNSTTL = *customDNSTTL
+	cfg.MaxCustomMappings = *maxCustomMappings
+	cfg.CustomDNSOnError = strings.ToLower(strings.TrimSpace(*customDNSErrorPolicy))
+	cfg.MaxMessageSize = *maxMessageSize
+	cfg.MultiQuestionPolicy = strings.ToLower(strings.TrimSpace(*multiQuestionPolicy))
+	cfg.Workers = *workers
+	cfg.WorkerQueueSize = *workerQueueSize
+	cfg.ShutdownTimeout = *shutdownTimeout
+	cfg.ForceExitTimeout = *forceExitTimeout
 	cfg.Timeout = *timeout
 	cfg.RetryAttempts = *retryAttempts
+	cfg.QueryRetries = *queryRetries
+	cfg.QueryRetryDelay = *queryRetryDelay
+	cfg.UpstreamFailureThreshold = *failureThreshold
+	cfg.UpstreamRecoveryTimeout = *recoveryTimeout
+	cfg.HealthProbeName = strings.TrimSpace(*healthProbeName)
+	cfg.HealthProbeLatency = *healthProbeLatency
+	cfg.UDPTimeout = *udpTimeout
+	cfg.TCPTimeout = *tcpTimeout
+	cfg.MinimizeResponses = *minimizeResponses
+	cfg.AnswerOrder = strings.ToLower(strings.TrimSpace(*answerOrder))
+	cfg.AnswerLocalhost = *answerLocalhost
+	cfg.SelfHostnames = parseDomainList(*selfHostnames)
+	cfg.SelfAddress = strings.TrimSpace(*selfAddress)
+	cfg.LocalResponseCache = *localResponseCache
+	cfg.RandomizeQueryIDs = *randomizeQueryIDs
+	cfg.LogTLSDetails = *logTLSDetails
+	cfg.ServfailIsFailure = *servfailIsFailure
+	cfg.FailoverOnRefused = *failoverOnRefused
+	cfg.ChaseDanglingCNAMEs = *chaseDanglingCNAMEs
+	cfg.MaxCNAMEChain = *maxCNAMEChain
+	cfg.CNAMEChainPolicy = strings.ToLower(strings.TrimSpace(*cnameChainPolicy))
+	cfg.TCPFallback = *tcpFallback
+	cfg.DNSSECValidate = *dnssecValidate
+	cfg.DoHPoolSize = *dohPoolSize
+	cfg.PaddingBlockSize = *paddingBlockSize
+	cfg.PadPlainDNS = *padPlainDNS
+	cfg.SlowQueryThreshold = *slowQueryThreshold
+	cfg.LogAttemptsWait = *logAttemptsWait
+	cfg.LocalDomains = parseDomainList(*localDomains)
+	cfg.LocalDomainPolicy = strings.ToLower(strings.TrimSpace(*localDomainPolicy))
+	cfg.PrivatePTRPolicy = strings.ToLower(strings.TrimSpace(*privatePTRPolicy))
+	cfg.AllowlistFile = strings.TrimSpace(*allowlistFile)
+	cfg.BlocklistFile = strings.TrimSpace(*blocklistFile)
+	cfg.RewriteRulesFile = strings.TrimSpace(*rewriteRulesFile)
+	cfg.BlocklistSinkhole = parseList(*blocklistSinkhole)
+	cfg.CanaryDomains = parseDomainList(*canaryDomains)
+	cfg.CanarySinkhole = parseList(*canarySinkhole)
+	cfg.CanaryWebhook = strings.TrimSpace(*canaryWebhook)
+	cfg.AlertWebhook = strings.TrimSpace(*alertWebhook)
+	cfg.AlertInterval = *alertInterval
+	cfg.AlertFailureRate = *alertFailureRate
+	cfg.AlertWindow = *alertWindow
+	cfg.CustomZones = parseDomainList(*customZones)
+	cfg.ZoneNameservers = parseDomainList(*zoneNameservers)
+	cfg.ZoneHostmaster = strings.TrimSpace(*zoneHostmaster)
+
+	// Load the domain allowlist
+	if cfg.AllowlistFile != "" {
+		domains, err := loadAllowlistFile(cfg.AllowlistFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.AllowedDomains = domains
+	}
+
+	// Load the domain blocklist
+	if cfg.BlocklistFile != "" {
+		domains, err := resolver.LoadBlocklistFile(cfg.BlocklistFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.BlockedDomains = domains
+	}
+
+	// Load the answer rewriting rules
+	if cfg.RewriteRulesFile != "" {
+		rules, err := rewrite.LoadFile(cfg.RewriteRulesFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RewriteRules = rules
+	}
 
 	// Parse upstream servers
 	if strings.TrimSpace(*upstreams) != "" {
@@ -107,35 +510,161 @@ func LoadFromFlags() (*Config, error) {
 		}
 	}
 
+	// Parse upstream aliases
+	if strings.TrimSpace(*upstreamAliases) != "" {
+		cfg.UpstreamAliases = make(map[string]string)
+		for _, entry := range strings.Split(*upstreamAliases, ",") {
+			entry = strings.TrimSpace(entry)
+			if entry == "" {
+				continue
+			}
+			parts := strings.SplitN(entry, "=", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("invalid upstream alias format: %s (expected alias=upstream)", entry)
+			}
+			cfg.UpstreamAliases[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+		}
+	}
+
+	// Parse special-use domain policies
+	if strings.TrimSpace(*specialUseDomains) != "" {
+		cfg.SpecialUseDomains = make(map[string]string)
+		for _, entry := range strings.Split(*specialUseDomains, ",") {
+			entry = strings.TrimSpace(entry)
+			if entry == "" {
+				continue
+			}
+			parts := strings.SplitN(entry, "=", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("invalid special-use domain policy format: %s (expected domain=action)", entry)
+			}
+			domains := parseDomainList(parts[0])
+			if len(domains) != 1 {
+				return nil, fmt.Errorf("invalid special-use domain policy format: %s (domain cannot be empty)", entry)
+			}
+			cfg.SpecialUseDomains[domains[0]] = strings.ToLower(strings.TrimSpace(parts[1]))
+		}
+	}
+
+	// Parse per-upstream timeouts
+	if strings.TrimSpace(*upstreamTimeouts) != "" {
+		cfg.UpstreamTimeouts = make(ServerTimeouts)
+		for _, entry := range strings.Split(*upstreamTimeouts, ",") {
+			entry = strings.TrimSpace(entry)
+			if entry == "" {
+				continue
+			}
+			i := strings.LastIndex(entry, "=")
+			if i <= 0 {
+				return nil, fmt.Errorf("invalid upstream timeout format: %s (expected upstream=duration)", entry)
+			}
+			timeout, err := time.ParseDuration(strings.TrimSpace(entry[i+1:]))
+			if err != nil {
+				return nil, fmt.Errorf("invalid upstream timeout %s: %w", entry, err)
+			}
+			cfg.UpstreamTimeouts[strings.TrimSpace(entry[:i])] = timeout
+		}
+	}
+
+	// Parse stub zones and upstream routes
+	stubZoneServers, err := parseZoneServers(*stubZones, "stub zone")
+	if err != nil {
+		return nil, err
+	}
+	cfg.StubZones = stubZoneServers
+	routes, err := parseZoneServers(*upstreamRoutes, "upstream route")
+	if err != nil {
+		return nil, err
+	}
+	cfg.UpstreamRoutes = routes
+
+	// Parse per-client query type restrictions
+	if strings.TrimSpace(*clientQueryTypes) != "" {
+		cfg.ClientQueryTypes = make(QueryTypeRules)
+		for _, entry := range strings.Split(*clientQueryTypes, ",") {
+			entry = strings.TrimSpace(entry)
+			if entry == "" {
+				continue
+			}
+			parts := strings.SplitN(entry, "=", 2)
+			if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+				return nil, fmt.Errorf("invalid client query types format: %s (expected subnet=type1|type2)", entry)
+			}
+			var qtypes []string
+			for _, qtype := range strings.Split(parts[1], "|") {
+				if qtype = strings.ToUpper(strings.TrimSpace(qtype)); qtype != "" {
+					qtypes = append(qtypes, qtype)
+				}
+			}
+			cfg.ClientQueryTypes[strings.TrimSpace(parts[0])] = qtypes
+		}
+	}
+
 	// Parse custom DNS mappings
 	if strings.TrimSpace(*customDNS) != "" {
 		mappingList := strings.Split(*customDNS, ",")
 		cfg.CustomDNS = make(map[string]string)
-		for _, mapping := range mappingList {
-			mapping = strings.TrimSpace(mapping)
-			if mapping == "" {
+		var previous string
+		for _, entry := range mappingList {
+			entry = strings.TrimSpace(entry)
+			if entry == "" {
+				continue
+			}
+			if previous != "" && resolver.RecordType(entry) != 0 {
+				// A further typed record of the previous mapping, whose text may
+				// hold '=' (e.g. mail.local=10.0.0.5,txt:v=spf1 mx -all)
+				cfg.CustomDNS[previous] += mapping.ValueSeparator + entry
 				continue
 			}
-			parts := strings.SplitN(mapping, "=", 2)
+			parts := strings.SplitN(entry, "=", 2)
 			if len(parts) != 2 {
-				return nil, fmt.Errorf("invalid custom DNS mapping format: %s (expected domain=ip)", mapping)
+				// A further IP or the TTL of the previous mapping (e.g. web.local=10.0.0.1,10.0.0.2,ttl:60)
+				if _, isTTL := resolver.ParseTTLValue(entry); previous != "" && (net.ParseIP(entry) != nil || isTTL) {
+					cfg.CustomDNS[previous] += mapping.ValueSeparator + entry
+					continue
+				}
+				return nil, fmt.Errorf("invalid custom DNS mapping format: %s (expected domain=ip)", entry)
 			}
 			domain := strings.TrimSpace(parts[0])
 			ip := strings.TrimSpace(parts[1])
 			if domain == "" || ip == "" {
-				return nil, fmt.Errorf("invalid custom DNS mapping format: %s (domain and IP cannot be empty)", mapping)
-			}
-			// Ensure domain ends with a dot for DNS processing
-			if !strings.HasSuffix(domain, ".") {
-				domain += "."
+				return nil, fmt.Errorf("invalid custom DNS mapping format: %s (domain and IP cannot be empty)", entry)
 			}
+			// Ensure domain ends with a dot for DNS processing (regex keys are kept as written)
+			domain = mapping.NormalizeKey(domain)
+			cfg.CustomDNS[domain] = ip
+			previous = domain
+		}
+	}
+
+	cfg.flagMappings = make(map[string]string, len(cfg.CustomDNS))
+	for domain, ip := range cfg.CustomDNS {
+		cfg.flagMappings[domain] = ip
+	}
+
+	// Load hosts file mappings, overriding the command line
+	cfg.HostsFile = strings.TrimSpace(*hostsFile)
+	if cfg.HostsFile != "" {
+		mappings, err := loadHostsFile(cfg.HostsFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.hostsMappings = mappings
+		if cfg.CustomDNS == nil {
+			cfg.CustomDNS = make(map[string]string, len(mappings))
+		}
+		for domain, ip := range mappings {
 			cfg.CustomDNS[domain] = ip
 		}
 	}
 
 	// Load custom DNS mappings - try PostgreSQL first, then fall back to file
-	if err := cfg.loadCustomDNS(); err != nil {
-		return nil, fmt.Errorf("failed to load custom DNS configuration: %w", err)
+	if err := cfg.loadCustomDNSWithPolicy(); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.ResolveUpstreamAliases(); err != nil {
+		return nil, err
 	}
 
 	return cfg, cfg.Validate()
@@ -163,6 +692,46 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("timeout must be positive, got %v", c.Timeout)
 	}
 
+	if c.MaxMessageSize < 0 || c.MaxMessageSize > dns.MaxMsgSize {
+		return fmt.Errorf("max message size must be between 0 and %d, got %d", dns.MaxMsgSize, c.MaxMessageSize)
+	}
+
+	if c.Workers < 0 || c.WorkerQueueSize < 0 {
+		return fmt.Errorf("worker pool sizes must be non-negative, got workers=%d queue=%d", c.Workers, c.WorkerQueueSize)
+	}
+
+	if c.CustomDNSTTL < 0 || c.CustomDNSTTL > resolver.MaxTTL {
+		return fmt.Errorf("custom DNS TTL must be between 0 and %d seconds, got %d", resolver.MaxTTL, c.CustomDNSTTL)
+	}
+
+	if c.MaxCustomMappings < 0 {
+		return fmt.Errorf("max custom mappings must be non-negative, got %d", c.MaxCustomMappings)
+	}
+
+	if c.QueryRetries < 0 {
+		return fmt.Errorf("query retries must be non-negative, got %d", c.QueryRetries)
+	}
+
+	if c.QueryRetryDelay < 0 {
+		return fmt.Errorf("query retry delay must be non-negative, got %v", c.QueryRetryDelay)
+	}
+
+	if c.UpstreamStagger < 0 {
+		return fmt.Errorf("upstream stagger must be non-negative, got %v", c.UpstreamStagger)
+	}
+
+	if c.SlowQueryThreshold < 0 {
+		return fmt.Errorf("slow query threshold must be non-negative, got %v", c.SlowQueryThreshold)
+	}
+
+	if c.LogAttemptsWait < 0 {
+		return fmt.Errorf("log attempts wait must be non-negative, got %v", c.LogAttemptsWait)
+	}
+
+	if c.UDPTimeout < 0 || c.TCPTimeout < 0 {
+		return fmt.Errorf("listener timeouts must be non-negative, got udp=%v tcp=%v", c.UDPTimeout, c.TCPTimeout)
+	}
+
 	// Validate log level
 	validLogLevels := map[string]bool{
 		"debug": true,
@@ -174,10 +743,505 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("invalid log level %q, must be one of: debug, info, warn, error", c.LogLevel)
 	}
 
+	if c.LogMaxSize < 0 {
+		return fmt.Errorf("log max size must be non-negative, got %d", c.LogMaxSize)
+	}
+	if c.LogMaxBackups < 0 {
+		return fmt.Errorf("log max backups must be non-negative, got %d", c.LogMaxBackups)
+	}
+
+	switch c.LocalDomainPolicy {
+	case LocalDomainPolicyRefuse, LocalDomainPolicyNXDomain, LocalDomainPolicyForward:
+	default:
+		return fmt.Errorf("invalid local domain policy %q, must be one of: refuse, nxdomain, forward", c.LocalDomainPolicy)
+	}
+
+	switch c.PrivatePTRPolicy {
+	case LocalDomainPolicyRefuse, LocalDomainPolicyNXDomain, LocalDomainPolicyForward:
+	default:
+		return fmt.Errorf("invalid private PTR policy %q, must be one of: refuse, nxdomain, forward", c.PrivatePTRPolicy)
+	}
+
+	switch c.UpstreamStrategy {
+	case UpstreamStrategyConcurrent, UpstreamStrategySequential:
+	default:
+		return fmt.Errorf("invalid upstream strategy %q, must be one of: concurrent, sequential", c.UpstreamStrategy)
+	}
+
+	switch c.RecursionDesired {
+	case RecursionDesiredPreserve, RecursionDesiredOn, RecursionDesiredOff:
+	default:
+		return fmt.Errorf("invalid recursion desired mode %q, must be one of: preserve, on, off", c.RecursionDesired)
+	}
+
+	switch c.CustomDNSOnError {
+	case CustomDNSErrorPolicyFail, CustomDNSErrorPolicyWarn:
+	default:
+		return fmt.Errorf("invalid custom DNS error policy %q, must be one of: fail, warn", c.CustomDNSOnError)
+	}
+
+	switch c.QueryRedaction {
+	case QueryRedactionNone, QueryRedactionRegistrable, QueryRedactionHash, QueryRedactionDrop:
+	default:
+		return fmt.Errorf("invalid query redaction %q, must be one of: none, registrable, hash, drop", c.QueryRedaction)
+	}
+	if c.QueryRedaction == QueryRedactionHash && c.QueryRedactionKey == "" {
+		return fmt.Errorf("query redaction hash requires a query redaction key")
+	}
+
+	switch c.AnswerOrder {
+	case AnswerOrderNone, AnswerOrderIPv4First, AnswerOrderIPv6First:
+	default:
+		return fmt.Errorf("invalid answer order %q, must be one of: none, ipv4-first, ipv6-first", c.AnswerOrder)
+	}
+
+	switch c.MultiQuestionPolicy {
+	case MultiQuestionPolicyFormErr, MultiQuestionPolicyFirst:
+	default:
+		return fmt.Errorf("invalid multi-question policy %q, must be one of: formerr, first", c.MultiQuestionPolicy)
+	}
+
+	if c.MaxCNAMEChain < 0 {
+		return fmt.Errorf("max CNAME chain must be non-negative, got %d", c.MaxCNAMEChain)
+	}
+	switch c.CNAMEChainPolicy {
+	case CNAMEChainPolicyReject, CNAMEChainPolicyTruncate:
+	default:
+		return fmt.Errorf("invalid CNAME chain policy %q, must be one of: reject, truncate", c.CNAMEChainPolicy)
+	}
+
+	for _, zone := range c.CustomZones {
+		if _, ok := dns.IsDomainName(zone); !ok {
+			return fmt.Errorf("invalid custom zone %q", zone)
+		}
+	}
+	for _, ns := range c.ZoneNameservers {
+		if _, ok := dns.IsDomainName(ns); !ok {
+			return fmt.Errorf("invalid zone nameserver %q", ns)
+		}
+	}
+	if c.ZoneHostmaster != "" {
+		if _, ok := dns.IsDomainName(c.ZoneHostmaster); !ok {
+			return fmt.Errorf("invalid zone hostmaster %q", c.ZoneHostmaster)
+		}
+	}
+
+	for domain, value := range c.CustomDNS {
+		if mapping.IsPatternKey(domain) {
+			if _, err := resolver.CompilePattern(domain); err != nil {
+				return err
+			}
+		}
+		for _, v := range mapping.Values(value) {
+			if _, err := resolver.RecordValue(domain, v, 0); err != nil {
+				return fmt.Errorf("invalid custom DNS mapping for %s: %w", domain, err)
+			}
+		}
+	}
+	if err := resolver.CheckPatternLimit(c.CustomDNS); err != nil {
+		return err
+	}
+
+	for domain, action := range c.SpecialUseDomains {
+		switch action {
+		case SpecialUseLoopback, LocalDomainPolicyRefuse, LocalDomainPolicyNXDomain, LocalDomainPolicyForward:
+		default:
+			return fmt.Errorf("invalid special-use policy %q for %s, must be one of: loopback, refuse, nxdomain, forward", action, domain)
+		}
+	}
+
+	for upstream, timeout := range c.UpstreamTimeouts {
+		if timeout <= 0 {
+			return fmt.Errorf("timeout for upstream %s must be positive, got %v", upstream, timeout)
+		}
+		if !c.hasUpstream(upstream) {
+			return fmt.Errorf("timeout configured for unknown upstream %s", upstream)
+		}
+	}
+
+	for zone, servers := range c.StubZones {
+		if _, ok := dns.IsDomainName(zone); !ok {
+			return fmt.Errorf("invalid stub zone %q", zone)
+		}
+		if len(servers) == 0 {
+			return fmt.Errorf("no authoritative servers for stub zone %s", zone)
+		}
+	}
+	for zone, servers := range c.UpstreamRoutes {
+		if _, ok := dns.IsDomainName(zone); !ok || zone == "." {
+			return fmt.Errorf("invalid upstream route domain %q", zone)
+		}
+		if len(servers) == 0 {
+			return fmt.Errorf("no upstream servers for route %s", zone)
+		}
+		if _, ok := c.StubZones[zone]; ok {
+			return fmt.Errorf("%s is both a stub zone and an upstream route", zone)
+		}
+	}
+
+	for subnet, qtypes := range c.ClientQueryTypes {
+		if _, err := ParseClientSubnet(subnet); err != nil {
+			return err
+		}
+		if len(qtypes) == 0 {
+			return fmt.Errorf("no query types allowed for client subnet %s", subnet)
+		}
+		for _, qtype := range qtypes {
+			if _, ok := dns.StringToType[qtype]; !ok {
+				return fmt.Errorf("invalid query type %q for client subnet %s", qtype, subnet)
+			}
+		}
+	}
+
+	if c.HealthProbeName != "" {
+		if _, ok := dns.IsDomainName(c.HealthProbeName); !ok {
+			return fmt.Errorf("invalid health probe name %q", c.HealthProbeName)
+		}
+	}
+
+	for _, name := range c.SelfHostnames {
+		if _, ok := dns.IsDomainName(name); !ok {
+			return fmt.Errorf("invalid self hostname %q", name)
+		}
+	}
+
+	for _, addr := range c.BlocklistSinkhole {
+		if net.ParseIP(addr) == nil {
+			return fmt.Errorf("invalid blocklist sinkhole address %q", addr)
+		}
+	}
+
+	for _, rule := range c.RewriteRules {
+		if err := rule.Validate(); err != nil {
+			return err
+		}
+	}
+
+	for _, domain := range c.CanaryDomains {
+		if _, ok := dns.IsDomainName(domain); !ok || domain == "." {
+			return fmt.Errorf("invalid canary domain %q", domain)
+		}
+	}
+	for _, addr := range c.CanarySinkhole {
+		if net.ParseIP(addr) == nil {
+			return fmt.Errorf("invalid canary sinkhole address %q", addr)
+		}
+	}
+	if c.CanaryWebhook != "" && !isWebhookURL(c.CanaryWebhook) {
+		return fmt.Errorf("invalid canary webhook URL %q, must be an http or https URL", c.CanaryWebhook)
+	}
+
+	if c.AlertWebhook != "" && !isWebhookURL(c.AlertWebhook) {
+		return fmt.Errorf("invalid alert webhook URL %q, must be an http or https URL", c.AlertWebhook)
+	}
+	if c.AlertInterval < 0 {
+		return fmt.Errorf("alert interval cannot be negative, got %v", c.AlertInterval)
+	}
+	if c.AlertFailureRate < 0 || c.AlertFailureRate > 1 {
+		return fmt.Errorf("alert failure rate must be between 0 and 1, got %v", c.AlertFailureRate)
+	}
+	if c.AlertFailureRate > 0 && c.AlertWindow <= 0 {
+		return fmt.Errorf("alert window must be positive, got %v", c.AlertWindow)
+	}
+
+	if c.SelfAddress != "" && net.ParseIP(c.SelfAddress) == nil {
+		return fmt.Errorf("invalid self address %q", c.SelfAddress)
+	}
+
+	if c.HealthProbeLatency < 0 {
+		return fmt.Errorf("health probe latency must be non-negative, got %v", c.HealthProbeLatency)
+	}
+
+	if c.PaddingBlockSize < 0 || c.PaddingBlockSize > dns.MaxMsgSize {
+		return fmt.Errorf("padding block size must be between 0 and %d, got %d", dns.MaxMsgSize, c.PaddingBlockSize)
+	}
+
+	if c.DoHPoolSize <= 0 {
+		return fmt.Errorf("DoH pool size must be positive, got %d", c.DoHPoolSize)
+	}
+
+	if c.ShutdownTimeout <= 0 {
+		return fmt.Errorf("shutdown timeout must be positive, got %v", c.ShutdownTimeout)
+	}
+
+	if c.ForceExitTimeout <= 0 {
+		return fmt.Errorf("force exit timeout must be positive, got %v", c.ForceExitTimeout)
+	}
+
+	if c.UpstreamFailureThreshold <= 0 {
+		return fmt.Errorf("upstream failure threshold must be positive, got %d", c.UpstreamFailureThreshold)
+	}
+
+	if c.UpstreamRecoveryTimeout < 0 {
+		return fmt.Errorf("upstream recovery timeout must be non-negative, got %v", c.UpstreamRecoveryTimeout)
+	}
+
 	return nil
 }
 
-// loadCustomDNS loads custom DNS mappings from PostgreSQL (if available) or from file
+// parseDomainList splits a comma-separated list of domains and normalizes each
+// entry to a lower-case fully qualified name
+func parseDomainList(list string) []string {
+	domains := make([]string, 0)
+	for _, domain := range strings.Split(list, ",") {
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		if domain == "" {
+			continue
+		}
+		// Ensure domain ends with a dot for DNS processing
+		if !strings.HasSuffix(domain, ".") {
+			domain += "."
+		}
+		domains = append(domains, domain)
+	}
+	return domains
+}
+
+// parseList splits a comma-separated list, trimming entries and dropping empty ones
+func parseList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseZoneServers parses a comma-separated list of zone=server1|server2
+// entries, lowercasing the zones. An empty list yields nil.
+func parseZoneServers(list, kind string) (ZoneServers, error) {
+	if strings.TrimSpace(list) == "" {
+		return nil, nil
+	}
+
+	zones := make(ZoneServers)
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("invalid %s format: %s (expected zone=server1|server2)", kind, entry)
+		}
+		var servers []string
+		for _, server := range strings.Split(parts[1], "|") {
+			if server = strings.TrimSpace(server); server != "" {
+				servers = append(servers, server)
+			}
+		}
+		zone := strings.ToLower(dns.Fqdn(strings.TrimSpace(parts[0])))
+		zones[zone] = servers
+	}
+	return zones, nil
+}
+
+// isWebhookURL reports whether rawURL is an absolute http or https URL
+func isWebhookURL(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// loadAllowlistFile reads a domain allowlist file with one domain per line.
+// Blank lines and lines starting with # are ignored. The returned slice is
+// never nil, so an empty file still enables allowlist mode.
+func loadAllowlistFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open allowlist file %s: %w", path, err)
+	}
+	defer file.Close()
+
+	domains := make([]string, 0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		domains = append(domains, parseDomainList(line)...)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read allowlist file %s: %w", path, err)
+	}
+
+	return domains, nil
+}
+
+// ResolveUpstreamAliases replaces upstream aliases in UpstreamDNS, the servers
+// of StubZones and UpstreamRoutes and the keys of UpstreamTimeouts with the upstreams they stand
+// for, keeping any priority suffix of the reference. Names that can only be an
+// alias but are not defined are an error.
+func (c *Config) ResolveUpstreamAliases() error {
+	for alias, upstream := range c.UpstreamAliases {
+		if !isAliasName(alias) {
+			return fmt.Errorf("invalid upstream alias name %q (letters, digits, '-' and '_', starting with a letter)", alias)
+		}
+		if upstream == "" {
+			return fmt.Errorf("upstream alias %s has no upstream", alias)
+		}
+	}
+
+	for i, upstream := range c.UpstreamDNS {
+		resolved, err := c.resolveUpstreamAlias(upstream)
+		if err != nil {
+			return err
+		}
+		c.UpstreamDNS[i] = resolved
+	}
+
+	for _, zones := range []ZoneServers{c.StubZones, c.UpstreamRoutes} {
+		for _, servers := range zones {
+			for i, server := range servers {
+				resolved, err := c.resolveUpstreamAlias(server)
+				if err != nil {
+					return err
+				}
+				servers[i] = resolved
+			}
+		}
+	}
+
+	if len(c.UpstreamTimeouts) > 0 {
+		timeouts := make(ServerTimeouts, len(c.UpstreamTimeouts))
+		for upstream, timeout := range c.UpstreamTimeouts {
+			resolved, err := c.resolveUpstreamAlias(upstream)
+			if err != nil {
+				return err
+			}
+			timeouts[resolved] = timeout
+		}
+		c.UpstreamTimeouts = timeouts
+	}
+
+	return nil
+}
+
+// resolveUpstreamAlias returns the upstream an alias stands for, or upstream
+// itself when it is not an alias
+func (c *Config) resolveUpstreamAlias(upstream string) (string, error) {
+	name, priority, hasPriority := strings.Cut(upstream, "#")
+
+	resolved, ok := c.UpstreamAliases[name]
+	if !ok {
+		if isAliasName(name) {
+			return "", fmt.Errorf("unknown upstream alias %q", name)
+		}
+		return upstream, nil
+	}
+
+	if hasPriority {
+		resolved, _, _ = strings.Cut(resolved, "#")
+		resolved += "#" + priority
+	}
+	return resolved, nil
+}
+
+// isAliasName reports whether name has the form of an upstream alias: a word
+// that cannot be mistaken for an address, since it has no dots, colons or
+// scheme and starts with a letter
+func isAliasName(name string) bool {
+	for i, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+		case i > 0 && (r >= '0' && r <= '9' || r == '-' || r == '_'):
+		default:
+			return false
+		}
+	}
+	return name != ""
+}
+
+// hasUpstream reports whether addr is one of the configured upstream servers,
+// ignoring any priority suffix
+func (c *Config) hasUpstream(addr string) bool {
+	addr, _, _ = strings.Cut(addr, "#")
+	for _, upstream := range c.UpstreamDNS {
+		if upstream, _, _ = strings.Cut(upstream, "#"); upstream == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseClientSubnet parses a client subnet in CIDR notation; a bare IP address
+// is treated as a single-host subnet
+func ParseClientSubnet(subnet string) (netip.Prefix, error) {
+	if addr, err := netip.ParseAddr(subnet); err == nil {
+		return netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()), nil
+	}
+	prefix, err := netip.ParsePrefix(subnet)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid client subnet %q", subnet)
+	}
+	return prefix.Masked(), nil
+}
+
+// AllowlistEnabled reports whether only allowlisted domains may be resolved
+func (c *Config) AllowlistEnabled() bool {
+	return c.AllowedDomains != nil
+}
+
+// loadHostsFile reads custom DNS mappings from a file in hosts file format: an
+// IP address followed by one or more domains per line, with # starting a
+// comment. Domains listed on several lines are mapped to all their addresses,
+// in file order.
+func loadHostsFile(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open hosts file %s: %w", path, err)
+	}
+	defer file.Close()
+
+	mappings := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 || net.ParseIP(fields[0]) == nil {
+			return nil, fmt.Errorf("invalid hosts file line %d in %s: %q (expected IP domain [domain...])", lineNumber, path, strings.TrimSpace(line))
+		}
+
+		ip := fields[0]
+		for _, domain := range fields[1:] {
+			domain = mapping.NormalizeKey(domain)
+			existing := mapping.Values(mappings[domain])
+			if slices.Contains(existing, ip) {
+				continue
+			}
+			mappings[domain] = strings.Join(append(existing, ip), mapping.ValueSeparator)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read hosts file %s: %w", path, err)
+	}
+
+	return mappings, nil
+}
+
+// withBaseMappings returns mappings on top of copies of the command line and
+// hosts file mappings, so the latter survive reloads of the other sources
+func (c *Config) withBaseMappings(hosts, mappings map[string]string) map[string]string {
+	merged := make(map[string]string, len(c.flagMappings)+len(hosts)+len(mappings))
+	for domain, ip := range c.flagMappings {
+		merged[domain] = ip
+	}
+	for domain, ip := range hosts {
+		merged[domain] = ip
+	}
+	for domain, ip := range mappings {
+		merged[domain] = ip
+	}
+	return merged
+}
+
+// loadCustomDNS loads custom DNS mappings from PostgreSQL (if available) or from
+// file. The mappings are only applied once they are within the limits; on error
+// the existing mappings are left as they were.
 func (c *Config) loadCustomDNS() error {
 	// Initialize CustomDNS map if it doesn't exist
 	if c.CustomDNS == nil {
@@ -213,11 +1277,7 @@ func (c *Config) loadCustomDNS() error {
 			// Load mappings from PostgreSQL
 			if mappings, err := pgClient.GetAllDNSMappings(); err == nil {
 				// PostgreSQL mappings take precedence over command line
-				for domain, ip := range mappings {
-					c.CustomDNS[domain] = ip
-				}
-				// Successfully loaded from PostgreSQL, return
-				return nil
+				return c.applyLoadedMappings(mappings)
 			} else {
 				// Failed to load from PostgreSQL, fall back to file
 				fmt.Printf("Warning: Failed to load DNS mappings from PostgreSQL: %v\n", err)
@@ -226,11 +1286,70 @@ func (c *Config) loadCustomDNS() error {
 	}
 
 	// Fall back to loading from file if PostgreSQL is not available or failed
-	return c.loadCustomDNSFromFile()
+	fileMappings, err := c.loadCustomDNSFromFile()
+	if err != nil {
+		return err
+	}
+	// Config file mappings take precedence over command line (but not PostgreSQL)
+	return c.applyLoadedMappings(fileMappings)
 }
 
-// loadCustomDNSFromFile loads custom DNS mappings from the configuration file if it exists
-func (c *Config) loadCustomDNSFromFile() error {
+// applyLoadedMappings merges loaded mappings over the existing ones and applies
+// the result only if it is within the mapping limits
+func (c *Config) applyLoadedMappings(loaded map[string]string) error {
+	merged := make(map[string]string, len(c.CustomDNS)+len(loaded))
+	for domain, ip := range c.CustomDNS {
+		merged[domain] = ip
+	}
+	for domain, ip := range loaded {
+		merged[domain] = ip
+	}
+	if err := c.checkMappings(merged); err != nil {
+		return err
+	}
+	c.CustomDNS = merged
+	return nil
+}
+
+// loadCustomDNSWithPolicy loads custom DNS mappings and applies the custom DNS
+// error policy: fail returns the error, warn logs it and keeps the mappings
+// loaded so far (e.g. from the command line)
+func (c *Config) loadCustomDNSWithPolicy() error {
+	err := c.loadCustomDNS()
+	if err == nil {
+		return nil
+	}
+
+	if c.CustomDNSOnError != CustomDNSErrorPolicyWarn {
+		fmt.Printf("Error: Failed to load custom DNS configuration: %v\n", err)
+		return fmt.Errorf("failed to load custom DNS configuration: %w", err)
+	}
+
+	fmt.Printf("Warning: Failed to load custom DNS configuration, continuing with %d existing mappings: %v\n", len(c.CustomDNS), err)
+	return nil
+}
+
+// CheckMappingLimit returns an error if count custom DNS mappings exceed the
+// configured maximum
+func (c *Config) CheckMappingLimit(count int) error {
+	if c.MaxCustomMappings > 0 && count > c.MaxCustomMappings {
+		return fmt.Errorf("%d custom DNS mappings exceed the limit of %d", count, c.MaxCustomMappings)
+	}
+	return nil
+}
+
+// checkMappings returns an error if mappings exceed the configured maximum or
+// hold more regex mappings than are evaluated per query
+func (c *Config) checkMappings(mappings map[string]string) error {
+	if err := c.CheckMappingLimit(len(mappings)); err != nil {
+		return err
+	}
+	return resolver.CheckPatternLimit(mappings)
+}
+
+// loadCustomDNSFromFile reads custom DNS mappings from the configuration file if
+// it exists
+func (c *Config) loadCustomDNSFromFile() (map[string]string, error) {
 	// Get the path to the custom DNS configuration file
 	configPath := customDNSConfigFile
 
@@ -250,10 +1369,10 @@ func (c *Config) loadCustomDNSFromFile() error {
 	fileInfo, err := os.Stat(configPath)
 	if os.IsNotExist(err) {
 		// File doesn't exist, which is fine - custom DNS feature is disabled
-		return nil
+		return nil, nil
 	}
 	if err != nil {
-		return fmt.Errorf("failed to stat custom DNS config file %s: %w", configPath, err)
+		return nil, fmt.Errorf("failed to stat custom DNS config file %s: %w", configPath, err)
 	}
 
 	// Store modification time for hot reload tracking
@@ -262,34 +1381,57 @@ func (c *Config) loadCustomDNSFromFile() error {
 	// Read the configuration file
 	data, err := os.ReadFile(configPath)
 	if err != nil {
-		return fmt.Errorf("failed to read custom DNS config file %s: %w", configPath, err)
+		return nil, fmt.Errorf("failed to read custom DNS config file %s: %w", configPath, err)
 	}
 
 	// Parse the JSON configuration
 	var customDNSConfig CustomDNSConfig
 	if err := json.Unmarshal(data, &customDNSConfig); err != nil {
-		return fmt.Errorf("failed to parse custom DNS config file %s: %w", configPath, err)
+		return nil, fmt.Errorf("failed to parse custom DNS config file %s: %w", configPath, err)
 	}
 
-	// Process and normalize the mappings from the config file
-	for domain, ip := range customDNSConfig.Mappings {
+	// Process and normalize the mappings from the config file, applying them only
+	// once the whole file is valid
+	fileMappings := make(map[string]string, len(customDNSConfig.Mappings))
+	for domain, value := range customDNSConfig.Mappings {
 		domain = strings.TrimSpace(domain)
-		ip = strings.TrimSpace(ip)
+		ip := strings.TrimSpace(string(value))
 
 		if domain == "" || ip == "" {
-			return fmt.Errorf("invalid custom DNS mapping in config file: empty domain or IP")
+			return nil, fmt.Errorf("invalid custom DNS mapping in config file: empty domain or IP")
 		}
 
-		// Ensure domain ends with a dot for DNS processing
-		if !strings.HasSuffix(domain, ".") {
-			domain += "."
-		}
+		// Ensure domain ends with a dot for DNS processing (regex keys are kept as written)
+		domain = mapping.NormalizeKey(domain)
+		fileMappings[domain] = mapping.NormalizeValue(ip)
+	}
+
+	return fileMappings, nil
+}
 
-		// Config file mappings take precedence over command line (but not PostgreSQL)
-		c.CustomDNS[domain] = ip
+// ListenerTimeout returns the overall resolution budget for queries received on
+// the given listener network ("udp" or "tcp"), falling back to Timeout
+func (c *Config) ListenerTimeout(network string) time.Duration {
+	var timeout time.Duration
+	switch network {
+	case "udp":
+		timeout = c.UDPTimeout
+	case "tcp":
+		timeout = c.TCPTimeout
+	}
+	if timeout <= 0 {
+		return c.Timeout
 	}
+	return timeout
+}
 
-	return nil
+// WorkerQueueLength returns how many queries may wait for a worker, falling back
+// to MaxConcurrent
+func (c *Config) WorkerQueueLength() int {
+	if c.WorkerQueueSize <= 0 {
+		return c.MaxConcurrent
+	}
+	return c.WorkerQueueSize
 }
 
 // String returns a string representation of the configuration (excluding sensitive data).
@@ -329,6 +1471,66 @@ func (c *Config) ReloadCustomDNS() (map[string]string, error) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
+	mappings, modTime, err := c.readCustomDNS(c.hostsMappings)
+	c.recordCustomDNSModTime(mappings, modTime)
+	if err != nil || mappings == nil {
+		return nil, err
+	}
+	c.CustomDNS = mappings
+	return mappings, nil
+}
+
+// ReloadMappings re-reads HostsFile and the custom DNS configuration, and
+// applies them together once both are read and within the limits. On error
+// the running mappings are left as they were. It returns nil mappings when
+// there is nothing to reload.
+func (c *Config) ReloadMappings() (map[string]string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	hosts := c.hostsMappings
+	if c.HostsFile != "" {
+		var err error
+		if hosts, err = loadHostsFile(c.HostsFile); err != nil {
+			return nil, err
+		}
+	}
+
+	mappings, modTime, err := c.readCustomDNS(hosts)
+	c.recordCustomDNSModTime(mappings, modTime)
+	if err != nil {
+		return nil, err
+	}
+	if mappings == nil {
+		if c.HostsFile == "" {
+			return nil, nil
+		}
+		// Without a custom DNS source, only the hosts file changes
+		mappings = c.withBaseMappings(hosts, nil)
+		if err := c.checkMappings(mappings); err != nil {
+			return nil, err
+		}
+	}
+
+	c.hostsMappings = hosts
+	c.CustomDNS = mappings
+	return mappings, nil
+}
+
+// recordCustomDNSModTime remembers the version of the custom DNS file that was
+// read, even when it is malformed, so it is only read again once it changes
+func (c *Config) recordCustomDNSModTime(mappings map[string]string, modTime time.Time) {
+	if mappings != nil || !modTime.IsZero() {
+		c.customDNSModTime = modTime
+	}
+}
+
+// readCustomDNS reads the custom DNS configuration from PostgreSQL (if
+// available) or from file, on top of the command line and hosts mappings, and
+// returns the mappings with the modification time of the file, which is also
+// returned when the file cannot be parsed. The mappings are nil when there is
+// no configuration to read. Nothing is applied.
+func (c *Config) readCustomDNS(hosts map[string]string) (map[string]string, time.Time, error) {
 	// Try PostgreSQL first if available
 	pgHost := os.Getenv("POSTGRES_HOST")
 	pgPort := os.Getenv("POSTGRES_PORT")
@@ -349,9 +1551,11 @@ func (c *Config) ReloadCustomDNS() (map[string]string, error) {
 			defer pgClient.Close()
 
 			if mappings, err := pgClient.GetAllDNSMappings(); err == nil {
-				// Update the config's custom DNS mappings
-				c.CustomDNS = mappings
-				return mappings, nil
+				mappings = c.withBaseMappings(hosts, mappings)
+				if err := c.checkMappings(mappings); err != nil {
+					return nil, time.Time{}, err
+				}
+				return mappings, c.customDNSModTime, nil
 			}
 		}
 	}
@@ -359,60 +1563,53 @@ func (c *Config) ReloadCustomDNS() (map[string]string, error) {
 	// Fall back to file reload
 	// If no path is stored, nothing to reload
 	if c.customDNSPath == "" {
-		return nil, nil
+		return nil, time.Time{}, nil
 	}
 
 	// Check if file exists
 	fileInfo, err := os.Stat(c.customDNSPath)
 	if os.IsNotExist(err) {
 		// File was deleted - clear mappings
-		c.customDNSModTime = time.Time{}
-		c.CustomDNS = make(map[string]string)
-		return c.CustomDNS, nil
+		return c.withBaseMappings(hosts, nil), time.Time{}, nil
 	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to stat custom DNS config file: %w", err)
+		return nil, time.Time{}, fmt.Errorf("failed to stat custom DNS config file: %w", err)
 	}
 
-	// Update modification time
-	c.customDNSModTime = fileInfo.ModTime()
-
 	// Read the configuration file
 	data, err := os.ReadFile(c.customDNSPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read custom DNS config file: %w", err)
+		return nil, fileInfo.ModTime(), fmt.Errorf("failed to read custom DNS config file: %w", err)
 	}
 
 	// Parse the JSON configuration
 	var customDNSConfig CustomDNSConfig
 	if err := json.Unmarshal(data, &customDNSConfig); err != nil {
-		return nil, fmt.Errorf("failed to parse custom DNS config file: %w", err)
+		return nil, fileInfo.ModTime(), fmt.Errorf("failed to parse custom DNS config file: %w", err)
 	}
 
 	// Create new mappings
-	newMappings := make(map[string]string)
+	newMappings := c.withBaseMappings(hosts, nil)
 
 	// Process and normalize the mappings from the config file
-	for domain, ip := range customDNSConfig.Mappings {
+	for domain, value := range customDNSConfig.Mappings {
 		domain = strings.TrimSpace(domain)
-		ip = strings.TrimSpace(ip)
+		ip := strings.TrimSpace(string(value))
 
 		if domain == "" || ip == "" {
-			return nil, fmt.Errorf("invalid custom DNS mapping in config file: empty domain or IP")
+			return nil, fileInfo.ModTime(), fmt.Errorf("invalid custom DNS mapping in config file: empty domain or IP")
 		}
 
-		// Ensure domain ends with a dot for DNS processing
-		if !strings.HasSuffix(domain, ".") {
-			domain += "."
-		}
-
-		newMappings[domain] = ip
+		// Ensure domain ends with a dot for DNS processing (regex keys are kept as written)
+		domain = mapping.NormalizeKey(domain)
+		newMappings[domain] = mapping.NormalizeValue(ip)
 	}
 
-	// Update the config's custom DNS mappings
-	c.CustomDNS = newMappings
+	if err := c.checkMappings(newMappings); err != nil {
+		return nil, fileInfo.ModTime(), err
+	}
 
-	return newMappings, nil
+	return newMappings, fileInfo.ModTime(), nil
 }
 
 // GetCustomDNS returns a thread-safe copy of the current custom DNS mappings
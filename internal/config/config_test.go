@@ -2,9 +2,14 @@ package config
 
 import (
 	"flag"
+	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
+
+	"dns-go/internal/resolver"
 )
 
 func TestDefaultConfig(t *testing.T) {
@@ -118,6 +123,65 @@ func TestConfig_Validate(t *testing.T) {
 			wantErr: true,
 			errMsg:  "invalid log level",
 		},
+		{
+			name: "invalid local domain policy",
+			config: &Config{
+				Port:              "53",
+				UpstreamDNS:       []string{"8.8.8.8:53"},
+				MaxConcurrent:     100,
+				RetryAttempts:     3,
+				Timeout:           5 * time.Second,
+				LogLevel:          "info",
+				LocalDomainPolicy: "drop",
+			},
+			wantErr: true,
+			errMsg:  "invalid local domain policy",
+		},
+		{
+			name: "invalid regex mapping",
+			config: &Config{
+				Port:                "53",
+				UpstreamDNS:         []string{"8.8.8.8:53"},
+				MaxConcurrent:       100,
+				RetryAttempts:       3,
+				Timeout:             5 * time.Second,
+				LogLevel:            "info",
+				QueryRedaction:      QueryRedactionNone,
+				LocalDomainPolicy:   LocalDomainPolicyRefuse,
+				PrivatePTRPolicy:    LocalDomainPolicyForward,
+				AnswerOrder:         AnswerOrderNone,
+				CustomDNSOnError:    CustomDNSErrorPolicyFail,
+				UpstreamStrategy:    UpstreamStrategyConcurrent,
+				RecursionDesired:    RecursionDesiredPreserve,
+				MultiQuestionPolicy: MultiQuestionPolicyFormErr,
+				CNAMEChainPolicy:    CNAMEChainPolicyReject,
+				CustomDNS:           map[string]string{`re:^db-(\d+$`: "10.0.0.1"},
+			},
+			wantErr: true,
+			errMsg:  "invalid regex in mapping",
+		},
+		{
+			name: "hash redaction without key",
+			config: &Config{
+				Port:                "53",
+				UpstreamDNS:         []string{"8.8.8.8:53"},
+				MaxConcurrent:       100,
+				RetryAttempts:       3,
+				Timeout:             5 * time.Second,
+				LogLevel:            "info",
+				QueryRedaction:      QueryRedactionHash,
+				LocalDomainPolicy:   LocalDomainPolicyRefuse,
+				PrivatePTRPolicy:    LocalDomainPolicyForward,
+				AnswerOrder:         AnswerOrderNone,
+				CustomDNSOnError:    CustomDNSErrorPolicyFail,
+				UpstreamStrategy:    UpstreamStrategyConcurrent,
+				RecursionDesired:    RecursionDesiredPreserve,
+				MultiQuestionPolicy: MultiQuestionPolicyFormErr,
+				CNAMEChainPolicy:    CNAMEChainPolicyReject,
+			},
+			wantErr: true,
+			errMsg:  "requires a query redaction key",
+		},
 	}
 
 	for _, tt := range tests {
@@ -282,3 +346,580 @@ func containsSubstring(s, substr string) bool {
 	}
 	return false
 }
+
+func TestConfig_ListenerTimeout(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Timeout = 5 * time.Second
+	cfg.TCPTimeout = 8 * time.Second
+
+	if got := cfg.ListenerTimeout("tcp"); got != 8*time.Second {
+		t.Errorf("Expected TCP timeout 8s, got %v", got)
+	}
+	if got := cfg.ListenerTimeout("udp"); got != 5*time.Second {
+		t.Errorf("Expected UDP timeout to fall back to 5s, got %v", got)
+	}
+}
+
+func TestLoadAllowlistFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "allowlist.txt")
+	content := "# Appliance allowlist\nExample.com\n\n  updates.vendor.net.  \n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write allowlist: %v", err)
+	}
+
+	domains, err := loadAllowlistFile(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	expected := []string{"example.com.", "updates.vendor.net."}
+	if len(domains) != len(expected) {
+		t.Fatalf("Expected %d domains, got %v", len(expected), domains)
+	}
+	for i, domain := range expected {
+		if domains[i] != domain {
+			t.Errorf("Expected domain[%d] = %s, got %s", i, domain, domains[i])
+		}
+	}
+
+	if _, err := loadAllowlistFile(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Error("Expected error for missing allowlist file")
+	}
+}
+
+func TestLoadHostsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hosts")
+	content := `# LAN hosts
+192.168.0.30   server.local server   # NAS
+192.168.0.31	web.local
+
+fd00::30 server.local
+192.168.0.30 server.local
+`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write hosts file: %v", err)
+	}
+
+	mappings, err := loadHostsFile(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	expected := map[string]string{
+		"server.local.": "192.168.0.30,fd00::30",
+		"server.":       "192.168.0.30",
+		"web.local.":    "192.168.0.31",
+	}
+	if len(mappings) != len(expected) {
+		t.Fatalf("Expected %d mappings, got %v", len(expected), mappings)
+	}
+	for domain, ip := range expected {
+		if mappings[domain] != ip {
+			t.Errorf("Expected %s=%s, got %q", domain, ip, mappings[domain])
+		}
+	}
+
+	for _, bad := range []string{"server.local 192.168.0.30\n", "192.168.0.30\n"} {
+		path := filepath.Join(t.TempDir(), "hosts")
+		if err := os.WriteFile(path, []byte(bad), 0644); err != nil {
+			t.Fatalf("Failed to write hosts file: %v", err)
+		}
+		if _, err := loadHostsFile(path); err == nil {
+			t.Errorf("Expected error for hosts file %q", bad)
+		}
+	}
+}
+
+func TestReloadCustomDNS_KeepsHostsMappings(t *testing.T) {
+	for _, env := range []string{"POSTGRES_HOST", "POSTGRES_PORT", "POSTGRES_DB"} {
+		t.Setenv(env, "")
+	}
+
+	path := filepath.Join(t.TempDir(), "custom-dns.json")
+	data := `{"mappings": {"server.local": "10.0.0.1"}}`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatalf("Failed to write mappings file: %v", err)
+	}
+
+	cfg := DefaultConfig()
+	cfg.hostsMappings = map[string]string{"server.local.": "192.168.0.30", "nas.local.": "192.168.0.50"}
+	cfg.customDNSPath = path
+
+	mappings, err := cfg.ReloadCustomDNS()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// custom-dns.json takes precedence over the hosts file
+	if mappings["server.local."] != "10.0.0.1" || mappings["nas.local."] != "192.168.0.50" {
+		t.Errorf("Expected JSON mappings on top of hosts file mappings, got %v", mappings)
+	}
+}
+
+func TestReloadCustomDNS_NormalizesValues(t *testing.T) {
+	for _, env := range []string{"POSTGRES_HOST", "POSTGRES_PORT", "POSTGRES_DB"} {
+		t.Setenv(env, "")
+	}
+
+	path := filepath.Join(t.TempDir(), "custom-dns.json")
+	data := `{"mappings": {"web.local": " 10.0.0.1 , 10.0.0.2,"}}`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatalf("Failed to write mappings file: %v", err)
+	}
+
+	cfg := DefaultConfig()
+	cfg.customDNSPath = path
+
+	// Values are stored as at startup, so reloading an unchanged file reports
+	// no changed mappings
+	mappings, err := cfg.ReloadCustomDNS()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if mappings["web.local."] != "10.0.0.1,10.0.0.2" {
+		t.Errorf("Expected normalized value 10.0.0.1,10.0.0.2, got %q", mappings["web.local."])
+	}
+}
+
+func TestReloadCustomDNS_TypedRecords(t *testing.T) {
+	for _, env := range []string{"POSTGRES_HOST", "POSTGRES_PORT", "POSTGRES_DB"} {
+		t.Setenv(env, "")
+	}
+
+	path := filepath.Join(t.TempDir(), "custom-dns.json")
+	data := `{"mappings": {
+		"mail.local": ["192.168.0.25", {"type": "TXT", "value": "v=spf1 mx -all"}],
+		"local": {"type": "MX", "value": "10 mail.local"}
+	}}`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatalf("Failed to write mappings file: %v", err)
+	}
+
+	cfg := DefaultConfig()
+	cfg.customDNSPath = path
+
+	mappings, err := cfg.ReloadCustomDNS()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if mappings["mail.local."] != "192.168.0.25,txt:v=spf1 mx -all" || mappings["local."] != "mx:10 mail.local" {
+		t.Errorf("Expected typed records in value string form, got %v", mappings)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected typed records to be valid, got %v", err)
+	}
+
+	cfg.CustomDNS["srv.local."] = "srv:10 5 sip.local"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected an error for a malformed SRV value")
+	}
+}
+
+func TestReloadCustomDNS_MappingLimit(t *testing.T) {
+	for _, env := range []string{"POSTGRES_HOST", "POSTGRES_PORT", "POSTGRES_DB"} {
+		t.Setenv(env, "")
+	}
+
+	path := filepath.Join(t.TempDir(), "custom-dns.json")
+	data := `{"mappings": {"a.local": "10.0.0.1", "b.local": "10.0.0.2", "c.local": "10.0.0.3"}}`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatalf("Failed to write mappings file: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		limit   int
+		wantErr bool
+	}{
+		{name: "below limit", limit: 5},
+		{name: "at limit", limit: 3},
+		{name: "no limit", limit: 0},
+		{name: "over limit", limit: 2, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.MaxCustomMappings = tt.limit
+			cfg.CustomDNS = map[string]string{"old.local.": "10.0.0.9"}
+			cfg.customDNSPath = path
+
+			mappings, err := cfg.ReloadCustomDNS()
+			if tt.wantErr {
+				if err == nil || !containsString(err.Error(), "exceed the limit") {
+					t.Fatalf("Expected mapping limit error, got %v", err)
+				}
+				if _, kept := cfg.CustomDNS["old.local."]; !kept {
+					t.Errorf("Expected previous mappings to be kept after a rejected reload")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if len(mappings) != 3 {
+				t.Errorf("Expected 3 mappings, got %d", len(mappings))
+			}
+		})
+	}
+}
+
+func TestReloadCustomDNS_PatternLimit(t *testing.T) {
+	for _, env := range []string{"POSTGRES_HOST", "POSTGRES_PORT", "POSTGRES_DB"} {
+		t.Setenv(env, "")
+	}
+
+	patterns := make([]string, 0, resolver.MaxPatternMappings+1)
+	for i := 0; i <= resolver.MaxPatternMappings; i++ {
+		patterns = append(patterns, fmt.Sprintf(`"re:^host-%d\\.local\\.$": "10.0.0.%d"`, i, i%250+1))
+	}
+	path := filepath.Join(t.TempDir(), "custom-dns.json")
+	data := `{"mappings": {` + strings.Join(patterns, ", ") + `}}`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatalf("Failed to write mappings file: %v", err)
+	}
+
+	cfg := DefaultConfig()
+	cfg.MaxCustomMappings = 0
+	cfg.CustomDNS = map[string]string{"old.local.": "10.0.0.9"}
+	cfg.customDNSPath = path
+
+	if _, err := cfg.ReloadCustomDNS(); err == nil || !containsString(err.Error(), "regex DNS mappings exceed the limit") {
+		t.Fatalf("Expected regex mapping limit error, got %v", err)
+	}
+	if _, kept := cfg.CustomDNS["old.local."]; !kept {
+		t.Errorf("Expected previous mappings to be kept after a rejected reload")
+	}
+}
+
+func TestConfig_ValidatePatternLimit(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.CustomDNS = make(map[string]string)
+	for i := 0; i < resolver.MaxPatternMappings; i++ {
+		cfg.CustomDNS[fmt.Sprintf(`re:^host-%d\.local\.$`, i)] = "10.0.0.1"
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Expected %d regex mappings to be valid, got %v", resolver.MaxPatternMappings, err)
+	}
+
+	cfg.CustomDNS[`re:^one-too-many\.local\.$`] = "10.0.0.1"
+	if err := cfg.Validate(); err == nil || !containsString(err.Error(), "regex DNS mappings exceed the limit") {
+		t.Errorf("Expected regex mapping limit error, got %v", err)
+	}
+}
+
+func TestLoadCustomDNSWithPolicy_MalformedFile(t *testing.T) {
+	for _, env := range []string{"POSTGRES_HOST", "POSTGRES_PORT", "POSTGRES_DB"} {
+		t.Setenv(env, "")
+	}
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "custom-dns.json"), []byte(`{"mappings": {"a.local": `), 0644); err != nil {
+		t.Fatalf("Failed to write mappings file: %v", err)
+	}
+	t.Chdir(dir)
+
+	tests := []struct {
+		name    string
+		policy  string
+		wantErr bool
+	}{
+		{name: "fail", policy: CustomDNSErrorPolicyFail, wantErr: true},
+		{name: "warn", policy: CustomDNSErrorPolicyWarn},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.CustomDNSOnError = tt.policy
+			cfg.CustomDNS = map[string]string{"cli.local.": "10.0.0.1"}
+
+			err := cfg.loadCustomDNSWithPolicy()
+			if tt.wantErr {
+				if err == nil || !containsString(err.Error(), "failed to parse custom DNS config file") {
+					t.Fatalf("Expected parse error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected malformed file to be ignored, got %v", err)
+			}
+			if len(cfg.CustomDNS) != 1 || cfg.CustomDNS["cli.local."] != "10.0.0.1" {
+				t.Errorf("Expected existing mappings to be kept, got %v", cfg.CustomDNS)
+			}
+		})
+	}
+}
+
+func TestLoadCustomDNSWithPolicy_OverLimitFile(t *testing.T) {
+	for _, env := range []string{"POSTGRES_HOST", "POSTGRES_PORT", "POSTGRES_DB"} {
+		t.Setenv(env, "")
+	}
+
+	dir := t.TempDir()
+	data := `{"mappings": {"a.local": "10.0.0.1", "b.local": "10.0.0.2", "c.local": "10.0.0.3"}}`
+	if err := os.WriteFile(filepath.Join(dir, "custom-dns.json"), []byte(data), 0644); err != nil {
+		t.Fatalf("Failed to write mappings file: %v", err)
+	}
+	t.Chdir(dir)
+
+	cfg := DefaultConfig()
+	cfg.CustomDNSOnError = CustomDNSErrorPolicyWarn
+	cfg.MaxCustomMappings = 2
+	cfg.CustomDNS = map[string]string{"cli.local.": "10.0.0.9"}
+
+	if err := cfg.loadCustomDNSWithPolicy(); err != nil {
+		t.Fatalf("Expected the over-limit file to be ignored under warn, got %v", err)
+	}
+	if len(cfg.CustomDNS) != 1 || cfg.CustomDNS["cli.local."] != "10.0.0.9" {
+		t.Errorf("Expected only the pre-existing mappings to be kept, got %v", cfg.CustomDNS)
+	}
+}
+
+func TestConfig_ValidateShutdownTimeouts(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(*Config)
+		errMsg    string
+	}{
+		{name: "zero shutdown timeout", configure: func(c *Config) { c.ShutdownTimeout = 0 }, errMsg: "shutdown timeout must be positive"},
+		{name: "negative force exit timeout", configure: func(c *Config) { c.ForceExitTimeout = -time.Second }, errMsg: "force exit timeout must be positive"},
+		{name: "zero failure threshold", configure: func(c *Config) { c.UpstreamFailureThreshold = 0 }, errMsg: "upstream failure threshold must be positive"},
+		{name: "negative recovery timeout", configure: func(c *Config) { c.UpstreamRecoveryTimeout = -time.Second }, errMsg: "upstream recovery timeout must be non-negative"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			tt.configure(cfg)
+
+			err := cfg.Validate()
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("Expected error containing %q, got %v", tt.errMsg, err)
+			}
+		})
+	}
+}
+
+func TestConfig_ValidateClientQueryTypes(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   QueryTypeRules
+		wantErr bool
+	}{
+		{name: "subnet and single address", rules: QueryTypeRules{"192.168.10.0/24": {"A", "AAAA"}, "2001:db8::1": {"A"}}},
+		{name: "invalid subnet", rules: QueryTypeRules{"192.168.10.0/33": {"A"}}, wantErr: true},
+		{name: "unknown type", rules: QueryTypeRules{"192.168.10.0/24": {"BOGUS"}}, wantErr: true},
+		{name: "no types", rules: QueryTypeRules{"192.168.10.0/24": nil}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.ClientQueryTypes = tt.rules
+
+			if err := cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Expected error=%v, got: %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestConfig_ValidateUpstreamTimeouts(t *testing.T) {
+	tests := []struct {
+		name     string
+		timeouts ServerTimeouts
+		wantErr  bool
+	}{
+		{name: "listed upstream", timeouts: ServerTimeouts{"8.8.8.8:53": 2 * time.Second}},
+		{name: "listed upstream with priority", timeouts: ServerTimeouts{"1.1.1.1:53#1": time.Second}},
+		{name: "unknown upstream", timeouts: ServerTimeouts{"9.9.9.9:53": time.Second}, wantErr: true},
+		{name: "zero timeout", timeouts: ServerTimeouts{"8.8.8.8:53": 0}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.UpstreamDNS = []string{"8.8.8.8:53", "1.1.1.1:53#1"}
+			cfg.UpstreamTimeouts = tt.timeouts
+
+			if err := cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Expected error=%v, got: %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestLoadFromFlags_UpstreamAliases(t *testing.T) {
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+
+	os.Args = []string{"test",
+		"-upstream-aliases=cloudflare=1.1.1.1:53, google=8.8.8.8:53",
+		"-upstreams=cloudflare,google#1,9.9.9.9:53",
+		"-upstream-timeouts=cloudflare=2s",
+	}
+
+	cfg, err := LoadFromFlags()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	want := []string{"1.1.1.1:53", "8.8.8.8:53#1", "9.9.9.9:53"}
+	if strings.Join(cfg.UpstreamDNS, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected upstreams %v, got %v", want, cfg.UpstreamDNS)
+	}
+	if timeout := cfg.UpstreamTimeouts["1.1.1.1:53"]; timeout != 2*time.Second {
+		t.Errorf("Expected the aliased timeout to apply to 1.1.1.1:53, got %v", cfg.UpstreamTimeouts)
+	}
+}
+
+func TestConfig_ResolveUpstreamAliases(t *testing.T) {
+	tests := []struct {
+		name      string
+		aliases   map[string]string
+		upstreams []string
+		want      []string
+		errMsg    string
+	}{
+		{
+			name:      "aliases and addresses",
+			aliases:   map[string]string{"quad9": "tls://dns.quad9.net", "local": "192.168.0.1:53#0"},
+			upstreams: []string{"local", "quad9#2", "dns.google", "[2606:4700::1111]:53"},
+			want:      []string{"192.168.0.1:53#0", "tls://dns.quad9.net#2", "dns.google", "[2606:4700::1111]:53"},
+		},
+		{
+			name:      "priority of the reference replaces the alias's",
+			aliases:   map[string]string{"local": "192.168.0.1:53#0"},
+			upstreams: []string{"local#3"},
+			want:      []string{"192.168.0.1:53#3"},
+		},
+		{
+			name:      "unknown alias",
+			aliases:   map[string]string{"cloudflare": "1.1.1.1:53"},
+			upstreams: []string{"cloudfare"},
+			errMsg:    `unknown upstream alias "cloudfare"`,
+		},
+		{
+			name:      "invalid alias name",
+			aliases:   map[string]string{"dns.local": "192.168.0.1:53"},
+			upstreams: []string{"8.8.8.8:53"},
+			errMsg:    `invalid upstream alias name "dns.local"`,
+		},
+		{
+			name:      "alias without upstream",
+			aliases:   map[string]string{"local": ""},
+			upstreams: []string{"8.8.8.8:53"},
+			errMsg:    "upstream alias local has no upstream",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.UpstreamAliases = tt.aliases
+			cfg.UpstreamDNS = tt.upstreams
+
+			err := cfg.ResolveUpstreamAliases()
+			if tt.errMsg != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+					t.Fatalf("Expected error containing %q, got %v", tt.errMsg, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if strings.Join(cfg.UpstreamDNS, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("Expected upstreams %v, got %v", tt.want, cfg.UpstreamDNS)
+			}
+		})
+	}
+}
+
+func TestLoadFromFlags_CustomDNSMultipleIPs(t *testing.T) {
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+
+	os.Args = []string{"test", "-custom-dns=web.local=10.0.0.1,10.0.0.2, fd00::1,db.local=10.0.0.3,ttl:60,mail.local=txt:v=spf1 mx -all,mx:10 mail.local"}
+
+	cfg, err := LoadFromFlags()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	want := map[string]string{
+		"web.local.":  "10.0.0.1,10.0.0.2,fd00::1",
+		"db.local.":   "10.0.0.3,ttl:60",
+		"mail.local.": "txt:v=spf1 mx -all,mx:10 mail.local",
+	}
+	for domain, value := range want {
+		if cfg.CustomDNS[domain] != value {
+			t.Errorf("Expected %s=%s, got %q", domain, value, cfg.CustomDNS[domain])
+		}
+	}
+
+	// A value without a mapping to belong to is still an error
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	os.Args = []string{"test", "-custom-dns=10.0.0.2,web.local=10.0.0.1"}
+	if _, err := LoadFromFlags(); err == nil {
+		t.Error("Expected an error for an IP without a domain")
+	}
+}
+
+func TestLoadFromFlags_StubZones(t *testing.T) {
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+
+	os.Args = []string{"test", "-upstream-aliases=ns1=10.0.0.53:53", "-stub-zones=Corp.Internal=ns1| 10.0.0.54:53,lab.local=10.1.0.53:53"}
+
+	cfg, err := LoadFromFlags()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	want := ZoneServers{
+		"corp.internal.": {"10.0.0.53:53", "10.0.0.54:53"},
+		"lab.local.":     {"10.1.0.53:53"},
+	}
+	if len(cfg.StubZones) != len(want) {
+		t.Fatalf("Expected stub zones %v, got %v", want, cfg.StubZones)
+	}
+	for zone, servers := range want {
+		if strings.Join(cfg.StubZones[zone], ",") != strings.Join(servers, ",") {
+			t.Errorf("Expected %s to be served by %v, got %v", zone, servers, cfg.StubZones[zone])
+		}
+	}
+
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	os.Args = []string{"test", "-stub-zones=corp.internal="}
+	if _, err := LoadFromFlags(); err == nil {
+		t.Error("Expected an error for a stub zone without servers")
+	}
+}
+
+func TestLoadFromFlags_UpstreamRoutes(t *testing.T) {
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+
+	os.Args = []string{"test", "-upstream-aliases=corp=10.0.0.53:53", "-upstream-routes=Corp.Internal=corp|10.0.0.54:53"}
+
+	cfg, err := LoadFromFlags()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := strings.Join(cfg.UpstreamRoutes["corp.internal."], ","); got != "10.0.0.53:53,10.0.0.54:53" {
+		t.Errorf("Expected corp.internal. to be routed to both servers, got %v", cfg.UpstreamRoutes)
+	}
+
+	// A zone cannot be both a stub zone and an upstream route
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	os.Args = []string{"test", "-upstream-routes=corp.internal=10.0.0.53:53", "-stub-zones=corp.internal=10.0.0.54:53"}
+	if _, err := LoadFromFlags(); err == nil {
+		t.Error("Expected an error for a zone configured twice")
+	}
+}
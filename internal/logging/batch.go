@@ -0,0 +1,118 @@
+package logging
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"dns-go/internal/types"
+)
+
+const (
+	// defaultPostgresBatchSize is the number of pending DNS log entries that
+	// triggers a write to PostgreSQL
+	defaultPostgresBatchSize = 100
+	// defaultPostgresFlushInterval is how long DNS log entries wait at most
+	// before being written to PostgreSQL
+	defaultPostgresFlushInterval = time.Second
+	// postgresQueueBatches is how many batches may be queued before new
+	// entries are dropped
+	postgresQueueBatches = 10
+)
+
+// logBatcher buffers DNS log entries and hands them to insert in batches, once
+// maxSize entries are pending or every interval, whichever comes first.
+// Entries dropped because the queue is full are counted and reported once per
+// flush.
+type logBatcher struct {
+	insert   func([]types.LogEntry) (int64, error)
+	onError  func(error)
+	maxSize  int
+	interval time.Duration
+	dropped  atomic.Int64
+
+	entries   chan types.LogEntry
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// newLogBatcher starts a batcher writing entries with insert and reporting
+// failed writes to onError
+func newLogBatcher(insert func([]types.LogEntry) (int64, error), onError func(error), maxSize int, interval time.Duration) *logBatcher {
+	b := &logBatcher{
+		insert:   insert,
+		onError:  onError,
+		maxSize:  maxSize,
+		interval: interval,
+		entries:  make(chan types.LogEntry, maxSize*postgresQueueBatches),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+// Add queues an entry without blocking, and reports whether there was room
+// for it. Dropped entries are reported with the next flush.
+func (b *logBatcher) Add(entry types.LogEntry) bool {
+	select {
+	case b.entries <- entry:
+		return true
+	default:
+		b.dropped.Add(1)
+		return false
+	}
+}
+
+// Close writes the entries still queued and stops the batcher
+func (b *logBatcher) Close() {
+	b.closeOnce.Do(func() { close(b.stop) })
+	<-b.done
+}
+
+func (b *logBatcher) run() {
+	defer close(b.done)
+
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+
+	batch := make([]types.LogEntry, 0, b.maxSize)
+	for {
+		select {
+		case entry := <-b.entries:
+			batch = append(batch, entry)
+			if len(batch) >= b.maxSize {
+				batch = b.flush(batch)
+			}
+		case <-ticker.C:
+			batch = b.flush(batch)
+		case <-b.stop:
+			for {
+				select {
+				case entry := <-b.entries:
+					batch = append(batch, entry)
+				default:
+					b.flush(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+// flush reports the entries dropped since the last flush, writes the batch and
+// returns it emptied for reuse
+func (b *logBatcher) flush(batch []types.LogEntry) []types.LogEntry {
+	if dropped := b.dropped.Swap(0); dropped > 0 && b.onError != nil {
+		b.onError(fmt.Errorf("%w, dropped %d log entries", errPostgresQueueFull, dropped))
+	}
+	if len(batch) == 0 {
+		return batch
+	}
+	if _, err := b.insert(batch); err != nil && b.onError != nil {
+		b.onError(err)
+	}
+	return batch[:0]
+}
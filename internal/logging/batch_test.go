@@ -0,0 +1,136 @@
+package logging
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"dns-go/internal/types"
+)
+
+// recordingInsert collects the batches handed to it
+type recordingInsert struct {
+	mu      sync.Mutex
+	batches [][]string
+	err     error
+}
+
+func (r *recordingInsert) insert(entries []types.LogEntry) (int64, error) {
+	uuids := make([]string, len(entries))
+	for i, entry := range entries {
+		uuids[i] = entry.UUID
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.batches = append(r.batches, uuids)
+	return int64(len(entries)), r.err
+}
+
+func (r *recordingInsert) sizes() []int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sizes := make([]int, len(r.batches))
+	for i, batch := range r.batches {
+		sizes[i] = len(batch)
+	}
+	return sizes
+}
+
+func batchEntry(i int) types.LogEntry {
+	return types.LogEntry{UUID: fmt.Sprintf("entry-%d", i), Timestamp: time.Now()}
+}
+
+func TestLogBatcher_FlushesOnSize(t *testing.T) {
+	rec := &recordingInsert{}
+	b := newLogBatcher(rec.insert, nil, 3, time.Hour)
+
+	for i := 0; i < 7; i++ {
+		if !b.Add(batchEntry(i)) {
+			t.Fatalf("Entry %d was dropped", i)
+		}
+	}
+	deadline := time.Now().Add(time.Second)
+	for len(rec.sizes()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if got := rec.sizes(); fmt.Sprint(got) != "[3 3]" {
+		t.Fatalf("Expected two full batches before closing, got %v", got)
+	}
+
+	b.Close()
+	if got := rec.sizes(); fmt.Sprint(got) != "[3 3 1]" {
+		t.Errorf("Expected the remaining entry to be written on close, got %v", got)
+	}
+	if rec.batches[2][0] != "entry-6" {
+		t.Errorf("Expected entries in order, got %v", rec.batches)
+	}
+}
+
+func TestLogBatcher_FlushesOnInterval(t *testing.T) {
+	rec := &recordingInsert{}
+	b := newLogBatcher(rec.insert, nil, 100, 20*time.Millisecond)
+	defer b.Close()
+
+	b.Add(batchEntry(1))
+	b.Add(batchEntry(2))
+
+	deadline := time.Now().Add(time.Second)
+	for len(rec.sizes()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if got := rec.sizes(); fmt.Sprint(got) != "[2]" {
+		t.Errorf("Expected the pending entries to be written after the interval, got %v", got)
+	}
+}
+
+func TestLogBatcher_ReportsErrors(t *testing.T) {
+	rec := &recordingInsert{err: errors.New("connection reset")}
+	var reported []error
+	b := newLogBatcher(rec.insert, func(err error) { reported = append(reported, err) }, 10, time.Hour)
+
+	b.Add(batchEntry(1))
+	b.Close()
+
+	if len(reported) != 1 || reported[0] != rec.err {
+		t.Errorf("Expected the insert error to be reported once, got %v", reported)
+	}
+}
+
+func TestLogDNSEntry_ReportsFullQueue(t *testing.T) {
+	block := make(chan struct{})
+	insert := func(entries []types.LogEntry) (int64, error) {
+		<-block
+		return int64(len(entries)), nil
+	}
+
+	logger := New(io.Discard, ERROR)
+	var reports []error
+	logger.SetPostgresErrorObserver(func(err error) {
+		if errors.Is(err, errPostgresQueueFull) {
+			reports = append(reports, err)
+		}
+	})
+	logger.SetHumanOutput(io.Discard)
+	logger.pgBatcher = newLogBatcher(insert, logger.reportPostgresError, 1, time.Hour)
+
+	// One entry is held by the blocked insert, the queue holds
+	// postgresQueueBatches more and the rest are dropped
+	total := postgresQueueBatches + 5
+	for i := 0; i < total; i++ {
+		logger.LogDNSEntry(batchEntry(i))
+		if i == 0 {
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+	close(block)
+	logger.Close()
+
+	// The drops are reported together once the batcher flushes again
+	if len(reports) != 1 || !strings.Contains(reports[0].Error(), "dropped 4 log entries") {
+		t.Errorf("Expected one report of 4 dropped entries, got %v", reports)
+	}
+}
@@ -2,11 +2,13 @@ package logging
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -48,11 +50,17 @@ type Logger struct {
 	output      io.Writer
 	jsonEncoder *json.Encoder
 	humanLogger *log.Logger
-	jsonFile    *os.File
-	humanFile   *os.File
+	jsonFile    *RotatingFile
+	humanFile   *RotatingFile
 	pgClient    *postgres.Client
+	pgBatcher   *logBatcher
+	pgObserver  func(error) // called when storing an entry in PostgreSQL fails
 }
 
+// errPostgresQueueFull is reported when DNS log entries arrive faster than they
+// can be written to PostgreSQL
+var errPostgresQueueFull = errors.New("PostgreSQL log queue is full")
+
 // New creates a new structured logger
 func New(output io.Writer, level LogLevel) *Logger {
 	logger := &Logger{
@@ -63,8 +71,25 @@ func New(output io.Writer, level LogLevel) *Logger {
 	return logger
 }
 
-// NewFromConfig creates a logger from configuration with dual file support
-func NewFromConfig(logFile string, logLevel string) (*Logger, *os.File, *os.File, error) {
+// SetPostgresErrorObserver registers a function called each time a log entry
+// cannot be stored in PostgreSQL
+func (l *Logger) SetPostgresErrorObserver(fn func(error)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.pgObserver = fn
+}
+
+// SetHumanOutput sends human-readable (non-request) log messages to w
+func (l *Logger) SetHumanOutput(w io.Writer) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.humanLogger = log.New(w, "", log.LstdFlags|log.Lmicroseconds)
+}
+
+// NewFromConfig creates a logger from configuration with dual file support.
+// Each log file is rotated once it would exceed maxSize bytes (0 = never),
+// keeping up to maxBackups rotated files.
+func NewFromConfig(logFile string, logLevel string, maxSize int64, maxBackups int) (*Logger, *RotatingFile, *RotatingFile, error) {
 	level := parseLogLevel(logLevel)
 
 	if logFile == "" {
@@ -81,7 +106,7 @@ func NewFromConfig(logFile string, logLevel string) (*Logger, *os.File, *os.File
 	}
 
 	// Open JSON log file (for requests/responses only)
-	jsonFile, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	jsonFile, err := OpenRotatingFile(logFile, maxSize, maxBackups)
 	if err != nil {
 		return nil, nil, nil, err
 	}
@@ -92,7 +117,7 @@ func NewFromConfig(logFile string, logLevel string) (*Logger, *os.File, *os.File
 		// For dns-requests.log, create dns-server.log for human-readable logs
 		humanLogFile = strings.Replace(logFile, "dns-requests.log", "dns-server.log", 1)
 	}
-	humanFile, err := os.OpenFile(humanLogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	humanFile, err := OpenRotatingFile(humanLogFile, maxSize, maxBackups)
 	if err != nil {
 		jsonFile.Close()
 		return nil, nil, nil, err
@@ -131,6 +156,8 @@ func NewFromConfig(logFile string, logLevel string) (*Logger, *os.File, *os.File
 		for i := 0; i < maxRetries; i++ {
 			if pgClient, err := postgres.NewClient(pgConfig); err == nil {
 				logger.pgClient = pgClient
+				logger.pgBatcher = newLogBatcher(pgClient.InsertLogEntriesBatch, logger.reportPostgresError,
+					postgresBatchSize(), postgresFlushInterval())
 				if logger.humanLogger != nil {
 					logger.humanLogger.Printf("✅ DNS server PostgreSQL client initialized successfully")
 				} else {
@@ -163,6 +190,25 @@ func NewFromConfig(logFile string, logLevel string) (*Logger, *os.File, *os.File
 	return logger, jsonFile, humanFile, nil
 }
 
+// postgresBatchSize returns the number of pending DNS log entries that triggers
+// a write to PostgreSQL, from the POSTGRES_BATCH_SIZE environment variable
+func postgresBatchSize() int {
+	if size, err := strconv.Atoi(os.Getenv("POSTGRES_BATCH_SIZE")); err == nil && size > 0 {
+		return size
+	}
+	return defaultPostgresBatchSize
+}
+
+// postgresFlushInterval returns how long DNS log entries wait at most before
+// being written to PostgreSQL, from the POSTGRES_FLUSH_INTERVAL environment
+// variable
+func postgresFlushInterval() time.Duration {
+	if interval, err := time.ParseDuration(os.Getenv("POSTGRES_FLUSH_INTERVAL")); err == nil && interval > 0 {
+		return interval
+	}
+	return defaultPostgresFlushInterval
+}
+
 // parseLogLevel converts string to LogLevel
 func parseLogLevel(level string) LogLevel {
 	switch level {
@@ -281,17 +327,34 @@ func (l *Logger) LogDNSEntry(entry types.LogEntry) {
 	// Log to JSON file
 	l.LogJSON(entry)
 
-	// Log to PostgreSQL if available
-	if l.pgClient != nil {
-		go func() {
-			if err := l.pgClient.InsertLogEntry(entry); err != nil {
-				// Log error but don't block the main logging flow
-				if l.humanLogger != nil {
-					l.humanLogger.Printf("Warning: Failed to insert log entry to PostgreSQL: %v", err)
-				} else {
-					log.Printf("Warning: Failed to insert log entry to PostgreSQL: %v", err)
-				}
-			}
-		}()
+	// Queue for PostgreSQL if available; entries are written in batches, and
+	// those dropped while the queue is full are reported by the batcher
+	if l.pgBatcher != nil {
+		l.pgBatcher.Add(entry)
+	}
+}
+
+// reportPostgresError logs a failure to store log entries in PostgreSQL and
+// notifies the registered observer
+func (l *Logger) reportPostgresError(err error) {
+	// Log error but don't block the main logging flow
+	if l.humanLogger != nil {
+		l.humanLogger.Printf("Warning: Failed to insert log entry to PostgreSQL: %v", err)
+	} else {
+		log.Printf("Warning: Failed to insert log entry to PostgreSQL: %v", err)
+	}
+
+	l.mu.Lock()
+	observer := l.pgObserver
+	l.mu.Unlock()
+	if observer != nil {
+		observer(err)
+	}
+}
+
+// Close writes the DNS log entries still buffered for PostgreSQL
+func (l *Logger) Close() {
+	if l.pgBatcher != nil {
+		l.pgBatcher.Close()
 	}
 }
@@ -0,0 +1,91 @@
+package logging
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"dns-go/internal/types"
+)
+
+func TestLogDNSEntry_RotatesBySize(t *testing.T) {
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "dns-requests.log")
+
+	const maxSize = 1024
+	logger, jsonFile, humanFile, err := NewFromConfig(logFile, "info", maxSize, 2)
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+	defer humanFile.Close()
+
+	for i := 0; i < 50; i++ {
+		logger.LogDNSEntry(types.LogEntry{
+			Timestamp: time.Now(),
+			UUID:      "00000000-0000-0000-0000-000000000000",
+			Request:   types.RequestInfo{Client: "192.0.2.10", Query: "example.com.", Type: "A"},
+			Status:    "success",
+		})
+	}
+	jsonFile.Close()
+
+	backups, err := jsonFile.Backups()
+	if err != nil {
+		t.Fatalf("Failed to list backups: %v", err)
+	}
+	if len(backups) != 2 {
+		t.Fatalf("Expected 2 rotated files to be kept, got %v", backups)
+	}
+	for _, path := range append(backups, logFile) {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("Failed to stat %s: %v", path, err)
+		}
+		if info.Size() == 0 || info.Size() > maxSize {
+			t.Errorf("Expected %s to hold up to %d bytes, got %d", path, maxSize, info.Size())
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "dns-server.log")); err != nil {
+		t.Errorf("Expected the human-readable log to be left in place: %v", err)
+	}
+}
+
+func TestRotatingFile_KeepsOtherFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "queries.log")
+	human := path + ".human"
+	if err := os.WriteFile(human, []byte("human log\n"), 0666); err != nil {
+		t.Fatalf("Failed to write %s: %v", human, err)
+	}
+
+	f, err := OpenRotatingFile(path, 10, 0)
+	if err != nil {
+		t.Fatalf("Failed to open log file: %v", err)
+	}
+	defer f.Close()
+
+	for i := 0; i < 3; i++ {
+		if _, err := f.Write([]byte("0123456789\n")); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+
+	backups, err := f.Backups()
+	if err != nil {
+		t.Fatalf("Failed to list backups: %v", err)
+	}
+	if len(backups) != 0 {
+		t.Errorf("Expected no rotated files to be kept, got %v", backups)
+	}
+	if _, err := os.Stat(human); err != nil {
+		t.Errorf("Expected %s to survive rotation: %v", human, err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read log file: %v", err)
+	}
+	if string(data) != "0123456789\n" {
+		t.Errorf("Expected only the last write in the log file, got %q", data)
+	}
+}
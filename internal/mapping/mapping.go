@@ -0,0 +1,69 @@
+// Package mapping holds the syntax of custom DNS mapping keys and values, shared
+// by the resolver answering them and the configuration and storage keeping them.
+package mapping
+
+import "strings"
+
+const (
+	// PatternPrefix marks a custom DNS mapping key as a regular expression
+	// matched against the lower-case, fully qualified query name
+	PatternPrefix = "re:"
+
+	// ValueSeparator separates the values of a custom DNS mapping answered
+	// with several records (e.g. 192.168.0.30,192.168.0.31)
+	ValueSeparator = ","
+)
+
+const (
+	// CNAMEPrefix marks a custom DNS mapping value as an alias of another name
+	// (e.g. app.local=cname:server.local)
+	CNAMEPrefix = "cname:"
+
+	// TXTPrefix marks a custom DNS mapping value answered as a TXT record
+	// (e.g. mail.local=txt:v=spf1 mx -all)
+	TXTPrefix = "txt:"
+
+	// MXPrefix marks a custom DNS mapping value answered as an MX record with
+	// its preference and exchange (e.g. local=mx:10 mail.local)
+	MXPrefix = "mx:"
+
+	// SRVPrefix marks a custom DNS mapping value answered as an SRV record with
+	// its priority, weight, port and target (e.g. _sip._udp.local=srv:10 5 5060 pbx.local)
+	SRVPrefix = "srv:"
+)
+
+// IsPatternKey reports whether a custom DNS mapping key is a regex pattern
+func IsPatternKey(key string) bool {
+	return strings.HasPrefix(key, PatternPrefix)
+}
+
+// NormalizeKey prepares a custom DNS mapping key for storage: domains get a
+// trailing dot for DNS processing, regex patterns are kept as written
+func NormalizeKey(key string) string {
+	key = strings.TrimSpace(key)
+	if IsPatternKey(key) {
+		return key
+	}
+	// Ensure domain ends with a dot for DNS processing
+	if !strings.HasSuffix(key, ".") {
+		key += "."
+	}
+	return key
+}
+
+// Values splits a custom DNS mapping value into its values
+func Values(value string) []string {
+	var values []string
+	for _, v := range strings.Split(value, ValueSeparator) {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// NormalizeValue prepares a custom DNS mapping value for storage, trimming its
+// values and dropping empty ones
+func NormalizeValue(value string) string {
+	return strings.Join(Values(value), ValueSeparator)
+}
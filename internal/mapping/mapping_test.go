@@ -0,0 +1,18 @@
+package mapping
+
+import "testing"
+
+func TestNormalizeKey(t *testing.T) {
+	if got := NormalizeKey(" server.local "); got != "server.local." {
+		t.Errorf("Expected server.local., got %q", got)
+	}
+	if got := NormalizeKey(`re:^db-\d+\.internal\.$`); got != `re:^db-\d+\.internal\.$` {
+		t.Errorf("Expected regex key to be kept as written, got %q", got)
+	}
+}
+
+func TestNormalizeValue(t *testing.T) {
+	if got := NormalizeValue(" 10.0.0.1 , ,10.0.0.2,"); got != "10.0.0.1,10.0.0.2" {
+		t.Errorf("Expected 10.0.0.1,10.0.0.2, got %q", got)
+	}
+}
@@ -0,0 +1,81 @@
+package mapping
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Value is the value of a custom DNS mapping in a JSON configuration
+// file. Besides the plain value string (e.g. "192.168.0.30,192.168.0.31"), it
+// accepts a record object such as {"type": "MX", "value": "10 mail.local"} or
+// a list of strings and record objects, all stored in value string form.
+type Value string
+
+// recordJSON is a typed record of a custom DNS mapping in JSON
+type recordJSON struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (v *Value) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err == nil {
+		*v = Value(value)
+		return nil
+	}
+
+	var list []json.RawMessage
+	if err := json.Unmarshal(data, &list); err == nil {
+		values := make([]string, 0, len(list))
+		for _, item := range list {
+			var value Value
+			if err := json.Unmarshal(item, &value); err != nil {
+				return err
+			}
+			values = append(values, string(value))
+		}
+		*v = Value(strings.Join(values, ValueSeparator))
+		return nil
+	}
+
+	var record recordJSON
+	if err := json.Unmarshal(data, &record); err != nil {
+		return fmt.Errorf("custom DNS mapping must be a string, a record object or a list of them: %w", err)
+	}
+	value, err := record.value()
+	if err != nil {
+		return err
+	}
+	*v = Value(value)
+	return nil
+}
+
+// value returns the record in value string form
+func (r recordJSON) value() (string, error) {
+	value := strings.TrimSpace(r.Value)
+	if value == "" {
+		return "", fmt.Errorf("custom DNS record of type %q has no value", r.Type)
+	}
+
+	var prefix string
+	switch strings.ToUpper(strings.TrimSpace(r.Type)) {
+	case "A", "AAAA", "PTR":
+	case "CNAME":
+		prefix = CNAMEPrefix
+	case "TXT":
+		prefix = TXTPrefix
+	case "MX":
+		prefix = MXPrefix
+	case "SRV":
+		prefix = SRVPrefix
+	default:
+		return "", fmt.Errorf("unsupported custom DNS record type %q, must be one of: A, AAAA, PTR, CNAME, TXT, MX, SRV", r.Type)
+	}
+
+	if strings.Contains(value, ValueSeparator) {
+		return "", fmt.Errorf("custom DNS %s record value %q must not contain %q", strings.ToUpper(r.Type), value, ValueSeparator)
+	}
+	return prefix + value, nil
+}
@@ -0,0 +1,36 @@
+package mapping
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestValue_UnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		want    string
+		wantErr bool
+	}{
+		{"string", `"192.168.0.30,ttl:60"`, "192.168.0.30,ttl:60", false},
+		{"record", `{"type": "mx", "value": "10 mail.local"}`, "mx:10 mail.local", false},
+		{"address record", `{"type": "A", "value": "192.168.0.25"}`, "192.168.0.25", false},
+		{"list", `["192.168.0.25", {"type": "TXT", "value": "v=spf1 mx -all"}, {"type": "CNAME", "value": "x"}]`, "192.168.0.25,txt:v=spf1 mx -all,cname:x", false},
+		{"unsupported type", `{"type": "NS", "value": "ns.local"}`, "", true},
+		{"empty value", `{"type": "TXT", "value": ""}`, "", true},
+		{"separator in value", `{"type": "TXT", "value": "a,b"}`, "", true},
+		{"number", `42`, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var value Value
+			err := json.Unmarshal([]byte(tt.json), &value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if string(value) != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, value)
+			}
+		})
+	}
+}
@@ -34,18 +34,35 @@ type Metrics struct {
 	// Query type statistics
 	queryTypeStats map[string]int64
 
+	// Response code statistics
+	rcodeStats map[string]int64
+
 	// Upstream statistics
 	upstreamStats map[string]*UpstreamStats
 
 	// Response time statistics
 	responseTimeSum   float64
 	responseTimeCount int64
+	responseTimes     []responseTime // Recent requests for latency percentiles
 
 	// Requests for real-time display
 	requests      []types.LogEntry
 	maxRecentSize int
 }
 
+// percentileWindow is the recent period latency percentiles are computed over,
+// the same as for the PostgreSQL overview
+const percentileWindow = time.Hour
+
+// maxResponseTimes bounds the response times kept for latency percentiles
+const maxResponseTimes = 10000
+
+// responseTime is the duration of a request in milliseconds
+type responseTime struct {
+	at       time.Time
+	duration float64
+}
+
 // ClientStats holds statistics for a specific client
 type ClientStats struct {
 	TotalRequests     int64     `json:"total_requests"`
@@ -59,10 +76,35 @@ type UpstreamStats struct {
 	TotalQueries      int64     `json:"total_queries"`
 	SuccessfulQueries int64     `json:"successful_queries"`
 	FailedQueries     int64     `json:"failed_queries"`
+	Races             int64     `json:"races"` // Answered queries this server was sent
+	Wins              int64     `json:"wins"`  // Answered queries this server's response was used for
+	Truncations       int64     `json:"truncations"`
 	AverageRTT        float64   `json:"average_rtt_ms"`
 	LastUsed          time.Time `json:"last_used"`
 	RTTSum            float64   `json:"-"`
 	RTTCount          int64     `json:"-"`
+	RTTHistogram      []int64   `json:"-"` // Answers per RTTBuckets bound, the last for slower ones
+}
+
+// RTTBuckets are the upper bounds in milliseconds of the upstream RTT histogram
+var RTTBuckets = []float64{1, 5, 10, 25, 50, 100, 250, 500, 1000, 2500}
+
+// observeRTT counts an answer's RTT in the histogram
+func (s *UpstreamStats) observeRTT(rtt float64) {
+	if s.RTTHistogram == nil {
+		s.RTTHistogram = make([]int64, len(RTTBuckets)+1)
+	}
+	i := sort.SearchFloat64s(RTTBuckets, rtt)
+	s.RTTHistogram[i]++
+}
+
+// Snapshot is a consistent copy of the counters, e.g. for exporting them
+type Snapshot struct {
+	TotalRequests     int64
+	SuccessfulQueries int64
+	FailedQueries     int64
+	ActiveClients     int
+	Upstreams         map[string]UpstreamStats
 }
 
 // QueryTypeMetric represents a query type with its count (sorted by backend)
@@ -71,12 +113,19 @@ type QueryTypeMetric struct {
 	Count int64  `json:"count"`
 }
 
+// RcodeMetric represents a response code with its count (sorted by backend)
+type RcodeMetric struct {
+	Rcode string `json:"rcode"`
+	Count int64  `json:"count"`
+}
+
 // DashboardMetrics represents the metrics data structure for the web dashboard
 type DashboardMetrics struct {
 	Overview        OverviewMetrics           `json:"overview"`
 	TimeSeriesData  TimeSeriesData            `json:"time_series"`
 	TopClients      []ClientMetric            `json:"top_clients"`
 	QueryTypes      []QueryTypeMetric         `json:"query_types"` // Pre-sorted, top 8 query types
+	Rcodes          []RcodeMetric             `json:"rcodes"`      // Pre-sorted response code breakdown
 	UpstreamServers map[string]*UpstreamStats `json:"upstream_servers"`
 	Requests        []types.LogEntry          `json:"requests"` // Requests for real-time display
 	SystemInfo      SystemInfo                `json:"system_info"`
@@ -89,6 +138,9 @@ type OverviewMetrics struct {
 	RequestsPerSecond   float64 `json:"requests_per_second"`
 	SuccessRate         float64 `json:"success_rate"`
 	AverageResponseTime float64 `json:"average_response_time_ms"`
+	P50ResponseTime     float64 `json:"p50_response_time_ms"`
+	P95ResponseTime     float64 `json:"p95_response_time_ms"`
+	P99ResponseTime     float64 `json:"p99_response_time_ms"`
 	Clients             int     `json:"clients"`
 }
 
@@ -129,6 +181,7 @@ func NewMetrics() *Metrics {
 		requestsLastMonth: make(map[int64]int64),
 		clientStats:       make(map[string]*ClientStats),
 		queryTypeStats:    make(map[string]int64),
+		rcodeStats:        make(map[string]int64),
 		upstreamStats:     make(map[string]*UpstreamStats),
 		requests:          make([]types.LogEntry, 0),
 		maxRecentSize:     100, // Keep last 100 requests
@@ -170,6 +223,11 @@ func (m *Metrics) RecordRequest(entry types.LogEntry) {
 	// Query type statistics
 	m.queryTypeStats[entry.Request.Type]++
 
+	// Response code statistics
+	if rcode := ResponseRcode(entry); rcode != "" {
+		m.rcodeStats[rcode]++
+	}
+
 	// Status-based metrics
 	switch entry.Status {
 	case "success":
@@ -200,7 +258,11 @@ func (m *Metrics) RecordRequest(entry types.LogEntry) {
 					RTTCount:          1,
 				}
 			}
+			m.upstreamStats[upstream].observeRTT(entry.Response.RTT)
 		}
+
+		// Which of the queried upstreams answered first
+		m.recordWinner(entry)
 	case "all_upstreams_failed":
 		m.failedQueries++
 		m.clientStats[clientIP].FailedQueries++
@@ -223,6 +285,13 @@ func (m *Metrics) RecordRequest(entry types.LogEntry) {
 		m.malformedQueries++
 	}
 
+	m.recordTruncations(entry)
+
+	m.responseTimes = append(m.responseTimes, responseTime{at: entry.Timestamp, duration: entry.Duration})
+	if len(m.responseTimes) > maxResponseTimes {
+		m.responseTimes = m.responseTimes[1:]
+	}
+
 	// Add to requests
 	m.requests = append(m.requests, entry)
 	if len(m.requests) > m.maxRecentSize {
@@ -230,6 +299,43 @@ func (m *Metrics) RecordRequest(entry types.LogEntry) {
 	}
 }
 
+// recordWinner counts, for each upstream queried for an answered request,
+// whether its response was the one returned to the client
+func (m *Metrics) recordWinner(entry types.LogEntry) {
+	raced := make(map[string]bool, len(entry.Upstreams))
+	for _, attempt := range entry.Upstreams {
+		stats, exists := m.upstreamStats[attempt.Server]
+		if !exists {
+			stats = &UpstreamStats{}
+			m.upstreamStats[attempt.Server] = stats
+		}
+		if !raced[attempt.Server] {
+			raced[attempt.Server] = true
+			stats.Races++
+			stats.LastUsed = entry.Timestamp
+		}
+		if attempt.Successful {
+			stats.Wins++
+		}
+	}
+}
+
+// recordTruncations counts the upstreams whose UDP answer to the request was
+// truncated
+func (m *Metrics) recordTruncations(entry types.LogEntry) {
+	for _, attempt := range entry.Upstreams {
+		if !attempt.Truncated {
+			continue
+		}
+		stats, exists := m.upstreamStats[attempt.Server]
+		if !exists {
+			stats = &UpstreamStats{}
+			m.upstreamStats[attempt.Server] = stats
+		}
+		stats.Truncations++
+	}
+}
+
 // RecordRateLimited records a rate-limited request
 func (m *Metrics) RecordRateLimited(clientIP string) {
 	m.mu.Lock()
@@ -276,14 +382,8 @@ func (m *Metrics) GetDashboardMetrics(version string) DashboardMetrics {
 	// Get top clients
 	topClients := m.getTopClients()
 
-	// Count active clients (seen in last hour)
-	activeClients := 0
-	oneHourAgo := time.Now().Add(-time.Hour)
-	for _, stats := range m.clientStats {
-		if stats.LastSeen.After(oneHourAgo) {
-			activeClients++
-		}
-	}
+	// Latency percentiles over the recent requests
+	durations := m.recentResponseTimes()
 
 	return DashboardMetrics{
 		Overview: OverviewMetrics{
@@ -292,11 +392,15 @@ func (m *Metrics) GetDashboardMetrics(version string) DashboardMetrics {
 			RequestsPerSecond:   requestsPerSecond,
 			SuccessRate:         successRate,
 			AverageResponseTime: avgResponseTime,
-			Clients:             activeClients,
+			P50ResponseTime:     percentile(durations, 0.5),
+			P95ResponseTime:     percentile(durations, 0.95),
+			P99ResponseTime:     percentile(durations, 0.99),
+			Clients:             m.activeClients(),
 		},
 		TimeSeriesData:  timeSeriesData,
 		TopClients:      topClients,
 		QueryTypes:      m.getTopQueryTypes(),
+		Rcodes:          m.getRcodeBreakdown(),
 		UpstreamServers: m.upstreamStats,
 		Requests:        m.getRequests(),
 		SystemInfo: SystemInfo{
@@ -306,6 +410,67 @@ func (m *Metrics) GetDashboardMetrics(version string) DashboardMetrics {
 	}
 }
 
+// recentResponseTimes returns the sorted durations of the requests within the
+// percentile window
+func (m *Metrics) recentResponseTimes() []float64 {
+	since := time.Now().Add(-percentileWindow)
+	durations := make([]float64, 0, len(m.responseTimes))
+	for _, rt := range m.responseTimes {
+		if rt.at.After(since) {
+			durations = append(durations, rt.duration)
+		}
+	}
+	sort.Float64s(durations)
+	return durations
+}
+
+// percentile interpolates the p-th percentile (0 to 1) of sorted values, like
+// PostgreSQL's PERCENTILE_CONT, or returns 0 without values
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	pos := p * float64(len(sorted)-1)
+	lower := int(pos)
+	if lower+1 >= len(sorted) {
+		return sorted[lower]
+	}
+	return sorted[lower] + (sorted[lower+1]-sorted[lower])*(pos-float64(lower))
+}
+
+// Snapshot returns a copy of the request, client and upstream counters
+func (m *Metrics) Snapshot() Snapshot {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	upstreams := make(map[string]UpstreamStats, len(m.upstreamStats))
+	for server, stats := range m.upstreamStats {
+		stats := *stats
+		stats.RTTHistogram = append([]int64(nil), stats.RTTHistogram...)
+		upstreams[server] = stats
+	}
+
+	return Snapshot{
+		TotalRequests:     m.totalRequests,
+		SuccessfulQueries: m.successfulQueries,
+		FailedQueries:     m.failedQueries,
+		ActiveClients:     m.activeClients(),
+		Upstreams:         upstreams,
+	}
+}
+
+// activeClients counts the clients seen in the last hour
+func (m *Metrics) activeClients() int {
+	count := 0
+	oneHourAgo := time.Now().Add(-time.Hour)
+	for _, stats := range m.clientStats {
+		if stats.LastSeen.After(oneHourAgo) {
+			count++
+		}
+	}
+	return count
+}
+
 // LoadFromLogFile loads historical data from log files
 func (m *Metrics) LoadFromLogFile(logFilePath string) error {
 	file, err := os.Open(logFilePath)
@@ -542,6 +707,44 @@ func (m *Metrics) getTopQueryTypes() []QueryTypeMetric {
 	return queryTypes
 }
 
+func (m *Metrics) getRcodeBreakdown() []RcodeMetric {
+	rcodes := make([]RcodeMetric, 0, len(m.rcodeStats))
+
+	for rcode, count := range m.rcodeStats {
+		rcodes = append(rcodes, RcodeMetric{
+			Rcode: rcode,
+			Count: count,
+		})
+	}
+
+	// Sort by count (descending), then by name for a stable order
+	sort.Slice(rcodes, func(i, j int) bool {
+		if rcodes[i].Count != rcodes[j].Count {
+			return rcodes[i].Count > rcodes[j].Count
+		}
+		return rcodes[i].Rcode < rcodes[j].Rcode
+	})
+
+	return rcodes
+}
+
+// ResponseRcode returns the response code sent to the client for a log entry.
+// Entries without a recorded response are attributed to the SERVFAIL or FORMERR
+// answer the server sends for them; an empty string means it is unknown.
+func ResponseRcode(entry types.LogEntry) string {
+	if entry.Response != nil && entry.Response.Rcode != "" {
+		return entry.Response.Rcode
+	}
+
+	switch entry.Status {
+	case "all_upstreams_failed":
+		return "SERVFAIL"
+	case "malformed_query":
+		return "FORMERR"
+	}
+	return ""
+}
+
 func (m *Metrics) getRequests() []types.LogEntry {
 	// Return a copy of requests (reversed to show newest first)
 	recent := make([]types.LogEntry, len(m.requests))
@@ -0,0 +1,119 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"dns-go/internal/types"
+)
+
+func TestRecordRequest_RcodeBreakdown(t *testing.T) {
+	m := NewMetrics()
+
+	record := func(status, rcode string) {
+		entry := types.LogEntry{
+			Timestamp: time.Now(),
+			Request:   types.RequestInfo{Client: "192.0.2.10", Query: "example.com.", Type: "A"},
+			Status:    status,
+		}
+		if rcode != "" {
+			entry.Response = &types.ResponseInfo{Upstream: "8.8.8.8:53", Rcode: rcode}
+		}
+		m.RecordRequest(entry)
+	}
+
+	for i := 0; i < 3; i++ {
+		record("success", "NOERROR")
+	}
+	record("success", "NXDOMAIN")
+	record("success", "NXDOMAIN")
+	record("local_domain", "REFUSED")
+	record("all_upstreams_failed", "")
+	record("malformed_query", "")
+
+	rcodes := m.GetDashboardMetrics("test").Rcodes
+
+	expected := []RcodeMetric{
+		{Rcode: "NOERROR", Count: 3},
+		{Rcode: "NXDOMAIN", Count: 2},
+		{Rcode: "FORMERR", Count: 1},
+		{Rcode: "REFUSED", Count: 1},
+		{Rcode: "SERVFAIL", Count: 1},
+	}
+	if len(rcodes) != len(expected) {
+		t.Fatalf("Expected %d rcodes, got %v", len(expected), rcodes)
+	}
+	for i, want := range expected {
+		if rcodes[i] != want {
+			t.Errorf("Expected rcode[%d] = %+v, got %+v", i, want, rcodes[i])
+		}
+	}
+}
+
+func TestRecordRequest_UpstreamWins(t *testing.T) {
+	m := NewMetrics()
+
+	record := func(winner string, queried ...string) {
+		entry := types.LogEntry{
+			Timestamp: time.Now(),
+			Request:   types.RequestInfo{Client: "192.0.2.10", Query: "example.com.", Type: "A"},
+			Status:    "success",
+			Response:  &types.ResponseInfo{Upstream: winner, Rcode: "NOERROR"},
+		}
+		for _, server := range queried {
+			entry.Upstreams = append(entry.Upstreams, types.UpstreamAttempt{Server: server, Attempt: 1, Successful: server == winner})
+		}
+		m.RecordRequest(entry)
+	}
+
+	record("1.1.1.1:53", "8.8.8.8:53", "1.1.1.1:53")
+	record("1.1.1.1:53", "8.8.8.8:53", "1.1.1.1:53")
+	record("8.8.8.8:53", "8.8.8.8:53", "1.1.1.1:53")
+	record("8.8.8.8:53", "8.8.8.8:53")
+
+	servers := m.GetDashboardMetrics("test").UpstreamServers
+	expected := map[string]struct{ races, wins int64 }{
+		"1.1.1.1:53": {races: 3, wins: 2},
+		"8.8.8.8:53": {races: 4, wins: 2},
+	}
+	for server, want := range expected {
+		stats := servers[server]
+		if stats == nil {
+			t.Fatalf("Expected stats for %s, got %v", server, servers)
+		}
+		if stats.Races != want.races || stats.Wins != want.wins {
+			t.Errorf("Expected %s to win %d of %d races, got %d of %d", server, want.wins, want.races, stats.Wins, stats.Races)
+		}
+	}
+}
+
+func TestRecordRequest_UpstreamTruncations(t *testing.T) {
+	m := NewMetrics()
+
+	record := func(status string, attempts ...types.UpstreamAttempt) {
+		m.RecordRequest(types.LogEntry{
+			Timestamp: time.Now(),
+			Request:   types.RequestInfo{Client: "192.0.2.10", Query: "example.com.", Type: "TXT"},
+			Status:    status,
+			Upstreams: attempts,
+		})
+	}
+
+	record("success", types.UpstreamAttempt{Server: "8.8.8.8:53", Attempt: 1, Truncated: true, Successful: true})
+	record("success", types.UpstreamAttempt{Server: "8.8.8.8:53", Attempt: 1, Successful: true})
+	record("all_upstreams_failed",
+		types.UpstreamAttempt{Server: "8.8.8.8:53", Attempt: 1, Truncated: true},
+		types.UpstreamAttempt{Server: "1.1.1.1:53", Attempt: 2})
+
+	servers := m.GetDashboardMetrics("test").UpstreamServers
+	expected := map[string]int64{"8.8.8.8:53": 2, "1.1.1.1:53": 0}
+	for server, want := range expected {
+		stats := servers[server]
+		if stats == nil {
+			t.Fatalf("Expected stats for %s, got %v", server, servers)
+		}
+		if stats.Truncations != want {
+			t.Errorf("Expected %d truncations for %s, got %d", want, server, stats.Truncations)
+		}
+	}
+}
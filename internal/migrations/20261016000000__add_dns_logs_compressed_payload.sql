@@ -0,0 +1,7 @@
+-- Migration: Add compressed payload column to dns_logs
+-- Timestamp: 20261016000000
+-- Description: Adds a bytea column holding gzip-compressed upstreams and answers for rows
+-- written with payload compression enabled; the upstreams and answers JSONB columns are
+-- left NULL for those rows and older rows keep using them
+
+ALTER TABLE dns_logs ADD COLUMN IF NOT EXISTS compressed_payload BYTEA;
@@ -0,0 +1,6 @@
+-- Migration: Widen ip_address in dns_mappings
+-- Timestamp: 20261016000001
+-- Description: A mapping value may now list several comma-separated IP addresses,
+-- which no longer fit the 45 characters of a single IPv6 address
+
+ALTER TABLE dns_mappings ALTER COLUMN ip_address TYPE TEXT;
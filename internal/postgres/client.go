@@ -1,19 +1,24 @@
 package postgres
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
+	"dns-go/internal/mapping"
 	"dns-go/internal/migrations"
 	"dns-go/internal/types"
 
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 const (
@@ -26,7 +31,15 @@ const (
 
 // Client wraps the PostgreSQL client with DNS-specific functionality
 type Client struct {
-	db *gorm.DB
+	mu  sync.RWMutex
+	db  *gorm.DB
+	dsn string
+
+	// stopMonitor stops the health monitor started by StartHealthMonitor
+	stopMonitor chan struct{}
+
+	// compressPayloads stores upstreams and answers gzip-compressed
+	compressPayloads bool
 }
 
 // Config holds PostgreSQL configuration
@@ -36,8 +49,22 @@ type Config struct {
 	Database string
 	User     string
 	Password string
+
+	// ConnectMaxWait is how long NewClientWithRetry keeps retrying before giving up
+	ConnectMaxWait time.Duration
+
+	// CompressPayloads stores the upstreams and answers of new log entries
+	// gzip-compressed (also enabled by POSTGRES_COMPRESS_PAYLOADS=true)
+	CompressPayloads bool
 }
 
+var (
+	// initialConnectBackoff is the delay before the first connection retry
+	initialConnectBackoff = 1 * time.Second
+	// maxConnectBackoff caps the delay between connection retries
+	maxConnectBackoff = 16 * time.Second
+)
+
 // NewClient creates a new PostgreSQL client using GORM
 func NewClient(cfg Config) (*Client, error) {
 	host := getEnvOrDefault("POSTGRES_HOST", cfg.Host)
@@ -116,7 +143,9 @@ func NewClient(cfg Config) (*Client, error) {
 	}
 
 	client := &Client{
-		db: db,
+		db:               db,
+		dsn:              dsn,
+		compressPayloads: cfg.CompressPayloads || os.Getenv("POSTGRES_COMPRESS_PAYLOADS") == "true",
 	}
 
 	// Run migrations using GORM AutoMigrate
@@ -127,6 +156,46 @@ func NewClient(cfg Config) (*Client, error) {
 	return client, nil
 }
 
+// NewClientWithRetry creates a new PostgreSQL client, retrying with exponential
+// backoff for up to cfg.ConnectMaxWait while the server is not reachable yet
+// (e.g. the database container is still starting)
+func NewClientWithRetry(cfg Config) (*Client, error) {
+	return connectWithRetry(cfg.ConnectMaxWait, func() (*Client, error) {
+		return NewClient(cfg)
+	})
+}
+
+// connectWithRetry calls connect until it succeeds or maxWait has elapsed,
+// doubling the delay between attempts up to maxConnectBackoff
+func connectWithRetry(maxWait time.Duration, connect func() (*Client, error)) (*Client, error) {
+	deadline := time.Now().Add(maxWait)
+	backoff := initialConnectBackoff
+
+	for attempt := 1; ; attempt++ {
+		client, err := connect()
+		if err == nil {
+			return client, nil
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil, fmt.Errorf("failed to connect to PostgreSQL after %d attempts: %w", attempt, err)
+		}
+
+		wait := backoff
+		if wait > remaining {
+			wait = remaining
+		}
+		fmt.Printf("⏳ PostgreSQL connection attempt %d failed: %v. Retrying in %v...\n", attempt, err, wait)
+		time.Sleep(wait)
+
+		backoff *= 2
+		if backoff > maxConnectBackoff {
+			backoff = maxConnectBackoff
+		}
+	}
+}
+
 // isDatabaseExistsError checks if the error indicates the database already exists
 func isDatabaseExistsError(err error) bool {
 	if err == nil {
@@ -144,19 +213,19 @@ func (c *Client) initialize() error {
 	defer cancel()
 
 	// Check and run file-based migrations first
-	migrator := migrations.NewMigrator(c.db)
+	migrator := migrations.NewMigrator(c.conn())
 	if err := migrator.Run(ctx); err != nil {
 		return fmt.Errorf("failed to run migrations: %w", err)
 	}
 
 	// Then run GORM AutoMigrate to ensure models match current code
 	// This handles any schema changes that might be needed
-	if err := c.db.WithContext(ctx).AutoMigrate(&DNSLog{}, &DNSMapping{}); err != nil {
+	if err := c.conn().WithContext(ctx).AutoMigrate(&DNSLog{}, &DNSMapping{}); err != nil {
 		// If AutoMigrate fails on constraint issues with existing tables, continue
 		if strings.Contains(err.Error(), "constraint") || strings.Contains(err.Error(), "does not exist") {
 			fmt.Printf("⚠️  Warning: AutoMigrate encountered constraint issues (tables exist, continuing): %v\n", err)
 			// Verify tables exist
-			if !c.db.Migrator().HasTable(&DNSLog{}) || !c.db.Migrator().HasTable(&DNSMapping{}) {
+			if !c.conn().Migrator().HasTable(&DNSLog{}) || !c.conn().Migrator().HasTable(&DNSMapping{}) {
 				return fmt.Errorf("required tables missing after migration: %w", err)
 			}
 		} else {
@@ -185,6 +254,9 @@ func toDNSLog(entry types.LogEntry) *DNSLog {
 		if upstream.RTT != nil {
 			upstreamData["rtt_ms"] = *upstream.RTT
 		}
+		if upstream.Successful {
+			upstreamData["successful"] = true
+		}
 		upstreamsJSON[i] = upstreamData
 	}
 
@@ -223,8 +295,64 @@ func toDNSLog(entry types.LogEntry) *DNSLog {
 	return log
 }
 
+// logPayload is the compressed form of the upstreams and answers columns
+type logPayload struct {
+	Upstreams JSONB `json:"upstreams,omitempty"`
+	Answers   JSONB `json:"answers,omitempty"`
+}
+
+// compressPayload moves the upstreams and answers of a log row into the
+// gzip-compressed payload column
+func compressPayload(log *DNSLog) error {
+	data, err := json.Marshal(logPayload{Upstreams: log.Upstreams, Answers: log.Answers})
+	if err != nil {
+		return fmt.Errorf("failed to encode log payload: %w", err)
+	}
+
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write(data); err != nil {
+		return fmt.Errorf("failed to compress log payload: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to compress log payload: %w", err)
+	}
+
+	log.CompressedPayload = buf.Bytes()
+	log.Upstreams = nil
+	log.Answers = nil
+	return nil
+}
+
+// decompressPayload restores the upstreams and answers of a row written with
+// payload compression. Rows without a compressed payload are left as they are.
+func decompressPayload(log *DNSLog) error {
+	if len(log.CompressedPayload) == 0 {
+		return nil
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader(log.CompressedPayload))
+	if err != nil {
+		return fmt.Errorf("failed to decompress log payload: %w", err)
+	}
+	defer reader.Close()
+
+	var payload logPayload
+	if err := json.NewDecoder(reader).Decode(&payload); err != nil {
+		return fmt.Errorf("failed to decode log payload: %w", err)
+	}
+
+	log.Upstreams = payload.Upstreams
+	log.Answers = payload.Answers
+	return nil
+}
+
 // toLogEntry converts DNSLog model to types.LogEntry
 func toLogEntry(log *DNSLog) types.LogEntry {
+	if err := decompressPayload(log); err != nil {
+		fmt.Printf("⚠️  Warning: Failed to read compressed payload of log entry %s: %v\n", log.UUID, err)
+	}
+
 	entry := types.LogEntry{
 		Timestamp: log.Timestamp,
 		UUID:      log.UUID,
@@ -260,6 +388,9 @@ func toLogEntry(log *DNSLog) types.LogEntry {
 				if rtt := getFloat64Ptr(data, "rtt_ms"); rtt != nil {
 					attempt.RTT = rtt
 				}
+				if successful, ok := data["successful"].(bool); ok {
+					attempt.Successful = successful
+				}
 				upstreams = append(upstreams, attempt)
 			}
 		}
@@ -380,9 +511,14 @@ func (c *Client) InsertLogEntry(entry types.LogEntry) error {
 	defer cancel()
 
 	log := toDNSLog(entry)
+	if c.compressPayloads {
+		if err := compressPayload(log); err != nil {
+			return err
+		}
+	}
 
 	// Use GORM's FirstOrCreate to handle ON CONFLICT (do nothing if exists)
-	result := c.db.WithContext(ctx).Where("uuid = ?", log.UUID).FirstOrCreate(log)
+	result := c.conn().WithContext(ctx).Where("uuid = ?", log.UUID).FirstOrCreate(log)
 	if result.Error != nil {
 		return fmt.Errorf("failed to insert log entry: %w", result.Error)
 	}
@@ -390,6 +526,41 @@ func (c *Client) InsertLogEntry(entry types.LogEntry) error {
 	return nil
 }
 
+// logInsertBatchSize is the number of rows written by each INSERT statement of
+// InsertLogEntriesBatch
+const logInsertBatchSize = 500
+
+// InsertLogEntriesBatch inserts DNS log entries with multi-row INSERT
+// statements, skipping entries whose UUID is already stored, and returns the
+// number of rows inserted
+func (c *Client) InsertLogEntriesBatch(entries []types.LogEntry) (int64, error) {
+	if len(entries) == 0 {
+		return 0, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	logs := make([]*DNSLog, len(entries))
+	for i, entry := range entries {
+		logs[i] = toDNSLog(entry)
+		if c.compressPayloads {
+			if err := compressPayload(logs[i]); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	result := c.conn().WithContext(ctx).
+		Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "uuid"}}, DoNothing: true}).
+		CreateInBatches(logs, logInsertBatchSize)
+	if result.Error != nil {
+		return result.RowsAffected, fmt.Errorf("failed to insert log entries: %w", result.Error)
+	}
+
+	return result.RowsAffected, nil
+}
+
 // SearchLogs searches through DNS logs stored in PostgreSQL
 type SearchResult struct {
 	Results []types.LogEntry `json:"results"`
@@ -401,7 +572,7 @@ func (c *Client) SearchLogs(domain, clientIP string, limit, offset int, since *t
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	query := c.db.WithContext(ctx).Model(&DNSLog{})
+	query := c.conn().WithContext(ctx).Model(&DNSLog{})
 
 	// Add domain filter if specified
 	if domain != "" {
@@ -450,7 +621,7 @@ func (c *Client) GetLogCount() (int64, error) {
 	defer cancel()
 
 	var count int64
-	if err := c.db.WithContext(ctx).Model(&DNSLog{}).Count(&count).Error; err != nil {
+	if err := c.conn().WithContext(ctx).Model(&DNSLog{}).Count(&count).Error; err != nil {
 		return 0, fmt.Errorf("failed to count logs: %w", err)
 	}
 
@@ -459,20 +630,44 @@ func (c *Client) GetLogCount() (int64, error) {
 
 // DeleteOldLogs deletes DNS logs older than the specified retention period
 func (c *Client) DeleteOldLogs(retentionDays int) (int64, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
-	defer cancel()
+	return c.PurgeLogsOlderThan(time.Duration(retentionDays) * 24 * time.Hour)
+}
 
-	cutoffTime := time.Now().AddDate(0, 0, -retentionDays)
+// purgeBatchSize is the number of rows removed by each DELETE statement of
+// PurgeLogsOlderThan, keeping row locks short
+const purgeBatchSize = 5000
 
-	result := c.db.WithContext(ctx).
-		Where("timestamp < ?", cutoffTime).
-		Delete(&DNSLog{})
+// PurgeLogsOlderThan deletes DNS logs with a timestamp older than d, in batches,
+// and returns the number of rows deleted
+func (c *Client) PurgeLogsOlderThan(d time.Duration) (int64, error) {
+	return c.purgeLogsOlderThan(d, purgeBatchSize)
+}
 
-	if result.Error != nil {
-		return 0, fmt.Errorf("failed to delete old logs: %w", result.Error)
+func (c *Client) purgeLogsOlderThan(d time.Duration, batchSize int) (int64, error) {
+	cutoffTime := time.Now().Add(-d)
+
+	var deleted int64
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		// Select each batch through the timestamp index rather than scanning the table
+		result := c.conn().WithContext(ctx).Exec(`
+			DELETE FROM dns_logs
+			WHERE id IN (
+				SELECT id FROM dns_logs
+				WHERE timestamp < ?
+				ORDER BY timestamp
+				LIMIT ?
+			)`, cutoffTime, batchSize)
+		cancel()
+
+		if result.Error != nil {
+			return deleted, fmt.Errorf("failed to delete old logs: %w", result.Error)
+		}
+		deleted += result.RowsAffected
+		if result.RowsAffected < int64(batchSize) {
+			return deleted, nil
+		}
 	}
-
-	return result.RowsAffected, nil
 }
 
 // DomainCount represents a domain with its request count
@@ -487,7 +682,7 @@ func (c *Client) GetDomainCounts(since *time.Time, domainFilter, clientIP string
 	defer cancel()
 
 	// Get raw database connection for direct sql.Scan
-	sqlDB, err := c.db.WithContext(ctx).DB()
+	sqlDB, err := c.conn().WithContext(ctx).DB()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get database connection: %w", err)
 	}
@@ -558,6 +753,85 @@ type TimeSeriesPoint struct {
 	Count int64 `gorm:"column:count" json:"count"`
 }
 
+// DomainTTL holds TTL statistics of the answers upstreams returned for a domain
+type DomainTTL struct {
+	Domain  string  `json:"domain"`
+	MinTTL  int64   `json:"min_ttl"`
+	AvgTTL  float64 `json:"avg_ttl"`
+	MaxTTL  int64   `json:"max_ttl"`
+	Records int64   `json:"records"`
+}
+
+// GetDomainTTLs returns the minimum, average and maximum TTL of answer records
+// returned by upstreams per queried domain, most frequently answered first.
+// Rows stored with payload compression are not included.
+func (c *Client) GetDomainTTLs(since *time.Time, domainFilter string, limit int) ([]DomainTTL, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Get raw database connection for direct sql.Scan
+	sqlDB, err := c.conn().WithContext(ctx).DB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get database connection: %w", err)
+	}
+
+	// Answers are stored as arrays of record fields: name, ttl, class, type, data...
+	var queryBuilder strings.Builder
+	queryBuilder.WriteString(`
+		SELECT
+			query AS domain,
+			MIN((answer->>1)::BIGINT) AS min_ttl,
+			AVG((answer->>1)::BIGINT)::DOUBLE PRECISION AS avg_ttl,
+			MAX((answer->>1)::BIGINT) AS max_ttl,
+			COUNT(*)::BIGINT AS records
+		FROM dns_logs, jsonb_array_elements(answers) AS answer
+		WHERE status = 'success'
+			AND jsonb_typeof(answers) = 'array'
+			AND answer->>1 ~ '^[0-9]+$'
+	`)
+
+	var args []interface{}
+	argIndex := 1
+
+	// Add time filter if specified
+	if since != nil {
+		queryBuilder.WriteString(fmt.Sprintf(" AND timestamp >= $%d AND timestamp <= $%d", argIndex, argIndex+1))
+		args = append(args, *since, time.Now())
+		argIndex += 2
+	}
+
+	// Add domain name filter if specified
+	if domainFilter != "" {
+		queryBuilder.WriteString(fmt.Sprintf(" AND query ILIKE $%d", argIndex))
+		args = append(args, "%"+domainFilter+"%")
+		argIndex++
+	}
+
+	queryBuilder.WriteString(fmt.Sprintf(" GROUP BY query ORDER BY records DESC, query LIMIT $%d", argIndex))
+	args = append(args, limit)
+
+	rows, err := sqlDB.QueryContext(ctx, queryBuilder.String(), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query domain TTLs: %w", err)
+	}
+	defer rows.Close()
+
+	var results []DomainTTL
+	for rows.Next() {
+		var dt DomainTTL
+		if err := rows.Scan(&dt.Domain, &dt.MinTTL, &dt.AvgTTL, &dt.MaxTTL, &dt.Records); err != nil {
+			return nil, fmt.Errorf("failed to scan domain TTL: %w", err)
+		}
+		results = append(results, dt)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating domain TTLs: %w", err)
+	}
+
+	return results, nil
+}
+
 // GetTimeSeriesData returns aggregated time series data from PostgreSQL
 func (c *Client) GetTimeSeriesData() (map[string][]TimeSeriesPoint, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
@@ -566,7 +840,7 @@ func (c *Client) GetTimeSeriesData() (map[string][]TimeSeriesPoint, error) {
 	result := make(map[string][]TimeSeriesPoint)
 
 	// Get raw database connection for direct sql.Scan
-	sqlDB, err := c.db.WithContext(ctx).DB()
+	sqlDB, err := c.conn().WithContext(ctx).DB()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get database connection: %w", err)
 	}
@@ -785,7 +1059,7 @@ func (c *Client) GetTopClients(limit int) ([]ClientMetric, error) {
 	}
 
 	var aggregates []ClientAggregate
-	if err := c.db.WithContext(ctx).Raw(`
+	if err := c.conn().WithContext(ctx).Raw(`
 		SELECT 
 			client_ip,
 			COUNT(*)::BIGINT as total_requests,
@@ -831,7 +1105,7 @@ func (c *Client) GetTopQueryTypes(limit int) ([]QueryTypeMetric, error) {
 	}
 
 	var aggregates []QueryTypeAggregate
-	if err := c.db.WithContext(ctx).Raw(`
+	if err := c.conn().WithContext(ctx).Raw(`
 		SELECT 
 			query_type,
 			COUNT(*)::BIGINT as count
@@ -854,14 +1128,185 @@ func (c *Client) GetTopQueryTypes(limit int) ([]QueryTypeMetric, error) {
 	return queryTypes, nil
 }
 
+// RcodeMetric represents aggregated response code statistics
+type RcodeMetric struct {
+	Rcode string
+	Count int64
+}
+
+// GetRcodeBreakdown returns the number of responses per rcode, optionally limited
+// to logs since the given time. Failed and malformed queries have no recorded
+// response and are attributed to the SERVFAIL and FORMERR answers sent for them.
+func (c *Client) GetRcodeBreakdown(since *time.Time) ([]RcodeMetric, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	type RcodeAggregate struct {
+		Rcode string `gorm:"column:rcode"`
+		Count int64  `gorm:"column:count"`
+	}
+
+	whereClause := ""
+	var args []interface{}
+	if since != nil {
+		whereClause = "WHERE timestamp >= ?"
+		args = append(args, *since)
+	}
+
+	var aggregates []RcodeAggregate
+	if err := c.conn().WithContext(ctx).Raw(fmt.Sprintf(`
+		SELECT rcode, COUNT(*)::BIGINT as count
+		FROM (
+			SELECT COALESCE(response_rcode, CASE status
+				WHEN 'all_upstreams_failed' THEN 'SERVFAIL'
+				WHEN 'malformed_query' THEN 'FORMERR'
+			END) as rcode
+			FROM dns_logs
+			%s
+		) responses
+		WHERE rcode IS NOT NULL
+		GROUP BY rcode
+		ORDER BY count DESC, rcode
+	`, whereClause), args...).Scan(&aggregates).Error; err != nil {
+		return nil, fmt.Errorf("failed to query rcode breakdown: %w", err)
+	}
+
+	rcodes := make([]RcodeMetric, len(aggregates))
+	for i, agg := range aggregates {
+		rcodes[i] = RcodeMetric{
+			Rcode: agg.Rcode,
+			Count: agg.Count,
+		}
+	}
+
+	return rcodes, nil
+}
+
+// UpstreamWinMetric represents how many answers an upstream server provided
+type UpstreamWinMetric struct {
+	Server string
+	Wins   int64
+}
+
+// GetUpstreamWins returns the number of answered queries per upstream server
+// whose response was returned to the client, optionally limited to logs since
+// the given time, most wins first
+func (c *Client) GetUpstreamWins(since *time.Time) ([]UpstreamWinMetric, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	type WinAggregate struct {
+		Server string `gorm:"column:server"`
+		Wins   int64  `gorm:"column:wins"`
+	}
+
+	whereClause := ""
+	var args []interface{}
+	if since != nil {
+		whereClause = "AND timestamp >= ?"
+		args = append(args, *since)
+	}
+
+	var aggregates []WinAggregate
+	if err := c.conn().WithContext(ctx).Raw(fmt.Sprintf(`
+		SELECT response_upstream as server, COUNT(*)::BIGINT as wins
+		FROM dns_logs
+		WHERE status = 'success' AND response_upstream IS NOT NULL %s
+		GROUP BY response_upstream
+		ORDER BY wins DESC, server
+	`, whereClause), args...).Scan(&aggregates).Error; err != nil {
+		return nil, fmt.Errorf("failed to query upstream wins: %w", err)
+	}
+
+	wins := make([]UpstreamWinMetric, len(aggregates))
+	for i, agg := range aggregates {
+		wins[i] = UpstreamWinMetric{
+			Server: agg.Server,
+			Wins:   agg.Wins,
+		}
+	}
+
+	return wins, nil
+}
+
 // OverviewStats represents overview statistics
 type OverviewStats struct {
 	TotalRequests       int64
 	SuccessfulQueries   int64
+	RequestsPerSecond   float64 // over the last requestRateWindow
 	AverageResponseTime float64
+	Latency             LatencyPercentiles // over the last requestRateWindow
 	ActiveClients       int
 }
 
+// requestRateWindow is the recent period requests per second and latency
+// percentiles are computed over
+const requestRateWindow = time.Hour
+
+// LatencyPercentiles holds response time percentiles in milliseconds
+type LatencyPercentiles struct {
+	P50 float64 `json:"p50"`
+	P95 float64 `json:"p95"`
+	P99 float64 `json:"p99"`
+}
+
+// GetLatencyPercentiles returns the median, 95th and 99th percentile response
+// times of the requests logged since the given time, or of all requests when
+// since is nil
+func (c *Client) GetLatencyPercentiles(since *time.Time) (*LatencyPercentiles, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	type PercentileAggregate struct {
+		P50 sql.NullFloat64 `gorm:"column:p50"`
+		P95 sql.NullFloat64 `gorm:"column:p95"`
+		P99 sql.NullFloat64 `gorm:"column:p99"`
+	}
+
+	whereClause := ""
+	var args []interface{}
+	if since != nil {
+		whereClause = "WHERE timestamp >= ?"
+		args = append(args, *since)
+	}
+
+	var agg PercentileAggregate
+	if err := c.conn().WithContext(ctx).Raw(fmt.Sprintf(`
+		SELECT
+			PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY duration_ms) as p50,
+			PERCENTILE_CONT(0.95) WITHIN GROUP (ORDER BY duration_ms) as p95,
+			PERCENTILE_CONT(0.99) WITHIN GROUP (ORDER BY duration_ms) as p99
+		FROM dns_logs
+		%s
+	`, whereClause), args...).Scan(&agg).Error; err != nil {
+		return nil, fmt.Errorf("failed to query latency percentiles: %w", err)
+	}
+
+	// No rows leave the percentiles NULL
+	return &LatencyPercentiles{
+		P50: agg.P50.Float64,
+		P95: agg.P95.Float64,
+		P99: agg.P99.Float64,
+	}, nil
+}
+
+// GetRequestRate returns the average number of requests per second logged
+// over the window ending now
+func (c *Client) GetRequestRate(window time.Duration) (float64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var count int64
+	if err := c.conn().WithContext(ctx).Raw(`
+		SELECT COUNT(*)::BIGINT
+		FROM dns_logs
+		WHERE timestamp >= ?
+	`, time.Now().Add(-window)).Scan(&count).Error; err != nil {
+		return 0, fmt.Errorf("failed to query request rate: %w", err)
+	}
+	return float64(count) / window.Seconds(), nil
+}
+
 // GetOverviewStats returns overview statistics from PostgreSQL
 func (c *Client) GetOverviewStats() (*OverviewStats, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
@@ -876,7 +1321,7 @@ func (c *Client) GetOverviewStats() (*OverviewStats, error) {
 	}
 
 	var agg StatsAggregate
-	if err := c.db.WithContext(ctx).Raw(`
+	if err := c.conn().WithContext(ctx).Raw(`
 		SELECT 
 			COUNT(*)::BIGINT as total_requests,
 			COUNT(*) FILTER (WHERE status = 'success')::BIGINT as successful,
@@ -894,7 +1339,7 @@ func (c *Client) GetOverviewStats() (*OverviewStats, error) {
 
 	// Get active clients (seen in last hour)
 	var activeClients int
-	if err := c.db.WithContext(ctx).Raw(`
+	if err := c.conn().WithContext(ctx).Raw(`
 		SELECT COUNT(DISTINCT client_ip)::INTEGER
 		FROM dns_logs
 		WHERE timestamp >= NOW() - INTERVAL '1 hour'
@@ -904,6 +1349,20 @@ func (c *Client) GetOverviewStats() (*OverviewStats, error) {
 	}
 	stats.ActiveClients = activeClients
 
+	// Percentiles sort every row they cover, so only the recent window is used
+	since := time.Now().Add(-requestRateWindow)
+	latency, err := c.GetLatencyPercentiles(&since)
+	if err != nil {
+		return nil, err
+	}
+	stats.Latency = *latency
+
+	rate, err := c.GetRequestRate(requestRateWindow)
+	if err != nil {
+		return nil, err
+	}
+	stats.RequestsPerSecond = rate
+
 	return stats, nil
 }
 
@@ -921,7 +1380,12 @@ func (c *Client) HealthCheck() error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	sqlDB, err := c.db.WithContext(ctx).DB()
+	return c.PingContext(ctx)
+}
+
+// PingContext checks if PostgreSQL is healthy, giving up when ctx is done
+func (c *Client) PingContext(ctx context.Context) error {
+	sqlDB, err := c.conn().WithContext(ctx).DB()
 	if err != nil {
 		return fmt.Errorf("failed to get database connection: %w", err)
 	}
@@ -933,8 +1397,16 @@ func (c *Client) HealthCheck() error {
 	return nil
 }
 
-// Close closes the PostgreSQL connection
+// Close stops the health monitor and closes the PostgreSQL connection
 func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.stopMonitor != nil {
+		close(c.stopMonitor)
+		c.stopMonitor = nil
+	}
+
 	if c.db != nil {
 		sqlDB, err := c.db.DB()
 		if err != nil {
@@ -945,6 +1417,119 @@ func (c *Client) Close() error {
 	return nil
 }
 
+// conn returns the current database handle. It may be swapped by Reconnect.
+func (c *Client) conn() *gorm.DB {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.db
+}
+
+// Reconnect opens a fresh connection pool and replaces the current one once it
+// answers a ping. The old pool is closed; on failure the current pool is kept.
+func (c *Client) Reconnect() error {
+	db, err := gorm.Open(postgres.Open(c.dsn), &gorm.Config{})
+	if err != nil {
+		return fmt.Errorf("failed to reopen database connection: %w", err)
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return fmt.Errorf("failed to get database connection: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := sqlDB.PingContext(ctx); err != nil {
+		sqlDB.Close()
+		return fmt.Errorf("failed to ping database: %w", err)
+	}
+
+	c.mu.Lock()
+	old := c.db
+	c.db = db
+	c.mu.Unlock()
+
+	if old != nil {
+		if oldSQL, err := old.DB(); err == nil {
+			oldSQL.Close()
+		}
+	}
+
+	return nil
+}
+
+// StartHealthMonitor runs HealthCheck every interval and re-opens the connection
+// once maxFailures consecutive checks have failed, so a PostgreSQL restart does
+// not leave the client broken until the process restarts. It stops on Close.
+func (c *Client) StartHealthMonitor(interval time.Duration, maxFailures int) {
+	c.mu.Lock()
+	if c.stopMonitor != nil {
+		c.mu.Unlock()
+		return
+	}
+	stop := make(chan struct{})
+	c.stopMonitor = stop
+	c.mu.Unlock()
+
+	monitor := &healthMonitor{
+		check:       c.HealthCheck,
+		reconnect:   c.Reconnect,
+		maxFailures: maxFailures,
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				monitor.observe()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// healthMonitor counts consecutive health check failures and triggers a
+// reconnect when they reach maxFailures
+type healthMonitor struct {
+	check       func() error
+	reconnect   func() error
+	maxFailures int
+	failures    int
+}
+
+// observe runs one health check, reconnecting on persistent failure. It reports
+// whether the connection is usable after the check.
+func (m *healthMonitor) observe() bool {
+	err := m.check()
+	if err == nil {
+		if m.failures > 0 {
+			fmt.Println("✅ PostgreSQL connection recovered")
+		}
+		m.failures = 0
+		return true
+	}
+
+	m.failures++
+	fmt.Printf("⚠️  PostgreSQL health check failed (%d/%d): %v\n", m.failures, m.maxFailures, err)
+	if m.failures < m.maxFailures {
+		return false
+	}
+
+	if err := m.reconnect(); err != nil {
+		fmt.Printf("⚠️  PostgreSQL reconnect failed: %v\n", err)
+		return false
+	}
+
+	fmt.Println("🔄 PostgreSQL connection re-established")
+	m.failures = 0
+	return true
+}
+
 // getEnvOrDefault returns environment variable value or default if not set
 func getEnvOrDefault(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
@@ -953,13 +1538,14 @@ func getEnvOrDefault(key, defaultValue string) string {
 	return defaultValue
 }
 
-// GetAllDNSMappings returns all DNS mappings from the database
+// GetAllDNSMappings returns all DNS mappings from the database, keyed by domain;
+// values listing several addresses are comma-separated
 func (c *Client) GetAllDNSMappings() (map[string]string, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
 	var mappings []DNSMapping
-	if err := c.db.WithContext(ctx).Order("domain").Find(&mappings).Error; err != nil {
+	if err := c.conn().WithContext(ctx).Order("domain").Find(&mappings).Error; err != nil {
 		return nil, fmt.Errorf("failed to query DNS mappings: %w", err)
 	}
 
@@ -971,13 +1557,16 @@ func (c *Client) GetAllDNSMappings() (map[string]string, error) {
 	return result, nil
 }
 
-// CreateDNSMapping creates a new DNS mapping
+// CreateDNSMapping creates a new DNS mapping. ipAddress may list several
+// comma-separated addresses.
 func (c *Client) CreateDNSMapping(domain, ipAddress string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
+	ipAddress = mapping.NormalizeValue(ipAddress)
+
 	// Use raw SQL for proper ON CONFLICT handling
-	result := c.db.WithContext(ctx).Exec(`
+	result := c.conn().WithContext(ctx).Exec(`
 		INSERT INTO dns_mappings (domain, ip_address, updated_at)
 		VALUES (?, ?, CURRENT_TIMESTAMP)
 		ON CONFLICT (domain) DO UPDATE
@@ -996,7 +1585,7 @@ func (c *Client) DeleteDNSMapping(domain string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	result := c.db.WithContext(ctx).Where("domain = ?", domain).Delete(&DNSMapping{})
+	result := c.conn().WithContext(ctx).Where("domain = ?", domain).Delete(&DNSMapping{})
 	if result.Error != nil {
 		return fmt.Errorf("failed to delete DNS mapping: %w", result.Error)
 	}
@@ -1021,7 +1610,7 @@ func (c *Client) MigrateDNSMappingsFromJSON(jsonFilePath string) error {
 	}
 
 	var config struct {
-		Mappings map[string]string `json:"mappings"`
+		Mappings map[string]mapping.Value `json:"mappings"`
 	}
 	if err := json.Unmarshal(data, &config); err != nil {
 		return fmt.Errorf("failed to parse JSON: %w", err)
@@ -1043,18 +1632,16 @@ func (c *Client) MigrateDNSMappingsFromJSON(jsonFilePath string) error {
 	}
 
 	// Migrate all mappings
-	for domain, ipAddress := range config.Mappings {
+	for domain, value := range config.Mappings {
 		domain = strings.TrimSpace(domain)
-		ipAddress = strings.TrimSpace(ipAddress)
+		ipAddress := strings.TrimSpace(string(value))
 
 		if domain == "" || ipAddress == "" {
 			continue
 		}
 
-		// Ensure domain ends with a dot for DNS processing
-		if !strings.HasSuffix(domain, ".") {
-			domain += "."
-		}
+		// Ensure domain ends with a dot for DNS processing (regex keys are kept as written)
+		domain = mapping.NormalizeKey(domain)
 
 		if err := c.CreateDNSMapping(domain, ipAddress); err != nil {
 			return fmt.Errorf("failed to migrate mapping %s: %w", domain, err)
@@ -1070,6 +1657,8 @@ type AggregatedStatsData struct {
 	TimeSeriesData map[string][]TimeSeriesPoint `json:"time_series_data"`
 	TopClients     []ClientMetric               `json:"top_clients"`
 	QueryTypes     []QueryTypeMetric            `json:"query_types"`
+	Rcodes         []RcodeMetric                `json:"rcodes"`
+	UpstreamWins   []UpstreamWinMetric          `json:"upstream_wins"`
 	UpdatedAt      time.Time                    `json:"updated_at"`
 }
 
@@ -1099,12 +1688,24 @@ func (c *Client) CalculateAndStoreAggregatedStats() error {
 		return fmt.Errorf("failed to get query types: %w", err)
 	}
 
+	rcodes, err := c.GetRcodeBreakdown(nil)
+	if err != nil {
+		return fmt.Errorf("failed to get rcode breakdown: %w", err)
+	}
+
+	upstreamWins, err := c.GetUpstreamWins(nil)
+	if err != nil {
+		return fmt.Errorf("failed to get upstream wins: %w", err)
+	}
+
 	// Prepare stats data
 	statsData := AggregatedStatsData{
 		OverviewStats:  overviewStats,
 		TimeSeriesData: timeSeriesData,
 		TopClients:     topClients,
 		QueryTypes:     topQueryTypes,
+		Rcodes:         rcodes,
+		UpstreamWins:   upstreamWins,
 		UpdatedAt:      time.Now(),
 	}
 
@@ -1115,7 +1716,7 @@ func (c *Client) CalculateAndStoreAggregatedStats() error {
 	}
 
 	// Store in database using upsert
-	result := c.db.WithContext(ctx).Exec(`
+	result := c.conn().WithContext(ctx).Exec(`
 		INSERT INTO aggregated_stats (stats_type, stats_data, updated_at, created_at)
 		VALUES ($1, $2::jsonb, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
 		ON CONFLICT (stats_type) 
@@ -1137,7 +1738,7 @@ func (c *Client) GetCachedAggregatedStats() (*AggregatedStatsData, error) {
 	defer cancel()
 
 	// Get raw database connection for direct JSONB handling
-	sqlDB, err := c.db.WithContext(ctx).DB()
+	sqlDB, err := c.conn().WithContext(ctx).DB()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get database connection: %w", err)
 	}
@@ -1177,7 +1778,7 @@ func (c *Client) GetEarliestLogTimestamp() (*time.Time, error) {
 	defer cancel()
 
 	var earliestTimestamp sql.NullTime
-	if err := c.db.WithContext(ctx).Raw(`
+	if err := c.conn().WithContext(ctx).Raw(`
 		SELECT MIN(timestamp) as earliest_timestamp
 		FROM dns_logs
 	`).Scan(&earliestTimestamp).Error; err != nil {
@@ -1202,7 +1803,7 @@ func (c *Client) SetDNSServerStartTime(startTime time.Time) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	result := c.db.WithContext(ctx).Exec(`
+	result := c.conn().WithContext(ctx).Exec(`
 		INSERT INTO system_metadata (metadata_key, metadata_value, updated_at, created_at)
 		VALUES ($1, $2, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
 		ON CONFLICT (metadata_key) 
@@ -1223,7 +1824,7 @@ func (c *Client) GetDNSServerStartTime() (*time.Time, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	sqlDB, err := c.db.WithContext(ctx).DB()
+	sqlDB, err := c.conn().WithContext(ctx).DB()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get database connection: %w", err)
 	}
@@ -0,0 +1,531 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"dns-go/internal/types"
+)
+
+func TestConnectWithRetry_SucceedsAfterFailures(t *testing.T) {
+	oldInitial, oldMax := initialConnectBackoff, maxConnectBackoff
+	initialConnectBackoff, maxConnectBackoff = time.Millisecond, 4*time.Millisecond
+	defer func() { initialConnectBackoff, maxConnectBackoff = oldInitial, oldMax }()
+
+	attempts := 0
+	want := &Client{}
+	client, err := connectWithRetry(time.Second, func() (*Client, error) {
+		attempts++
+		if attempts < 3 {
+			return nil, errors.New("connection refused")
+		}
+		return want, nil
+	})
+
+	if err != nil {
+		t.Fatalf("Expected success, got: %v", err)
+	}
+	if client != want {
+		t.Errorf("Expected the client from the successful attempt")
+	}
+	if attempts != 3 {
+		t.Errorf("Expected 3 attempts, got %d", attempts)
+	}
+}
+
+func TestConnectWithRetry_GivesUpAfterMaxWait(t *testing.T) {
+	oldInitial, oldMax := initialConnectBackoff, maxConnectBackoff
+	initialConnectBackoff, maxConnectBackoff = time.Millisecond, 4*time.Millisecond
+	defer func() { initialConnectBackoff, maxConnectBackoff = oldInitial, oldMax }()
+
+	attempts := 0
+	start := time.Now()
+	_, err := connectWithRetry(30*time.Millisecond, func() (*Client, error) {
+		attempts++
+		return nil, errors.New("connection refused")
+	})
+
+	if err == nil {
+		t.Fatal("Expected error after max wait, got none")
+	}
+	if attempts < 2 {
+		t.Errorf("Expected several attempts, got %d", attempts)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected to give up shortly after max wait, took %v", elapsed)
+	}
+}
+
+func TestHealthMonitor_ReconnectsAfterPersistentFailure(t *testing.T) {
+	// Simulate PostgreSQL restarting: checks fail until a reconnect is made
+	connected := true
+	reconnects := 0
+
+	m := &healthMonitor{
+		check: func() error {
+			if !connected {
+				return errors.New("connection reset by peer")
+			}
+			return nil
+		},
+		reconnect: func() error {
+			reconnects++
+			connected = true
+			return nil
+		},
+		maxFailures: 3,
+	}
+
+	if !m.observe() {
+		t.Fatal("Expected healthy connection before the drop")
+	}
+
+	connected = false
+	for i := 1; i < 3; i++ {
+		if m.observe() {
+			t.Fatalf("Expected check %d to report unhealthy", i)
+		}
+	}
+	if reconnects != 0 {
+		t.Fatalf("Expected no reconnect before %d failures, got %d", m.maxFailures, reconnects)
+	}
+
+	if !m.observe() {
+		t.Fatal("Expected reconnect on the third consecutive failure")
+	}
+	if reconnects != 1 {
+		t.Errorf("Expected 1 reconnect, got %d", reconnects)
+	}
+
+	// Subsequent requests use the new connection
+	if !m.observe() {
+		t.Error("Expected healthy connection after reconnect")
+	}
+	if m.failures != 0 {
+		t.Errorf("Expected failure count reset, got %d", m.failures)
+	}
+}
+
+func TestHealthMonitor_RetriesFailedReconnect(t *testing.T) {
+	serverUp := false
+	reconnects := 0
+
+	m := &healthMonitor{
+		check: func() error { return errors.New("connection refused") },
+		reconnect: func() error {
+			reconnects++
+			if !serverUp {
+				return errors.New("connection refused")
+			}
+			return nil
+		},
+		maxFailures: 1,
+	}
+
+	if m.observe() {
+		t.Fatal("Expected failure while the server is down")
+	}
+
+	serverUp = true
+	if !m.observe() {
+		t.Fatal("Expected reconnect to succeed once the server is back")
+	}
+	if reconnects != 2 {
+		t.Errorf("Expected 2 reconnect attempts, got %d", reconnects)
+	}
+}
+
+// newTestClient connects to the PostgreSQL instance configured through the
+// POSTGRES_* environment variables, skipping the test when none is configured
+func newTestClient(t *testing.T) *Client {
+	t.Helper()
+
+	if os.Getenv("POSTGRES_HOST") == "" {
+		t.Skip("POSTGRES_HOST not set, skipping PostgreSQL integration test")
+	}
+
+	client, err := NewClient(Config{})
+	if err != nil {
+		t.Fatalf("Failed to connect to PostgreSQL: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// seedLogs inserts log entries and removes them when the test finishes
+func seedLogs(t *testing.T, client *Client, entries []types.LogEntry) {
+	t.Helper()
+
+	uuids := make([]string, len(entries))
+	for i, entry := range entries {
+		if err := client.InsertLogEntry(entry); err != nil {
+			t.Fatalf("Failed to seed log entry: %v", err)
+		}
+		uuids[i] = entry.UUID
+	}
+	t.Cleanup(func() {
+		client.conn().Where("uuid IN ?", uuids).Delete(&DNSLog{})
+	})
+}
+
+func TestGetRcodeBreakdown(t *testing.T) {
+	client := newTestClient(t)
+
+	// Seed far in the future so existing rows don't affect the counts
+	since := time.Now().AddDate(50, 0, 0)
+	rcodes := []string{"NOERROR", "NOERROR", "NOERROR", "NXDOMAIN", "NXDOMAIN", "REFUSED", "", ""}
+	statuses := []string{"success", "success", "success", "success", "success", "local_domain", "all_upstreams_failed", "malformed_query"}
+
+	entries := make([]types.LogEntry, len(rcodes))
+	for i, rcode := range rcodes {
+		entries[i] = types.LogEntry{
+			Timestamp: since.Add(time.Duration(i) * time.Second),
+			UUID:      fmt.Sprintf("rcode-test-%d-%d", since.UnixNano(), i),
+			Request: types.RequestInfo{
+				Client: "192.0.2.10",
+				Query:  "example.com.",
+				Type:   "A",
+			},
+			Status: statuses[i],
+		}
+		if rcode != "" {
+			entries[i].Response = &types.ResponseInfo{Upstream: "8.8.8.8:53", Rcode: rcode}
+		}
+	}
+	seedLogs(t, client, entries)
+
+	breakdown, err := client.GetRcodeBreakdown(&since)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	expected := map[string]int64{"NOERROR": 3, "NXDOMAIN": 2, "REFUSED": 1, "SERVFAIL": 1, "FORMERR": 1}
+	if len(breakdown) != len(expected) {
+		t.Fatalf("Expected %d rcodes, got %v", len(expected), breakdown)
+	}
+	for _, rc := range breakdown {
+		if rc.Count != expected[rc.Rcode] {
+			t.Errorf("Expected %d %s responses, got %d", expected[rc.Rcode], rc.Rcode, rc.Count)
+		}
+	}
+	if breakdown[0].Rcode != "NOERROR" {
+		t.Errorf("Expected NOERROR first, got %s", breakdown[0].Rcode)
+	}
+}
+
+func TestGetUpstreamWins(t *testing.T) {
+	client := newTestClient(t)
+
+	// Seed far in the future so existing rows don't affect the counts
+	since := time.Now().AddDate(50, 0, 0)
+	upstreams := []string{"1.1.1.1:53", "8.8.8.8:53", "1.1.1.1:53", "1.1.1.1:53", ""}
+	statuses := []string{"success", "success", "success", "success", "all_upstreams_failed"}
+
+	entries := make([]types.LogEntry, len(upstreams))
+	for i, upstream := range upstreams {
+		entries[i] = types.LogEntry{
+			Timestamp: since.Add(time.Duration(i) * time.Second),
+			UUID:      fmt.Sprintf("wins-test-%d-%d", since.UnixNano(), i),
+			Request: types.RequestInfo{
+				Client: "192.0.2.10",
+				Query:  "example.com.",
+				Type:   "A",
+			},
+			Status: statuses[i],
+		}
+		if upstream != "" {
+			entries[i].Response = &types.ResponseInfo{Upstream: upstream, Rcode: "NOERROR"}
+		}
+	}
+	seedLogs(t, client, entries)
+
+	wins, err := client.GetUpstreamWins(&since)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	expected := []UpstreamWinMetric{{Server: "1.1.1.1:53", Wins: 3}, {Server: "8.8.8.8:53", Wins: 1}}
+	if len(wins) != len(expected) {
+		t.Fatalf("Expected %d upstreams, got %v", len(expected), wins)
+	}
+	for i, want := range expected {
+		if wins[i] != want {
+			t.Errorf("Expected wins[%d] = %+v, got %+v", i, want, wins[i])
+		}
+	}
+}
+
+// compressionTestEntry returns a log entry with upstream attempts and answers
+func compressionTestEntry(uuid string, timestamp time.Time) types.LogEntry {
+	rtt := 12.5
+	failure := "i/o timeout"
+	return types.LogEntry{
+		Timestamp: timestamp,
+		UUID:      uuid,
+		Request:   types.RequestInfo{Client: "192.0.2.10", Query: "example.com.", Type: "A"},
+		Upstreams: []types.UpstreamAttempt{
+			{Server: "8.8.8.8:53", Attempt: 1, Error: &failure, Duration: 200},
+			{Server: "1.1.1.1:53", Attempt: 2, RTT: &rtt, Duration: 12.5, Successful: true},
+		},
+		Answers:     [][]string{{"example.com.", "300", "IN", "A", "93.184.216.34"}},
+		IPAddresses: []string{"93.184.216.34"},
+		Status:      "success",
+	}
+}
+
+func TestCompressPayload_RoundTrip(t *testing.T) {
+	entry := compressionTestEntry("compress-roundtrip", time.Now().UTC())
+	log := toDNSLog(entry)
+
+	if err := compressPayload(log); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(log.CompressedPayload) == 0 || log.Upstreams != nil || log.Answers != nil {
+		t.Fatalf("Expected payload to move into the compressed column, got %+v", log)
+	}
+
+	restored := toLogEntry(log)
+
+	if len(restored.Upstreams) != 2 || !restored.Upstreams[1].Successful || *restored.Upstreams[0].Error != "i/o timeout" {
+		t.Errorf("Unexpected upstreams after round trip: %+v", restored.Upstreams)
+	}
+	if len(restored.Answers) != 1 || restored.Answers[0][4] != "93.184.216.34" {
+		t.Errorf("Unexpected answers after round trip: %v", restored.Answers)
+	}
+}
+
+func TestDecompressPayload_UncompressedRow(t *testing.T) {
+	log := toDNSLog(compressionTestEntry("uncompressed-row", time.Now().UTC()))
+
+	if err := decompressPayload(log); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if restored := toLogEntry(log); len(restored.Upstreams) != 2 || len(restored.Answers) != 1 {
+		t.Errorf("Expected JSONB columns to be read as before, got %+v", restored)
+	}
+}
+
+func TestSearchLogs_CompressedAndPlainRows(t *testing.T) {
+	client := newTestClient(t)
+
+	// Use a unique query name so existing rows don't show up in the search
+	now := time.Now().UTC()
+	domain := fmt.Sprintf("compress-%d.example.com.", now.UnixNano())
+
+	plain := compressionTestEntry("plain-"+domain, now)
+	plain.Request.Query = domain
+	seedLogs(t, client, []types.LogEntry{plain})
+
+	client.compressPayloads = true
+	compressed := compressionTestEntry("compressed-"+domain, now)
+	compressed.Request.Query = domain
+	seedLogs(t, client, []types.LogEntry{compressed})
+
+	result, err := client.SearchLogs(domain, "", 10, 0, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	found := 0
+	for _, entry := range result.Results {
+		if entry.UUID != plain.UUID && entry.UUID != compressed.UUID {
+			continue
+		}
+		found++
+		if len(entry.Upstreams) != 2 || len(entry.Answers) != 1 {
+			t.Errorf("Entry %s: expected upstreams and answers to be readable, got %+v", entry.UUID, entry)
+		}
+	}
+	if found != 2 {
+		t.Errorf("Expected both seeded entries, found %d", found)
+	}
+}
+
+func TestGetDomainTTLs(t *testing.T) {
+	client := newTestClient(t)
+
+	now := time.Now().UTC()
+	domain := fmt.Sprintf("ttl-%d.example.com.", now.UnixNano())
+	answers := [][][]string{
+		{{domain, "300", "IN", "A", "192.0.2.1"}, {domain, "100", "IN", "A", "192.0.2.2"}},
+		{{domain, "200", "IN", "A", "192.0.2.1"}},
+	}
+
+	entries := make([]types.LogEntry, len(answers))
+	for i, answer := range answers {
+		entries[i] = types.LogEntry{
+			Timestamp: now,
+			UUID:      fmt.Sprintf("%s-%d", domain, i),
+			Request:   types.RequestInfo{Client: "192.0.2.10", Query: domain, Type: "A"},
+			Answers:   answer,
+			Status:    "success",
+		}
+	}
+	seedLogs(t, client, entries)
+
+	ttls, err := client.GetDomainTTLs(nil, domain, 10)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(ttls) != 1 {
+		t.Fatalf("Expected TTL stats for one domain, got %v", ttls)
+	}
+
+	got := ttls[0]
+	if got.Domain != domain || got.MinTTL != 100 || got.MaxTTL != 300 || got.AvgTTL != 200 || got.Records != 3 {
+		t.Errorf("Unexpected TTL stats: %+v", got)
+	}
+}
+
+func TestGetLatencyPercentiles(t *testing.T) {
+	client := newTestClient(t)
+
+	// Seed far in the future so existing rows don't affect the percentiles
+	since := time.Now().AddDate(50, 0, 0)
+	entries := make([]types.LogEntry, 100)
+	for i := range entries {
+		entries[i] = types.LogEntry{
+			Timestamp: since.Add(time.Duration(i) * time.Second),
+			UUID:      fmt.Sprintf("latency-test-%d-%d", since.UnixNano(), i),
+			Request:   types.RequestInfo{Client: "192.0.2.10", Query: "example.com.", Type: "A"},
+			Status:    "success",
+			Duration:  float64(i + 1),
+		}
+	}
+	seedLogs(t, client, entries)
+
+	latency, err := client.GetLatencyPercentiles(&since)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	// PERCENTILE_CONT interpolates between the two closest durations
+	expected := LatencyPercentiles{P50: 50.5, P95: 95.05, P99: 99.01}
+	const tolerance = 1e-9
+	for _, got := range []struct {
+		name        string
+		value, want float64
+	}{
+		{"p50", latency.P50, expected.P50},
+		{"p95", latency.P95, expected.P95},
+		{"p99", latency.P99, expected.P99},
+	} {
+		if diff := got.value - got.want; diff > tolerance || diff < -tolerance {
+			t.Errorf("Expected %s of %v ms, got %v", got.name, got.want, got.value)
+		}
+	}
+
+	empty := since.AddDate(1, 0, 0)
+	latency, err = client.GetLatencyPercentiles(&empty)
+	if err != nil {
+		t.Fatalf("Expected no error without rows, got: %v", err)
+	}
+	if *latency != (LatencyPercentiles{}) {
+		t.Errorf("Expected zero percentiles without rows, got %+v", latency)
+	}
+}
+
+func TestInsertLogEntriesBatch(t *testing.T) {
+	client := newTestClient(t)
+
+	now := time.Now().UTC()
+	prefix := fmt.Sprintf("batch-test-%d", now.UnixNano())
+	entry := func(i int) types.LogEntry {
+		return types.LogEntry{
+			Timestamp: now,
+			UUID:      fmt.Sprintf("%s-%d", prefix, i),
+			Request:   types.RequestInfo{Client: "192.0.2.10", Query: "example.com.", Type: "A"},
+			Status:    "success",
+		}
+	}
+	t.Cleanup(func() {
+		client.conn().Where("uuid LIKE ?", prefix+"-%").Delete(&DNSLog{})
+	})
+
+	// An entry stored earlier and a UUID repeated within the batch are skipped
+	if err := client.InsertLogEntry(entry(0)); err != nil {
+		t.Fatalf("Failed to seed log entry: %v", err)
+	}
+	batch := []types.LogEntry{entry(0), entry(1), entry(2), entry(3), entry(2)}
+
+	inserted, err := client.InsertLogEntriesBatch(batch)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if inserted != 3 {
+		t.Errorf("Expected 3 rows inserted, got %d", inserted)
+	}
+
+	var count int64
+	if err := client.conn().Model(&DNSLog{}).Where("uuid LIKE ?", prefix+"-%").Count(&count).Error; err != nil {
+		t.Fatalf("Failed to count rows: %v", err)
+	}
+	if count != 4 {
+		t.Errorf("Expected 4 stored rows, got %d", count)
+	}
+
+	inserted, err = client.InsertLogEntriesBatch(batch)
+	if err != nil || inserted != 0 {
+		t.Errorf("Expected an already stored batch to insert nothing, got %d, %v", inserted, err)
+	}
+}
+
+func TestPurgeLogsOlderThan(t *testing.T) {
+	client := newTestClient(t)
+
+	// Seed old rows decades back so the purge leaves real logs alone
+	now := time.Now().UTC()
+	prefix := fmt.Sprintf("purge-test-%d", now.UnixNano())
+	old := now.AddDate(-60, 0, 0)
+	var entries []types.LogEntry
+	for i := 0; i < 7; i++ {
+		entries = append(entries, types.LogEntry{
+			Timestamp: old.Add(time.Duration(i) * time.Minute),
+			UUID:      fmt.Sprintf("%s-old-%d", prefix, i),
+			Request:   types.RequestInfo{Client: "192.0.2.10", Query: "example.com.", Type: "A"},
+			Status:    "success",
+		})
+	}
+	recent := types.LogEntry{
+		Timestamp: now,
+		UUID:      prefix + "-recent",
+		Request:   types.RequestInfo{Client: "192.0.2.10", Query: "example.com.", Type: "A"},
+		Status:    "success",
+	}
+	seedLogs(t, client, append(entries, recent))
+
+	// A batch size below the number of old rows deletes them over several statements
+	deleted, err := client.purgeLogsOlderThan(50*365*24*time.Hour, 3)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if deleted != int64(len(entries)) {
+		t.Errorf("Expected %d old rows deleted, got %d", len(entries), deleted)
+	}
+
+	var remaining []DNSLog
+	if err := client.conn().Where("uuid LIKE ?", prefix+"-%").Find(&remaining).Error; err != nil {
+		t.Fatalf("Failed to read remaining rows: %v", err)
+	}
+	if len(remaining) != 1 || remaining[0].UUID != recent.UUID {
+		t.Errorf("Expected only the recent row to remain, got %d rows", len(remaining))
+	}
+}
+
+func TestPingContext(t *testing.T) {
+	client := newTestClient(t)
+
+	if err := client.PingContext(context.Background()); err != nil {
+		t.Fatalf("Expected the ping to succeed, got: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := client.PingContext(ctx); err == nil {
+		t.Error("Expected a ping with a canceled context to fail")
+	}
+}
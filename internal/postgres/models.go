@@ -24,6 +24,7 @@ type DNSLog struct {
 	ResponseRTTMs       *float64    `gorm:"type:double precision"`
 	Upstreams           JSONB       `gorm:"type:jsonb"`
 	Answers             JSONB       `gorm:"type:jsonb"`
+	CompressedPayload   []byte      `gorm:"type:bytea"` // gzip-compressed upstreams and answers, see compressPayload
 	IPAddresses         StringArray `gorm:"type:inet[]"`
 	CreatedAt           time.Time   `gorm:"type:timestamp;default:CURRENT_TIMESTAMP"`
 }
@@ -37,7 +38,7 @@ func (DNSLog) TableName() string {
 type DNSMapping struct {
 	ID        uint      `gorm:"primaryKey;autoIncrement"`
 	Domain    string    `gorm:"type:varchar(255);uniqueIndex;not null"`
-	IPAddress string    `gorm:"type:varchar(45);not null"`
+	IPAddress string    `gorm:"type:text;not null"` // comma-separated when several
 	CreatedAt time.Time `gorm:"type:timestamp;default:CURRENT_TIMESTAMP"`
 	UpdatedAt time.Time `gorm:"type:timestamp;default:CURRENT_TIMESTAMP"`
 }
@@ -0,0 +1,175 @@
+package resolver
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// hostsFileNames are the entries of a stock hosts file that blocklists in hosts
+// format commonly carry over; they are never blocked
+var hostsFileNames = map[string]bool{
+	"localhost.":             true,
+	"localhost.localdomain.": true,
+	"local.":                 true,
+	"broadcasthost.":         true,
+	"ip6-localhost.":         true,
+	"ip6-loopback.":          true,
+	"ip6-localnet.":          true,
+	"ip6-mcastprefix.":       true,
+	"ip6-allnodes.":          true,
+	"ip6-allrouters.":        true,
+	"ip6-allhosts.":          true,
+}
+
+// Blocklist answers queries for blocked domains and their subdomains with
+// NXDOMAIN, or with sinkhole addresses when configured
+type Blocklist struct {
+	mu       sync.RWMutex
+	domains  map[string]bool
+	sinkhole []net.IP
+}
+
+// NewBlocklist creates a blocklist of the given domains
+func NewBlocklist(domains []string) *Blocklist {
+	b := &Blocklist{}
+	b.SetDomains(domains)
+	return b
+}
+
+// SetDomains replaces the blocked domains, e.g. after the blocklist file changed
+func (b *Blocklist) SetDomains(domains []string) {
+	set := make(map[string]bool, len(domains))
+	for _, domain := range domains {
+		set[strings.ToLower(dns.Fqdn(domain))] = true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.domains = set
+}
+
+// SetSinkhole makes blocked A and AAAA queries get the addresses of the
+// matching family (e.g. 0.0.0.0 and ::) instead of NXDOMAIN. Other query types
+// get an empty answer. Invalid addresses are skipped; they are rejected by
+// config.Validate before the server starts.
+func (b *Blocklist) SetSinkhole(addrs []string) {
+	var sinkhole []net.IP
+	for _, addr := range addrs {
+		if ip := net.ParseIP(addr); ip != nil {
+			sinkhole = append(sinkhole, ip)
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.sinkhole = sinkhole
+}
+
+// Blocked reports whether name is a blocked domain or one of its subdomains
+func (b *Blocklist) Blocked(name string) bool {
+	name = strings.ToLower(dns.Fqdn(name))
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for offset, end := 0, false; !end; offset, end = dns.NextLabel(name, offset) {
+		if b.domains[name[offset:]] {
+			return true
+		}
+	}
+	return false
+}
+
+// Response returns the answer to a question about a blocked name, or nil when
+// the name is not blocked
+func (b *Blocklist) Response(question dns.Question) *dns.Msg {
+	if !b.Blocked(question.Name) {
+		return nil
+	}
+
+	msg := &dns.Msg{}
+	msg.SetReply(&dns.Msg{Question: []dns.Question{question}})
+	msg.Authoritative = true
+
+	b.mu.RLock()
+	sinkhole := b.sinkhole
+	b.mu.RUnlock()
+
+	if len(sinkhole) == 0 {
+		msg.Rcode = dns.RcodeNameError
+		return msg
+	}
+
+	for _, ip := range sinkhole {
+		hdr := dns.RR_Header{
+			Name:   question.Name,
+			Rrtype: question.Qtype,
+			Class:  dns.ClassINET,
+			Ttl:    300, // 5 minutes TTL
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			if question.Qtype == dns.TypeA {
+				msg.Answer = append(msg.Answer, &dns.A{Hdr: hdr, A: ip4})
+			}
+		} else if question.Qtype == dns.TypeAAAA {
+			msg.Answer = append(msg.Answer, &dns.AAAA{Hdr: hdr, AAAA: ip})
+		}
+	}
+
+	return msg
+}
+
+// LoadBlocklistFile reads the domains of a blocklist file, see ParseBlocklist
+func LoadBlocklistFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open blocklist file %s: %w", path, err)
+	}
+	defer file.Close()
+
+	domains, err := ParseBlocklist(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blocklist file %s: %w", path, err)
+	}
+	return domains, nil
+}
+
+// ParseBlocklist reads blocked domains in hosts file format (an address
+// followed by one or more names, e.g. 0.0.0.0 ads.example.com) or as a plain
+// list with one domain per line. Comments starting with #, malformed lines,
+// invalid names and stock hosts file entries such as localhost are ignored.
+func ParseBlocklist(r io.Reader) ([]string, error) {
+	domains := make([]string, 0)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if net.ParseIP(fields[0]) != nil {
+			fields = fields[1:]
+		} else if len(fields) > 1 {
+			// Neither a hosts file entry nor a single domain
+			continue
+		}
+
+		for _, name := range fields {
+			name = strings.ToLower(dns.Fqdn(name))
+			if _, ok := dns.IsDomainName(name); !ok || name == "." || hostsFileNames[name] || net.ParseIP(strings.TrimSuffix(name, ".")) != nil {
+				continue
+			}
+			domains = append(domains, name)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return domains, nil
+}
@@ -0,0 +1,83 @@
+package resolver
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestBlocklist_Blocked(t *testing.T) {
+	b := NewBlocklist([]string{"ads.example.com", "Tracker.NET."})
+
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{name: "ads.example.com.", want: true},
+		{name: "ADS.example.com", want: true},
+		{name: "cdn.ads.example.com.", want: true},
+		{name: "x.tracker.net.", want: true},
+		{name: "example.com."},
+		{name: "notads.example.com."},
+		{name: "ads.example.com.evil."},
+		{name: "."},
+	}
+
+	for _, tt := range tests {
+		if got := b.Blocked(tt.name); got != tt.want {
+			t.Errorf("Blocked(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBlocklist_Response(t *testing.T) {
+	b := NewBlocklist([]string{"ads.example.com."})
+
+	if resp := b.Response(dns.Question{Name: "example.com.", Qtype: dns.TypeA, Qclass: dns.ClassINET}); resp != nil {
+		t.Errorf("Expected nil for a domain that is not blocked, got %v", resp)
+	}
+
+	question := dns.Question{Name: "www.ads.example.com.", Qtype: dns.TypeA, Qclass: dns.ClassINET}
+	if resp := b.Response(question); resp == nil || resp.Rcode != dns.RcodeNameError {
+		t.Errorf("Expected NXDOMAIN without a sinkhole, got %v", resp)
+	}
+
+	b.SetSinkhole([]string{"0.0.0.0", "::"})
+	resp := b.Response(question)
+	if resp == nil || resp.Rcode != dns.RcodeSuccess || len(resp.Answer) != 1 {
+		t.Fatalf("Expected a single sinkhole record, got %v", resp)
+	}
+	if a := resp.Answer[0].(*dns.A); !a.A.Equal(net.IPv4zero) {
+		t.Errorf("Expected 0.0.0.0, got %s", a.A)
+	}
+
+	resp = b.Response(dns.Question{Name: "ads.example.com.", Qtype: dns.TypeMX, Qclass: dns.ClassINET})
+	if resp == nil || resp.Rcode != dns.RcodeSuccess || len(resp.Answer) != 0 {
+		t.Errorf("Expected NODATA for other query types, got %v", resp)
+	}
+}
+
+func TestParseBlocklist(t *testing.T) {
+	data := `# Hosts file format
+127.0.0.1 localhost
+::1 ip6-localhost ip6-loopback
+0.0.0.0 0.0.0.0
+0.0.0.0 ads.example.com tracker.example.com # trailing comment
+:: ipv6.ads.example
+
+# Plain list
+Malware.Example.
+not a..valid name
+`
+	domains, err := ParseBlocklist(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	want := "ads.example.com. tracker.example.com. ipv6.ads.example. malware.example."
+	if got := strings.Join(domains, " "); got != want {
+		t.Errorf("Expected domains %q, got %q", want, got)
+	}
+}
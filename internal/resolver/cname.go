@@ -0,0 +1,91 @@
+package resolver
+
+import (
+	"strings"
+
+	"dns-go/internal/mapping"
+
+	"github.com/miekg/dns"
+)
+
+// maxCNAMEChain bounds how many aliases are followed within the custom
+// mappings, so cyclic mappings cannot loop forever
+const maxCNAMEChain = 8
+
+// CNAMETarget returns the fully qualified alias target of a custom DNS mapping
+// value, and whether the value is an alias at all
+func CNAMETarget(value string) (string, bool) {
+	values, _ := splitTTL(value, 0)
+	if len(values) != 1 {
+		return "", false
+	}
+	value = values[0]
+	if !strings.HasPrefix(strings.ToLower(value), mapping.CNAMEPrefix) {
+		return "", false
+	}
+	target := strings.TrimSpace(value[len(mapping.CNAMEPrefix):])
+	if _, ok := dns.IsDomainName(target); !ok || target == "" {
+		return "", false
+	}
+	return dns.Fqdn(target), true
+}
+
+// cnameRecord returns the CNAME record aliasing domain to target
+func cnameRecord(domain, target string, ttl uint32) dns.RR {
+	return &dns.CNAME{
+		Hdr: dns.RR_Header{
+			Name:   domain,
+			Rrtype: dns.TypeCNAME,
+			Class:  dns.ClassINET,
+			Ttl:    ttl,
+		},
+		Target: target,
+	}
+}
+
+// followCNAMEs adds the CNAME records of an alias chain starting at domain to
+// msg, following targets that are custom mappings themselves. It returns the
+// last name of the chain and its mapping value, or ok=false when the records
+// for the final name cannot be added: the chain leaves the custom mappings, the
+// question is for the CNAME itself, or the chain is too long (SERVFAIL). Each
+// CNAME record gets the TTL of its mapping, or ttl.
+func (r *LocalResolver) followCNAMEs(msg *dns.Msg, domain, value string, qtype uint16, ttl uint32) (string, string, bool) {
+	for depth := 0; ; depth++ {
+		target, isAlias := CNAMETarget(value)
+		if !isAlias {
+			return domain, value, true
+		}
+		_, aliasTTL := splitTTL(value, ttl)
+		if qtype == dns.TypeCNAME && depth == 0 {
+			msg.Answer = append(msg.Answer, cnameRecord(domain, target, aliasTTL))
+			return "", "", false
+		}
+		if depth >= maxCNAMEChain {
+			msg.Rcode = dns.RcodeServerFailure
+			return "", "", false
+		}
+		msg.Answer = append(msg.Answer, cnameRecord(domain, target, aliasTTL))
+
+		next, exists := r.lookup(target)
+		if !exists {
+			// The target is resolved elsewhere; the client follows the alias
+			return "", "", false
+		}
+		domain, value = target, next
+	}
+}
+
+// lookup returns the mapping value of a domain, from an exact or regex mapping
+func (r *LocalResolver) lookup(domain string) (string, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.lookupLocked(domain)
+}
+
+// lookupLocked is lookup for callers holding r.mu
+func (r *LocalResolver) lookupLocked(domain string) (string, bool) {
+	if value, exists := r.mappings[domain]; exists {
+		return value, true
+	}
+	return matchPattern(r.patterns, domain)
+}
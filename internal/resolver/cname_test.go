@@ -0,0 +1,97 @@
+package resolver
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestResolve_CNAMEMapping(t *testing.T) {
+	r := New(map[string]string{"app.local.": "cname:www.example.com"})
+
+	// The target is not mapped, so only the alias is answered
+	resp := r.Resolve(dns.Question{Name: "app.local.", Qtype: dns.TypeA, Qclass: dns.ClassINET})
+	if resp == nil || resp.Rcode != dns.RcodeSuccess || len(resp.Answer) != 1 {
+		t.Fatalf("Expected a single CNAME answer, got %v", resp)
+	}
+	cname, ok := resp.Answer[0].(*dns.CNAME)
+	if !ok || cname.Hdr.Name != "app.local." || cname.Target != "www.example.com." {
+		t.Errorf("Expected app.local. CNAME www.example.com., got %v", resp.Answer[0])
+	}
+
+	resp = r.Resolve(dns.Question{Name: "app.local.", Qtype: dns.TypeCNAME, Qclass: dns.ClassINET})
+	if resp == nil || len(resp.Answer) != 1 || resp.Answer[0].Header().Rrtype != dns.TypeCNAME {
+		t.Errorf("Expected the CNAME record for a CNAME query, got %v", resp)
+	}
+}
+
+func TestResolve_CNAMEChain(t *testing.T) {
+	for _, cache := range []bool{true, false} {
+		r := New(map[string]string{
+			"app.local.":    "cname:web.local",
+			"web.local.":    "cname:server.local.",
+			"server.local.": "192.168.0.30,192.168.0.31",
+		})
+		r.SetResponseCache(cache)
+
+		for i := 0; i < 2; i++ {
+			resp := r.Resolve(dns.Question{Name: "app.local.", Qtype: dns.TypeA, Qclass: dns.ClassINET})
+			if resp == nil || resp.Rcode != dns.RcodeSuccess || len(resp.Answer) != 4 {
+				t.Fatalf("cache=%v: expected 2 CNAME and 2 A records, got %v", cache, resp)
+			}
+
+			// Aliases stay in chain order ahead of the rotated addresses
+			first, ok1 := resp.Answer[0].(*dns.CNAME)
+			second, ok2 := resp.Answer[1].(*dns.CNAME)
+			if !ok1 || !ok2 || first.Target != "web.local." || second.Hdr.Name != "web.local." || second.Target != "server.local." {
+				t.Fatalf("cache=%v: expected app.local. -> web.local. -> server.local., got %v", cache, resp.Answer)
+			}
+			for _, rr := range resp.Answer[2:] {
+				if a, ok := rr.(*dns.A); !ok || a.Hdr.Name != "server.local." {
+					t.Errorf("cache=%v: expected A records for server.local., got %v", cache, rr)
+				}
+			}
+		}
+
+		// The target has no IPv6 address: the chain is answered without records
+		resp := r.Resolve(dns.Question{Name: "app.local.", Qtype: dns.TypeAAAA, Qclass: dns.ClassINET})
+		if resp == nil || resp.Rcode != dns.RcodeSuccess || len(resp.Answer) != 2 {
+			t.Errorf("cache=%v: expected the CNAME chain only, got %v", cache, resp)
+		}
+	}
+}
+
+func TestResolve_CNAMECycle(t *testing.T) {
+	r := New(map[string]string{
+		"a.local.": "cname:b.local",
+		"b.local.": "cname:a.local",
+	})
+
+	resp := r.Resolve(dns.Question{Name: "a.local.", Qtype: dns.TypeA, Qclass: dns.ClassINET})
+	if resp == nil || resp.Rcode != dns.RcodeServerFailure {
+		t.Fatalf("Expected SERVFAIL for a cyclic alias, got %v", resp)
+	}
+	if len(resp.Answer) != maxCNAMEChain {
+		t.Errorf("Expected the chain to stop after %d aliases, got %d records", maxCNAMEChain, len(resp.Answer))
+	}
+}
+
+func TestCNAMETarget(t *testing.T) {
+	tests := []struct {
+		value      string
+		wantTarget string
+		wantAlias  bool
+	}{
+		{value: "cname:server.local", wantTarget: "server.local.", wantAlias: true},
+		{value: " CNAME: server.local. ", wantTarget: "server.local.", wantAlias: true},
+		{value: "192.168.0.30"},
+		{value: "cname:"},
+	}
+
+	for _, tt := range tests {
+		target, alias := CNAMETarget(tt.value)
+		if target != tt.wantTarget || alias != tt.wantAlias {
+			t.Errorf("CNAMETarget(%q) = %q, %v; want %q, %v", tt.value, target, alias, tt.wantTarget, tt.wantAlias)
+		}
+	}
+}
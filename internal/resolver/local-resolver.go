@@ -3,14 +3,47 @@ package resolver
 
 import (
 	"net"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
+
+	"dns-go/internal/mapping"
 
 	"github.com/miekg/dns"
 )
 
+// maxCachedResponses bounds the synthesized response cache; once full, further
+// responses are built on every query until the mappings change
+const maxCachedResponses = 4096
+
 // LocalResolver handles custom DNS mappings
 type LocalResolver struct {
+	mu       sync.RWMutex
 	mappings map[string]string
+	patterns []patternMapping // regex-keyed mappings, tried after exact matches
+	ttl      uint32           // TTL of mappings without a ttl: value
+
+	// Domains of the mapped addresses by reverse name, see reverseIndex
+	reverse map[string]string
+
+	// Synthesized responses keyed by name and query type, reset by UpdateMappings
+	cacheEnabled bool
+	cache        map[responseKey]*dns.Msg
+	generation   uint64 // incremented by UpdateMappings
+
+	// Custom zones answered with NS and SOA records at their apex
+	zones      map[string]bool
+	zoneConfig ZoneConfig
+
+	// rotation shifts the order of multi-record answers on each response
+	rotation uint64 // atomic
+}
+
+// responseKey identifies a synthesized response
+type responseKey struct {
+	name  string
+	qtype uint16
 }
 
 // New creates a new LocalResolver with the given custom DNS mappings
@@ -22,79 +55,213 @@ func New(customMappings map[string]string) *LocalResolver {
 	}
 
 	return &LocalResolver{
-		mappings: mappings,
+		mappings:     mappings,
+		patterns:     compilePatterns(mappings),
+		reverse:      reverseIndex(mappings),
+		ttl:          DefaultTTL,
+		cacheEnabled: true,
+		cache:        make(map[responseKey]*dns.Msg),
 	}
 }
 
+// SetResponseCache enables or disables caching of synthesized responses
+func (r *LocalResolver) SetResponseCache(enabled bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.cacheEnabled = enabled
+	r.cache = make(map[responseKey]*dns.Msg)
+}
+
 // Resolve attempts to resolve a DNS question using custom mappings.
 // Returns a DNS response if a mapping exists, nil otherwise.
 func (r *LocalResolver) Resolve(question dns.Question) *dns.Msg {
+	if msg := r.resolveZoneApex(question); msg != nil {
+		return msg
+	}
+
 	// Normalize the domain name (ensure it ends with a dot)
 	domain := question.Name
 	if !strings.HasSuffix(domain, ".") {
 		domain += "."
 	}
 
+	key := responseKey{name: domain, qtype: question.Qtype}
+
+	r.mu.RLock()
+	ip, exists := r.lookupLocked(domain)
+	if !exists && question.Qtype == dns.TypePTR {
+		// Answer reverse lookups of mapped addresses with their domains
+		ip, exists = r.reverse[strings.ToLower(domain)]
+	}
+	cached := r.cache[key]
+	generation := r.generation
+	ttl := r.ttl
+	r.mu.RUnlock()
+
 	// Check if we have a custom mapping for this domain
-	ip, exists := r.mappings[domain]
 	if !exists {
 		return nil
 	}
 
+	if cached != nil {
+		return r.reuseResponse(cached, question)
+	}
+
+	msg := r.synthesize(domain, ip, question, ttl)
+
+	r.mu.Lock()
+	// Only cache if the mappings were not replaced while building the response
+	if r.cacheEnabled && len(r.cache) < maxCachedResponses && r.generation == generation {
+		r.cache[key] = msg
+	}
+	r.mu.Unlock()
+
+	return r.reuseResponse(msg, question)
+}
+
+// reuseResponse returns a shallow copy of a synthesized response for the given
+// question, so callers can set the message ID without touching the cached
+// message. Answers with several records are rotated by one position on each
+// response for simple client-side load balancing, keeping leading CNAME records
+// in place. Answer records are shared and must not be modified.
+func (r *LocalResolver) reuseResponse(cached *dns.Msg, question dns.Question) *dns.Msg {
+	msg := new(dns.Msg)
+	*msg = *cached
+	msg.Question = []dns.Question{question}
+
+	aliases := 0
+	for aliases < len(cached.Answer) && cached.Answer[aliases].Header().Rrtype == dns.TypeCNAME {
+		aliases++
+	}
+
+	if n := len(cached.Answer) - aliases; n > 1 {
+		offset := int((atomic.AddUint64(&r.rotation, 1) - 1) % uint64(n))
+		msg.Answer = make([]dns.RR, len(cached.Answer))
+		copy(msg.Answer, cached.Answer[:aliases])
+		for i := 0; i < n; i++ {
+			msg.Answer[aliases+i] = cached.Answer[aliases+(i+offset)%n]
+		}
+	}
+	return msg
+}
+
+// synthesize builds the response for a question about a mapped domain, with
+// one record per value of the mapping that fits the query type: addresses for
+// A and AAAA, host names for PTR and typed values for TXT, MX and SRV. Aliases
+// are answered with their CNAME records, followed by the records of the target
+// when it is mapped too. Records get the mapping's ttl: value, or ttl.
+func (r *LocalResolver) synthesize(domain, value string, question dns.Question, ttl uint32) *dns.Msg {
 	// Create DNS response
 	msg := &dns.Msg{}
 	msg.SetReply(&dns.Msg{Question: []dns.Question{question}})
 	msg.Authoritative = true
 
-	// Handle different query types
-	switch question.Qtype {
+	domain, value, ok := r.followCNAMEs(msg, domain, value, question.Qtype, ttl)
+	if !ok {
+		return msg
+	}
+
+	values, ttl := splitTTL(value, ttl)
+	for _, v := range values {
+		if rr := mappingRecord(domain, v, question.Qtype, ttl); rr != nil {
+			msg.Answer = append(msg.Answer, rr)
+		}
+	}
+
+	// No value of the requested type (e.g. no IPv6 address) leaves an empty
+	// NOERROR (NODATA) answer: the domain exists, the record type doesn't
+	return msg
+}
+
+// mappingRecord returns the record answering a query of type qtype for domain
+// from a single mapping value, or nil when the value does not fit the type
+func mappingRecord(domain, value string, qtype uint16, ttl uint32) dns.RR {
+	if RecordType(value) != 0 {
+		// Typed values (txt:, mx:, srv:) only answer queries of their own type
+		if rr, err := RecordValue(domain, value, ttl); err == nil && rr.Header().Rrtype == qtype {
+			return rr
+		}
+		return nil
+	}
+
+	switch qtype {
 	case dns.TypeA:
 		// IPv4 address query
-		if parsedIP := net.ParseIP(ip); parsedIP != nil && parsedIP.To4() != nil {
-			rr := &dns.A{
+		if parsedIP := net.ParseIP(value); parsedIP != nil && parsedIP.To4() != nil {
+			return &dns.A{
 				Hdr: dns.RR_Header{
 					Name:   domain,
 					Rrtype: dns.TypeA,
 					Class:  dns.ClassINET,
-					Ttl:    300, // 5 minutes TTL
+					Ttl:    ttl,
 				},
 				A: parsedIP.To4(),
 			}
-			msg.Answer = append(msg.Answer, rr)
-		} else {
-			// Invalid IPv4 address, return NXDOMAIN
-			msg.SetRcode(&dns.Msg{Question: []dns.Question{question}}, dns.RcodeNameError)
 		}
 
 	case dns.TypeAAAA:
 		// IPv6 address query
-		if parsedIP := net.ParseIP(ip); parsedIP != nil && parsedIP.To16() != nil && parsedIP.To4() == nil {
-			rr := &dns.AAAA{
+		if parsedIP := net.ParseIP(value); parsedIP != nil && parsedIP.To16() != nil && parsedIP.To4() == nil {
+			return &dns.AAAA{
 				Hdr: dns.RR_Header{
 					Name:   domain,
 					Rrtype: dns.TypeAAAA,
 					Class:  dns.ClassINET,
-					Ttl:    300, // 5 minutes TTL
+					Ttl:    ttl,
 				},
 				AAAA: parsedIP.To16(),
 			}
-			msg.Answer = append(msg.Answer, rr)
-		} else {
-			// No IPv6 address or invalid, return NXDOMAIN
-			msg.SetRcode(&dns.Msg{Question: []dns.Question{question}}, dns.RcodeNameError)
 		}
 
 	case dns.TypePTR:
-		// Reverse DNS lookup - not supported for custom mappings
-		msg.SetRcode(&dns.Msg{Question: []dns.Question{question}}, dns.RcodeNameError)
+		// Reverse DNS lookup - answered when the mapping value is a host name
+		// (e.g. 10.0.168.192.in-addr.arpa=nas.lan)
+		if _, ok := dns.IsDomainName(value); ok && net.ParseIP(value) == nil {
+			return &dns.PTR{
+				Hdr: dns.RR_Header{
+					Name:   domain,
+					Rrtype: dns.TypePTR,
+					Class:  dns.ClassINET,
+					Ttl:    ttl,
+				},
+				Ptr: dns.Fqdn(value),
+			}
+		}
+	}
+
+	return nil
+}
 
-	default:
-		// For other query types, return NXDOMAIN if we have an IP mapping
-		// This indicates the domain exists but the requested record type doesn't
-		msg.SetRcode(&dns.Msg{Question: []dns.Question{question}}, dns.RcodeNameError)
+// reverseIndex maps the reverse names (in-addr.arpa and ip6.arpa) of the
+// addresses in the mappings to the domains mapped to them, as mapping values
+// answering PTR queries. Regex mappings have no domain to point to and are
+// skipped.
+func reverseIndex(mappings map[string]string) map[string]string {
+	domains := make(map[string][]string)
+	for domain, value := range mappings {
+		if mapping.IsPatternKey(domain) {
+			continue
+		}
+		values, _ := splitTTL(value, 0)
+		for _, v := range values {
+			if net.ParseIP(v) == nil {
+				continue
+			}
+			reverse, err := dns.ReverseAddr(v)
+			if err != nil {
+				continue
+			}
+			domains[reverse] = append(domains[reverse], strings.ToLower(dns.Fqdn(domain)))
+		}
 	}
 
-	return msg
+	index := make(map[string]string, len(domains))
+	for reverse, names := range domains {
+		sort.Strings(names)
+		index[reverse] = strings.Join(names, mapping.ValueSeparator)
+	}
+	return index
 }
 
 // HasMapping returns true if the resolver has a custom mapping for the given domain
@@ -103,12 +270,16 @@ func (r *LocalResolver) HasMapping(domain string) bool {
 	if !strings.HasSuffix(domain, ".") {
 		domain += "."
 	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	_, exists := r.mappings[domain]
 	return exists
 }
 
 // GetMappings returns a copy of all current mappings
 func (r *LocalResolver) GetMappings() map[string]string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	mappings := make(map[string]string, len(r.mappings))
 	for domain, ip := range r.mappings {
 		mappings[domain] = ip
@@ -116,10 +287,107 @@ func (r *LocalResolver) GetMappings() map[string]string {
 	return mappings
 }
 
-// UpdateMappings replaces all current mappings with the provided ones
+// UpdateMappings replaces all current mappings with the provided ones and drops
+// any cached responses
 func (r *LocalResolver) UpdateMappings(newMappings map[string]string) {
-	r.mappings = make(map[string]string, len(newMappings))
+	mappings := make(map[string]string, len(newMappings))
 	for domain, ip := range newMappings {
-		r.mappings[domain] = ip
+		mappings[domain] = ip
 	}
+
+	patterns := compilePatterns(mappings)
+	reverse := reverseIndex(mappings)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.mappings = mappings
+	r.patterns = patterns
+	r.reverse = reverse
+	r.cache = make(map[responseKey]*dns.Msg)
+	r.generation++
+}
+
+// Loopback builds an authoritative response answering A and AAAA questions with
+// the IPv4 and IPv6 loopback addresses. Other query types get an empty NOERROR
+// (NODATA) response.
+func Loopback(question dns.Question) *dns.Msg {
+	msg := &dns.Msg{}
+	msg.SetReply(&dns.Msg{Question: []dns.Question{question}})
+	msg.Authoritative = true
+
+	switch question.Qtype {
+	case dns.TypeA:
+		msg.Answer = append(msg.Answer, &dns.A{
+			Hdr: dns.RR_Header{
+				Name:   question.Name,
+				Rrtype: dns.TypeA,
+				Class:  dns.ClassINET,
+				Ttl:    300, // 5 minutes TTL
+			},
+			A: net.IPv4(127, 0, 0, 1).To4(),
+		})
+	case dns.TypeAAAA:
+		msg.Answer = append(msg.Answer, &dns.AAAA{
+			Hdr: dns.RR_Header{
+				Name:   question.Name,
+				Rrtype: dns.TypeAAAA,
+				Class:  dns.ClassINET,
+				Ttl:    300, // 5 minutes TTL
+			},
+			AAAA: net.IPv6loopback,
+		})
+	}
+
+	return msg
+}
+
+// localhostName is the special-use name (RFC 6761) that always refers to the local host
+const localhostName = "localhost."
+
+// loopbackReverseNames are the PTR names of the IPv4 and IPv6 loopback addresses
+var loopbackReverseNames = map[string]bool{
+	mustReverseAddr("127.0.0.1"): true,
+	mustReverseAddr("::1"):       true,
+}
+
+// mustReverseAddr returns the in-addr.arpa or ip6.arpa name of a literal IP address
+func mustReverseAddr(ip string) string {
+	name, err := dns.ReverseAddr(ip)
+	if err != nil {
+		panic(err)
+	}
+	return name
+}
+
+// Localhost answers queries for localhost and its subdomains with loopback
+// addresses, and PTR queries for the loopback addresses with localhost.
+// Returns nil for any other name.
+func Localhost(question dns.Question) *dns.Msg {
+	name := strings.ToLower(dns.Fqdn(question.Name))
+
+	if dns.IsSubDomain(localhostName, name) {
+		return Loopback(question)
+	}
+
+	if !loopbackReverseNames[name] {
+		return nil
+	}
+
+	msg := &dns.Msg{}
+	msg.SetReply(&dns.Msg{Question: []dns.Question{question}})
+	msg.Authoritative = true
+
+	if question.Qtype == dns.TypePTR {
+		msg.Answer = append(msg.Answer, &dns.PTR{
+			Hdr: dns.RR_Header{
+				Name:   question.Name,
+				Rrtype: dns.TypePTR,
+				Class:  dns.ClassINET,
+				Ttl:    300, // 5 minutes TTL
+			},
+			Ptr: localhostName,
+		})
+	}
+
+	return msg
 }
@@ -0,0 +1,260 @@
+package resolver
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestLocalhost(t *testing.T) {
+	tests := []struct {
+		name       string
+		qname      string
+		qtype      uint16
+		wantAnswer string
+	}{
+		{name: "localhost A", qname: "localhost.", qtype: dns.TypeA, wantAnswer: "127.0.0.1"},
+		{name: "localhost AAAA", qname: "LocalHost.", qtype: dns.TypeAAAA, wantAnswer: "::1"},
+		{name: "subdomain A", qname: "app.localhost.", qtype: dns.TypeA, wantAnswer: "127.0.0.1"},
+		{name: "IPv4 loopback PTR", qname: "1.0.0.127.in-addr.arpa.", qtype: dns.TypePTR, wantAnswer: "localhost."},
+		{name: "IPv6 loopback PTR", qname: "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.ip6.arpa.", qtype: dns.TypePTR, wantAnswer: "localhost."},
+		{name: "localhost MX", qname: "localhost.", qtype: dns.TypeMX},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := Localhost(dns.Question{Name: tt.qname, Qtype: tt.qtype, Qclass: dns.ClassINET})
+			if resp == nil {
+				t.Fatal("Expected a local answer, got nil")
+			}
+			if resp.Rcode != dns.RcodeSuccess || !resp.Authoritative {
+				t.Errorf("Expected authoritative NOERROR, got %v", resp)
+			}
+
+			if tt.wantAnswer == "" {
+				if len(resp.Answer) != 0 {
+					t.Errorf("Expected empty NODATA answer, got %v", resp.Answer)
+				}
+				return
+			}
+			if len(resp.Answer) != 1 {
+				t.Fatalf("Expected 1 answer, got %v", resp.Answer)
+			}
+
+			var got string
+			switch rr := resp.Answer[0].(type) {
+			case *dns.A:
+				got = rr.A.String()
+			case *dns.AAAA:
+				got = rr.AAAA.String()
+			case *dns.PTR:
+				got = rr.Ptr
+			}
+			if got != tt.wantAnswer {
+				t.Errorf("Expected %s, got %s", tt.wantAnswer, got)
+			}
+		})
+	}
+}
+
+func TestLocalhost_OtherNames(t *testing.T) {
+	names := []string{"example.com.", "notlocalhost.", "2.0.0.127.in-addr.arpa."}
+	for _, name := range names {
+		if resp := Localhost(dns.Question{Name: name, Qtype: dns.TypePTR, Qclass: dns.ClassINET}); resp != nil {
+			t.Errorf("Expected nil for %s, got %v", name, resp)
+		}
+	}
+}
+
+func TestResolve_ExactMapping(t *testing.T) {
+	r := New(map[string]string{"server.local.": "192.168.0.30"})
+
+	resp := r.Resolve(dns.Question{Name: "server.local.", Qtype: dns.TypeA, Qclass: dns.ClassINET})
+	if resp == nil || len(resp.Answer) != 1 {
+		t.Fatalf("Expected mapped answer, got %v", resp)
+	}
+	if a := resp.Answer[0].(*dns.A); !a.A.Equal(net.ParseIP("192.168.0.30")) {
+		t.Errorf("Expected 192.168.0.30, got %s", a.A)
+	}
+
+	if resp := r.Resolve(dns.Question{Name: "other.local.", Qtype: dns.TypeA, Qclass: dns.ClassINET}); resp != nil {
+		t.Errorf("Expected nil for unmapped name, got %v", resp)
+	}
+}
+
+func TestResolve_ReusesCachedResponse(t *testing.T) {
+	r := New(map[string]string{"server.local.": "192.168.0.30"})
+	question := dns.Question{Name: "server.local.", Qtype: dns.TypeA, Qclass: dns.ClassINET}
+
+	first := r.Resolve(question)
+	first.Id = 1
+	second := r.Resolve(question)
+	second.Id = 2
+
+	if first == second {
+		t.Fatal("Expected each caller to get its own message")
+	}
+	if first.Id != 1 {
+		t.Errorf("Expected setting the ID of one response not to affect another, got %d", first.Id)
+	}
+	if first.Answer[0] != second.Answer[0] {
+		t.Error("Expected repeated resolutions to reuse the cached answer")
+	}
+
+	// A different query type is cached separately
+	if aaaa := r.Resolve(dns.Question{Name: "server.local.", Qtype: dns.TypeAAAA, Qclass: dns.ClassINET}); aaaa.Rcode != dns.RcodeSuccess || len(aaaa.Answer) != 0 {
+		t.Errorf("Expected NODATA for AAAA of an IPv4 mapping, got %v", aaaa)
+	}
+}
+
+func TestResolve_UpdateMappingsInvalidatesCache(t *testing.T) {
+	r := New(map[string]string{"server.local.": "192.168.0.30"})
+	question := dns.Question{Name: "server.local.", Qtype: dns.TypeA, Qclass: dns.ClassINET}
+
+	before := r.Resolve(question)
+
+	r.UpdateMappings(map[string]string{"server.local.": "192.168.0.31"})
+	after := r.Resolve(question)
+
+	if after.Answer[0] == before.Answer[0] {
+		t.Fatal("Expected a new answer after the mappings changed")
+	}
+	if a := after.Answer[0].(*dns.A); !a.A.Equal(net.ParseIP("192.168.0.31")) {
+		t.Errorf("Expected updated address 192.168.0.31, got %s", a.A)
+	}
+
+	r.UpdateMappings(map[string]string{})
+	if resp := r.Resolve(question); resp != nil {
+		t.Errorf("Expected nil after the mapping was removed, got %v", resp)
+	}
+}
+
+func TestResolve_ResponseCacheDisabled(t *testing.T) {
+	r := New(map[string]string{"server.local.": "192.168.0.30"})
+	r.SetResponseCache(false)
+	question := dns.Question{Name: "server.local.", Qtype: dns.TypeA, Qclass: dns.ClassINET}
+
+	if r.Resolve(question).Answer[0] == r.Resolve(question).Answer[0] {
+		t.Error("Expected a fresh answer on every resolution with the cache disabled")
+	}
+}
+
+func BenchmarkResolve(b *testing.B) {
+	question := dns.Question{Name: "server.local.", Qtype: dns.TypeA, Qclass: dns.ClassINET}
+
+	for _, cached := range []bool{false, true} {
+		name := "Uncached"
+		if cached {
+			name = "Cached"
+		}
+		b.Run(name, func(b *testing.B) {
+			r := New(map[string]string{"server.local.": "192.168.0.30"})
+			r.SetResponseCache(cached)
+
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				r.Resolve(question)
+			}
+		})
+	}
+}
+
+func TestResolve_MultipleAddresses(t *testing.T) {
+	for _, cache := range []bool{true, false} {
+		r := New(map[string]string{"web.local.": "192.168.0.31, 192.168.0.32,192.168.0.33,fd00::31"})
+		r.SetResponseCache(cache)
+
+		question := dns.Question{Name: "web.local.", Qtype: dns.TypeA, Qclass: dns.ClassINET}
+		var firsts []string
+		for i := 0; i < 3; i++ {
+			resp := r.Resolve(question)
+			if resp == nil || len(resp.Answer) != 3 {
+				t.Fatalf("cache=%v: expected 3 A records, got %v", cache, resp)
+			}
+
+			// Every response holds all addresses, in rotated order
+			seen := make(map[string]bool)
+			for _, rr := range resp.Answer {
+				seen[rr.(*dns.A).A.String()] = true
+			}
+			if len(seen) != 3 {
+				t.Errorf("cache=%v: expected 3 distinct addresses, got %v", cache, resp.Answer)
+			}
+			firsts = append(firsts, resp.Answer[0].(*dns.A).A.String())
+		}
+
+		want := []string{"192.168.0.31", "192.168.0.32", "192.168.0.33"}
+		for i := range want {
+			if firsts[i] != want[i] {
+				t.Errorf("cache=%v: expected responses to start with %v in turn, got %v", cache, want, firsts)
+				break
+			}
+		}
+
+		resp := r.Resolve(dns.Question{Name: "web.local.", Qtype: dns.TypeAAAA, Qclass: dns.ClassINET})
+		if resp == nil || len(resp.Answer) != 1 || resp.Answer[0].(*dns.AAAA).AAAA.String() != "fd00::31" {
+			t.Errorf("cache=%v: expected the single IPv6 address, got %v", cache, resp)
+		}
+	}
+}
+
+func TestResolve_ReversePTR(t *testing.T) {
+	r := New(map[string]string{
+		"NAS.lan":                    "192.168.0.50,fd00::50",
+		"files.lan.":                 "192.168.0.50",
+		"printer.lan.":               "192.168.0.60",
+		"60.0.168.192.in-addr.arpa.": "laser.lan",
+		`re:^db-\d+\.lan\.$`:         "192.168.0.70",
+	})
+
+	ptrs := func(qname string) []string {
+		t.Helper()
+		resp := r.Resolve(dns.Question{Name: qname, Qtype: dns.TypePTR, Qclass: dns.ClassINET})
+		if resp == nil {
+			return nil
+		}
+		var names []string
+		for _, rr := range resp.Answer {
+			names = append(names, rr.(*dns.PTR).Ptr)
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	if got := ptrs("50.0.168.192.in-addr.arpa."); strings.Join(got, " ") != "files.lan. nas.lan." {
+		t.Errorf("Expected both domains of 192.168.0.50, got %v", got)
+	}
+	reverse, _ := dns.ReverseAddr("fd00::50")
+	if got := ptrs(strings.ToUpper(reverse)); strings.Join(got, " ") != "nas.lan." {
+		t.Errorf("Expected nas.lan. for fd00::50, got %v", got)
+	}
+
+	// An explicit reverse mapping takes precedence over the address mappings
+	if got := ptrs("60.0.168.192.in-addr.arpa."); strings.Join(got, " ") != "laser.lan." {
+		t.Errorf("Expected the explicit mapping laser.lan., got %v", got)
+	}
+
+	// Unmapped addresses and regex mappings are not answered locally
+	for _, qname := range []string{"99.0.168.192.in-addr.arpa.", "70.0.168.192.in-addr.arpa."} {
+		if resp := r.Resolve(dns.Question{Name: qname, Qtype: dns.TypePTR, Qclass: dns.ClassINET}); resp != nil {
+			t.Errorf("Expected nil for %s, got %v", qname, resp)
+		}
+	}
+
+	// Only PTR queries use the reverse index
+	if resp := r.Resolve(dns.Question{Name: "50.0.168.192.in-addr.arpa.", Qtype: dns.TypeA, Qclass: dns.ClassINET}); resp != nil {
+		t.Errorf("Expected nil for an A query of a reverse name, got %v", resp)
+	}
+
+	// The index follows mapping updates
+	r.UpdateMappings(map[string]string{"printer.lan.": "192.168.0.61"})
+	if got := ptrs("61.0.168.192.in-addr.arpa."); strings.Join(got, " ") != "printer.lan." {
+		t.Errorf("Expected printer.lan. after the update, got %v", got)
+	}
+	if got := ptrs("50.0.168.192.in-addr.arpa."); got != nil {
+		t.Errorf("Expected the removed mapping to be gone, got %v", got)
+	}
+}
@@ -0,0 +1,91 @@
+package resolver
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"dns-go/internal/mapping"
+)
+
+const (
+	// maxPatternLength bounds the size of a mapping regex so it fits the
+	// dns_mappings.domain column and keeps compiled programs small
+	maxPatternLength = 250
+
+	// MaxPatternMappings bounds how many regex mappings may be configured, as
+	// each query may be matched against all of them. Go regexps run in linear
+	// time, so this caps the per-query cost at a fixed number of linear scans
+	// of the (at most 255 byte) query name.
+	MaxPatternMappings = 64
+)
+
+// patternMapping is a compiled regex-keyed mapping
+type patternMapping struct {
+	pattern *regexp.Regexp
+	ip      string
+}
+
+// CompilePattern validates and compiles a regex mapping key
+func CompilePattern(key string) (*regexp.Regexp, error) {
+	expr := strings.TrimPrefix(key, mapping.PatternPrefix)
+	if expr == "" {
+		return nil, fmt.Errorf("empty regex in mapping %q", key)
+	}
+	if len(expr) > maxPatternLength {
+		return nil, fmt.Errorf("regex in mapping %q is longer than %d characters", key, maxPatternLength)
+	}
+
+	pattern, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex in mapping %q: %w", key, err)
+	}
+	return pattern, nil
+}
+
+// CheckPatternLimit returns an error if mappings hold more regex mappings than
+// MaxPatternMappings
+func CheckPatternLimit(mappings map[string]string) error {
+	count := 0
+	for key := range mappings {
+		if mapping.IsPatternKey(key) {
+			count++
+		}
+	}
+	if count > MaxPatternMappings {
+		return fmt.Errorf("%d regex DNS mappings exceed the limit of %d", count, MaxPatternMappings)
+	}
+	return nil
+}
+
+// compilePatterns compiles the regex-keyed mappings in a stable order.
+// Invalid patterns are skipped; they are rejected when mappings are added.
+func compilePatterns(mappings map[string]string) []patternMapping {
+	keys := make([]string, 0)
+	for key := range mappings {
+		if mapping.IsPatternKey(key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	patterns := make([]patternMapping, 0, len(keys))
+	for _, key := range keys {
+		if pattern, err := CompilePattern(key); err == nil {
+			patterns = append(patterns, patternMapping{pattern: pattern, ip: mappings[key]})
+		}
+	}
+	return patterns
+}
+
+// matchPattern returns the IP of the first regex mapping matching domain
+func matchPattern(patterns []patternMapping, domain string) (string, bool) {
+	name := strings.ToLower(domain)
+	for _, mapping := range patterns {
+		if mapping.pattern.MatchString(name) {
+			return mapping.ip, true
+		}
+	}
+	return "", false
+}
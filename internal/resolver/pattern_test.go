@@ -0,0 +1,116 @@
+package resolver
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestResolve_PatternMappings(t *testing.T) {
+	r := New(map[string]string{
+		`re:^db-\d+\.internal\.$`: "10.0.0.50",
+		"db-1.internal.":          "10.0.0.1",
+	})
+
+	tests := []struct {
+		name   string
+		qname  string
+		wantIP string
+	}{
+		{name: "numbered host matches regex", qname: "db-42.internal.", wantIP: "10.0.0.50"},
+		{name: "matching is case-insensitive on the name", qname: "DB-7.Internal.", wantIP: "10.0.0.50"},
+		{name: "exact mapping takes precedence", qname: "db-1.internal.", wantIP: "10.0.0.1"},
+		{name: "non-numbered host does not match", qname: "db-x.internal."},
+		{name: "anchored regex does not match subdomain", qname: "a.db-2.internal."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := r.Resolve(dns.Question{Name: tt.qname, Qtype: dns.TypeA, Qclass: dns.ClassINET})
+			if tt.wantIP == "" {
+				if resp != nil {
+					t.Fatalf("Expected no match, got %v", resp)
+				}
+				return
+			}
+			if resp == nil || len(resp.Answer) != 1 {
+				t.Fatalf("Expected an answer, got %v", resp)
+			}
+			a := resp.Answer[0].(*dns.A)
+			if a.A.String() != tt.wantIP {
+				t.Errorf("Expected %s, got %s", tt.wantIP, a.A)
+			}
+			if a.Hdr.Name != tt.qname {
+				t.Errorf("Expected owner name %s, got %s", tt.qname, a.Hdr.Name)
+			}
+		})
+	}
+}
+
+func TestResolve_PatternMappingsBeyondFirst64(t *testing.T) {
+	mappings := make(map[string]string)
+	for i := 0; i < MaxPatternMappings; i++ {
+		mappings[fmt.Sprintf(`re:^a%02d\.internal\.$`, i)] = "10.0.0.1"
+	}
+	// Sorts after every other pattern, so a per-query cap would skip it
+	mappings[`re:^zz\.internal\.$`] = "10.0.0.99"
+	r := New(mappings)
+
+	resp := r.Resolve(dns.Question{Name: "zz.internal.", Qtype: dns.TypeA, Qclass: dns.ClassINET})
+	if resp == nil || len(resp.Answer) != 1 || resp.Answer[0].(*dns.A).A.String() != "10.0.0.99" {
+		t.Errorf("Expected the last pattern to be consulted, got %v", resp)
+	}
+}
+
+func TestCheckPatternLimit(t *testing.T) {
+	mappings := map[string]string{"host.internal.": "10.0.0.1"}
+	for i := 0; i < MaxPatternMappings; i++ {
+		mappings[fmt.Sprintf(`re:^h%d\.internal\.$`, i)] = "10.0.0.1"
+	}
+	if err := CheckPatternLimit(mappings); err != nil {
+		t.Fatalf("Expected %d regex mappings to be accepted, got %v", MaxPatternMappings, err)
+	}
+
+	mappings[`re:^extra\.internal\.$`] = "10.0.0.1"
+	if err := CheckPatternLimit(mappings); err == nil {
+		t.Error("Expected an error above the regex mapping limit")
+	}
+}
+
+func TestResolve_PatternMappingsUpdated(t *testing.T) {
+	r := New(map[string]string{})
+	question := dns.Question{Name: "web-3.internal.", Qtype: dns.TypeA, Qclass: dns.ClassINET}
+
+	if resp := r.Resolve(question); resp != nil {
+		t.Fatalf("Expected no match before update, got %v", resp)
+	}
+
+	r.UpdateMappings(map[string]string{`re:^web-\d+\.internal\.$`: "10.0.1.1"})
+	if resp := r.Resolve(question); resp == nil || len(resp.Answer) != 1 {
+		t.Fatalf("Expected regex match after update, got %v", resp)
+	}
+}
+
+func TestCompilePattern(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		wantErr bool
+	}{
+		{name: "valid", key: `re:^db-\d+\.internal\.$`},
+		{name: "invalid syntax", key: `re:^db-(\d+\.internal\.$`, wantErr: true},
+		{name: "empty", key: "re:", wantErr: true},
+		{name: "too long", key: "re:" + strings.Repeat("a", maxPatternLength+1), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := CompilePattern(tt.key)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Expected error=%v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
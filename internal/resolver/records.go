@@ -0,0 +1,108 @@
+package resolver
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"dns-go/internal/mapping"
+
+	"github.com/miekg/dns"
+)
+
+// recordPrefixes maps the prefixes of typed mapping values to their record type
+var recordPrefixes = map[string]uint16{
+	mapping.TXTPrefix: dns.TypeTXT,
+	mapping.MXPrefix:  dns.TypeMX,
+	mapping.SRVPrefix: dns.TypeSRV,
+}
+
+// RecordType returns the record type of a typed custom DNS mapping value, or 0
+// for addresses, host names and other values
+func RecordType(value string) uint16 {
+	prefix, _, ok := strings.Cut(strings.TrimSpace(value), ":")
+	if !ok {
+		return 0
+	}
+	return recordPrefixes[strings.ToLower(prefix)+":"]
+}
+
+// RecordValue returns the record of domain for a typed custom DNS mapping
+// value, or an error when the value is malformed. Untyped values yield neither.
+func RecordValue(domain, value string, ttl uint32) (dns.RR, error) {
+	rrtype := RecordType(value)
+	if rrtype == 0 {
+		return nil, nil
+	}
+	_, data, _ := strings.Cut(strings.TrimSpace(value), ":")
+	data = strings.TrimSpace(data)
+
+	hdr := dns.RR_Header{
+		Name:   domain,
+		Rrtype: rrtype,
+		Class:  dns.ClassINET,
+		Ttl:    ttl,
+	}
+	fields := strings.Fields(data)
+
+	switch rrtype {
+	case dns.TypeTXT:
+		if data == "" {
+			return nil, fmt.Errorf("invalid TXT value %q: empty text", value)
+		}
+		return &dns.TXT{Hdr: hdr, Txt: splitTXT(data)}, nil
+
+	case dns.TypeMX:
+		numbers, ok := parseUint16s(fields, 1)
+		if len(fields) != 2 || !ok || !isHostName(fields[1]) {
+			return nil, fmt.Errorf("invalid MX value %q, expected %s<preference> <host>", value, mapping.MXPrefix)
+		}
+		return &dns.MX{Hdr: hdr, Preference: numbers[0], Mx: dns.Fqdn(fields[1])}, nil
+
+	default: // dns.TypeSRV
+		numbers, ok := parseUint16s(fields, 3)
+		if len(fields) != 4 || !ok || !isHostName(fields[3]) {
+			return nil, fmt.Errorf("invalid SRV value %q, expected %s<priority> <weight> <port> <target>", value, mapping.SRVPrefix)
+		}
+		return &dns.SRV{
+			Hdr:      hdr,
+			Priority: numbers[0],
+			Weight:   numbers[1],
+			Port:     numbers[2],
+			Target:   dns.Fqdn(fields[3]),
+		}, nil
+	}
+}
+
+// parseUint16s parses the first n fields as 16-bit numbers
+func parseUint16s(fields []string, n int) ([]uint16, bool) {
+	if len(fields) < n {
+		return nil, false
+	}
+	numbers := make([]uint16, n)
+	for i := range numbers {
+		number, err := strconv.ParseUint(fields[i], 10, 16)
+		if err != nil {
+			return nil, false
+		}
+		numbers[i] = uint16(number)
+	}
+	return numbers, true
+}
+
+// isHostName reports whether value is a valid domain name rather than an address
+func isHostName(value string) bool {
+	_, ok := dns.IsDomainName(value)
+	return ok && RecordType(value) == 0
+}
+
+// splitTXT splits text into the strings of a TXT record, which hold at most
+// 255 bytes each
+func splitTXT(text string) []string {
+	var parts []string
+	for len(text) > 255 {
+		parts = append(parts, text[:255])
+		text = text[255:]
+	}
+	return append(parts, text)
+}
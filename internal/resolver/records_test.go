@@ -0,0 +1,127 @@
+package resolver
+
+import (
+	"testing"
+
+	"dns-go/internal/mapping"
+
+	"github.com/miekg/dns"
+)
+
+func TestResolve_TypedRecords(t *testing.T) {
+	r := New(map[string]string{
+		"mail.local.":      "192.168.0.25,txt:v=spf1 mx -all,ttl:60",
+		"local.":           "mx:10 mail.local,mx:20 backup.local.",
+		"_sip._udp.local.": "srv:10 5 5060 pbx.local",
+		"broken.local.":    "mx:ten mail.local",
+		"server.local.":    "192.168.0.30",
+	})
+
+	resolve := func(qname string, qtype uint16) *dns.Msg {
+		t.Helper()
+		resp := r.Resolve(dns.Question{Name: qname, Qtype: qtype, Qclass: dns.ClassINET})
+		if resp == nil || resp.Rcode != dns.RcodeSuccess {
+			t.Fatalf("Expected a NOERROR answer for %s, got %v", qname, resp)
+		}
+		return resp
+	}
+
+	resp := resolve("mail.local.", dns.TypeTXT)
+	if len(resp.Answer) != 1 {
+		t.Fatalf("Expected a single TXT record, got %v", resp.Answer)
+	}
+	if txt := resp.Answer[0].(*dns.TXT); len(txt.Txt) != 1 || txt.Txt[0] != "v=spf1 mx -all" || txt.Hdr.Ttl != 60 {
+		t.Errorf("Expected TXT v=spf1 mx -all with TTL 60, got %v", txt)
+	}
+	if resp := resolve("mail.local.", dns.TypeA); len(resp.Answer) != 1 {
+		t.Errorf("Expected the TXT value not to affect the A answer, got %v", resp.Answer)
+	}
+
+	resp = resolve("local.", dns.TypeMX)
+	if len(resp.Answer) != 2 {
+		t.Fatalf("Expected two MX records, got %v", resp.Answer)
+	}
+	exchanges := map[string]uint16{}
+	for _, rr := range resp.Answer {
+		mx := rr.(*dns.MX)
+		exchanges[mx.Mx] = mx.Preference
+	}
+	if exchanges["mail.local."] != 10 || exchanges["backup.local."] != 20 {
+		t.Errorf("Expected mail.local. (10) and backup.local. (20), got %v", exchanges)
+	}
+
+	resp = resolve("_sip._udp.local.", dns.TypeSRV)
+	if len(resp.Answer) != 1 {
+		t.Fatalf("Expected a single SRV record, got %v", resp.Answer)
+	}
+	if srv := resp.Answer[0].(*dns.SRV); srv.Priority != 10 || srv.Weight != 5 || srv.Port != 5060 || srv.Target != "pbx.local." {
+		t.Errorf("Expected SRV 10 5 5060 pbx.local., got %v", srv)
+	}
+
+	// Types without a value of their own get NODATA
+	for _, q := range []struct {
+		name  string
+		qtype uint16
+	}{
+		{"server.local.", dns.TypeTXT},
+		{"server.local.", dns.TypeAAAA},
+		{"local.", dns.TypeA},
+		{"_sip._udp.local.", dns.TypePTR},
+		{"broken.local.", dns.TypeMX},
+	} {
+		if resp := resolve(q.name, q.qtype); len(resp.Answer) != 0 {
+			t.Errorf("Expected NODATA for %s %s, got %v", q.name, dns.TypeToString[q.qtype], resp.Answer)
+		}
+	}
+}
+
+func TestRecordValue(t *testing.T) {
+	tests := []struct {
+		value   string
+		rrtype  uint16
+		wantErr bool
+	}{
+		{"192.168.0.30", 0, false},
+		{"fd00::1", 0, false},
+		{"cname:web.local", 0, false},
+		{"TXT:hello world", dns.TypeTXT, false},
+		{"txt:", 0, true},
+		{"mx:10 mail.local", dns.TypeMX, false},
+		{"mx:10", 0, true},
+		{"mx:70000 mail.local", 0, true},
+		{"mx:10 txt:mail", 0, true},
+		{"srv:0 0 443 web.local", dns.TypeSRV, false},
+		{"srv:0 0 web.local", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			rr, err := RecordValue("test.local.", tt.value, 30)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if tt.rrtype == 0 {
+				if rr != nil {
+					t.Errorf("Expected no record, got %v", rr)
+				}
+				return
+			}
+			if rr == nil || rr.Header().Rrtype != tt.rrtype || rr.Header().Ttl != 30 {
+				t.Errorf("Expected a %s record with TTL 30, got %v", dns.TypeToString[tt.rrtype], rr)
+			}
+		})
+	}
+}
+
+func TestRecordValue_LongTXT(t *testing.T) {
+	text := make([]byte, 300)
+	for i := range text {
+		text[i] = 'a'
+	}
+	rr, err := RecordValue("test.local.", mapping.TXTPrefix+string(text), 30)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if txt := rr.(*dns.TXT); len(txt.Txt) != 2 || len(txt.Txt[0]) != 255 || len(txt.Txt[1]) != 45 {
+		t.Errorf("Expected the text split into strings of at most 255 bytes, got %d strings", len(txt.Txt))
+	}
+}
@@ -0,0 +1,63 @@
+package resolver
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"dns-go/internal/mapping"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// DefaultTTL is the TTL of records synthesized for custom mappings unless
+	// configured otherwise
+	DefaultTTL = 300
+
+	// MaxTTL is the largest TTL allowed by RFC 2181
+	MaxTTL = math.MaxInt32
+
+	// TTLPrefix marks a custom DNS mapping value overriding the TTL of the
+	// mapping's records (e.g. web.local=192.168.0.31,ttl:60)
+	TTLPrefix = "ttl:"
+)
+
+// SetTTL sets the TTL of records synthesized for custom mappings without a TTL
+// of their own
+func (r *LocalResolver) SetTTL(ttl uint32) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.ttl = ttl
+	r.cache = make(map[responseKey]*dns.Msg)
+	r.generation++
+}
+
+// ParseTTLValue returns the TTL set by a custom DNS mapping value of the form
+// ttl:<seconds>, and whether the value is a valid TTL override
+func ParseTTLValue(value string) (uint32, bool) {
+	value = strings.TrimSpace(value)
+	if !strings.HasPrefix(strings.ToLower(value), TTLPrefix) {
+		return 0, false
+	}
+	ttl, err := strconv.ParseUint(strings.TrimSpace(value[len(TTLPrefix):]), 10, 32)
+	if err != nil || ttl > MaxTTL {
+		return 0, false
+	}
+	return uint32(ttl), true
+}
+
+// splitTTL separates the TTL override of a mapping from its record values,
+// falling back to ttl when the mapping has none
+func splitTTL(value string, ttl uint32) ([]string, uint32) {
+	var values []string
+	for _, v := range mapping.Values(value) {
+		if override, ok := ParseTTLValue(v); ok {
+			ttl = override
+			continue
+		}
+		values = append(values, v)
+	}
+	return values, ttl
+}
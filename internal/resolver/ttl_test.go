@@ -0,0 +1,66 @@
+package resolver
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestResolve_TTL(t *testing.T) {
+	r := New(map[string]string{
+		"server.local.": "192.168.0.30",
+		"web.local.":    "192.168.0.31,ttl:60,fd00::31",
+		"app.local.":    "cname:web.local,ttl:30",
+	})
+
+	ttls := func(qname string, qtype uint16) []uint32 {
+		t.Helper()
+		resp := r.Resolve(dns.Question{Name: qname, Qtype: qtype, Qclass: dns.ClassINET})
+		if resp == nil || len(resp.Answer) == 0 {
+			t.Fatalf("Expected an answer for %s, got %v", qname, resp)
+		}
+		var got []uint32
+		for _, rr := range resp.Answer {
+			got = append(got, rr.Header().Ttl)
+		}
+		return got
+	}
+
+	if got := ttls("server.local.", dns.TypeA); got[0] != DefaultTTL {
+		t.Errorf("Expected the default TTL %d, got %v", DefaultTTL, got)
+	}
+
+	r.SetTTL(120)
+	if got := ttls("server.local.", dns.TypeA); got[0] != 120 {
+		t.Errorf("Expected the configured TTL 120, got %v", got)
+	}
+
+	// A ttl: value overrides the configured TTL for its own mapping only
+	if got := ttls("web.local.", dns.TypeAAAA); len(got) != 1 || got[0] != 60 {
+		t.Errorf("Expected a single record with TTL 60, got %v", got)
+	}
+	if got := ttls("app.local.", dns.TypeA); len(got) != 2 || got[0] != 30 || got[1] != 60 {
+		t.Errorf("Expected the CNAME with TTL 30 and the A record with TTL 60, got %v", got)
+	}
+}
+
+func TestParseTTLValue(t *testing.T) {
+	tests := []struct {
+		value  string
+		want   uint32
+		wantOK bool
+	}{
+		{value: "ttl:60", want: 60, wantOK: true},
+		{value: " TTL: 0", want: 0, wantOK: true},
+		{value: "ttl:-1"},
+		{value: "ttl:2147483648"},
+		{value: "192.168.0.30"},
+	}
+
+	for _, tt := range tests {
+		got, ok := ParseTTLValue(tt.value)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("ParseTTLValue(%q) = %d, %v; want %d, %v", tt.value, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
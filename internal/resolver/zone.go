@@ -0,0 +1,117 @@
+package resolver
+
+import (
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// SOA timers served for custom zones
+const (
+	zoneSerial  = 1
+	zoneRefresh = 3600  // 1 hour
+	zoneRetry   = 600   // 10 minutes
+	zoneExpire  = 86400 // 1 day
+	zoneMinTTL  = 300   // 5 minutes, also the negative caching TTL
+	zoneTTL     = 300   // 5 minutes TTL for the NS and SOA records themselves
+)
+
+// ZoneConfig describes the NS and SOA records served at the apex of custom zones
+type ZoneConfig struct {
+	// Nameservers are the NS targets; defaults to ns.<zone>
+	Nameservers []string
+	// Hostmaster is the SOA mailbox in domain form; defaults to hostmaster.<zone>
+	Hostmaster string
+}
+
+// SetZones makes the resolver answer NS and SOA queries at the apex of the
+// given zones. Other names in the zones are still answered from the mappings.
+func (r *LocalResolver) SetZones(zones []string, cfg ZoneConfig) {
+	apexes := make(map[string]bool, len(zones))
+	for _, zone := range zones {
+		apexes[strings.ToLower(dns.Fqdn(zone))] = true
+	}
+
+	nameservers := make([]string, 0, len(cfg.Nameservers))
+	for _, ns := range cfg.Nameservers {
+		nameservers = append(nameservers, dns.Fqdn(ns))
+	}
+	cfg.Nameservers = nameservers
+	if cfg.Hostmaster != "" {
+		cfg.Hostmaster = dns.Fqdn(cfg.Hostmaster)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.zones = apexes
+	r.zoneConfig = cfg
+}
+
+// resolveZoneApex answers NS and SOA questions for a configured zone apex, or
+// returns nil when the question is not about one
+func (r *LocalResolver) resolveZoneApex(question dns.Question) *dns.Msg {
+	if question.Qtype != dns.TypeNS && question.Qtype != dns.TypeSOA {
+		return nil
+	}
+
+	apex := strings.ToLower(dns.Fqdn(question.Name))
+
+	r.mu.RLock()
+	isZone := r.zones[apex]
+	cfg := r.zoneConfig
+	r.mu.RUnlock()
+
+	if !isZone {
+		return nil
+	}
+
+	nameservers := cfg.Nameservers
+	if len(nameservers) == 0 {
+		nameservers = []string{"ns." + apex}
+	}
+
+	msg := &dns.Msg{}
+	msg.SetReply(&dns.Msg{Question: []dns.Question{question}})
+	msg.Authoritative = true
+
+	switch question.Qtype {
+	case dns.TypeSOA:
+		msg.Answer = append(msg.Answer, zoneSOA(apex, nameservers[0], cfg.Hostmaster))
+	case dns.TypeNS:
+		for _, ns := range nameservers {
+			msg.Answer = append(msg.Answer, &dns.NS{
+				Hdr: dns.RR_Header{
+					Name:   apex,
+					Rrtype: dns.TypeNS,
+					Class:  dns.ClassINET,
+					Ttl:    zoneTTL,
+				},
+				Ns: ns,
+			})
+		}
+	}
+
+	return msg
+}
+
+// zoneSOA builds the SOA record of a custom zone
+func zoneSOA(apex, primary, hostmaster string) *dns.SOA {
+	if hostmaster == "" {
+		hostmaster = "hostmaster." + apex
+	}
+	return &dns.SOA{
+		Hdr: dns.RR_Header{
+			Name:   apex,
+			Rrtype: dns.TypeSOA,
+			Class:  dns.ClassINET,
+			Ttl:    zoneTTL,
+		},
+		Ns:      primary,
+		Mbox:    hostmaster,
+		Serial:  zoneSerial,
+		Refresh: zoneRefresh,
+		Retry:   zoneRetry,
+		Expire:  zoneExpire,
+		Minttl:  zoneMinTTL,
+	}
+}
@@ -0,0 +1,81 @@
+package resolver
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestResolve_ZoneApexSOA(t *testing.T) {
+	r := New(map[string]string{"internal.local.": "10.0.0.1"})
+	r.SetZones([]string{"internal.local"}, ZoneConfig{
+		Nameservers: []string{"ns1.internal.local", "ns2.internal.local"},
+		Hostmaster:  "admin.internal.local",
+	})
+
+	resp := r.Resolve(dns.Question{Name: "Internal.Local.", Qtype: dns.TypeSOA, Qclass: dns.ClassINET})
+	if resp == nil || resp.Rcode != dns.RcodeSuccess || len(resp.Answer) != 1 {
+		t.Fatalf("Expected one SOA answer, got %v", resp)
+	}
+	if !resp.Authoritative {
+		t.Error("Expected an authoritative answer")
+	}
+
+	soa, ok := resp.Answer[0].(*dns.SOA)
+	if !ok {
+		t.Fatalf("Expected SOA record, got %T", resp.Answer[0])
+	}
+	if soa.Hdr.Name != "internal.local." || soa.Ns != "ns1.internal.local." || soa.Mbox != "admin.internal.local." {
+		t.Errorf("Unexpected SOA record: %s", soa)
+	}
+}
+
+func TestResolve_ZoneApexNS(t *testing.T) {
+	r := New(map[string]string{})
+	r.SetZones([]string{"internal.local."}, ZoneConfig{
+		Nameservers: []string{"ns1.internal.local", "ns2.internal.local"},
+	})
+
+	resp := r.Resolve(dns.Question{Name: "internal.local.", Qtype: dns.TypeNS, Qclass: dns.ClassINET})
+	if resp == nil || len(resp.Answer) != 2 {
+		t.Fatalf("Expected two NS answers, got %v", resp)
+	}
+	for i, want := range []string{"ns1.internal.local.", "ns2.internal.local."} {
+		if ns := resp.Answer[i].(*dns.NS); ns.Ns != want {
+			t.Errorf("Expected NS %s, got %s", want, ns.Ns)
+		}
+	}
+}
+
+func TestResolve_ZoneDefaults(t *testing.T) {
+	r := New(map[string]string{})
+	r.SetZones([]string{"internal.local"}, ZoneConfig{})
+
+	resp := r.Resolve(dns.Question{Name: "internal.local.", Qtype: dns.TypeSOA, Qclass: dns.ClassINET})
+	if resp == nil || len(resp.Answer) != 1 {
+		t.Fatalf("Expected one SOA answer, got %v", resp)
+	}
+	soa := resp.Answer[0].(*dns.SOA)
+	if soa.Ns != "ns.internal.local." || soa.Mbox != "hostmaster.internal.local." {
+		t.Errorf("Expected default NS and mailbox, got %s", soa)
+	}
+}
+
+func TestResolve_ZoneOnlyAtApex(t *testing.T) {
+	r := New(map[string]string{"internal.local.": "10.0.0.1"})
+	r.SetZones([]string{"internal.local"}, ZoneConfig{})
+
+	// Below the apex, NS and SOA queries are not answered by the zone
+	if resp := r.Resolve(dns.Question{Name: "host.internal.local.", Qtype: dns.TypeSOA, Qclass: dns.ClassINET}); resp != nil {
+		t.Errorf("Expected no answer below the apex, got %v", resp)
+	}
+
+	// Address queries at the apex still use the mapping
+	resp := r.Resolve(dns.Question{Name: "internal.local.", Qtype: dns.TypeA, Qclass: dns.ClassINET})
+	if resp == nil || len(resp.Answer) != 1 {
+		t.Fatalf("Expected A answer from the mapping, got %v", resp)
+	}
+	if a := resp.Answer[0].(*dns.A); a.A.String() != "10.0.0.1" {
+		t.Errorf("Expected 10.0.0.1, got %s", a.A)
+	}
+}
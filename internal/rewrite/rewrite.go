@@ -0,0 +1,128 @@
+// Package rewrite applies operator-defined rules to DNS responses, such as
+// substituting an address returned by the upstream servers or forcing the TTL
+// of the answers for a domain.
+package rewrite
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// Rule rewrites the answers to queries for a domain and its subdomains
+type Rule struct {
+	// Suffix is the domain the rule applies to, including its subdomains
+	Suffix string `json:"suffix"`
+
+	// Replace maps A and AAAA addresses in the answer to the addresses
+	// returned in their place
+	Replace map[string]string `json:"replace,omitempty"`
+
+	// TTL, when set, replaces the TTL of every answer record
+	TTL *uint32 `json:"ttl,omitempty"`
+}
+
+// Matches reports whether the rule applies to queries for name
+func (r Rule) Matches(name string) bool {
+	return dns.IsSubDomain(r.Suffix, strings.ToLower(dns.Fqdn(name)))
+}
+
+// Validate checks that the rule names a domain and rewrites something, and that
+// each address is replaced by one of the same family
+func (r Rule) Validate() error {
+	if _, ok := dns.IsDomainName(r.Suffix); !ok || r.Suffix == "" {
+		return fmt.Errorf("invalid rewrite rule suffix %q", r.Suffix)
+	}
+	if len(r.Replace) == 0 && r.TTL == nil {
+		return fmt.Errorf("rewrite rule for %s neither replaces addresses nor sets a TTL", r.Suffix)
+	}
+	for from, to := range r.Replace {
+		fromIP, toIP := net.ParseIP(from), net.ParseIP(to)
+		if fromIP == nil || toIP == nil {
+			return fmt.Errorf("rewrite rule for %s: invalid address replacement %s -> %s", r.Suffix, from, to)
+		}
+		if (fromIP.To4() == nil) != (toIP.To4() == nil) {
+			return fmt.Errorf("rewrite rule for %s: %s and %s are not of the same address family", r.Suffix, from, to)
+		}
+	}
+	return nil
+}
+
+// Apply rewrites the answer section of msg with each rule matching its
+// question, in order, and reports whether anything changed
+func Apply(msg *dns.Msg, rules []Rule) bool {
+	if msg == nil || len(msg.Question) == 0 {
+		return false
+	}
+
+	changed := false
+	for _, rule := range rules {
+		if !rule.Matches(msg.Question[0].Name) {
+			continue
+		}
+		for _, rr := range msg.Answer {
+			if rule.replaceAddress(rr) {
+				changed = true
+			}
+			if rule.TTL != nil && rr.Header().Ttl != *rule.TTL {
+				rr.Header().Ttl = *rule.TTL
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// replaceAddress substitutes the address of an A or AAAA record when the rule
+// replaces it
+func (r Rule) replaceAddress(rr dns.RR) bool {
+	var addr *net.IP
+	switch record := rr.(type) {
+	case *dns.A:
+		addr = &record.A
+	case *dns.AAAA:
+		addr = &record.AAAA
+	default:
+		return false
+	}
+
+	for from, to := range r.Replace {
+		if !addr.Equal(net.ParseIP(from)) {
+			continue
+		}
+		replacement := net.ParseIP(to)
+		if rr.Header().Rrtype == dns.TypeA {
+			replacement = replacement.To4()
+		}
+		*addr = replacement
+		return true
+	}
+	return false
+}
+
+// LoadFile reads rewrite rules from a JSON file holding a list of rules, and
+// validates them
+func LoadFile(path string) ([]Rule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rewrite rules file %s: %w", path, err)
+	}
+
+	var rules []Rule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("failed to parse rewrite rules file %s: %w", path, err)
+	}
+	for i := range rules {
+		if suffix := strings.TrimSpace(rules[i].Suffix); suffix != "" {
+			rules[i].Suffix = strings.ToLower(dns.Fqdn(suffix))
+		}
+		if err := rules[i].Validate(); err != nil {
+			return nil, fmt.Errorf("rewrite rules file %s: %w", path, err)
+		}
+	}
+	return rules, nil
+}
@@ -0,0 +1,117 @@
+package rewrite
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// answer builds a response to a query for name carrying the given records
+func answer(t *testing.T, name string, records ...string) *dns.Msg {
+	t.Helper()
+
+	req := new(dns.Msg)
+	req.SetQuestion(dns.Fqdn(name), dns.TypeA)
+	resp := new(dns.Msg)
+	resp.SetReply(req)
+	for _, record := range records {
+		rr, err := dns.NewRR(record)
+		if err != nil {
+			t.Fatalf("Invalid record %q: %v", record, err)
+		}
+		resp.Answer = append(resp.Answer, rr)
+	}
+	return resp
+}
+
+func TestApply_ReplacesAddress(t *testing.T) {
+	rules := []Rule{{
+		Suffix:  "example.com.",
+		Replace: map[string]string{"192.0.2.1": "10.0.0.1", "2001:db8::1": "fd00::1"},
+	}}
+
+	msg := answer(t, "www.Example.com",
+		"www.example.com. 300 IN A 192.0.2.1",
+		"www.example.com. 300 IN A 192.0.2.2",
+		"www.example.com. 300 IN AAAA 2001:db8::1")
+	if !Apply(msg, rules) {
+		t.Fatal("Expected the answer to be rewritten")
+	}
+
+	want := []string{"10.0.0.1", "192.0.2.2", "fd00::1"}
+	for i, rr := range msg.Answer {
+		var got net.IP
+		switch record := rr.(type) {
+		case *dns.A:
+			got = record.A
+		case *dns.AAAA:
+			got = record.AAAA
+		}
+		if !got.Equal(net.ParseIP(want[i])) {
+			t.Errorf("Answer %d: expected %s, got %s", i, want[i], got)
+		}
+	}
+	if a := msg.Answer[0].(*dns.A); len(a.A) != net.IPv4len {
+		t.Errorf("Expected a 4-byte IPv4 address to be packed, got %d bytes", len(a.A))
+	}
+
+	other := answer(t, "example.org", "example.org. 300 IN A 192.0.2.1")
+	if Apply(other, rules) {
+		t.Error("Expected an answer outside the suffix to be left alone")
+	}
+}
+
+func TestApply_OverridesTTL(t *testing.T) {
+	ttl := uint32(30)
+	rules := []Rule{{Suffix: "cdn.example.", TTL: &ttl}}
+
+	msg := answer(t, "img.cdn.example",
+		"img.cdn.example. 3600 IN CNAME edge.cdn.example.",
+		"edge.cdn.example. 20 IN A 192.0.2.1")
+	if !Apply(msg, rules) {
+		t.Fatal("Expected the answer to be rewritten")
+	}
+	for _, rr := range msg.Answer {
+		if rr.Header().Ttl != ttl {
+			t.Errorf("Expected TTL %d, got %s", ttl, rr)
+		}
+	}
+
+	if Apply(msg, rules) {
+		t.Error("Expected no change when the TTLs already match")
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rewrite.json")
+	data := `[{"suffix": "Example.com", "replace": {"192.0.2.1": "10.0.0.1"}}, {"suffix": "cdn.example", "ttl": 0}]`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatalf("Failed to write rules: %v", err)
+	}
+
+	rules, err := LoadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to load rules: %v", err)
+	}
+	if len(rules) != 2 || rules[0].Suffix != "example.com." || rules[1].TTL == nil || *rules[1].TTL != 0 {
+		t.Errorf("Unexpected rules: %+v", rules)
+	}
+
+	for _, invalid := range []string{
+		`[{"suffix": "example.com"}]`,
+		`[{"replace": {"192.0.2.1": "10.0.0.1"}}]`,
+		`[{"suffix": "example.com", "replace": {"192.0.2.1": "fd00::1"}}]`,
+		`[{"suffix": "example.com", "replace": {"192.0.2.1": "nowhere"}}]`,
+		`{"suffix": "example.com"}`,
+	} {
+		if err := os.WriteFile(path, []byte(invalid), 0644); err != nil {
+			t.Fatalf("Failed to write rules: %v", err)
+		}
+		if _, err := LoadFile(path); err == nil {
+			t.Errorf("Expected an error for %s", invalid)
+		}
+	}
+}
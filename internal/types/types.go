@@ -21,6 +21,15 @@ type LogEntry struct {
 	IPAddresses []string          `json:"ip_addresses,omitempty"`
 	Status      string            `json:"status"`
 	Duration    float64           `json:"total_duration_ms"`
+	Timings     *Timings          `json:"timings,omitempty"`
+}
+
+// Timings breaks the total duration of an upstream-resolved request down by
+// phase, in milliseconds
+type Timings struct {
+	Local    float64 `json:"local_ms"`    // Local checks before querying upstream
+	Upstream float64 `json:"upstream_ms"` // Upstream resolution, including retries
+	Write    float64 `json:"write_ms"`    // Writing the response to the client
 }
 
 // RequestInfo contains information about the DNS request
@@ -33,11 +42,13 @@ type RequestInfo struct {
 
 // UpstreamAttempt represents an attempt to query an upstream server
 type UpstreamAttempt struct {
-	Server   string   `json:"server"`
-	Attempt  int      `json:"attempt"`
-	Error    *string  `json:"error,omitempty"`
-	RTT      *float64 `json:"rtt_ms,omitempty"`
-	Duration float64  `json:"duration_ms"`
+	Server     string   `json:"server"`
+	Attempt    int      `json:"attempt"`
+	Error      *string  `json:"error,omitempty"`
+	RTT        *float64 `json:"rtt_ms,omitempty"`
+	Duration   float64  `json:"duration_ms"`
+	Successful bool     `json:"successful,omitempty"` // Attempt whose response was returned to the client
+	Truncated  bool     `json:"truncated,omitempty"`  // The UDP answer was truncated
 }
 
 // ResponseInfo contains information about the successful response
@@ -96,3 +107,16 @@ func ExtractIPAddresses(answers []dns.RR) []string {
 	}
 	return ips
 }
+
+// EDNS0SourceOption is an EDNS0 option code from the local/experimental range
+// (RFC 6891). A loopback client that adds it to a query, as the API server's
+// test resolutions do, gets the AnswerSource back in the same option.
+const EDNS0SourceOption = 65001
+
+// AnswerSource describes how the DNS server answered a query: its log status
+// and the upstream server that answered, or "local" and "custom" for answers
+// the server made itself
+type AnswerSource struct {
+	Status   string `json:"status"`
+	Upstream string `json:"upstream"`
+}
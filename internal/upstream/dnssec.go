@@ -0,0 +1,626 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// rootAnchors are the DS records of the root zone's key signing keys
+// (KSK-2017 and KSK-2024), as published by IANA
+var rootAnchors = []string{
+	". 86400 IN DS 20326 8 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D",
+	". 86400 IN DS 38696 8 2 683D2D0ACB8C9B712A1948B27F741219298D0A450D612C483AF444A4C0FB2B16",
+}
+
+// maxChainLength bounds the number of zones followed up to a trust anchor
+const maxChainLength = 16
+
+// maxChainCacheSize bounds the number of validated links of chains of trust
+// kept by a Manager
+const maxChainCacheSize = 4096
+
+// nsec3OptOut is the opt-out flag of NSEC3 records (RFC 5155 section 3.1.2.1)
+const nsec3OptOut = 1
+
+var (
+	// errBogus marks answers whose signatures do not validate
+	errBogus = errors.New("DNSSEC validation failed")
+
+	// errInsecure marks answers that cannot be traced to a trust anchor
+	// because a delegation on the way is proven to have no DS record
+	errInsecure = errors.New("no DNSSEC chain of trust")
+)
+
+// rootTrustAnchors parses rootAnchors
+func rootTrustAnchors() []*dns.DS {
+	anchors := make([]*dns.DS, 0, len(rootAnchors))
+	for _, anchor := range rootAnchors {
+		rr, err := dns.NewRR(anchor)
+		if err != nil {
+			panic(fmt.Sprintf("invalid root trust anchor %q: %v", anchor, err))
+		}
+		anchors = append(anchors, rr.(*dns.DS))
+	}
+	return anchors
+}
+
+// SetDNSSECValidation controls whether queries request DNSSEC records (the DO
+// bit) and signed answers are validated up to the root trust anchor (disabled
+// by default). Validated answers get the AD bit; answers failing validation,
+// including unsigned answers from signed zones, are replaced by SERVFAIL.
+// Unsigned answers below a proven unsigned delegation are relayed without the
+// AD bit.
+func (m *Manager) SetDNSSECValidation(enabled bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.dnssecValidate = enabled
+}
+
+// requestDNSSEC returns a copy of msg with the DO bit set, and whether an OPT
+// record had to be added
+func requestDNSSEC(msg *dns.Msg) (*dns.Msg, bool) {
+	msg = msg.Copy()
+	if opt := msg.IsEdns0(); opt != nil {
+		opt.SetDo()
+		return msg, false
+	}
+	msg.SetEdns0(dns.DefaultMsgSize, true)
+	return msg, true
+}
+
+// validateResponse validates the signatures of an upstream response to query,
+// unless the client disabled checking. It returns the response with the AD bit
+// reflecting the outcome, or SERVFAIL when validation fails. DNSSEC records the
+// client did not ask for are removed.
+func (m *Manager) validateResponse(ctx context.Context, server *Server, query, resp *dns.Msg, clientDO bool) *dns.Msg {
+	if !query.CheckingDisabled {
+		v := &validator{m: m, ctx: ctx, server: server}
+		secure, err := v.validate(query, resp)
+		if err != nil {
+			failed := new(dns.Msg)
+			failed.SetRcode(query, dns.RcodeServerFailure)
+			return failed
+		}
+		resp.AuthenticatedData = secure
+	}
+
+	if !clientDO {
+		stripDNSSEC(resp, query.Question)
+	}
+	return resp
+}
+
+// stripDNSSEC removes the DNSSEC records a client did not request, keeping
+// those of the queried type, and clears the DO bit of the response
+func stripDNSSEC(resp *dns.Msg, question []dns.Question) {
+	keep := func(rrs []dns.RR) []dns.RR {
+		kept := rrs[:0]
+		for _, rr := range rrs {
+			switch rr.Header().Rrtype {
+			case dns.TypeRRSIG, dns.TypeNSEC, dns.TypeNSEC3:
+				if len(question) == 0 || question[0].Qtype != rr.Header().Rrtype {
+					continue
+				}
+			}
+			kept = append(kept, rr)
+		}
+		if len(kept) == 0 {
+			return nil
+		}
+		return kept
+	}
+
+	resp.Answer = keep(resp.Answer)
+	resp.Ns = keep(resp.Ns)
+	resp.Extra = keep(resp.Extra)
+	if opt := resp.IsEdns0(); opt != nil {
+		opt.SetDo(false)
+	}
+}
+
+// rrset is a set of records sharing owner, class and type, with the signatures
+// covering it
+type rrset struct {
+	rrs  []dns.RR
+	sigs []*dns.RRSIG
+}
+
+// rrsets groups a message section into RRsets in order of first appearance
+func rrsets(section []dns.RR) []*rrset {
+	var sets []*rrset
+	index := make(map[string]*rrset)
+	key := func(name string, class, rrtype uint16) string {
+		return fmt.Sprintf("%s/%d/%d", dns.CanonicalName(name), class, rrtype)
+	}
+
+	for _, rr := range section {
+		hdr := rr.Header()
+		if hdr.Rrtype == dns.TypeRRSIG {
+			continue
+		}
+		k := key(hdr.Name, hdr.Class, hdr.Rrtype)
+		set, ok := index[k]
+		if !ok {
+			set = &rrset{}
+			index[k] = set
+			sets = append(sets, set)
+		}
+		set.rrs = append(set.rrs, rr)
+	}
+
+	for _, rr := range section {
+		sig, ok := rr.(*dns.RRSIG)
+		if !ok {
+			continue
+		}
+		if set, ok := index[key(sig.Hdr.Name, sig.Hdr.Class, sig.TypeCovered)]; ok {
+			set.sigs = append(set.sigs, sig)
+		}
+	}
+	return sets
+}
+
+// chainLink is a validated link of a chain of trust: the DNSKEY set of a zone,
+// or the DS set of a name, which is empty when the name is proven not to be a
+// zone cut and err is errInsecure when it is an unsigned delegation
+type chainLink struct {
+	keys    []*dns.DNSKEY
+	ds      []*dns.DS
+	err     error
+	expires time.Time
+}
+
+// cachedLink returns a link of a chain of trust validated earlier, unless its
+// records or signatures have expired since
+func (m *Manager) cachedLink(key string) (chainLink, bool) {
+	m.chainMu.Lock()
+	defer m.chainMu.Unlock()
+
+	link, ok := m.chainCache[key]
+	if !ok {
+		return chainLink{}, false
+	}
+	if !time.Now().Before(link.expires) {
+		delete(m.chainCache, key)
+		return chainLink{}, false
+	}
+	return link, true
+}
+
+// cacheLink keeps a validated link of a chain of trust until it expires
+func (m *Manager) cacheLink(key string, link chainLink) {
+	m.chainMu.Lock()
+	defer m.chainMu.Unlock()
+
+	if m.chainCache == nil {
+		m.chainCache = make(map[string]chainLink)
+	}
+	if len(m.chainCache) >= maxChainCacheSize {
+		now := time.Now()
+		for k, cached := range m.chainCache {
+			if !now.Before(cached.expires) {
+				delete(m.chainCache, k)
+			}
+		}
+		if len(m.chainCache) >= maxChainCacheSize {
+			return
+		}
+	}
+	m.chainCache[key] = link
+}
+
+// linkExpiry returns when records validated from sets must be validated again:
+// when the first of their TTLs runs out or the first signature expires
+func linkExpiry(sets ...*rrset) time.Time {
+	now := time.Now()
+	var expires time.Time
+	earlier := func(t time.Time) {
+		if expires.IsZero() || t.Before(expires) {
+			expires = t
+		}
+	}
+
+	for _, set := range sets {
+		for _, rr := range set.rrs {
+			earlier(now.Add(time.Duration(rr.Header().Ttl) * time.Second))
+		}
+		for _, sig := range set.sigs {
+			if sig.ValidityPeriod(now) {
+				earlier(time.Unix(int64(sig.Expiration), 0))
+			}
+		}
+	}
+	return expires
+}
+
+// validator checks the signatures of a response with keys fetched from the
+// same upstream, following DS records from zone to parent up to a trust anchor.
+// Validated keys and DS sets are cached on the Manager.
+type validator struct {
+	m      *Manager
+	ctx    context.Context
+	server *Server
+	zones  int // zones looked up so far
+}
+
+// validate verifies every RRset in the answer and authority sections. It
+// reports whether the answer is secure, i.e. non-empty with every RRset signed
+// and traced to a trust anchor, or an error wrapping errBogus when a signature
+// does not validate, an RRset of a signed zone is not signed, or a negative
+// answer for a name in a signed zone comes without a signed SOA, NSEC or NSEC3
+// record.
+func (v *validator) validate(query, resp *dns.Msg) (bool, error) {
+	secure := len(resp.Answer) > 0
+	var signedDenial bool
+
+	for _, section := range [][]dns.RR{resp.Answer, resp.Ns} {
+		for _, set := range rrsets(section) {
+			var err error
+			if len(set.sigs) == 0 {
+				err = v.unsigned(set)
+			} else {
+				err = v.verify(set)
+			}
+			if errors.Is(err, errInsecure) {
+				secure = false
+				continue
+			}
+			if err != nil {
+				return false, err
+			}
+			switch set.rrs[0].Header().Rrtype {
+			case dns.TypeSOA, dns.TypeNSEC, dns.TypeNSEC3:
+				signedDenial = signedDenial || len(set.sigs) > 0
+			}
+		}
+	}
+
+	if len(resp.Answer) == 0 {
+		// Denial of existence is not checked, so it is never reported as secure,
+		// but it must at least be signed unless the name is in no signed zone
+		if !signedDenial && len(query.Question) > 0 {
+			err := v.chainStatus(query.Question[0].Name)
+			if err == nil {
+				return false, fmt.Errorf("%w: negative answer for %s is not signed in a signed zone", errBogus, query.Question[0].Name)
+			}
+			if !errors.Is(err, errInsecure) {
+				return false, err
+			}
+		}
+		secure = false
+	}
+	return secure, nil
+}
+
+// unsigned checks an RRset without signatures, which is only acceptable below
+// a delegation proven to be unsigned
+func (v *validator) unsigned(set *rrset) error {
+	hdr := set.rrs[0].Header()
+	if err := v.chainStatus(hdr.Name); err != nil {
+		return err
+	}
+	return fmt.Errorf("%w: %s %s is not signed in a signed zone", errBogus, hdr.Name, dns.TypeToString[hdr.Rrtype])
+}
+
+// chainStatus follows the chain of trust from the closest trust anchor down to
+// name, one label at a time. It returns nil when name is in a signed zone,
+// errInsecure when a delegation on the way is proven unsigned or no trust
+// anchor covers name, or an error wrapping errBogus when a DS record is
+// neither validated nor proven absent.
+func (v *validator) chainStatus(name string) error {
+	name = dns.CanonicalName(name)
+	names := []string{name}
+	for off, end := dns.NextLabel(name, 0); !end; off, end = dns.NextLabel(name, off) {
+		names = append(names, name[off:])
+	}
+	if name != "." {
+		names = append(names, ".")
+	}
+
+	for i, zone := range names {
+		if len(v.m.anchorsFor(zone)) == 0 {
+			continue
+		}
+		for j := i - 1; j >= 0; j-- {
+			if _, err := v.delegation(names[j]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: no trust anchor covers %s", errInsecure, name)
+}
+
+// verify checks that one of the signatures covering an RRset was made by a
+// validated key of its zone and is currently valid
+func (v *validator) verify(set *rrset) error {
+	owner := set.rrs[0].Header().Name
+	lastErr := fmt.Errorf("%w: no usable signature for %s %s", errBogus, owner, dns.TypeToString[set.rrs[0].Header().Rrtype])
+
+	for _, sig := range set.sigs {
+		// Signers must be the owner's zone; DS records are signed by the parent
+		if !dns.IsSubDomain(sig.SignerName, owner) {
+			continue
+		}
+		if sig.TypeCovered == dns.TypeDS && dns.CanonicalName(sig.SignerName) == dns.CanonicalName(owner) {
+			continue
+		}
+
+		keys, err := v.zoneKeys(sig.SignerName)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if verifiedBy(sig, keys, set.rrs) {
+			return nil
+		}
+		lastErr = fmt.Errorf("%w: signature of %s %s by key %d does not verify", errBogus, owner, dns.TypeToString[sig.TypeCovered], sig.KeyTag)
+	}
+	return lastErr
+}
+
+// verifiedBy reports whether sig is currently valid and made by one of keys
+func verifiedBy(sig *dns.RRSIG, keys []*dns.DNSKEY, rrs []dns.RR) bool {
+	if !sig.ValidityPeriod(time.Now()) {
+		return false
+	}
+	for _, key := range keys {
+		if key.KeyTag() == sig.KeyTag && key.Algorithm == sig.Algorithm && sig.Verify(key, rrs) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// zoneKeys returns the DNSKEY set of a zone once it is signed by a key matching
+// one of the zone's trusted DS records
+func (v *validator) zoneKeys(zone string) ([]*dns.DNSKEY, error) {
+	zone = dns.CanonicalName(zone)
+	if link, ok := v.m.cachedLink("DNSKEY/" + zone); ok {
+		return link.keys, nil
+	}
+
+	v.zones++
+	if v.zones > maxChainLength {
+		return nil, fmt.Errorf("%w: chain of trust for %s is too long", errBogus, zone)
+	}
+
+	ds, err := v.trustedDS(zone)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := v.lookup(zone, dns.TypeDNSKEY)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []*dns.DNSKEY
+	var keySet []dns.RR
+	var sigs []*dns.RRSIG
+	for _, rr := range resp.Answer {
+		switch rr := rr.(type) {
+		case *dns.DNSKEY:
+			keys = append(keys, rr)
+			keySet = append(keySet, rr)
+		case *dns.RRSIG:
+			if rr.TypeCovered == dns.TypeDNSKEY {
+				sigs = append(sigs, rr)
+			}
+		}
+	}
+
+	// The key set must be signed by a key the parent vouches for
+	var anchored []*dns.DNSKEY
+	for _, key := range keys {
+		if matchesDS(key, ds) {
+			anchored = append(anchored, key)
+		}
+	}
+	for _, sig := range sigs {
+		if verifiedBy(sig, anchored, keySet) {
+			// The keys are only trusted as long as the DS records vouching for them
+			expires := linkExpiry(&rrset{rrs: keySet, sigs: sigs})
+			if link, ok := v.m.cachedLink("DS/" + zone); ok && link.expires.Before(expires) {
+				expires = link.expires
+			}
+			v.m.cacheLink("DNSKEY/"+zone, chainLink{keys: keys, expires: expires})
+			return keys, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: DNSKEY set of %s is not signed by a key matching its DS", errBogus, zone)
+}
+
+// matchesDS reports whether a key is referenced by one of the DS records
+func matchesDS(key *dns.DNSKEY, ds []*dns.DS) bool {
+	for _, d := range ds {
+		if d.KeyTag != key.KeyTag() || d.Algorithm != key.Algorithm {
+			continue
+		}
+		if digest := key.ToDS(d.DigestType); digest != nil && strings.EqualFold(digest.Digest, d.Digest) {
+			return true
+		}
+	}
+	return false
+}
+
+// trustedDS returns the trust anchors configured for a zone, or its DS set
+// validated with the parent zone's keys. A zone below an unsigned delegation
+// is insecure; one whose DS records are missing without proof is bogus.
+func (v *validator) trustedDS(zone string) ([]*dns.DS, error) {
+	if anchors := v.m.anchorsFor(zone); len(anchors) > 0 {
+		return anchors, nil
+	}
+	if zone == "." {
+		return nil, fmt.Errorf("%w: no trust anchor for the root zone", errBogus)
+	}
+
+	if err := v.chainStatus(parentZone(zone)); err != nil {
+		return nil, err
+	}
+	ds, err := v.delegation(zone)
+	if err != nil {
+		return nil, err
+	}
+	if len(ds) == 0 {
+		return nil, fmt.Errorf("%w: %s signs records but is not a zone cut", errBogus, zone)
+	}
+	return ds, nil
+}
+
+// delegation returns the validated DS set of name. It returns no records and
+// no error when name is proven not to be a zone cut, errInsecure when it is
+// proven to be an unsigned delegation, and an error wrapping errBogus when the
+// absence of DS records is not proven.
+func (v *validator) delegation(name string) ([]*dns.DS, error) {
+	name = dns.CanonicalName(name)
+	if link, ok := v.m.cachedLink("DS/" + name); ok {
+		return link.ds, link.err
+	}
+
+	resp, err := v.lookup(name, dns.TypeDS)
+	if err != nil {
+		return nil, err
+	}
+
+	var ds []*dns.DS
+	set := &rrset{}
+	for _, rr := range resp.Answer {
+		switch rr := rr.(type) {
+		case *dns.DS:
+			ds = append(ds, rr)
+			set.rrs = append(set.rrs, rr)
+		case *dns.RRSIG:
+			if rr.TypeCovered == dns.TypeDS {
+				set.sigs = append(set.sigs, rr)
+			}
+		}
+	}
+	if len(ds) > 0 {
+		if err := v.verify(set); err != nil {
+			return nil, err
+		}
+		v.m.cacheLink("DS/"+name, chainLink{ds: ds, expires: linkExpiry(set)})
+		return ds, nil
+	}
+
+	// Without DS records, NSEC or NSEC3 records signed above name must prove
+	// their absence
+	var proofs []*rrset
+	for _, proof := range rrsets(resp.Ns) {
+		switch proof.rrs[0].Header().Rrtype {
+		case dns.TypeNSEC, dns.TypeNSEC3:
+		default:
+			continue
+		}
+		proof.sigs = slices.DeleteFunc(proof.sigs, func(sig *dns.RRSIG) bool {
+			return dns.CanonicalName(sig.SignerName) == name
+		})
+		if len(proof.sigs) == 0 {
+			continue
+		}
+		if err := v.verify(proof); err != nil {
+			return nil, err
+		}
+		proofs = append(proofs, proof)
+	}
+
+	proven, cut := provesNoDS(name, proofs)
+	if !proven {
+		return nil, fmt.Errorf("%w: %s has no DS record and no proof of its absence", errBogus, name)
+	}
+	link := chainLink{expires: linkExpiry(proofs...)}
+	if cut {
+		link.err = fmt.Errorf("%w: %s is an unsigned delegation", errInsecure, name)
+	}
+	v.m.cacheLink("DS/"+name, link)
+	return nil, link.err
+}
+
+// provesNoDS reports whether validated NSEC or NSEC3 records prove that name
+// has no DS record, and whether name is then an unsigned delegation: a zone
+// cut, or a name covered by an opt-out NSEC3 record
+func provesNoDS(name string, proofs []*rrset) (proven, cut bool) {
+	// A record from the apex of the child zone (NS and SOA) proves nothing
+	// about the DS records kept by the parent
+	noDS := func(bitmap []uint16) bool {
+		return !slices.Contains(bitmap, dns.TypeDS) &&
+			!(slices.Contains(bitmap, dns.TypeNS) && slices.Contains(bitmap, dns.TypeSOA))
+	}
+
+	var optOut, encloser bool
+	parent := parentZone(name)
+	for _, proof := range proofs {
+		for _, rr := range proof.rrs {
+			switch rr := rr.(type) {
+			case *dns.NSEC:
+				if dns.CanonicalName(rr.Hdr.Name) == name && noDS(rr.TypeBitMap) {
+					return true, slices.Contains(rr.TypeBitMap, dns.TypeNS)
+				}
+			case *dns.NSEC3:
+				if rr.Match(name) && noDS(rr.TypeBitMap) {
+					return true, slices.Contains(rr.TypeBitMap, dns.TypeNS)
+				}
+				if rr.Flags&nsec3OptOut != 0 && !rr.Match(name) && rr.Cover(name) {
+					optOut = true
+				}
+				if rr.Match(parent) {
+					encloser = true
+				}
+			}
+		}
+	}
+
+	// The parent is the closest encloser and name, the next closer name, is
+	// covered by an opt-out record (RFC 5155 section 8.6)
+	if optOut && encloser {
+		return true, true
+	}
+	return false, false
+}
+
+// parentZone returns the name one label above name
+func parentZone(name string) string {
+	off, end := dns.NextLabel(name, 0)
+	if end {
+		return "."
+	}
+	return name[off:]
+}
+
+// lookup queries the upstream for records needed to build the chain of trust
+func (v *validator) lookup(name string, qtype uint16) (*dns.Msg, error) {
+	msg := new(dns.Msg)
+	msg.SetQuestion(name, qtype)
+	msg.SetEdns0(dns.DefaultMsgSize, true)
+	msg.CheckingDisabled = true
+
+	resp, _, _, err := v.m.exchange(v.ctx, v.server, msg)
+	if err != nil {
+		return nil, fmt.Errorf("%w: looking up %s %s: %v", errBogus, name, dns.TypeToString[qtype], err)
+	}
+	if resp.Rcode != dns.RcodeSuccess {
+		return nil, fmt.Errorf("%w: looking up %s %s: %s", errBogus, name, dns.TypeToString[qtype], dns.RcodeToString[resp.Rcode])
+	}
+	return resp, nil
+}
+
+// anchorsFor returns the trust anchors configured for a zone
+func (m *Manager) anchorsFor(zone string) []*dns.DS {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var anchors []*dns.DS
+	for _, anchor := range m.trustAnchors {
+		if dns.CanonicalName(anchor.Hdr.Name) == zone {
+			anchors = append(anchors, anchor)
+		}
+	}
+	return anchors
+}
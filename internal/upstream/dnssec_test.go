@@ -0,0 +1,304 @@
+package upstream
+
+import (
+	"context"
+	"crypto"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"dns-go/internal/upstream/testutil"
+
+	"github.com/miekg/dns"
+)
+
+// signedZone is a DNSSEC-signed fixture zone served by a fake upstream, with
+// the unsigned delegations insecure.example. (proven by NSEC) and
+// optout.example. (proven by opt-out NSEC3)
+type signedZone struct {
+	key        *dns.DNSKEY
+	answers    map[string][]dns.RR // by query name and type
+	authority  map[string][]dns.RR // by query name and type
+	rcodes     map[string]int      // by query name and type, NOERROR if missing
+	sawDO      atomic.Bool         // the last A query asked for DNSSEC records
+	stripSigs  atomic.Bool         // answer A queries without their signatures
+	keyQueries atomic.Int32        // DNSKEY queries answered
+}
+
+func newSignedZone(t *testing.T) *signedZone {
+	t.Helper()
+
+	key := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: "example.", Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+		Flags:     257,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+	priv, err := key.Generate(256)
+	if err != nil {
+		t.Fatalf("Failed to generate zone key: %v", err)
+	}
+
+	sign := func(rrs ...dns.RR) []dns.RR {
+		sig := &dns.RRSIG{
+			Hdr:        dns.RR_Header{Name: rrs[0].Header().Name, Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: 3600},
+			Algorithm:  key.Algorithm,
+			Inception:  uint32(time.Now().Add(-time.Hour).Unix()),
+			Expiration: uint32(time.Now().Add(time.Hour).Unix()),
+			KeyTag:     key.KeyTag(),
+			SignerName: key.Hdr.Name,
+		}
+		if err := sig.Sign(priv.(crypto.Signer), rrs); err != nil {
+			t.Fatalf("Failed to sign %v: %v", rrs, err)
+		}
+		return append(rrs, sig)
+	}
+	a := func(name, ip string) *dns.A {
+		return &dns.A{
+			Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 3600},
+			A:   net.ParseIP(ip).To4(),
+		}
+	}
+
+	nsec3 := func(owner, next string, flags uint8, types ...uint16) *dns.NSEC3 {
+		return &dns.NSEC3{
+			Hdr:        dns.RR_Header{Name: owner + ".example.", Rrtype: dns.TypeNSEC3, Class: dns.ClassINET, Ttl: 3600},
+			Hash:       dns.SHA1,
+			Flags:      flags,
+			HashLength: 20,
+			NextDomain: next,
+			TypeBitMap: types,
+		}
+	}
+
+	tampered := sign(a("tampered.example.", "192.0.2.1"))
+	tampered[0].(*dns.A).A = net.ParseIP("203.0.113.66").To4()
+
+	insecure := sign(&dns.NSEC{
+		Hdr:        dns.RR_Header{Name: "insecure.example.", Rrtype: dns.TypeNSEC, Class: dns.ClassINET, Ttl: 3600},
+		NextDomain: "tampered.example.",
+		TypeBitMap: []uint16{dns.TypeNS, dns.TypeRRSIG, dns.TypeNSEC},
+	})
+	apexHash := dns.HashName("example.", dns.SHA1, 0, "")
+	optOut := append(
+		sign(nsec3(apexHash, strings.Repeat("V", 32), 0, dns.TypeNS, dns.TypeSOA, dns.TypeDNSKEY)),
+		sign(nsec3(strings.Repeat("0", 32), strings.Repeat("V", 32), 1, dns.TypeNS))...,
+	)
+
+	soa := sign(&dns.SOA{
+		Hdr:     dns.RR_Header{Name: "example.", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 3600},
+		Ns:      "ns.example.",
+		Mbox:    "hostmaster.example.",
+		Serial:  1,
+		Refresh: 3600,
+		Retry:   600,
+		Expire:  86400,
+		Minttl:  300,
+	})
+
+	return &signedZone{
+		key: key,
+		answers: map[string][]dns.RR{
+			"example./DNSKEY":         sign(key),
+			"www.example./A":          sign(a("www.example.", "192.0.2.1")),
+			"tampered.example./A":     tampered,
+			"unsigned.example./A":     {a("unsigned.example.", "192.0.2.2")},
+			"www.insecure.example./A": {a("www.insecure.example.", "192.0.2.3")},
+			"www.optout.example./A":   {a("www.optout.example.", "192.0.2.4")},
+		},
+		authority: map[string][]dns.RR{
+			"insecure.example./DS": insecure,
+			"optout.example./DS":   optOut,
+			"missing.example./A":   soa,
+		},
+		rcodes: map[string]int{
+			"missing.example./A":       dns.RcodeNameError,
+			"forged.example./A":        dns.RcodeNameError,
+			"gone.insecure.example./A": dns.RcodeNameError,
+		},
+	}
+}
+
+// handle answers queries from the fixture zone, including signatures only
+// when the query has the DO bit set
+func (z *signedZone) handle(req *dns.Msg) *dns.Msg {
+	q := req.Question[0]
+	do := req.IsEdns0() != nil && req.IsEdns0().Do()
+	if q.Qtype == dns.TypeA {
+		z.sawDO.Store(do)
+	}
+
+	if q.Qtype == dns.TypeDNSKEY {
+		z.keyQueries.Add(1)
+	}
+	strip := !do || (q.Qtype == dns.TypeA && z.stripSigs.Load())
+
+	resp := new(dns.Msg)
+	resp.SetRcode(req, z.rcodes[q.Name+"/"+dns.TypeToString[q.Qtype]])
+	for _, rr := range z.answers[q.Name+"/"+dns.TypeToString[q.Qtype]] {
+		if rr.Header().Rrtype == dns.TypeRRSIG && strip {
+			continue
+		}
+		resp.Answer = append(resp.Answer, dns.Copy(rr))
+	}
+	for _, rr := range z.authority[q.Name+"/"+dns.TypeToString[q.Qtype]] {
+		if rr.Header().Rrtype == dns.TypeRRSIG && !do {
+			continue
+		}
+		resp.Ns = append(resp.Ns, dns.Copy(rr))
+	}
+	if opt := req.IsEdns0(); opt != nil {
+		resp.SetEdns0(opt.UDPSize(), do)
+	}
+	return resp
+}
+
+func TestQuerySingle_DNSSECValidation(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     string
+		clientDO  bool
+		cd        bool
+		untrusted bool
+		stripped  bool
+		wantRcode int
+		wantAD    bool
+		wantSigs  bool
+	}{
+		{name: "signed answer with DO", query: "www.example.", clientDO: true, wantRcode: dns.RcodeSuccess, wantAD: true, wantSigs: true},
+		{name: "signed answer without EDNS0", query: "www.example.", wantRcode: dns.RcodeSuccess, wantAD: true},
+		{name: "tampered answer", query: "tampered.example.", clientDO: true, wantRcode: dns.RcodeServerFailure},
+		{name: "tampered answer with checking disabled", query: "tampered.example.", clientDO: true, cd: true, wantRcode: dns.RcodeSuccess, wantSigs: true},
+		{name: "unsigned answer in a signed zone", query: "unsigned.example.", wantRcode: dns.RcodeServerFailure},
+		{name: "signatures stripped", query: "www.example.", clientDO: true, stripped: true, wantRcode: dns.RcodeServerFailure},
+		{name: "unsigned answer below an NSEC proven delegation", query: "www.insecure.example.", wantRcode: dns.RcodeSuccess},
+		{name: "unsigned answer below an opt-out delegation", query: "www.optout.example.", wantRcode: dns.RcodeSuccess},
+		{name: "signed negative answer", query: "missing.example.", clientDO: true, wantRcode: dns.RcodeNameError},
+		{name: "negative answer without records in a signed zone", query: "forged.example.", clientDO: true, wantRcode: dns.RcodeServerFailure},
+		{name: "negative answer without records below an unsigned delegation", query: "gone.insecure.example.", wantRcode: dns.RcodeNameError},
+		{name: "key not matching the trust anchor", query: "www.example.", clientDO: true, untrusted: true, wantRcode: dns.RcodeServerFailure},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			zone := newSignedZone(t)
+			zone.stripSigs.Store(tt.stripped)
+			fake := testutil.NewServer(t, zone.handle)
+			m := New([]string{fake.Addr}, testTimeout, 0)
+			m.SetDNSSECValidation(true)
+			m.trustAnchors = []*dns.DS{zone.key.ToDS(dns.SHA256)}
+			if tt.untrusted {
+				m.trustAnchors = []*dns.DS{newSignedZone(t).key.ToDS(dns.SHA256)}
+			}
+
+			msg := newQuery(tt.query)
+			msg.CheckingDisabled = tt.cd
+			if tt.clientDO {
+				msg.SetEdns0(dns.DefaultMsgSize, true)
+			}
+
+			result := m.querySingle(context.Background(), m.servers[0], msg)
+			if result.Error != nil {
+				t.Fatalf("Expected no error, got: %v", result.Error)
+			}
+			resp := result.Response
+			if !zone.sawDO.Load() {
+				t.Error("Expected the upstream query to have the DO bit set")
+			}
+			if resp.Id != msg.Id {
+				t.Errorf("Expected response ID %d, got %d", msg.Id, resp.Id)
+			}
+			if resp.Rcode != tt.wantRcode {
+				t.Fatalf("Expected rcode %s, got %s", dns.RcodeToString[tt.wantRcode], dns.RcodeToString[resp.Rcode])
+			}
+			if resp.AuthenticatedData != tt.wantAD {
+				t.Errorf("Expected AD=%v, got %v", tt.wantAD, resp.AuthenticatedData)
+			}
+
+			var sigs int
+			for _, rr := range resp.Answer {
+				if rr.Header().Rrtype == dns.TypeRRSIG {
+					sigs++
+				}
+			}
+			if (sigs > 0) != tt.wantSigs {
+				t.Errorf("Expected signatures=%v, got answer %v", tt.wantSigs, resp.Answer)
+			}
+			if !tt.clientDO && resp.IsEdns0() != nil {
+				t.Errorf("Expected no OPT record for a client without EDNS0, got %v", resp.IsEdns0())
+			}
+		})
+	}
+}
+
+func TestQuerySingle_DNSSECDisabledPassesAnswerThrough(t *testing.T) {
+	zone := newSignedZone(t)
+	fake := testutil.NewServer(t, zone.handle)
+	m := New([]string{fake.Addr}, testTimeout, 0)
+
+	result := m.querySingle(context.Background(), m.servers[0], newQuery("tampered.example."))
+	if result.Error != nil || result.Response.Rcode != dns.RcodeSuccess {
+		t.Fatalf("Expected the answer to be relayed unvalidated, got %+v", result)
+	}
+	if zone.sawDO.Load() {
+		t.Error("Expected no DO bit without DNSSEC validation")
+	}
+}
+
+func TestQuerySingle_DNSSECCachesChainOfTrust(t *testing.T) {
+	zone := newSignedZone(t)
+	fake := testutil.NewServer(t, zone.handle)
+	m := New([]string{fake.Addr}, testTimeout, 0)
+	m.SetDNSSECValidation(true)
+	m.trustAnchors = []*dns.DS{zone.key.ToDS(dns.SHA256)}
+
+	for i := 0; i < 2; i++ {
+		result := m.querySingle(context.Background(), m.servers[0], newQuery("www.example."))
+		if result.Error != nil || !result.Response.AuthenticatedData {
+			t.Fatalf("Expected a validated answer, got %+v", result)
+		}
+	}
+	if got := zone.keyQueries.Load(); got != 1 {
+		t.Errorf("Expected the DNSKEY set to be fetched once, got %d queries", got)
+	}
+
+	// Expired links are validated again
+	m.chainMu.Lock()
+	for key, link := range m.chainCache {
+		link.expires = time.Now().Add(-time.Second)
+		m.chainCache[key] = link
+	}
+	m.chainMu.Unlock()
+
+	result := m.querySingle(context.Background(), m.servers[0], newQuery("www.example."))
+	if result.Error != nil || !result.Response.AuthenticatedData {
+		t.Fatalf("Expected a validated answer, got %+v", result)
+	}
+	if got := zone.keyQueries.Load(); got != 2 {
+		t.Errorf("Expected the expired DNSKEY set to be fetched again, got %d queries", got)
+	}
+}
+
+func TestLinkExpiry(t *testing.T) {
+	now := time.Now()
+	set := &rrset{
+		rrs: []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: "www.example.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 3600}}},
+		sigs: []*dns.RRSIG{{
+			Inception:  uint32(now.Add(-time.Hour).Unix()),
+			Expiration: uint32(now.Add(10 * time.Minute).Unix()),
+		}},
+	}
+
+	expires := linkExpiry(set)
+	if expires.Sub(now) > 10*time.Minute || expires.Sub(now) < 9*time.Minute {
+		t.Errorf("Expected expiry at the signature expiration, got %v", expires.Sub(now))
+	}
+
+	set.sigs[0].Expiration = uint32(now.Add(2 * time.Hour).Unix())
+	expires = linkExpiry(set)
+	if expires.Sub(now) > time.Hour+time.Second || expires.Sub(now) < 59*time.Minute {
+		t.Errorf("Expected expiry at the end of the TTL, got %v", expires.Sub(now))
+	}
+}
@@ -0,0 +1,113 @@
+package upstream
+
+import (
+	"context"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"dns-go/internal/upstream/testutil"
+
+	"github.com/miekg/dns"
+)
+
+// newDoHServer starts an HTTP/2 DoH server answering every query with an A
+// record, counting the TCP connections clients open to it
+func newDoHServer(t testing.TB) (*httptest.Server, *int64) {
+	t.Helper()
+
+	answer := testutil.Answer("192.0.2.1", 60)
+	var conns int64
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 {
+			http.Error(w, "expected HTTP/2", http.StatusHTTPVersionNotSupported)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		req := new(dns.Msg)
+		if err := req.Unpack(body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		packed, err := answer(req).Pack()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(packed)
+	}))
+	srv.EnableHTTP2 = true
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt64(&conns, 1)
+		}
+	}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+
+	return srv, &conns
+}
+
+// trustServer makes the manager's DoH client trust the test server's certificate
+func trustServer(m *Manager, srv *httptest.Server) {
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+	m.dohPool.TLSClientConfig.RootCAs = pool
+}
+
+func TestQueryDoH_ReusesConnections(t *testing.T) {
+	srv, conns := newDoHServer(t)
+
+	const poolSize = 4
+	m := New([]string{srv.URL + "/dns-query"}, testTimeout, 0)
+	m.SetDoHPoolSize(poolSize)
+	trustServer(m, srv)
+
+	var wg sync.WaitGroup
+	var failures int64
+	for round := 0; round < 5; round++ {
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				result := m.querySingle(context.Background(), m.servers[0], newQuery("example.com"))
+				if result.Error != nil || len(result.Response.Answer) != 1 {
+					atomic.AddInt64(&failures, 1)
+				}
+			}()
+		}
+		wg.Wait()
+	}
+
+	if failures > 0 {
+		t.Fatalf("Expected all DoH queries to succeed, %d failed", failures)
+	}
+	if got := atomic.LoadInt64(conns); got < 1 || got > poolSize {
+		t.Errorf("Expected 100 queries to share at most %d connections, got %d", poolSize, got)
+	}
+}
+
+func BenchmarkQueryDoH(b *testing.B) {
+	srv, conns := newDoHServer(b)
+	m := New([]string{srv.URL + "/dns-query"}, testTimeout, 0)
+	trustServer(m, srv)
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			m.querySingle(context.Background(), m.servers[0], newQuery("example.com"))
+		}
+	})
+	b.ReportMetric(float64(atomic.LoadInt64(conns)), "conns")
+}
@@ -10,6 +10,8 @@ import (
 	"net"
 	"net/http"
 	"net/url"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -36,27 +38,134 @@ const (
 	ProtocolDoH                 // DNS over HTTPS
 )
 
+// Strategy selects how a query is spread across upstream servers
+type Strategy int
+
+const (
+	StrategyConcurrent Strategy = iota // Query all healthy servers at once, use the first answer
+	StrategySequential                 // Query healthy servers one at a time in configured order
+)
+
+// RDMode selects the Recursion Desired bit of queries sent upstream
+type RDMode int
+
+const (
+	RDPreserve RDMode = iota // Keep the client's RD bit
+	RDForceOn                // Always ask upstreams to recurse
+	RDForceOff               // Never ask upstreams to recurse
+)
+
+// Health probe settings
+const (
+	DefaultProbeName = "." // probed with an SOA query
+	slowProbeLimit   = 3   // consecutive slow probes before a server is marked unhealthy
+)
+
+// DoH connection pool settings
+const (
+	DefaultDoHPoolSize = 16               // connections opened and kept idle per DoH server
+	dohIdleConnTimeout = 90 * time.Second // idle DoH connections are closed after this
+)
+
 // Server represents an upstream DNS server with health tracking
 type Server struct {
 	Address      string
 	Protocol     Protocol
 	DoHURL       string // For DoH servers, the full URL
+	Priority     int    // Lower values are preferred, see parsePriority
 	State        int64  // atomic ServerState
 	FailureCount int64  // atomic
 	LastCheck    int64  // atomic time.Unix()
 	LastSuccess  int64  // atomic time.Unix()
 	ResponseTime int64  // atomic time in nanoseconds
+	StateChanges int64  // atomic count of health state transitions
+	SlowProbes   int64  // atomic consecutive health probes over the latency limit
+	Truncations  int64  // atomic count of truncated UDP answers
+
+	// UnhealthySince is when the server was last marked unhealthy, as an
+	// atomic time.UnixNano()
+	UnhealthySince int64
+
+	// Timeout overrides the manager timeout for this server when non-zero
+	Timeout time.Duration
+}
+
+// displayAddress returns the address shown in logs and results: the URL for
+// DoH servers, host:port otherwise
+func (s *Server) displayAddress() string {
+	if s.Protocol == ProtocolDoH && s.DoHURL != "" {
+		return s.DoHURL
+	}
+	return s.Address
 }
 
 // Manager handles multiple upstream DNS servers with health checking
 type Manager struct {
 	servers    []*Server
 	client     *dns.Client
+	tcpClient  *dns.Client // retries truncated UDP answers
 	dotClient  *dns.Client // DNS over TLS client
 	httpClient *http.Client
+	dohPool    *http.Transport // DoH connections, reused and HTTP/2 where possible
 	timeout    time.Duration
 	maxRetries int
 
+	// randomizeIDs sends each upstream exchange with a fresh random query ID
+	// instead of the client's, making spoofed answers harder to match
+	randomizeIDs bool
+
+	// servfailIsFailure counts failed answers toward the circuit breaker like
+	// transport failures; otherwise they are soft results that neither trip nor
+	// reset it
+	servfailIsFailure bool
+
+	// failoverOnRefused treats REFUSED answers like SERVFAIL: another upstream's
+	// answer is preferred and they count as failed answers
+	failoverOnRefused bool
+
+	// tcpFallback re-issues queries over TCP when the UDP answer is truncated
+	tcpFallback bool
+
+	// authoritative marks the servers as authoritative for the queried names
+	// rather than recursive resolvers: queries are sent without the RD bit
+	authoritative bool
+
+	// rdMode sets the RD bit of queries to recursive servers
+	rdMode RDMode
+
+	// paddingBlockSize pads queries to a multiple of this many bytes (0 = off),
+	// for DoT/DoH servers and, with padPlain, plain DNS servers too
+	paddingBlockSize int
+	padPlain         bool
+
+	// strategy selects between concurrent and sequential querying in Query
+	strategy Strategy
+
+	// staggerDelay spaces out concurrent queries: servers are queried fastest
+	// first, each one this much later than the previous (0 = all at once)
+	staggerDelay time.Duration
+
+	// tlsObserver receives the handshake details of new DoT/DoH connections
+	tlsObserver func(TLSDetails)
+
+	// stateObserver receives upstream health state transitions
+	stateObserver func(StateChange)
+
+	// dnssecValidate requests DNSSEC records from upstreams and validates
+	// signed answers against trustAnchors, see validateResponse
+	dnssecValidate bool
+	trustAnchors   []*dns.DS
+
+	// chainCache keeps validated DNSKEY and DS sets, and proven absences of DS
+	// records, until their TTLs run out or their signatures expire
+	chainCache map[string]chainLink
+	chainMu    sync.Mutex
+
+	// Health probes query probeName; a healthy server answering them slower
+	// than probeLatency slowProbeLimit times in a row is marked unhealthy
+	probeName    string
+	probeLatency time.Duration // 0 = latency is not checked
+
 	// Circuit breaker settings
 	failureThreshold  int
 	recoveryTimeout   time.Duration
@@ -71,6 +180,48 @@ type QueryResult struct {
 	RTT      time.Duration
 	Server   string
 	Error    error
+
+	// Truncated is set when the server's UDP answer was truncated, whether or
+	// not it was retried over TCP
+	Truncated bool
+}
+
+// ServFail reports whether the upstream answered, but with SERVFAIL
+func (r QueryResult) ServFail() bool {
+	return r.Error == nil && r.Response != nil && r.Response.Rcode == dns.RcodeServerFailure
+}
+
+// failedAnswer reports whether the upstream answered, but with an rcode that
+// makes another upstream's answer preferable: SERVFAIL, or REFUSED when
+// failover on REFUSED is enabled
+func (m *Manager) failedAnswer(result QueryResult) bool {
+	if result.ServFail() {
+		return true
+	}
+	if result.Error != nil || result.Response == nil || result.Response.Rcode != dns.RcodeRefused {
+		return false
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.failoverOnRefused
+}
+
+// parsePriority splits an optional priority suffix such as "#2" off an upstream
+// address. Servers with a lower priority are preferred; the default is 0.
+func parsePriority(addr string) (string, int, error) {
+	addr = strings.TrimSpace(addr)
+
+	i := strings.LastIndex(addr, "#")
+	if i < 0 {
+		return addr, 0, nil
+	}
+
+	priority, err := strconv.Atoi(addr[i+1:])
+	if err != nil || priority < 0 {
+		return "", 0, fmt.Errorf("invalid upstream priority %q", addr[i+1:])
+	}
+	return addr[:i], priority, nil
 }
 
 // parseUpstreamAddress parses an upstream address and determines the protocol
@@ -135,6 +286,12 @@ func parseUpstreamAddress(addr string) (protocol Protocol, address string, dohUR
 func New(addresses []string, timeout time.Duration, maxRetries int) *Manager {
 	servers := make([]*Server, 0, len(addresses))
 	for _, addr := range addresses {
+		addr, priority, err := parsePriority(addr)
+		if err != nil {
+			// Log error but continue with other servers
+			continue
+		}
+
 		protocol, address, dohURL, err := parseUpstreamAddress(addr)
 		if err != nil {
 			// Log error but continue with other servers
@@ -145,6 +302,7 @@ func New(addresses []string, timeout time.Duration, maxRetries int) *Manager {
 			Address:     address,
 			Protocol:    protocol,
 			DoHURL:      dohURL,
+			Priority:    priority,
 			State:       int64(StateHealthy),
 			LastCheck:   time.Now().Unix(),
 			LastSuccess: time.Now().Unix(),
@@ -152,8 +310,14 @@ func New(addresses []string, timeout time.Duration, maxRetries int) *Manager {
 		servers = append(servers, server)
 	}
 
-	// Create DNS client for standard DNS
+	// Keep servers ordered by priority, then in configured order
+	sort.SliceStable(servers, func(i, j int) bool {
+		return servers[i].Priority < servers[j].Priority
+	})
+
+	// Create DNS clients for standard DNS
 	dnsClient := &dns.Client{Timeout: timeout}
+	tcpClient := &dns.Client{Net: "tcp", Timeout: timeout}
 
 	// Create DoT client with TLS config
 	dotClient := &dns.Client{
@@ -165,29 +329,47 @@ func New(addresses []string, timeout time.Duration, maxRetries int) *Manager {
 		},
 	}
 
-	// Create HTTP client for DoH
+	// Create HTTP client for DoH, reusing connections across queries
+	dohTLSConfig := &tls.Config{
+		InsecureSkipVerify: false,
+	}
+	dohTransport := &http.Transport{
+		TLSClientConfig:     dohTLSConfig,
+		ForceAttemptHTTP2:   true,
+		MaxConnsPerHost:     DefaultDoHPoolSize,
+		MaxIdleConnsPerHost: DefaultDoHPoolSize,
+		IdleConnTimeout:     dohIdleConnTimeout,
+	}
 	httpClient := &http.Client{
-		Timeout: timeout,
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: false,
-			},
-		},
+		Timeout:   timeout,
+		Transport: dohTransport,
 	}
 
-	return &Manager{
+	m := &Manager{
 		servers:          servers,
 		client:           dnsClient,
+		tcpClient:        tcpClient,
 		dotClient:        dotClient,
 		httpClient:       httpClient,
+		dohPool:          dohTransport,
 		timeout:          timeout,
 		maxRetries:       maxRetries,
+		randomizeIDs:     true,
+		tcpFallback:      true,
+		paddingBlockSize: DefaultPaddingBlockSize,
+		probeName:        DefaultProbeName,
+		trustAnchors:     rootTrustAnchors(),
 		failureThreshold: 3,
 		recoveryTimeout:  30 * time.Second,
 	}
+
+	// Report TLS handshakes of new DoH connections
+	dohTLSConfig.VerifyConnection = m.verifyConnection
+
+	return m
 }
 
-// GetHealthyServers returns a list of currently healthy servers
+// GetHealthyServers returns a list of currently healthy servers, ordered by priority
 func (m *Manager) GetHealthyServers() []*Server {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
@@ -202,28 +384,159 @@ func (m *Manager) GetHealthyServers() []*Server {
 	return healthy
 }
 
-// QueryConcurrent performs concurrent queries to multiple upstream servers
+// preferredServers returns the healthy servers sharing the best priority, so
+// lower priority servers are only used when all better ones are unhealthy
+func (m *Manager) preferredServers() []*Server {
+	healthy := m.GetHealthyServers()
+	for i, server := range healthy {
+		if server.Priority != healthy[0].Priority {
+			return healthy[:i]
+		}
+	}
+	return healthy
+}
+
+// selectServers returns the preferred servers ordered by their most recent
+// response time, fastest first. Servers that have not answered yet keep their
+// configured order after the measured ones.
+func (m *Manager) selectServers() []*Server {
+	servers := m.preferredServers()
+	sort.SliceStable(servers, func(i, j int) bool {
+		ri := atomic.LoadInt64(&servers[i].ResponseTime)
+		rj := atomic.LoadInt64(&servers[j].ResponseTime)
+		if ri == 0 || rj == 0 {
+			return ri != 0 && rj == 0
+		}
+		return ri < rj
+	})
+	return servers
+}
+
+// Query resolves a message through the upstream servers using the configured strategy
+func (m *Manager) Query(ctx context.Context, msg *dns.Msg) (*QueryResult, []QueryResult) {
+	m.mu.RLock()
+	strategy := m.strategy
+	m.mu.RUnlock()
+
+	if strategy == StrategySequential {
+		return m.QuerySequential(ctx, msg)
+	}
+	return m.QueryConcurrent(ctx, msg)
+}
+
+// QuerySequential queries healthy servers one at a time by priority, then in
+// configured order, moving on to the next server only on error or a failed
+// answer (see failedAnswer)
+func (m *Manager) QuerySequential(ctx context.Context, msg *dns.Msg) (*QueryResult, []QueryResult) {
+	servers := m.GetHealthyServers()
+	if len(servers) == 0 {
+		// Fallback to all servers if none are healthy
+		servers = m.servers
+	}
+
+	var allResults []QueryResult
+	var firstFailed *QueryResult
+
+	for _, server := range servers {
+		if ctx.Err() != nil {
+			break
+		}
+
+		result := m.querySingle(ctx, server, msg)
+		allResults = append(allResults, result)
+
+		if m.failedAnswer(result) {
+			if firstFailed == nil {
+				failed := result
+				firstFailed = &failed
+			}
+			continue
+		}
+		if result.Error == nil {
+			return &result, allResults
+		}
+	}
+
+	if firstFailed != nil {
+		return firstFailed, allResults
+	}
+
+	if len(allResults) > 0 {
+		return &allResults[0], allResults
+	}
+
+	return &QueryResult{
+		Error: fmt.Errorf("no upstream servers available"),
+	}, allResults
+}
+
+// QueryConcurrent performs concurrent queries to the healthy upstream servers
+// of the best available priority, fastest first. With a stagger delay set,
+// slower servers are only queried if no answer arrived in the meantime.
 func (m *Manager) QueryConcurrent(ctx context.Context, msg *dns.Msg) (*QueryResult, []QueryResult) {
-	healthyServers := m.GetHealthyServers()
+	result, allResults, _ := m.queryConcurrent(ctx, msg)
+	return result, allResults
+}
+
+// QueryCollect queries the upstream servers like Query. It also returns the
+// results of the servers still being queried when the answer was chosen, on a
+// channel closed once they all answered or ctx is done; it is nil when no
+// query was left running. The queries stop early if ctx is canceled.
+func (m *Manager) QueryCollect(ctx context.Context, msg *dns.Msg) (*QueryResult, []QueryResult, <-chan QueryResult) {
+	m.mu.RLock()
+	strategy := m.strategy
+	m.mu.RUnlock()
+
+	if strategy == StrategySequential {
+		result, allResults := m.QuerySequential(ctx, msg)
+		return result, allResults, nil
+	}
+	return m.queryConcurrent(ctx, msg)
+}
+
+// queryConcurrent implements QueryConcurrent, returning the channel the
+// results of the queries still running are delivered on after the first
+// successful answer
+func (m *Manager) queryConcurrent(ctx context.Context, msg *dns.Msg) (*QueryResult, []QueryResult, <-chan QueryResult) {
+	healthyServers := m.selectServers()
 	if len(healthyServers) == 0 {
 		// Fallback to all servers if none are healthy
 		healthyServers = m.servers
 	}
 
+	m.mu.RLock()
+	staggerDelay := m.staggerDelay
+	m.mu.RUnlock()
+
 	resultChan := make(chan QueryResult, len(healthyServers))
 	var wg sync.WaitGroup
 
+	// Queries still waiting for their turn are skipped once an answer is chosen
+	done := make(chan struct{})
+	defer close(done)
+
 	// Start concurrent queries
-	for _, server := range healthyServers {
+	for i, server := range healthyServers {
 		wg.Add(1)
-		go func(srv *Server) {
+		go func(srv *Server, delay time.Duration) {
 			defer wg.Done()
+			if delay > 0 {
+				timer := time.NewTimer(delay)
+				defer timer.Stop()
+				select {
+				case <-timer.C:
+				case <-done:
+					return
+				case <-ctx.Done():
+					return
+				}
+			}
 			result := m.querySingle(ctx, srv, msg)
 			select {
 			case resultChan <- result:
 			case <-ctx.Done():
 			}
-		}(server)
+		}(server, time.Duration(i)*staggerDelay)
 	}
 
 	// Close channel when all queries complete
@@ -232,13 +545,21 @@ func (m *Manager) QueryConcurrent(ctx context.Context, msg *dns.Msg) (*QueryResu
 		close(resultChan)
 	}()
 
-	var firstSuccess *QueryResult
+	var firstSuccess, firstFailed *QueryResult
 	var allResults []QueryResult
 
 	// Process results as they arrive, return immediately on first success
 	for result := range resultChan {
 		allResults = append(allResults, result)
 
+		if m.failedAnswer(result) {
+			// Keep waiting for a better answer, but relay this one if none arrives
+			if firstFailed == nil {
+				firstFailed = &result
+			}
+			continue
+		}
+
 		if result.Error == nil && firstSuccess == nil {
 			firstSuccess = &result
 			// Return immediately on first success to avoid waiting for slower upstreams
@@ -248,64 +569,165 @@ func (m *Manager) QueryConcurrent(ctx context.Context, msg *dns.Msg) (*QueryResu
 	}
 
 	if firstSuccess != nil {
-		return firstSuccess, allResults
+		if len(allResults) < len(healthyServers) {
+			return firstSuccess, allResults, resultChan
+		}
+		return firstSuccess, allResults, nil
+	}
+
+	if firstFailed != nil {
+		return firstFailed, allResults, nil
 	}
 
 	// If no successful response, return the first result (which will be an error)
 	if len(allResults) > 0 {
-		return &allResults[0], allResults
+		return &allResults[0], allResults, nil
 	}
 
 	return &QueryResult{
 		Error: fmt.Errorf("no upstream servers available"),
-	}, allResults
+	}, allResults, nil
 }
 
 // querySingle performs a single DNS query to an upstream server
 func (m *Manager) querySingle(ctx context.Context, server *Server, msg *dns.Msg) QueryResult {
-	start := time.Now()
-	var resp *dns.Msg
-	var rtt time.Duration
-	var err error
+	// Query with a fresh ID; the message is shared by concurrent queries, so copy it
+	m.mu.RLock()
+	randomizeIDs := m.randomizeIDs
+	servfailIsFailure := m.servfailIsFailure
+	dnssecValidate := m.dnssecValidate
+	authoritative := m.authoritative
+	rdMode := m.rdMode
+	m.mu.RUnlock()
+
+	clientID := msg.Id
+	clientRD := msg.RecursionDesired
+	clientDO := msg.IsEdns0() != nil && msg.IsEdns0().Do()
+
+	upstreamRD := clientRD
+	switch {
+	case authoritative, rdMode == RDForceOff:
+		upstreamRD = false
+	case rdMode == RDForceOn:
+		upstreamRD = true
+	}
+	if randomizeIDs || upstreamRD != clientRD {
+		msg = msg.Copy()
+		if randomizeIDs {
+			msg.Id = dns.Id()
+		}
+		msg.RecursionDesired = upstreamRD
+	}
 
-	switch server.Protocol {
-	case ProtocolDoH:
-		resp, rtt, err = m.queryDoH(ctx, server, msg)
-	case ProtocolDoT:
-		resp, rtt, err = m.queryDoT(ctx, server, msg)
-	case ProtocolDNS:
-		fallthrough
-	default:
-		resp, rtt, err = m.client.ExchangeContext(ctx, msg, server.Address)
+	// Ask for the signatures needed to validate the answer
+	addedOPT := false
+	if dnssecValidate {
+		msg, addedOPT = requestDNSSEC(msg)
+	}
+
+	// Pad the query to hide its size from observers of the connection
+	if blockSize, ok := m.shouldPad(server); ok {
+		var added bool
+		msg, added = padQuery(msg, blockSize)
+		addedOPT = addedOPT || added
+	}
+
+	// Bound the exchange by the server's own timeout
+	ctx, cancel := context.WithTimeout(ctx, m.serverTimeout(server))
+	defer cancel()
+
+	start := time.Now()
+	resp, rtt, truncated, err := m.exchange(ctx, server, msg)
+	if err == nil && resp != nil && dnssecValidate {
+		resp = m.validateResponse(ctx, server, msg, resp, clientDO)
 	}
 
 	duration := time.Since(start)
+	if resp != nil {
+		// Map the response back to the client's query ID
+		resp.Id = clientID
+		if upstreamRD != clientRD {
+			// Servers echo the RD bit they were sent, not the client's
+			resp.RecursionDesired = clientRD
+		}
+		if addedOPT {
+			// The client did not use EDNS0, so its answer must not carry OPT
+			removeOPT(resp)
+		}
+	}
 	if rtt == 0 {
 		rtt = duration
 	}
 
-	displayAddr := server.Address
-	if server.Protocol == ProtocolDoH && server.DoHURL != "" {
-		displayAddr = server.DoHURL
-	}
-
 	result := QueryResult{
 		Response: resp,
 		RTT:      rtt,
-		Server:   displayAddr,
+		Server:   server.displayAddress(),
 		Error:    err,
+
+		Truncated: truncated,
 	}
 
 	// Update server statistics
-	if err != nil {
+	switch {
+	case err != nil:
 		m.recordFailure(server)
-	} else {
+	case m.failedAnswer(result):
+		// The server is reachable but could not or would not resolve the name
+		if servfailIsFailure {
+			m.recordFailure(server)
+		}
+	default:
 		m.recordSuccess(server, duration)
 	}
 
 	return result
 }
 
+// exchange sends a query to an upstream server over its protocol. It also
+// reports whether a UDP answer came back truncated.
+func (m *Manager) exchange(ctx context.Context, server *Server, msg *dns.Msg) (*dns.Msg, time.Duration, bool, error) {
+	switch server.Protocol {
+	case ProtocolDoH:
+		resp, rtt, err := m.queryDoH(ctx, server, msg)
+		return resp, rtt, false, err
+	case ProtocolDoT:
+		resp, rtt, err := m.queryDoT(ctx, server, msg)
+		return resp, rtt, false, err
+	case ProtocolDNS:
+		fallthrough
+	default:
+		return m.queryDNS(ctx, server, msg)
+	}
+}
+
+// queryDNS performs a plain DNS query over UDP, retrying over TCP when the
+// answer is truncated and TCP fallback is enabled. Truncated answers are
+// counted on the server.
+func (m *Manager) queryDNS(ctx context.Context, server *Server, msg *dns.Msg) (*dns.Msg, time.Duration, bool, error) {
+	resp, rtt, err := m.client.ExchangeContext(ctx, msg, server.Address)
+	if err != nil || resp == nil || !resp.Truncated {
+		return resp, rtt, false, err
+	}
+	atomic.AddInt64(&server.Truncations, 1)
+
+	m.mu.RLock()
+	tcpFallback := m.tcpFallback
+	m.mu.RUnlock()
+
+	if !tcpFallback {
+		return resp, rtt, true, err
+	}
+
+	tcpResp, tcpRTT, tcpErr := m.tcpClient.ExchangeContext(ctx, msg, server.Address)
+	if tcpErr != nil {
+		// Relay the truncated answer; the client can still retry over TCP itself
+		return resp, rtt, true, nil
+	}
+
+	return tcpResp, rtt + tcpRTT, true, nil
+}
+
 // queryDoT performs a DNS over TLS query
 func (m *Manager) queryDoT(ctx context.Context, server *Server, msg *dns.Msg) (*dns.Msg, time.Duration, error) {
 	// Extract hostname for TLS SNI
@@ -317,10 +739,11 @@ func (m *Manager) queryDoT(ctx context.Context, server *Server, msg *dns.Msg) (*
 	// Create a DoT client with proper SNI configuration
 	dotClient := &dns.Client{
 		Net:     "tcp-tls",
-		Timeout: m.timeout,
+		Timeout: m.serverTimeout(server),
 		TLSConfig: &tls.Config{
 			ServerName:         host,
 			InsecureSkipVerify: false,
+			VerifyConnection:   m.verifyConnection,
 		},
 	}
 
@@ -447,7 +870,7 @@ func (m *Manager) recordSuccess(server *Server, rtt time.Duration) {
 	// Restore to healthy state if recovering
 	currentState := ServerState(atomic.LoadInt64(&server.State))
 	if currentState == StateRecovering {
-		atomic.StoreInt64(&server.State, int64(StateHealthy))
+		m.setState(server, StateRecovering, StateHealthy)
 	}
 }
 
@@ -455,8 +878,12 @@ func (m *Manager) recordSuccess(server *Server, rtt time.Duration) {
 func (m *Manager) recordFailure(server *Server) {
 	failures := atomic.AddInt64(&server.FailureCount, 1)
 
-	if failures >= int64(m.failureThreshold) {
-		atomic.StoreInt64(&server.State, int64(StateUnhealthy))
+	m.mu.RLock()
+	failureThreshold := m.failureThreshold
+	m.mu.RUnlock()
+
+	if failures >= int64(failureThreshold) {
+		m.setState(server, ServerState(atomic.LoadInt64(&server.State)), StateUnhealthy)
 	}
 }
 
@@ -482,44 +909,226 @@ func (m *Manager) healthCheckLoop() {
 	}
 }
 
-// healthCheck performs a health check on a single server
+// healthCheck probes a single server. Healthy servers are probed to keep
+// their response time current and to catch gradual slowdowns; unhealthy ones
+// move to recovering once the recovery timeout has passed and they answer
+// again within the latency limit.
 func (m *Manager) healthCheck(server *Server) {
 	currentState := ServerState(atomic.LoadInt64(&server.State))
 
-	// Skip health check for healthy servers
-	if currentState == StateHealthy {
+	// Unhealthy servers sit out the recovery timeout before being probed again
+	if currentState == StateUnhealthy && !m.recoveryDue(server) {
 		return
 	}
 
+	m.mu.RLock()
+	probeName := m.probeName
+	probeLatency := m.probeLatency
+	servfailIsFailure := m.servfailIsFailure
+	m.mu.RUnlock()
+
 	// Create a simple DNS query for health check
 	msg := new(dns.Msg)
-	msg.SetQuestion(dns.Fqdn("health.check"), dns.TypeA)
+	msg.SetQuestion(dns.Fqdn(probeName), dns.TypeSOA)
 
-	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), m.serverTimeout(server))
 	defer cancel()
 
 	result := m.querySingle(ctx, server, msg)
 	atomic.StoreInt64(&server.LastCheck, time.Now().Unix())
 
-	if result.Error == nil {
+	if result.Error != nil || (servfailIsFailure && m.failedAnswer(result)) {
+		// querySingle already counted the failure toward the circuit breaker
+		return
+	}
+	slow := probeLatency > 0 && result.RTT > probeLatency
+
+	switch currentState {
+	case StateHealthy:
+		if !slow {
+			atomic.StoreInt64(&server.SlowProbes, 0)
+			return
+		}
+		if atomic.AddInt64(&server.SlowProbes, 1) >= slowProbeLimit {
+			atomic.StoreInt64(&server.SlowProbes, 0)
+			m.setState(server, StateHealthy, StateUnhealthy)
+		}
+	case StateUnhealthy:
 		// Server is responding, move to recovering state
-		if currentState == StateUnhealthy {
-			atomic.StoreInt64(&server.State, int64(StateRecovering))
+		if !slow {
+			m.setState(server, StateUnhealthy, StateRecovering)
+		}
+	}
+}
+
+// SetRandomizeQueryIDs controls whether upstream exchanges use a fresh random
+// query ID (the default) or reuse the client's ID
+func (m *Manager) SetRandomizeQueryIDs(enabled bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.randomizeIDs = enabled
+}
+
+// SetServfailIsFailure controls whether SERVFAIL answers (and REFUSED ones when
+// failover on REFUSED is enabled) count toward the circuit breaker like
+// timeouts and transport errors (disabled by default)
+func (m *Manager) SetServfailIsFailure(enabled bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.servfailIsFailure = enabled
+}
+
+// SetFailoverOnRefused controls whether REFUSED answers are handled like
+// SERVFAIL, so that another upstream's answer is preferred (disabled by default)
+func (m *Manager) SetFailoverOnRefused(enabled bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failoverOnRefused = enabled
+}
+
+// SetDoHPoolSize sets how many connections are opened and kept idle per DoH
+// server. Over HTTP/2 each connection carries many concurrent queries. It must
+// be called before the first query.
+func (m *Manager) SetDoHPoolSize(size int) {
+	if size > 0 {
+		m.dohPool.MaxConnsPerHost = size
+		m.dohPool.MaxIdleConnsPerHost = size
+	}
+}
+
+// SetServerTimeouts overrides the query timeout of individual servers, keyed by
+// their address as passed to New (a priority suffix is ignored). Unknown
+// addresses are skipped. It must be called before the first query.
+func (m *Manager) SetServerTimeouts(timeouts map[string]time.Duration) {
+	longest := m.timeout
+	for addr, timeout := range timeouts {
+		addr, _, err := parsePriority(addr)
+		if err != nil {
+			continue
+		}
+		_, address, dohURL, err := parseUpstreamAddress(addr)
+		if err != nil {
+			continue
+		}
+		for _, server := range m.servers {
+			if server.Address == address && server.DoHURL == dohURL {
+				server.Timeout = timeout
+			}
 		}
+		if timeout > longest {
+			longest = timeout
+		}
+	}
+
+	// Per-call deadlines enforce each server's timeout, so the shared clients
+	// must not cut off servers allowed to take longer than the default
+	m.client.Timeout = longest
+	m.tcpClient.Timeout = longest
+	m.httpClient.Timeout = longest
+}
+
+// serverTimeout returns the query timeout for a server
+func (m *Manager) serverTimeout(server *Server) time.Duration {
+	if server.Timeout > 0 {
+		return server.Timeout
+	}
+	return m.timeout
+}
+
+// SetTCPFallback controls whether truncated UDP answers are re-queried over
+// TCP (enabled by default)
+func (m *Manager) SetTCPFallback(enabled bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tcpFallback = enabled
+}
+
+// recoveryDue reports whether an unhealthy server has sat out the recovery
+// timeout and may be tried again
+func (m *Manager) recoveryDue(server *Server) bool {
+	m.mu.RLock()
+	recoveryTimeout := m.recoveryTimeout
+	m.mu.RUnlock()
+
+	since := time.Unix(0, atomic.LoadInt64(&server.UnhealthySince))
+	return time.Since(since) >= recoveryTimeout
+}
+
+// SetAuthoritative controls whether the servers are treated as authoritative
+// servers rather than recursive resolvers (disabled by default). Queries to
+// authoritative servers are sent without the RD bit; their answers, including
+// the AA bit, are relayed as received.
+func (m *Manager) SetAuthoritative(enabled bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.authoritative = enabled
+}
+
+// SetRDMode sets the RD bit of queries sent to recursive servers: the client's
+// own (the default), always set or always cleared. Queries to authoritative
+// servers never carry it, see SetAuthoritative.
+func (m *Manager) SetRDMode(mode RDMode) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rdMode = mode
+}
+
+// SetCircuitBreaker sets how many consecutive failures mark a server unhealthy
+// (3 by default), and how long it then sits out before health checks may move
+// it to recovering (30s by default)
+func (m *Manager) SetCircuitBreaker(failureThreshold int, recoveryTimeout time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if failureThreshold > 0 {
+		m.failureThreshold = failureThreshold
+	}
+	if recoveryTimeout >= 0 {
+		m.recoveryTimeout = recoveryTimeout
 	}
 }
 
+// SetHealthProbe sets the name queried (with type SOA) by health checks, and
+// the response time above which slowProbeLimit consecutive probes mark a
+// healthy server unhealthy (0 = latency is not checked). An empty name keeps
+// the current one.
+func (m *Manager) SetHealthProbe(name string, maxLatency time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if name != "" {
+		m.probeName = name
+	}
+	m.probeLatency = maxLatency
+}
+
+// SetStaggerDelay spaces out concurrent queries so that each server, fastest
+// first, is queried this much later than the previous one (0 = all at once)
+func (m *Manager) SetStaggerDelay(delay time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.staggerDelay = delay
+}
+
+// SetStrategy selects how Query spreads queries across upstream servers
+func (m *Manager) SetStrategy(strategy Strategy) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.strategy = strategy
+}
+
 // GetStats returns statistics for all upstream servers
 func (m *Manager) GetStats() []ServerStats {
 	stats := make([]ServerStats, len(m.servers))
 	for i, server := range m.servers {
 		stats[i] = ServerStats{
 			Address:      server.Address,
+			Priority:     server.Priority,
 			State:        ServerState(atomic.LoadInt64(&server.State)),
 			FailureCount: atomic.LoadInt64(&server.FailureCount),
 			LastCheck:    time.Unix(atomic.LoadInt64(&server.LastCheck), 0),
 			LastSuccess:  time.Unix(atomic.LoadInt64(&server.LastSuccess), 0),
 			ResponseTime: time.Duration(atomic.LoadInt64(&server.ResponseTime)),
+			StateChanges: atomic.LoadInt64(&server.StateChanges),
+			Truncations:  atomic.LoadInt64(&server.Truncations),
 		}
 	}
 	return stats
@@ -528,11 +1137,14 @@ func (m *Manager) GetStats() []ServerStats {
 // ServerStats represents statistics for an upstream server
 type ServerStats struct {
 	Address      string
+	Priority     int
 	State        ServerState
 	FailureCount int64
 	LastCheck    time.Time
 	LastSuccess  time.Time
 	ResponseTime time.Duration
+	StateChanges int64
+	Truncations  int64
 }
 
 // String returns a string representation of ServerState
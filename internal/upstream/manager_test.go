@@ -0,0 +1,711 @@
+package upstream
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"dns-go/internal/upstream/testutil"
+
+	"github.com/miekg/dns"
+)
+
+const testTimeout = 200 * time.Millisecond
+
+func newQuery(name string) *dns.Msg {
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn(name), dns.TypeA)
+	return msg
+}
+
+func TestQueryConcurrent_Success(t *testing.T) {
+	fake := testutil.NewServer(t, testutil.Answer("192.0.2.1", 60))
+	m := New([]string{fake.Addr}, testTimeout, 0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	result, all := m.QueryConcurrent(ctx, newQuery("example.com"))
+	if result.Error != nil {
+		t.Fatalf("Expected no error, got: %v", result.Error)
+	}
+	if len(result.Response.Answer) != 1 {
+		t.Fatalf("Expected 1 answer, got %d", len(result.Response.Answer))
+	}
+	if a, ok := result.Response.Answer[0].(*dns.A); !ok || a.A.String() != "192.0.2.1" {
+		t.Errorf("Expected A 192.0.2.1, got %v", result.Response.Answer[0])
+	}
+	if result.Server != fake.Addr {
+		t.Errorf("Expected server %s, got %s", fake.Addr, result.Server)
+	}
+	if len(all) != 1 {
+		t.Errorf("Expected 1 result, got %d", len(all))
+	}
+}
+
+func TestQueryConcurrent_Timeout(t *testing.T) {
+	fake := testutil.NewServer(t, testutil.Drop())
+	m := New([]string{fake.Addr}, testTimeout, 0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	result, _ := m.QueryConcurrent(ctx, newQuery("example.com"))
+	if result.Error == nil {
+		t.Fatal("Expected timeout error, got none")
+	}
+	if fake.Queries() != 1 {
+		t.Errorf("Expected 1 query to reach the upstream, got %d", fake.Queries())
+	}
+}
+
+func TestQueryConcurrent_Failover(t *testing.T) {
+	dead := testutil.NewServer(t, testutil.Drop())
+	alive := testutil.NewServer(t, testutil.Answer("192.0.2.2", 60))
+	m := New([]string{dead.Addr, alive.Addr}, testTimeout, 0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	result, _ := m.QueryConcurrent(ctx, newQuery("example.com"))
+	if result.Error != nil {
+		t.Fatalf("Expected failover to succeed, got: %v", result.Error)
+	}
+	if result.Server != alive.Addr {
+		t.Errorf("Expected answer from %s, got %s", alive.Addr, result.Server)
+	}
+}
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	fake := testutil.NewServer(t, testutil.Drop())
+	m := New([]string{fake.Addr}, 50*time.Millisecond, 0)
+	m.SetCircuitBreaker(3, 0)
+	server := m.servers[0]
+
+	state := func() ServerState { return ServerState(atomic.LoadInt64(&server.State)) }
+
+	for i := 0; i < m.failureThreshold; i++ {
+		if state() != StateHealthy {
+			t.Fatalf("Expected healthy before %d failures, got %s", m.failureThreshold, state())
+		}
+		m.querySingle(context.Background(), server, newQuery("example.com"))
+	}
+
+	if state() != StateUnhealthy {
+		t.Fatalf("Expected unhealthy after %d failures, got %s", m.failureThreshold, state())
+	}
+	if len(m.GetHealthyServers()) != 0 {
+		t.Errorf("Expected no healthy servers, got %d", len(m.GetHealthyServers()))
+	}
+
+	// Health check against a server that answers again moves it to recovering
+	fake.SetHandler(testutil.Answer("192.0.2.1", 60))
+	m.healthCheck(server)
+	if state() != StateRecovering {
+		t.Fatalf("Expected recovering after successful health check, got %s", state())
+	}
+
+	// The next successful query restores it to healthy
+	result := m.querySingle(context.Background(), server, newQuery("example.com"))
+	if result.Error != nil {
+		t.Fatalf("Expected success, got: %v", result.Error)
+	}
+	if state() != StateHealthy {
+		t.Errorf("Expected healthy after success, got %s", state())
+	}
+	if atomic.LoadInt64(&server.FailureCount) != 0 {
+		t.Errorf("Expected failure count reset, got %d", atomic.LoadInt64(&server.FailureCount))
+	}
+}
+
+func TestQuerySingle_RandomizesQueryID(t *testing.T) {
+	tests := []struct {
+		name      string
+		randomize bool
+	}{
+		{name: "randomized", randomize: true},
+		{name: "client ID reused", randomize: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var upstreamIDs []uint16
+			var mu sync.Mutex
+			answer := testutil.Answer("192.0.2.1", 60)
+			fake := testutil.NewServer(t, func(req *dns.Msg) *dns.Msg {
+				mu.Lock()
+				upstreamIDs = append(upstreamIDs, req.Id)
+				mu.Unlock()
+				return answer(req)
+			})
+			m := New([]string{fake.Addr}, testTimeout, 0)
+			m.SetRandomizeQueryIDs(tt.randomize)
+
+			// Several exchanges so a random ID colliding with the client's is ruled out
+			const clientID = 4242
+			for i := 0; i < 5; i++ {
+				msg := newQuery("example.com")
+				msg.Id = clientID
+
+				result := m.querySingle(context.Background(), m.servers[0], msg)
+				if result.Error != nil {
+					t.Fatalf("Expected no error, got: %v", result.Error)
+				}
+				if result.Response.Id != clientID {
+					t.Errorf("Expected response to carry client ID %d, got %d", clientID, result.Response.Id)
+				}
+				if msg.Id != clientID {
+					t.Errorf("Expected client message ID to be left untouched, got %d", msg.Id)
+				}
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			sameAsClient := 0
+			for _, id := range upstreamIDs {
+				if id == clientID {
+					sameAsClient++
+				}
+			}
+			if tt.randomize && sameAsClient == len(upstreamIDs) {
+				t.Errorf("Expected upstream-facing IDs to differ from the client ID, got %v", upstreamIDs)
+			}
+			if !tt.randomize && sameAsClient != len(upstreamIDs) {
+				t.Errorf("Expected upstream-facing IDs to match the client ID, got %v", upstreamIDs)
+			}
+		})
+	}
+}
+
+func TestQuerySingle_RDMode(t *testing.T) {
+	tests := []struct {
+		name       string
+		mode       RDMode
+		clientRD   bool
+		upstreamRD bool
+	}{
+		{name: "preserve set", mode: RDPreserve, clientRD: true, upstreamRD: true},
+		{name: "preserve clear", mode: RDPreserve, clientRD: false, upstreamRD: false},
+		{name: "force on", mode: RDForceOn, clientRD: false, upstreamRD: true},
+		{name: "force off", mode: RDForceOff, clientRD: true, upstreamRD: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sawRD atomic.Bool
+			answer := testutil.Answer("192.0.2.1", 60)
+			fake := testutil.NewServer(t, func(req *dns.Msg) *dns.Msg {
+				sawRD.Store(req.RecursionDesired)
+				return answer(req)
+			})
+			m := New([]string{fake.Addr}, testTimeout, 0)
+			m.SetRDMode(tt.mode)
+
+			msg := newQuery("example.com")
+			msg.RecursionDesired = tt.clientRD
+
+			result := m.querySingle(context.Background(), m.servers[0], msg)
+			if result.Error != nil {
+				t.Fatalf("Expected no error, got: %v", result.Error)
+			}
+			if sawRD.Load() != tt.upstreamRD {
+				t.Errorf("Expected upstream query RD %v, got %v", tt.upstreamRD, sawRD.Load())
+			}
+			if result.Response.RecursionDesired != tt.clientRD {
+				t.Errorf("Expected the response to echo the client's RD %v", tt.clientRD)
+			}
+			if msg.RecursionDesired != tt.clientRD {
+				t.Error("Expected the client message to be left untouched")
+			}
+		})
+	}
+}
+
+func TestQuerySingle_ServFailVersusTimeout(t *testing.T) {
+	tests := []struct {
+		name              string
+		handler           testutil.Handler
+		servfailIsFailure bool
+		failoverOnRefused bool
+		wantErr           bool
+		wantFailures      int64
+	}{
+		{name: "timeout", handler: testutil.Drop(), wantErr: true, wantFailures: 1},
+		{name: "servfail soft", handler: testutil.Rcode(dns.RcodeServerFailure), wantFailures: 0},
+		{name: "servfail counted", handler: testutil.Rcode(dns.RcodeServerFailure), servfailIsFailure: true, wantFailures: 1},
+		{name: "refused answer", handler: testutil.Rcode(dns.RcodeRefused), servfailIsFailure: true, wantFailures: 0},
+		{name: "refused counted", handler: testutil.Rcode(dns.RcodeRefused), servfailIsFailure: true, failoverOnRefused: true, wantFailures: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := testutil.NewServer(t, tt.handler)
+			m := New([]string{fake.Addr}, 50*time.Millisecond, 0)
+			m.SetServfailIsFailure(tt.servfailIsFailure)
+			m.SetFailoverOnRefused(tt.failoverOnRefused)
+			server := m.servers[0]
+
+			result := m.querySingle(context.Background(), server, newQuery("example.com"))
+			if (result.Error != nil) != tt.wantErr {
+				t.Fatalf("Expected error=%v, got: %v", tt.wantErr, result.Error)
+			}
+			if got := atomic.LoadInt64(&server.FailureCount); got != tt.wantFailures {
+				t.Errorf("Expected failure count %d, got %d", tt.wantFailures, got)
+			}
+		})
+	}
+}
+
+func TestQuerySingle_SoftServFailKeepsFailureCount(t *testing.T) {
+	fake := testutil.NewServer(t, testutil.Drop())
+	m := New([]string{fake.Addr}, 50*time.Millisecond, 0)
+	server := m.servers[0]
+
+	m.querySingle(context.Background(), server, newQuery("example.com"))
+
+	// A SERVFAIL answer neither trips nor resets the circuit breaker
+	fake.SetHandler(testutil.Rcode(dns.RcodeServerFailure))
+	m.querySingle(context.Background(), server, newQuery("example.com"))
+
+	if got := atomic.LoadInt64(&server.FailureCount); got != 1 {
+		t.Errorf("Expected failure count 1, got %d", got)
+	}
+}
+
+func TestQueryConcurrent_PrefersAnswerOverServFail(t *testing.T) {
+	servfail := testutil.NewServer(t, testutil.Rcode(dns.RcodeServerFailure))
+	answer := testutil.Answer("192.0.2.2", 60)
+	slow := testutil.NewServer(t, func(req *dns.Msg) *dns.Msg {
+		time.Sleep(20 * time.Millisecond)
+		return answer(req)
+	})
+	m := New([]string{servfail.Addr, slow.Addr}, testTimeout, 0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	result, _ := m.QueryConcurrent(ctx, newQuery("example.com"))
+	if result.Error != nil || result.Response == nil {
+		t.Fatalf("Expected an answer, got: %v", result.Error)
+	}
+	if result.Server != slow.Addr || result.Response.Rcode != dns.RcodeSuccess {
+		t.Errorf("Expected NOERROR from %s, got %s from %s", slow.Addr, dns.RcodeToString[result.Response.Rcode], result.Server)
+	}
+}
+
+func TestQueryConcurrent_FailoverOnRefused(t *testing.T) {
+	tests := []struct {
+		name              string
+		failoverOnRefused bool
+		wantRcode         int
+	}{
+		{name: "disabled", wantRcode: dns.RcodeRefused},
+		{name: "enabled", failoverOnRefused: true, wantRcode: dns.RcodeSuccess},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			refused := testutil.NewServer(t, testutil.Rcode(dns.RcodeRefused))
+			answer := testutil.Answer("192.0.2.2", 60)
+			slow := testutil.NewServer(t, func(req *dns.Msg) *dns.Msg {
+				time.Sleep(20 * time.Millisecond)
+				return answer(req)
+			})
+			m := New([]string{refused.Addr, slow.Addr}, testTimeout, 0)
+			m.SetFailoverOnRefused(tt.failoverOnRefused)
+
+			ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+			defer cancel()
+
+			result, _ := m.QueryConcurrent(ctx, newQuery("example.com"))
+			if result.Error != nil || result.Response == nil {
+				t.Fatalf("Expected an answer, got: %v", result.Error)
+			}
+			if result.Response.Rcode != tt.wantRcode {
+				t.Errorf("Expected %s, got %s from %s", dns.RcodeToString[tt.wantRcode], dns.RcodeToString[result.Response.Rcode], result.Server)
+			}
+		})
+	}
+}
+
+func TestQueryConcurrent_RelaysServFail(t *testing.T) {
+	servfail := testutil.NewServer(t, testutil.Rcode(dns.RcodeServerFailure))
+	dead := testutil.NewServer(t, testutil.Drop())
+	m := New([]string{servfail.Addr, dead.Addr}, 50*time.Millisecond, 0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	result, allResults := m.QueryConcurrent(ctx, newQuery("example.com"))
+	if !result.ServFail() {
+		t.Fatalf("Expected the SERVFAIL answer to be relayed, got %+v", result)
+	}
+	if len(allResults) != 2 {
+		t.Errorf("Expected both upstreams to be waited for, got %d results", len(allResults))
+	}
+}
+
+func TestQuerySequential_FailsOverInOrder(t *testing.T) {
+	dead := testutil.NewServer(t, testutil.Drop())
+	alive := testutil.NewServer(t, testutil.Answer("192.0.2.2", 60))
+	spare := testutil.NewServer(t, testutil.Answer("192.0.2.3", 60))
+	m := New([]string{dead.Addr, alive.Addr, spare.Addr}, 50*time.Millisecond, 0)
+
+	result, allResults := m.QuerySequential(context.Background(), newQuery("example.com"))
+	if result.Error != nil {
+		t.Fatalf("Expected failover to succeed, got: %v", result.Error)
+	}
+	if result.Server != alive.Addr {
+		t.Errorf("Expected answer from %s, got %s", alive.Addr, result.Server)
+	}
+
+	if len(allResults) != 2 {
+		t.Fatalf("Expected 2 attempts, got %d", len(allResults))
+	}
+	if allResults[0].Server != dead.Addr || allResults[0].Error == nil {
+		t.Errorf("Expected first attempt to fail against %s, got %+v", dead.Addr, allResults[0])
+	}
+	if allResults[1].Server != alive.Addr {
+		t.Errorf("Expected second attempt against %s, got %s", alive.Addr, allResults[1].Server)
+	}
+	if spare.Queries() != 0 {
+		t.Errorf("Expected the third server not to be queried, it saw %d queries", spare.Queries())
+	}
+}
+
+func TestQuerySequential_SkipsServFail(t *testing.T) {
+	servfail := testutil.NewServer(t, testutil.Rcode(dns.RcodeServerFailure))
+	alive := testutil.NewServer(t, testutil.Answer("192.0.2.2", 60))
+	m := New([]string{servfail.Addr, alive.Addr}, testTimeout, 0)
+	m.SetStrategy(StrategySequential)
+
+	result, allResults := m.Query(context.Background(), newQuery("example.com"))
+	if result.Server != alive.Addr || result.Response.Rcode != dns.RcodeSuccess {
+		t.Errorf("Expected NOERROR from %s, got %+v", alive.Addr, result)
+	}
+	if len(allResults) != 2 {
+		t.Errorf("Expected 2 attempts, got %d", len(allResults))
+	}
+}
+
+func TestQuerySingle_TCPFallback(t *testing.T) {
+	tests := []struct {
+		name           string
+		tcpFallback    bool
+		wantTruncated  bool
+		wantAnswers    int
+		wantTCPQueries int
+	}{
+		{name: "enabled", tcpFallback: true, wantAnswers: 1, wantTCPQueries: 1},
+		{name: "disabled", wantTruncated: true, wantAnswers: 0, wantTCPQueries: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := testutil.NewServer(t, testutil.Truncated())
+			fake.SetTCPHandler(testutil.Answer("192.0.2.2", 60))
+			m := New([]string{fake.Addr}, testTimeout, 0)
+			m.SetTCPFallback(tt.tcpFallback)
+
+			query := newQuery("example.com")
+			result := m.querySingle(context.Background(), m.servers[0], query)
+			if result.Error != nil {
+				t.Fatalf("Expected no error, got: %v", result.Error)
+			}
+			if result.Response.Truncated != tt.wantTruncated {
+				t.Errorf("Expected truncated=%v, got %v", tt.wantTruncated, result.Response.Truncated)
+			}
+			if len(result.Response.Answer) != tt.wantAnswers {
+				t.Errorf("Expected %d answers, got %v", tt.wantAnswers, result.Response.Answer)
+			}
+			if result.Response.Id != query.Id {
+				t.Errorf("Expected the client's query ID %d, got %d", query.Id, result.Response.Id)
+			}
+			if got := fake.TCPQueries(); got != tt.wantTCPQueries {
+				t.Errorf("Expected %d TCP queries, got %d", tt.wantTCPQueries, got)
+			}
+			if !result.Truncated {
+				t.Error("Expected the result to report the truncated UDP answer")
+			}
+			if got := m.GetStats()[0].Truncations; got != 1 {
+				t.Errorf("Expected 1 truncation, got %d", got)
+			}
+		})
+	}
+}
+
+func TestQuerySingle_TCPFallbackFailureRelaysTruncated(t *testing.T) {
+	fake := testutil.NewServer(t, testutil.Truncated())
+	fake.SetTCPHandler(testutil.Drop())
+	m := New([]string{fake.Addr}, 50*time.Millisecond, 0)
+
+	result := m.querySingle(context.Background(), m.servers[0], newQuery("example.com"))
+	if result.Error != nil {
+		t.Fatalf("Expected the truncated answer instead of an error, got: %v", result.Error)
+	}
+	if !result.Response.Truncated {
+		t.Errorf("Expected the truncated UDP answer, got %v", result.Response)
+	}
+}
+
+func TestParsePriority(t *testing.T) {
+	tests := []struct {
+		addr         string
+		wantAddr     string
+		wantPriority int
+		wantErr      bool
+	}{
+		{addr: "8.8.8.8:53", wantAddr: "8.8.8.8:53", wantPriority: 0},
+		{addr: " 8.8.8.8:53#2 ", wantAddr: "8.8.8.8:53", wantPriority: 2},
+		{addr: "tls://dns.google#1", wantAddr: "tls://dns.google", wantPriority: 1},
+		{addr: "https://dns.google/dns-query#3", wantAddr: "https://dns.google/dns-query", wantPriority: 3},
+		{addr: "8.8.8.8:53#", wantErr: true},
+		{addr: "8.8.8.8:53#-1", wantErr: true},
+		{addr: "8.8.8.8:53#high", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			addr, priority, err := parsePriority(tt.addr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error=%v, got: %v", tt.wantErr, err)
+			}
+			if tt.wantErr {
+				return
+			}
+			if addr != tt.wantAddr || priority != tt.wantPriority {
+				t.Errorf("Expected %q with priority %d, got %q with priority %d", tt.wantAddr, tt.wantPriority, addr, priority)
+			}
+		})
+	}
+}
+
+func TestNew_OrdersServersByPriority(t *testing.T) {
+	m := New([]string{"192.0.2.1:53#2", "192.0.2.2:53", "192.0.2.3:53#1", "192.0.2.4:53"}, testTimeout, 0)
+
+	want := []string{"192.0.2.2:53", "192.0.2.4:53", "192.0.2.3:53", "192.0.2.1:53"}
+	stats := m.GetStats()
+	if len(stats) != len(want) {
+		t.Fatalf("Expected %d servers, got %d", len(want), len(stats))
+	}
+	for i, addr := range want {
+		if stats[i].Address != addr {
+			t.Errorf("Server %d: expected %s, got %s", i, addr, stats[i].Address)
+		}
+	}
+}
+
+func TestQueryConcurrent_PrefersHigherPriority(t *testing.T) {
+	local := testutil.NewServer(t, testutil.Answer("192.0.2.1", 60))
+	fallback := testutil.NewServer(t, testutil.Answer("192.0.2.2", 60))
+	m := New([]string{fallback.Addr + "#1", local.Addr}, testTimeout, 0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	result, allResults := m.QueryConcurrent(ctx, newQuery("example.com"))
+	if result.Error != nil || result.Server != local.Addr {
+		t.Fatalf("Expected answer from %s, got %+v", local.Addr, result)
+	}
+	if len(allResults) != 1 || fallback.Queries() != 0 {
+		t.Errorf("Expected the lower priority server not to be queried, it saw %d queries", fallback.Queries())
+	}
+
+	// Once the preferred server is unhealthy, the fallback takes over
+	atomic.StoreInt64(&m.servers[0].State, int64(StateUnhealthy))
+
+	result, _ = m.QueryConcurrent(ctx, newQuery("example.com"))
+	if result.Error != nil || result.Server != fallback.Addr {
+		t.Errorf("Expected answer from %s, got %+v", fallback.Addr, result)
+	}
+}
+
+func TestQuerySingle_ServerTimeout(t *testing.T) {
+	dead := testutil.NewServer(t, testutil.Drop())
+	answer := testutil.Answer("192.0.2.2", 60)
+	slow := testutil.NewServer(t, func(req *dns.Msg) *dns.Msg {
+		time.Sleep(150 * time.Millisecond)
+		return answer(req)
+	})
+	m := New([]string{dead.Addr, slow.Addr + "#1"}, 100*time.Millisecond, 0)
+	m.SetServerTimeouts(map[string]time.Duration{
+		dead.Addr:        20 * time.Millisecond,
+		slow.Addr + "#1": time.Second,
+	})
+
+	// A short per-server timeout trips before the manager default
+	start := time.Now()
+	result := m.querySingle(context.Background(), m.servers[0], newQuery("example.com"))
+	if result.Error == nil {
+		t.Fatalf("Expected a timeout from %s", dead.Addr)
+	}
+	if elapsed := time.Since(start); elapsed >= 100*time.Millisecond {
+		t.Errorf("Expected the 20ms server timeout to apply, took %v", elapsed)
+	}
+
+	// A longer per-server timeout outlasts the manager default
+	result = m.querySingle(context.Background(), m.servers[1], newQuery("example.com"))
+	if result.Error != nil {
+		t.Errorf("Expected the slow server to answer within its own timeout, got: %v", result.Error)
+	}
+}
+
+func TestSelectServers_OrdersByResponseTime(t *testing.T) {
+	m := New([]string{"192.0.2.1:53", "192.0.2.2:53", "192.0.2.3:53", "192.0.2.4:53#1"}, testTimeout, 0)
+	atomic.StoreInt64(&m.servers[0].ResponseTime, int64(30*time.Millisecond))
+	atomic.StoreInt64(&m.servers[2].ResponseTime, int64(10*time.Millisecond))
+	atomic.StoreInt64(&m.servers[3].ResponseTime, int64(time.Millisecond))
+
+	// Unmeasured servers follow the measured ones; lower priorities stay out
+	want := []string{"192.0.2.3:53", "192.0.2.1:53", "192.0.2.2:53"}
+	servers := m.selectServers()
+	if len(servers) != len(want) {
+		t.Fatalf("Expected %d servers, got %d", len(want), len(servers))
+	}
+	for i, addr := range want {
+		if servers[i].Address != addr {
+			t.Errorf("Server %d: expected %s, got %s", i, addr, servers[i].Address)
+		}
+	}
+}
+
+func TestQueryConcurrent_StaggerDelay(t *testing.T) {
+	const stagger = 100 * time.Millisecond
+
+	answer := testutil.Answer("192.0.2.1", 60)
+	var slow atomic.Bool
+	fastest := testutil.NewServer(t, func(req *dns.Msg) *dns.Msg {
+		if slow.Load() {
+			time.Sleep(2 * stagger)
+		}
+		return answer(req)
+	})
+	other := testutil.NewServer(t, testutil.Answer("192.0.2.2", 60))
+
+	m := New([]string{other.Addr, fastest.Addr}, time.Second, 0)
+	m.SetStaggerDelay(stagger)
+	atomic.StoreInt64(&m.servers[0].ResponseTime, int64(50*time.Millisecond))
+	atomic.StoreInt64(&m.servers[1].ResponseTime, int64(time.Millisecond))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	// The fastest server answers within the delay, so the other is never queried
+	result, _ := m.QueryConcurrent(ctx, newQuery("example.com"))
+	if result.Error != nil || result.Server != fastest.Addr {
+		t.Fatalf("Expected answer from %s, got %+v", fastest.Addr, result)
+	}
+	time.Sleep(2 * stagger)
+	if other.Queries() != 0 {
+		t.Errorf("Expected the slower server not to be queried, it saw %d queries", other.Queries())
+	}
+
+	// Once the fastest server is slow to answer, the next one is queried after the delay
+	slow.Store(true)
+	start := time.Now()
+	result, _ = m.QueryConcurrent(ctx, newQuery("example.com"))
+	elapsed := time.Since(start)
+	if result.Error != nil || result.Server != other.Addr {
+		t.Fatalf("Expected answer from %s, got %+v", other.Addr, result)
+	}
+	if elapsed < stagger {
+		t.Errorf("Expected the second server to be queried only after %v, answered in %v", stagger, elapsed)
+	}
+	if other.Queries() != 1 {
+		t.Errorf("Expected 1 query to the second server, got %d", other.Queries())
+	}
+}
+
+func TestHealthCheck_DemotesSlowHealthyServer(t *testing.T) {
+	answer := testutil.Answer("192.0.2.1", 60)
+	var slow atomic.Bool
+	fake := testutil.NewServer(t, func(req *dns.Msg) *dns.Msg {
+		if slow.Load() {
+			time.Sleep(50 * time.Millisecond)
+		}
+		return answer(req)
+	})
+	m := New([]string{fake.Addr}, testTimeout, 0)
+	m.SetHealthProbe("example.com", 20*time.Millisecond)
+	m.SetCircuitBreaker(3, 0)
+	server := m.servers[0]
+
+	state := func() ServerState { return ServerState(atomic.LoadInt64(&server.State)) }
+
+	// Healthy servers are probed too, keeping their response time current
+	m.healthCheck(server)
+	if fake.Queries() != 1 {
+		t.Fatalf("Expected the healthy server to be probed, it saw %d queries", fake.Queries())
+	}
+	if atomic.LoadInt64(&server.ResponseTime) == 0 {
+		t.Error("Expected the probe to record a response time")
+	}
+
+	// A fast probe in between resets the count of slow ones
+	slow.Store(true)
+	m.healthCheck(server)
+	m.healthCheck(server)
+	slow.Store(false)
+	m.healthCheck(server)
+	slow.Store(true)
+
+	for i := 1; i <= slowProbeLimit; i++ {
+		if state() != StateHealthy {
+			t.Fatalf("Expected healthy before %d slow probes in a row, got %s after %d", slowProbeLimit, state(), i-1)
+		}
+		m.healthCheck(server)
+	}
+	if state() != StateUnhealthy {
+		t.Fatalf("Expected unhealthy after %d slow probes, got %s", slowProbeLimit, state())
+	}
+	if time.Duration(atomic.LoadInt64(&server.ResponseTime)) < 50*time.Millisecond {
+		t.Errorf("Expected the slow response time to be recorded, got %v", time.Duration(atomic.LoadInt64(&server.ResponseTime)))
+	}
+
+	// A slow server stays unhealthy; once fast again it starts recovering
+	m.healthCheck(server)
+	if state() != StateUnhealthy {
+		t.Fatalf("Expected a slow server to stay unhealthy, got %s", state())
+	}
+	slow.Store(false)
+	m.healthCheck(server)
+	if state() != StateRecovering {
+		t.Errorf("Expected recovering once the server is fast again, got %s", state())
+	}
+}
+
+func TestCircuitBreaker_ConfiguredThresholdAndRecovery(t *testing.T) {
+	const recoveryTimeout = 100 * time.Millisecond
+
+	fake := testutil.NewServer(t, testutil.Drop())
+	m := New([]string{fake.Addr}, 20*time.Millisecond, 0)
+	m.SetCircuitBreaker(2, recoveryTimeout)
+	server := m.servers[0]
+
+	state := func() ServerState { return ServerState(atomic.LoadInt64(&server.State)) }
+
+	m.querySingle(context.Background(), server, newQuery("example.com"))
+	if state() != StateHealthy {
+		t.Fatalf("Expected healthy after 1 failure, got %s", state())
+	}
+	m.querySingle(context.Background(), server, newQuery("example.com"))
+	if state() != StateUnhealthy {
+		t.Fatalf("Expected unhealthy after 2 failures, got %s", state())
+	}
+
+	// The server is not probed until the recovery timeout has passed
+	fake.SetHandler(testutil.Answer("192.0.2.1", 60))
+	queries := fake.Queries()
+	m.healthCheck(server)
+	if state() != StateUnhealthy || fake.Queries() != queries {
+		t.Fatalf("Expected no probe within the recovery timeout, got %s after %d queries", state(), fake.Queries()-queries)
+	}
+
+	time.Sleep(recoveryTimeout)
+	m.healthCheck(server)
+	if state() != StateRecovering {
+		t.Errorf("Expected recovering after the recovery timeout, got %s", state())
+	}
+}
@@ -0,0 +1,76 @@
+package upstream
+
+import "github.com/miekg/dns"
+
+// DefaultPaddingBlockSize is the block size recommended for queries by RFC 8467
+const DefaultPaddingBlockSize = 128
+
+// ednsOptionHeaderLen is the length of an EDNS0 option's code and length fields
+const ednsOptionHeaderLen = 4
+
+// SetPadding configures EDNS0 padding (RFC 7830) of outgoing queries to a
+// multiple of blockSize bytes. Queries to DoT and DoH servers are padded when
+// blockSize is positive; plain DNS queries only when padPlain is also set.
+func (m *Manager) SetPadding(blockSize int, padPlain bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.paddingBlockSize = blockSize
+	m.padPlain = padPlain
+}
+
+// shouldPad reports whether queries to the server get padded
+func (m *Manager) shouldPad(server *Server) (int, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.paddingBlockSize <= 0 {
+		return 0, false
+	}
+	if server.Protocol == ProtocolDNS && !m.padPlain {
+		return 0, false
+	}
+	return m.paddingBlockSize, true
+}
+
+// padQuery returns a copy of msg with an EDNS0 padding option sizing the packed
+// message to a multiple of blockSize, and whether an OPT record had to be added
+func padQuery(msg *dns.Msg, blockSize int) (*dns.Msg, bool) {
+	padded := msg.Copy()
+
+	addedOPT := false
+	opt := padded.IsEdns0()
+	if opt == nil {
+		padded.SetEdns0(dns.DefaultMsgSize, false)
+		opt = padded.IsEdns0()
+		addedOPT = true
+	}
+
+	// Replace any padding the client sent
+	options := opt.Option[:0]
+	for _, option := range opt.Option {
+		if option.Option() != dns.EDNS0PADDING {
+			options = append(options, option)
+		}
+	}
+	opt.Option = options
+
+	length := padded.Len() + ednsOptionHeaderLen
+	padding := (blockSize - length%blockSize) % blockSize
+	opt.Option = append(opt.Option, &dns.EDNS0_PADDING{Padding: make([]byte, padding)})
+
+	return padded, addedOPT
+}
+
+// removeOPT strips the OPT record from a response to a query that had none
+func removeOPT(resp *dns.Msg) {
+	extra := resp.Extra[:0]
+	for _, rr := range resp.Extra {
+		if rr.Header().Rrtype != dns.TypeOPT {
+			extra = append(extra, rr)
+		}
+	}
+	resp.Extra = extra
+	if len(resp.Extra) == 0 {
+		resp.Extra = nil
+	}
+}
@@ -0,0 +1,129 @@
+package upstream
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"dns-go/internal/upstream/testutil"
+
+	"github.com/miekg/dns"
+)
+
+func TestPadQuery(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     string
+		edns      bool
+		blockSize int
+	}{
+		{name: "short name without EDNS0", query: "a.io", blockSize: 128},
+		{name: "long name with EDNS0", query: "a-rather-long-label-to-push-the-size.subdomain.example.com", edns: true, blockSize: 128},
+		{name: "small block", query: "example.com", edns: true, blockSize: 16},
+		{name: "large block", query: "example.com", blockSize: 468},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := newQuery(tt.query)
+			if tt.edns {
+				msg.SetEdns0(1232, true)
+			}
+
+			padded, addedOPT := padQuery(msg, tt.blockSize)
+			if addedOPT == tt.edns {
+				t.Errorf("Expected addedOPT=%v, got %v", !tt.edns, addedOPT)
+			}
+			if msg.IsEdns0() != nil && len(msg.IsEdns0().Option) != 0 {
+				t.Errorf("Expected the original query to be left unchanged, got %v", msg)
+			}
+
+			packed, err := padded.Pack()
+			if err != nil {
+				t.Fatalf("Failed to pack padded query: %v", err)
+			}
+			if len(packed)%tt.blockSize != 0 {
+				t.Errorf("Expected padded length to be a multiple of %d, got %d", tt.blockSize, len(packed))
+			}
+			if tt.edns && !padded.IsEdns0().Do() {
+				t.Errorf("Expected the DO bit to be preserved")
+			}
+		})
+	}
+}
+
+func TestPadQuery_ReplacesClientPadding(t *testing.T) {
+	msg := newQuery("example.com")
+	msg.SetEdns0(1232, false)
+	opt := msg.IsEdns0()
+	opt.Option = append(opt.Option, &dns.EDNS0_PADDING{Padding: make([]byte, 7)})
+
+	padded, _ := padQuery(msg, 128)
+
+	var paddingOptions int
+	for _, option := range padded.IsEdns0().Option {
+		if option.Option() == dns.EDNS0PADDING {
+			paddingOptions++
+		}
+	}
+	if paddingOptions != 1 {
+		t.Errorf("Expected exactly one padding option, got %d", paddingOptions)
+	}
+
+	packed, err := padded.Pack()
+	if err != nil {
+		t.Fatalf("Failed to pack padded query: %v", err)
+	}
+	if len(packed)%128 != 0 {
+		t.Errorf("Expected padded length to be a multiple of 128, got %d", len(packed))
+	}
+}
+
+func TestQuerySingle_Padding(t *testing.T) {
+	tests := []struct {
+		name       string
+		padPlain   bool
+		wantPadded bool
+	}{
+		{name: "plain DNS unpadded by default", padPlain: false, wantPadded: false},
+		{name: "plain DNS padded when configured", padPlain: true, wantPadded: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var received *dns.Msg
+			answer := testutil.Answer("192.0.2.1", 60)
+			fake := testutil.NewServer(t, func(req *dns.Msg) *dns.Msg {
+				mu.Lock()
+				received = req
+				mu.Unlock()
+				resp := answer(req)
+				if opt := req.IsEdns0(); opt != nil {
+					resp.SetEdns0(opt.UDPSize(), false)
+				}
+				return resp
+			})
+			m := New([]string{fake.Addr}, testTimeout, 0)
+			m.SetPadding(DefaultPaddingBlockSize, tt.padPlain)
+
+			result := m.querySingle(context.Background(), m.servers[0], newQuery("example.com"))
+			if result.Error != nil {
+				t.Fatalf("Expected no error, got: %v", result.Error)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			padded := received.IsEdns0() != nil
+			if padded != tt.wantPadded {
+				t.Fatalf("Expected padded=%v, got query %v", tt.wantPadded, received)
+			}
+			if padded && received.Len()%DefaultPaddingBlockSize != 0 {
+				t.Errorf("Expected padded length to be a multiple of %d, got %d", DefaultPaddingBlockSize, received.Len())
+			}
+			if result.Response.IsEdns0() != nil {
+				t.Errorf("Expected the OPT record added for padding to be removed from the answer")
+			}
+		})
+	}
+}
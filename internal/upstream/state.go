@@ -0,0 +1,48 @@
+package upstream
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// StateChange describes an upstream server moving between health states
+type StateChange struct {
+	Server       string
+	From         ServerState
+	To           ServerState
+	FailureCount int64
+}
+
+// SetStateObserver registers a function called each time an upstream server
+// changes health state, or removes it when fn is nil
+func (m *Manager) SetStateObserver(fn func(StateChange)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stateObserver = fn
+}
+
+// setState moves a server from one health state to another. It does nothing
+// when the server is no longer in the from state, so concurrent callers report
+// each transition once.
+func (m *Manager) setState(server *Server, from, to ServerState) {
+	if from == to || !atomic.CompareAndSwapInt64(&server.State, int64(from), int64(to)) {
+		return
+	}
+	atomic.AddInt64(&server.StateChanges, 1)
+	if to == StateUnhealthy {
+		atomic.StoreInt64(&server.UnhealthySince, time.Now().UnixNano())
+	}
+
+	m.mu.RLock()
+	observer := m.stateObserver
+	m.mu.RUnlock()
+
+	if observer != nil {
+		observer(StateChange{
+			Server:       server.displayAddress(),
+			From:         from,
+			To:           to,
+			FailureCount: atomic.LoadInt64(&server.FailureCount),
+		})
+	}
+}
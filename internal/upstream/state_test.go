@@ -0,0 +1,55 @@
+package upstream
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"dns-go/internal/upstream/testutil"
+)
+
+func TestStateObserver_ReportsEachTransitionOnce(t *testing.T) {
+	fake := testutil.NewServer(t, testutil.Drop())
+	m := New([]string{fake.Addr}, 50*time.Millisecond, 0)
+	m.SetCircuitBreaker(3, 0)
+	server := m.servers[0]
+
+	var mu sync.Mutex
+	var changes []StateChange
+	m.SetStateObserver(func(change StateChange) {
+		mu.Lock()
+		defer mu.Unlock()
+		changes = append(changes, change)
+	})
+
+	// Failures past the threshold keep the server unhealthy without new events
+	for i := 0; i < m.failureThreshold+2; i++ {
+		m.querySingle(context.Background(), server, newQuery("example.com"))
+	}
+
+	fake.SetHandler(testutil.Answer("192.0.2.1", 60))
+	m.healthCheck(server)
+	m.querySingle(context.Background(), server, newQuery("example.com"))
+
+	want := []StateChange{
+		{Server: fake.Addr, From: StateHealthy, To: StateUnhealthy, FailureCount: int64(m.failureThreshold)},
+		{Server: fake.Addr, From: StateUnhealthy, To: StateRecovering, FailureCount: 0},
+		{Server: fake.Addr, From: StateRecovering, To: StateHealthy, FailureCount: 0},
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(changes) != len(want) {
+		t.Fatalf("Expected %d state changes, got %+v", len(want), changes)
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Errorf("State change %d: expected %+v, got %+v", i, want[i], changes[i])
+		}
+	}
+
+	if got := m.GetStats()[0].StateChanges; got != int64(len(want)) {
+		t.Errorf("Expected %d counted state changes, got %d", len(want), got)
+	}
+}
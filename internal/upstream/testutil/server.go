@@ -0,0 +1,200 @@
+// Package testutil provides an in-process fake upstream DNS server so the
+// upstream manager's query, failover and health logic can be exercised
+// deterministically without touching real networks.
+package testutil
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// Handler produces the scripted reply for a query. Returning nil drops the
+// query without answering, which the client observes as a timeout.
+type Handler func(req *dns.Msg) *dns.Msg
+
+// Server is a fake upstream DNS server listening on a random local port, over
+// both UDP and TCP
+type Server struct {
+	// Addr is the host:port the server is listening on
+	Addr string
+
+	udpServer  *dns.Server
+	tcpServer  *dns.Server
+	handler    Handler
+	tcpHandler Handler
+	queries    int64
+	tcpQueries int64
+	mu         sync.RWMutex
+}
+
+// NewServer starts a fake upstream on 127.0.0.1 with a random port, serving
+// the same handler over UDP and TCP. The server is shut down automatically
+// when the test finishes.
+func NewServer(t testing.TB, handler Handler) *Server {
+	t.Helper()
+
+	pc, ln := listen(t)
+
+	s := &Server{
+		Addr:    pc.LocalAddr().String(),
+		handler: handler,
+	}
+
+	s.udpServer = s.start(&dns.Server{PacketConn: pc})
+	s.tcpServer = s.start(&dns.Server{Listener: ln})
+
+	t.Cleanup(s.Close)
+	return s
+}
+
+// listen opens a UDP socket and a TCP listener on the same random local port
+func listen(t testing.TB) (net.PacketConn, net.Listener) {
+	t.Helper()
+
+	var lastErr error
+	for attempt := 0; attempt < 10; attempt++ {
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("failed to listen for fake upstream: %v", err)
+		}
+
+		// The port is free for UDP, but may already be taken for TCP
+		ln, err := net.Listen("tcp", pc.LocalAddr().String())
+		if err == nil {
+			return pc, ln
+		}
+		pc.Close()
+		lastErr = err
+	}
+
+	t.Fatalf("failed to listen for fake upstream over TCP: %v", lastErr)
+	return nil, nil
+}
+
+// start serves DNS on the server's listener and waits until it is ready
+func (s *Server) start(server *dns.Server) *dns.Server {
+	started := make(chan struct{})
+	server.Handler = dns.HandlerFunc(s.serveDNS)
+	server.NotifyStartedFunc = func() { close(started) }
+
+	go server.ActivateAndServe()
+	<-started
+
+	return server
+}
+
+// SetHandler replaces the scripted handler for subsequent queries
+func (s *Server) SetHandler(handler Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handler = handler
+}
+
+// SetTCPHandler replaces the scripted handler for subsequent queries received
+// over TCP; nil uses the same handler as for UDP
+func (s *Server) SetTCPHandler(handler Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tcpHandler = handler
+}
+
+// Queries returns the number of queries the server has received
+func (s *Server) Queries() int {
+	return int(atomic.LoadInt64(&s.queries))
+}
+
+// TCPQueries returns the number of queries the server has received over TCP
+func (s *Server) TCPQueries() int {
+	return int(atomic.LoadInt64(&s.tcpQueries))
+}
+
+// Close stops the server
+func (s *Server) Close() {
+	s.udpServer.Shutdown()
+	s.tcpServer.Shutdown()
+}
+
+// serveDNS dispatches a query to the current scripted handler
+func (s *Server) serveDNS(w dns.ResponseWriter, r *dns.Msg) {
+	atomic.AddInt64(&s.queries, 1)
+
+	s.mu.RLock()
+	handler := s.handler
+	if _, ok := w.RemoteAddr().(*net.TCPAddr); ok {
+		atomic.AddInt64(&s.tcpQueries, 1)
+		if s.tcpHandler != nil {
+			handler = s.tcpHandler
+		}
+	}
+	s.mu.RUnlock()
+
+	if handler == nil {
+		return
+	}
+
+	if resp := handler(r); resp != nil {
+		w.WriteMsg(resp)
+	}
+}
+
+// Answer returns a handler replying with a single A record for the queried name
+func Answer(ip string, ttl uint32) Handler {
+	return func(req *dns.Msg) *dns.Msg {
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		resp.Answer = append(resp.Answer, &dns.A{
+			Hdr: dns.RR_Header{
+				Name:   req.Question[0].Name,
+				Rrtype: dns.TypeA,
+				Class:  dns.ClassINET,
+				Ttl:    ttl,
+			},
+			A: net.ParseIP(ip).To4(),
+		})
+		return resp
+	}
+}
+
+// Rcode returns a handler replying with an empty response carrying the given rcode
+func Rcode(rcode int) Handler {
+	return func(req *dns.Msg) *dns.Msg {
+		resp := new(dns.Msg)
+		resp.SetRcode(req, rcode)
+		return resp
+	}
+}
+
+// Truncated returns a handler replying with an empty response that has the TC
+// bit set, telling the client to retry over TCP
+func Truncated() Handler {
+	return func(req *dns.Msg) *dns.Msg {
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		resp.Truncated = true
+		return resp
+	}
+}
+
+// Drop returns a handler that never answers
+func Drop() Handler {
+	return func(req *dns.Msg) *dns.Msg {
+		return nil
+	}
+}
+
+// Sequence returns a handler that uses the given handlers in order, one per
+// query, repeating the last one once the script is exhausted.
+func Sequence(handlers ...Handler) Handler {
+	var calls int64
+	return func(req *dns.Msg) *dns.Msg {
+		i := int(atomic.AddInt64(&calls, 1)) - 1
+		if i >= len(handlers) {
+			i = len(handlers) - 1
+		}
+		return handlers[i](req)
+	}
+}
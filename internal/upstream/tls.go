@@ -0,0 +1,52 @@
+package upstream
+
+import (
+	"crypto/tls"
+	"time"
+)
+
+// TLSDetails describes a TLS handshake with a DoT or DoH upstream
+type TLSDetails struct {
+	ServerName  string
+	Version     string
+	CipherSuite string
+	Subject     string
+	NotAfter    time.Time
+}
+
+// tlsDetails extracts the negotiated parameters and leaf certificate details
+// from a TLS connection state
+func tlsDetails(state tls.ConnectionState) TLSDetails {
+	details := TLSDetails{
+		ServerName:  state.ServerName,
+		Version:     tls.VersionName(state.Version),
+		CipherSuite: tls.CipherSuiteName(state.CipherSuite),
+	}
+	if len(state.PeerCertificates) > 0 {
+		leaf := state.PeerCertificates[0]
+		details.Subject = leaf.Subject.String()
+		details.NotAfter = leaf.NotAfter
+	}
+	return details
+}
+
+// SetTLSObserver registers a function called with the handshake details of each
+// new DoT or DoH connection, or removes it when fn is nil
+func (m *Manager) SetTLSObserver(fn func(TLSDetails)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tlsObserver = fn
+}
+
+// verifyConnection reports a completed TLS handshake to the observer. It runs
+// after standard certificate verification and never rejects the connection.
+func (m *Manager) verifyConnection(state tls.ConnectionState) error {
+	m.mu.RLock()
+	observer := m.tlsObserver
+	m.mu.RUnlock()
+
+	if observer != nil {
+		observer(tlsDetails(state))
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package upstream
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+	"time"
+)
+
+func TestTLSDetails(t *testing.T) {
+	notAfter := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	state := tls.ConnectionState{
+		ServerName:  "dns.example.net",
+		Version:     tls.VersionTLS13,
+		CipherSuite: tls.TLS_AES_128_GCM_SHA256,
+		PeerCertificates: []*x509.Certificate{
+			{Subject: pkix.Name{CommonName: "dns.example.net", Organization: []string{"Example"}}, NotAfter: notAfter},
+			{Subject: pkix.Name{CommonName: "Example CA"}},
+		},
+	}
+
+	details := tlsDetails(state)
+
+	if details.ServerName != "dns.example.net" {
+		t.Errorf("Expected server name dns.example.net, got %q", details.ServerName)
+	}
+	if details.Version != "TLS 1.3" {
+		t.Errorf("Expected TLS 1.3, got %q", details.Version)
+	}
+	if details.CipherSuite != "TLS_AES_128_GCM_SHA256" {
+		t.Errorf("Expected TLS_AES_128_GCM_SHA256, got %q", details.CipherSuite)
+	}
+	if details.Subject != "CN=dns.example.net,O=Example" {
+		t.Errorf("Expected leaf certificate subject, got %q", details.Subject)
+	}
+	if !details.NotAfter.Equal(notAfter) {
+		t.Errorf("Expected expiry %v, got %v", notAfter, details.NotAfter)
+	}
+}
+
+func TestTLSDetails_NoCertificates(t *testing.T) {
+	details := tlsDetails(tls.ConnectionState{Version: tls.VersionTLS12})
+
+	if details.Version != "TLS 1.2" {
+		t.Errorf("Expected TLS 1.2, got %q", details.Version)
+	}
+	if details.Subject != "" || !details.NotAfter.IsZero() {
+		t.Errorf("Expected no certificate details, got %+v", details)
+	}
+}
+
+func TestVerifyConnection_NotifiesObserver(t *testing.T) {
+	m := New(nil, testTimeout, 0)
+
+	var got []TLSDetails
+	m.SetTLSObserver(func(details TLSDetails) {
+		got = append(got, details)
+	})
+
+	if err := m.verifyConnection(tls.ConnectionState{ServerName: "dns.example.net", Version: tls.VersionTLS13}); err != nil {
+		t.Fatalf("Expected connection to be accepted, got: %v", err)
+	}
+	if len(got) != 1 || got[0].ServerName != "dns.example.net" {
+		t.Errorf("Expected observer to receive the handshake, got %+v", got)
+	}
+}
@@ -39,7 +39,7 @@ const dashboardHTML = `<!DOCTYPE html>
             <div class="card overview-card">
                 <h3>Avg Response Time</h3>
                 <div class="metric-value" id="avgResponseTime">- ms</div>
-                <div class="metric-subtitle">Performance</div>
+                <div class="metric-subtitle" id="latencyPercentiles">p50 - · p95 - · p99 - ms</div>
             </div>
 
             <div class="card overview-card">
@@ -73,6 +73,14 @@ const dashboardHTML = `<!DOCTYPE html>
                 </div>
             </div>
 
+            <!-- Response Codes -->
+            <div class="card">
+                <h3>Response Codes</h3>
+                <div class="query-types" id="rcodes">
+                    <div class="loading">Loading...</div>
+                </div>
+            </div>
+
             <!-- Top Clients -->
             <div class="card">
                 <h3>Top Clients</h3>
@@ -577,6 +585,7 @@ class DNSDashboard {
         this.updateOverview(data.overview);
         this.updateCharts(data.time_series);
         this.updateQueryTypes(data.query_types);
+        this.updateRcodes(data.rcodes);
         this.updateTopClients(data.top_clients);
         this.updateUpstreamServers(data.upstream_servers);
         this.updateRequests(data.requests);
@@ -590,6 +599,7 @@ class DNSDashboard {
         const requestsPerSecondEl = document.getElementById('requestsPerSecond');
         const successRateEl = document.getElementById('successRate');
         const avgResponseTimeEl = document.getElementById('avgResponseTime');
+        const latencyPercentilesEl = document.getElementById('latencyPercentiles');
         const clientsEl = document.getElementById('clients');
         const uptimeEl = document.getElementById('uptime');
         
@@ -597,6 +607,11 @@ class DNSDashboard {
         if (requestsPerSecondEl) requestsPerSecondEl.textContent = (overview.requests_per_second || 0).toFixed(2) + ' req/sec';
         if (successRateEl) successRateEl.textContent = (overview.success_rate || 0).toFixed(1) + '%';
         if (avgResponseTimeEl) avgResponseTimeEl.textContent = (overview.average_response_time_ms || 0).toFixed(1) + ' ms';
+        if (latencyPercentilesEl) {
+            latencyPercentilesEl.textContent = 'p50 ' + (overview.p50_response_time_ms || 0).toFixed(1) +
+                ' · p95 ' + (overview.p95_response_time_ms || 0).toFixed(1) +
+                ' · p99 ' + (overview.p99_response_time_ms || 0).toFixed(1) + ' ms';
+        }
         if (clientsEl) clientsEl.textContent = overview.clients || 0;
         if (uptimeEl) uptimeEl.textContent = overview.uptime || '-';
     }
@@ -643,6 +658,26 @@ class DNSDashboard {
         container.innerHTML = html;
     }
 
+    updateRcodes(rcodes) {
+        const container = document.getElementById('rcodes');
+        
+        if (!rcodes || rcodes.length === 0) {
+            container.innerHTML = '<div class="loading">No response data available</div>';
+            return;
+        }
+
+        const total = rcodes.reduce((sum, rcode) => sum + rcode.count, 0);
+        const html = rcodes.map(rcode => 
+                '<div class="query-type">' +
+                '<div class="query-type-name">' + rcode.rcode + '</div>' +
+                '<div class="query-type-count">' + this.formatNumber(rcode.count) + '</div>' +
+                '<div>' + (total > 0 ? (rcode.count / total * 100).toFixed(1) : '0.0') + '%</div>' +
+                '</div>'
+            ).join('');
+
+        container.innerHTML = html;
+    }
+
     updateTopClients(clients) {
         const container = document.getElementById('topClients');
         
@@ -687,13 +722,16 @@ class DNSDashboard {
             const successRate = stats.total_queries > 0 ? 
                 (stats.successful_queries / stats.total_queries * 100).toFixed(1) : '0.0';
             const isHealthy = stats.successful_queries > stats.failed_queries;
+            const winRate = stats.races > 0 ?
+                (stats.wins / stats.races * 100).toFixed(1) : '0.0';
             
             return '<div class="upstream-server ' + (isHealthy ? '' : 'failed') + '">' +
                 '<div class="upstream-name">' + server + '</div>' +
                 '<div class="upstream-stats">' +
                     '<div>Total: ' + this.formatNumber(stats.total_queries) + '</div>' +
                     '<div>Success: ' + successRate + '%</div>' +
-                    '<div>Avg RTT: ' + stats.average_rtt.toFixed(1) + 'ms</div>' +
+                    '<div>Wins: ' + this.formatNumber(stats.wins) + ' (' + winRate + '%)</div>' +
+                    '<div>Avg RTT: ' + (stats.average_rtt_ms || 0).toFixed(1) + 'ms</div>' +
                     '<div>Last Used: ' + this.formatTime(stats.last_used) + '</div>' +
                 '</div>' +
             '</div>';
@@ -788,7 +826,7 @@ class DNSDashboard {
     }
 
     showError(message) {
-        const containers = ['queryTypes', 'topClients', 'upstreamServers', 'requests'];
+        const containers = ['queryTypes', 'rcodes', 'topClients', 'upstreamServers', 'requests'];
         containers.forEach(id => {
             const element = document.getElementById(id);
             if (element) {
@@ -62,6 +62,7 @@ func NewWebServer(cfg Config) (*WebServer, error) {
 	// API endpoints
 	mux.HandleFunc("/api/metrics", ws.handleMetrics)
 	mux.HandleFunc("/api/health", ws.handleHealth)
+	mux.HandleFunc("/api/version", ws.handleVersion)
 
 	// Dashboard UI
 	mux.HandleFunc("/", ws.handleDashboard)
@@ -133,6 +134,17 @@ func (ws *WebServer) handleHealth(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(health)
 }
 
+func (ws *WebServer) handleVersion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	json.NewEncoder(w).Encode(version.Get())
+}
+
 func (ws *WebServer) handleDashboard(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/html")
 
@@ -0,0 +1,98 @@
+package webserver
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"dns-go/internal/metrics"
+	"dns-go/internal/types"
+	"dns-go/pkg/version"
+)
+
+func TestHandleVersion(t *testing.T) {
+	ws := &WebServer{}
+
+	rec := httptest.NewRecorder()
+	ws.handleVersion(rec, httptest.NewRequest(http.MethodGet, "/api/version", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Expected application/json content type, got %q", ct)
+	}
+
+	var body map[string]string
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	expected := version.Get()
+	fields := map[string]string{
+		"version":    expected.Version,
+		"git_commit": expected.GitCommit,
+		"build_date": expected.BuildDate,
+		"go_version": expected.GoVersion,
+		"platform":   expected.Platform,
+	}
+	for field, want := range fields {
+		if got, ok := body[field]; !ok || got != want {
+			t.Errorf("Expected %s = %q, got %q", field, want, got)
+		}
+	}
+}
+
+func TestHandleVersion_MethodNotAllowed(t *testing.T) {
+	ws := &WebServer{}
+
+	rec := httptest.NewRecorder()
+	ws.handleVersion(rec, httptest.NewRequest(http.MethodPost, "/api/version", nil))
+
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status 405, got %d", rec.Code)
+	}
+}
+
+func TestHandleMetrics_LatencyPercentiles(t *testing.T) {
+	ws := &WebServer{metrics: metrics.NewMetrics()}
+
+	record := func(at time.Time, duration float64) {
+		ws.metrics.RecordRequest(types.LogEntry{
+			Timestamp: at,
+			Request:   types.RequestInfo{Client: "192.0.2.10", Query: "example.com.", Type: "A"},
+			Status:    "success",
+			Duration:  duration,
+		})
+	}
+	// Requests older than an hour are left out
+	record(time.Now().Add(-2*time.Hour), 5000)
+	for i := 1; i <= 100; i++ {
+		record(time.Now(), float64(i))
+	}
+
+	rec := httptest.NewRecorder()
+	ws.handleMetrics(rec, httptest.NewRequest(http.MethodGet, "/api/metrics", nil))
+
+	var body struct {
+		Overview map[string]interface{} `json:"overview"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	expected := map[string]float64{
+		"p50_response_time_ms": 50.5,
+		"p95_response_time_ms": 95.05,
+		"p99_response_time_ms": 99.01,
+	}
+	for field, want := range expected {
+		got, _ := body.Overview[field].(float64)
+		if math.Abs(got-want) > 1e-9 {
+			t.Errorf("Expected %s = %v, got %v", field, want, body.Overview[field])
+		}
+	}
+}